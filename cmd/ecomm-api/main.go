@@ -1,32 +1,217 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
-	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gauss2302/ecomm-service/ecomm-api/handler"
 	"github.com/gauss2302/ecomm-service/ecomm-api/server"
 	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
 
+	"github.com/gauss2302/ecomm-service/config"
 	"github.com/gauss2302/ecomm-service/db"
+	"github.com/gauss2302/ecomm-service/payments"
+	"github.com/gauss2302/ecomm-service/token"
 )
 
 func main() {
-	db, err := db.NewDatabase()
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("error loading config: %v", err)
+	}
+	log.Printf("Loaded config: %+v", *cfg)
+
+	db, err := db.NewDatabase(cfg.Database)
 	if err != nil {
 		log.Fatalf("error opening database: %v", err)
 	}
 	defer db.Close()
 
-	log.Println("Connected to database")
+	log.Printf("Connected to %s database", cfg.Database.Driver)
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(context.Background(), db, args[1:]); err != nil {
+			log.Fatalf("error running migrations: %v", err)
+		}
+		return
+	}
+
+	// a local SQLite database is set up on the fly so that no separate
+	// migration step is needed for development
+	if cfg.Database.Driver == "sqlite" {
+		if err := db.MigrateUp(context.Background()); err != nil {
+			log.Fatalf("error running migrations: %v", err)
+		}
+	}
 
-	st := storer.NewMySQLStorer(db.GetDB())
-	srv := server.NewServer(st)
-	hdl := handler.NewHandler(srv)
-	r := handler.RegisterRoutes(hdl) // Get the router
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Printf("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", r); err != nil {
-		log.Fatalf("error starting server: %v", err)
+	st := newStorer(cfg.Database.Driver, db)
+	gateway, err := payments.NewGateway(cfg.Payments.Gateway, cfg.Payments.DeclineCards)
+	if err != nil {
+		log.Fatalf("error creating payment gateway: %v", err)
+	}
+	srv := server.NewServer(st, cfg.Pricing, gateway)
+
+	if len(args) > 0 && args[0] == "webhooks" {
+		if err := runWebhooks(ctx, srv, args[1:]); err != nil {
+			log.Fatalf("error running webhooks command: %v", err)
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "users" {
+		if err := runUsers(ctx, srv, args[1:]); err != nil {
+			log.Fatalf("error running users command: %v", err)
+		}
+		return
+	}
+
+	tokenMaker, err := newTokenMaker(cfg.Auth, st)
+	if err != nil {
+		log.Fatalf("error creating token maker: %v", err)
 	}
+	go token.PurgeRevokedTokensEvery(ctx, st, time.Hour)
+
+	hdl := handler.NewHandler(srv, tokenMaker, cfg.Auth.AccessTokenDuration, cfg.Auth.RefreshTokenDuration, cfg.Payments)
+
+	log.Printf("Starting server on %s", cfg.Server.Addr)
+	if err := handler.Start(ctx, cfg.Server, hdl); err != nil {
+		log.Printf("error running server: %v", err)
+	}
+
+	// the database is closed by the deferred Close only once in-flight
+	// requests have drained
+	log.Println("Server stopped")
+}
+
+func newStorer(driver string, database *db.Database) storer.Storer {
+	switch driver {
+	case "postgres":
+		return storer.NewPostgresStorer(database.GetDB())
+	case "sqlite":
+		return storer.NewSQLiteStorer(database.GetDB())
+	default:
+		return storer.NewMySQLStorer(database.GetDB())
+	}
+}
+
+// runMigrate implements the "migrate up|down|to <version>|status" subcommand.
+func runMigrate(ctx context.Context, database *db.Database, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down|to <version>|status")
+	}
+
+	switch args[0] {
+	case "up":
+		return database.MigrateUp(ctx)
+	case "down":
+		return database.MigrateDown(ctx)
+	case "to":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: migrate to <version>")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q: %w", args[1], err)
+		}
+		return database.MigrateTo(ctx, version)
+	case "status":
+		statuses, err := database.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied at " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+// runWebhooks implements the "webhooks replay [event-id...]" subcommand, which
+// applies again the payment webhook events that failed, or those given.
+func runWebhooks(ctx context.Context, srv *server.Server, args []string) error {
+	if len(args) == 0 || args[0] != "replay" {
+		return fmt.Errorf("usage: webhooks replay [event-id...]")
+	}
+
+	events, err := srv.ReplayWebhookEvents(ctx, args[1:]...)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		fmt.Printf("%s\t%s\t%s\n", e.EventID, e.Status, e.Error)
+	}
+	return nil
+}
+
+// runUsers implements the "users grant-admin <email>" subcommand, which makes
+// an existing user an admin. Admin rights cannot be taken at sign up, so the
+// first admin is made with it.
+func runUsers(ctx context.Context, srv *server.Server, args []string) error {
+	if len(args) != 2 || args[0] != "grant-admin" {
+		return fmt.Errorf("usage: users grant-admin <email>")
+	}
+
+	u, err := srv.GetUser(ctx, args[1])
+	if err != nil {
+		return err
+	}
+	u.IsAdmin = true
+	if _, err := srv.UpdateUser(ctx, u); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is now an admin\n", u.Email)
+	return nil
+}
+
+// newTokenMaker builds a key ring from the configured signing key and the
+// optional previous key, falling back to an HMAC key. Tokens signed by the
+// previous key stay valid for the lifetime of the longest token from the time
+// it was retired at.
+func newTokenMaker(cfg config.AuthConfig, revocations token.RevocationStore) (*token.KeyRingMaker, error) {
+	gracePeriod := cfg.RefreshTokenDuration
+
+	if cfg.SigningKeyFile == "" {
+		return token.NewKeyRingMaker(token.NewHMACKey("hmac", []byte(cfg.SecretKey)), gracePeriod, revocations), nil
+	}
+
+	active, err := loadKey(cfg.SigningKeyID, cfg.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	maker := token.NewKeyRingMaker(active, gracePeriod, revocations)
+
+	if cfg.PreviousKeyFile != "" {
+		prev, err := loadKey(cfg.PreviousKeyID, cfg.PreviousKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		maker.AddRetiredKey(prev, cfg.PreviousKeyRetiredAt)
+	}
+
+	return maker, nil
+}
+
+func loadKey(id, path string) (*token.Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %w", err)
+	}
+
+	return token.ParsePrivateKeyPEM(id, data)
 }
@@ -0,0 +1,396 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/gauss2302/ecomm-service/payments"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the API. Values are resolved from, in
+// increasing order of precedence: defaults, the YAML config file, ECOMM_*
+// environment variables and command line flags.
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Database DatabaseConfig `yaml:"database"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Pricing  PricingConfig  `yaml:"pricing"`
+	Payments PaymentsConfig `yaml:"payments"`
+}
+
+type ServerConfig struct {
+	Addr         string        `yaml:"addr"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	// RequestTimeout bounds the context handed to the storer for each
+	// request, so it should stay below WriteTimeout.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// ShutdownTimeout bounds how long in-flight requests may take to finish
+	// once the server is asked to stop.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type DatabaseConfig struct {
+	// Driver is one of mysql, postgres or sqlite.
+	Driver string `yaml:"driver"`
+	// DSN is the data source name, or the database file path for sqlite.
+	DSN             Secret        `yaml:"dsn"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+type AuthConfig struct {
+	// SecretKey is the HMAC key used when no SigningKeyFile is configured.
+	SecretKey            Secret        `yaml:"secret_key"`
+	SigningKeyFile       string        `yaml:"signing_key_file"`
+	SigningKeyID         string        `yaml:"signing_key_id"`
+	PreviousKeyFile      string        `yaml:"previous_key_file"`
+	PreviousKeyID        string        `yaml:"previous_key_id"`
+	AccessTokenDuration  time.Duration `yaml:"access_token_duration"`
+	RefreshTokenDuration time.Duration `yaml:"refresh_token_duration"`
+	// PreviousKeyRetiredAt is when the previous key stopped signing tokens.
+	// It is accepted for RefreshTokenDuration from then on, however often
+	// the server restarts.
+	PreviousKeyRetiredAt time.Time `yaml:"previous_key_retired_at"`
+}
+
+// PricingConfig holds the rules used to price orders server-side.
+type PricingConfig struct {
+	// Currency is the ISO 4217 code of every price in the store, and of the
+	// amounts below.
+	Currency string `yaml:"currency"`
+	// TaxRate is applied to the items price, e.g. 0.15 for 15%.
+	TaxRate       float64 `yaml:"tax_rate"`
+	ShippingPrice float64 `yaml:"shipping_price"`
+	// FreeShippingThreshold is the items price from which shipping is free.
+	// Zero disables free shipping.
+	FreeShippingThreshold float64 `yaml:"free_shipping_threshold"`
+}
+
+// PaymentsConfig selects the payment gateway orders are paid through.
+type PaymentsConfig struct {
+	// Gateway is the name of the provider. Only fake is built in.
+	Gateway string `yaml:"gateway"`
+	// DeclineCards are the card numbers the fake gateway declines, as
+	// "number" or "number:reason". Empty uses its default test cards.
+	DeclineCards []string `yaml:"decline_cards"`
+	// WebhookSecret is the HMAC key the provider signs webhooks with. Without
+	// it every webhook is rejected.
+	WebhookSecret Secret `yaml:"webhook_secret"`
+	// WebhookTolerance is how far the time a webhook was signed at may be
+	// from now.
+	WebhookTolerance time.Duration `yaml:"webhook_tolerance"`
+}
+
+// Secret is a string that is redacted when printed, so that a Config can be
+// logged safely.
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+func (s Secret) GoString() string {
+	return strconv.Quote(s.String())
+}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     2 * time.Minute,
+			RequestTimeout:  15 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Driver:          "mysql",
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 5 * time.Minute,
+		},
+		Auth: AuthConfig{
+			AccessTokenDuration:  15 * time.Minute,
+			RefreshTokenDuration: 24 * time.Hour,
+		},
+		Pricing: PricingConfig{
+			Currency:              "USD",
+			TaxRate:               0.15,
+			ShippingPrice:         10,
+			FreeShippingThreshold: 100,
+		},
+		Payments: PaymentsConfig{
+			Gateway:          payments.FakeGatewayName,
+			WebhookTolerance: 5 * time.Minute,
+		},
+	}
+}
+
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value func(*Config) flag.Value
+}
+
+var settings = []setting{
+	{"addr", "ECOMM_ADDR", "address the HTTP server listens on", func(c *Config) flag.Value { return (*stringValue)(&c.Server.Addr) }},
+	{"read-timeout", "ECOMM_READ_TIMEOUT", "maximum duration for reading a request", func(c *Config) flag.Value { return (*durationValue)(&c.Server.ReadTimeout) }},
+	{"write-timeout", "ECOMM_WRITE_TIMEOUT", "maximum duration for writing a response", func(c *Config) flag.Value { return (*durationValue)(&c.Server.WriteTimeout) }},
+	{"idle-timeout", "ECOMM_IDLE_TIMEOUT", "maximum time to wait for the next request on a keep-alive connection", func(c *Config) flag.Value { return (*durationValue)(&c.Server.IdleTimeout) }},
+	{"request-timeout", "ECOMM_REQUEST_TIMEOUT", "maximum time a request may spend in the handlers and storer", func(c *Config) flag.Value { return (*durationValue)(&c.Server.RequestTimeout) }},
+	{"shutdown-timeout", "ECOMM_SHUTDOWN_TIMEOUT", "maximum time to drain in-flight requests on shutdown", func(c *Config) flag.Value { return (*durationValue)(&c.Server.ShutdownTimeout) }},
+	{"db", "ECOMM_DB_DRIVER", "storage backend to use: mysql, postgres or sqlite", func(c *Config) flag.Value { return (*stringValue)(&c.Database.Driver) }},
+	{"dsn", "ECOMM_DB_DSN", "database data source name, or file path for sqlite", func(c *Config) flag.Value { return (*stringValue)(&c.Database.DSN) }},
+	{"db-max-open-conns", "ECOMM_DB_MAX_OPEN_CONNS", "maximum number of open database connections", func(c *Config) flag.Value { return (*intValue)(&c.Database.MaxOpenConns) }},
+	{"db-max-idle-conns", "ECOMM_DB_MAX_IDLE_CONNS", "maximum number of idle database connections", func(c *Config) flag.Value { return (*intValue)(&c.Database.MaxIdleConns) }},
+	{"db-conn-max-lifetime", "ECOMM_DB_CONN_MAX_LIFETIME", "maximum lifetime of a database connection", func(c *Config) flag.Value { return (*durationValue)(&c.Database.ConnMaxLifetime) }},
+	{"jwt-secret-key", "ECOMM_JWT_SECRET_KEY", "HMAC key used to sign tokens when no signing key file is set", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.SecretKey) }},
+	{"jwt-signing-key-file", "ECOMM_JWT_SIGNING_KEY_FILE", "PEM encoded Ed25519 or RSA private key used to sign tokens", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.SigningKeyFile) }},
+	{"jwt-signing-key-id", "ECOMM_JWT_SIGNING_KEY_ID", "kid of the signing key", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.SigningKeyID) }},
+	{"jwt-previous-key-file", "ECOMM_JWT_PREVIOUS_KEY_FILE", "previous signing key, still accepted during rotation", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.PreviousKeyFile) }},
+	{"jwt-previous-key-id", "ECOMM_JWT_PREVIOUS_KEY_ID", "kid of the previous signing key", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.PreviousKeyID) }},
+	{"jwt-previous-key-retired-at", "ECOMM_JWT_PREVIOUS_KEY_RETIRED_AT", "RFC 3339 time the previous signing key was retired at", func(c *Config) flag.Value { return (*timeValue)(&c.Auth.PreviousKeyRetiredAt) }},
+	{"access-token-duration", "ECOMM_ACCESS_TOKEN_DURATION", "lifetime of access tokens", func(c *Config) flag.Value { return (*durationValue)(&c.Auth.AccessTokenDuration) }},
+	{"refresh-token-duration", "ECOMM_REFRESH_TOKEN_DURATION", "lifetime of refresh tokens", func(c *Config) flag.Value { return (*durationValue)(&c.Auth.RefreshTokenDuration) }},
+	{"currency", "ECOMM_CURRENCY", "ISO 4217 code of the currency prices are in", func(c *Config) flag.Value { return (*stringValue)(&c.Pricing.Currency) }},
+	{"tax-rate", "ECOMM_TAX_RATE", "tax rate applied to the items price of an order", func(c *Config) flag.Value { return (*floatValue)(&c.Pricing.TaxRate) }},
+	{"shipping-price", "ECOMM_SHIPPING_PRICE", "shipping price of an order", func(c *Config) flag.Value { return (*floatValue)(&c.Pricing.ShippingPrice) }},
+	{"free-shipping-threshold", "ECOMM_FREE_SHIPPING_THRESHOLD", "items price from which shipping is free, 0 to disable", func(c *Config) flag.Value { return (*floatValue)(&c.Pricing.FreeShippingThreshold) }},
+	{"payment-gateway", "ECOMM_PAYMENT_GATEWAY", "payment gateway orders are paid through: fake", func(c *Config) flag.Value { return (*stringValue)(&c.Payments.Gateway) }},
+	{"fake-decline-cards", "ECOMM_FAKE_DECLINE_CARDS", "comma separated card numbers the fake gateway declines", func(c *Config) flag.Value { return (*listValue)(&c.Payments.DeclineCards) }},
+	{"payment-webhook-secret", "ECOMM_PAYMENT_WEBHOOK_SECRET", "HMAC key payment webhooks are signed with", func(c *Config) flag.Value { return (*stringValue)(&c.Payments.WebhookSecret) }},
+	{"payment-webhook-tolerance", "ECOMM_PAYMENT_WEBHOOK_TOLERANCE", "maximum age of a payment webhook signature", func(c *Config) flag.Value { return (*durationValue)(&c.Payments.WebhookTolerance) }},
+}
+
+// Load resolves the configuration from the config file, the environment and
+// args, which should not include the program name. It returns the arguments
+// left after the flags, such as a subcommand.
+func Load(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("ecomm-api", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("ECOMM_CONFIG"), "path to a YAML config file (env ECOMM_CONFIG)")
+
+	// flags are recorded first and applied last, since the config file they
+	// point to must be loaded before them
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		fs.Var(&recordValue{name: s.flag, values: flagValues}, s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, nil, fmt.Errorf("error parsing config file: %w", err)
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.value(cfg).Set(v); err != nil {
+				return nil, nil, fmt.Errorf("invalid value for %s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := flagValues[s.flag]; ok {
+			if err := s.value(cfg).Set(v); err != nil {
+				return nil, nil, fmt.Errorf("invalid value for -%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+// Validate checks the configuration for missing or inconsistent settings and
+// reports all problems at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server address must be set"))
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 || c.Server.RequestTimeout < 0 || c.Server.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("server timeouts must not be negative"))
+	}
+
+	switch c.Database.Driver {
+	case "mysql", "postgres", "sqlite":
+	default:
+		errs = append(errs, fmt.Errorf("unknown database driver %q", c.Database.Driver))
+	}
+	if c.Database.DSN == "" {
+		errs = append(errs, errors.New("database dsn must be set"))
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database pool sizes must not be negative"))
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		errs = append(errs, errors.New("database max idle conns must not exceed max open conns"))
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("database conn max lifetime must not be negative"))
+	}
+
+	if c.Auth.SigningKeyFile == "" && c.Auth.SecretKey == "" {
+		errs = append(errs, errors.New("either a jwt signing key file or a jwt secret key must be set"))
+	}
+	if c.Auth.SigningKeyFile != "" && c.Auth.SigningKeyID == "" {
+		errs = append(errs, errors.New("jwt signing key id must be set with the signing key file"))
+	}
+	if c.Auth.PreviousKeyFile != "" && c.Auth.PreviousKeyID == "" {
+		errs = append(errs, errors.New("jwt previous key id must be set with the previous key file"))
+	}
+	if c.Auth.PreviousKeyFile != "" && c.Auth.PreviousKeyRetiredAt.IsZero() {
+		errs = append(errs, errors.New("jwt previous key retired at must be set with the previous key file"))
+	}
+	if c.Auth.AccessTokenDuration <= 0 || c.Auth.RefreshTokenDuration <= 0 {
+		errs = append(errs, errors.New("token durations must be positive"))
+	}
+	if c.Auth.AccessTokenDuration > c.Auth.RefreshTokenDuration {
+		errs = append(errs, errors.New("access token duration must not exceed refresh token duration"))
+	}
+
+	if !money.IsCurrency(c.Pricing.Currency) {
+		errs = append(errs, fmt.Errorf("unsupported currency %q", c.Pricing.Currency))
+	}
+	if c.Pricing.TaxRate < 0 || c.Pricing.TaxRate >= 1 {
+		errs = append(errs, errors.New("tax rate must be in [0, 1)"))
+	}
+	if c.Pricing.ShippingPrice < 0 || c.Pricing.FreeShippingThreshold < 0 {
+		errs = append(errs, errors.New("shipping prices must not be negative"))
+	}
+
+	switch c.Payments.Gateway {
+	case payments.FakeGatewayName:
+	default:
+		errs = append(errs, fmt.Errorf("unknown payment gateway %q", c.Payments.Gateway))
+	}
+	if c.Payments.WebhookTolerance <= 0 {
+		errs = append(errs, errors.New("payment webhook tolerance must be positive"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+type recordValue struct {
+	name   string
+	values map[string]string
+}
+
+func (v *recordValue) String() string { return "" }
+
+func (v *recordValue) Set(s string) error {
+	v.values[v.name] = s
+	return nil
+}
+
+type stringValue string
+
+func (v *stringValue) String() string { return string(*v) }
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+type intValue int
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v = intValue(n)
+	return nil
+}
+
+type durationValue time.Duration
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+type timeValue time.Time
+
+func (v *timeValue) String() string {
+	if time.Time(*v).IsZero() {
+		return ""
+	}
+	return time.Time(*v).Format(time.RFC3339)
+}
+
+func (v *timeValue) Set(s string) error {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return err
+	}
+	*v = timeValue(t)
+	return nil
+}
+
+type floatValue float64
+
+func (v *floatValue) String() string { return strconv.FormatFloat(float64(*v), 'f', -1, 64) }
+
+func (v *floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*v = floatValue(f)
+	return nil
+}
+
+type listValue []string
+
+func (v *listValue) String() string { return strings.Join(*v, ",") }
+
+func (v *listValue) Set(s string) error {
+	var l []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+	*v = l
+	return nil
+}
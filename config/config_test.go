@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  addr: ":9000"
+database:
+  driver: postgres
+  dsn: postgres://file
+  max_open_conns: 10
+  max_idle_conns: 5
+auth:
+  secret_key: file-secret
+  access_token_duration: 5m
+  previous_key_retired_at: 2024-11-01T10:00:00Z
+pricing:
+  currency: EUR
+  tax_rate: 0.2
+`)
+
+	t.Setenv("ECOMM_CONFIG", path)
+	t.Setenv("ECOMM_ADDR", ":9001")
+	t.Setenv("ECOMM_DB_DSN", "postgres://env")
+	t.Setenv("ECOMM_SHIPPING_PRICE", "4.5")
+	t.Setenv("ECOMM_PAYMENT_WEBHOOK_TOLERANCE", "1m")
+	t.Setenv("ECOMM_FAKE_DECLINE_CARDS", "4111111111111111, 4242424242424242:stolen_card")
+
+	cfg, args, err := Load([]string{"-addr", ":9002", "migrate", "up"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"migrate", "up"}, args)
+
+	// flag beats env beats file beats defaults
+	require.Equal(t, ":9002", cfg.Server.Addr)
+	require.Equal(t, Secret("postgres://env"), cfg.Database.DSN)
+	require.Equal(t, "postgres", cfg.Database.Driver)
+	require.Equal(t, 10, cfg.Database.MaxOpenConns)
+	require.Equal(t, 5*time.Minute, cfg.Auth.AccessTokenDuration)
+	require.Equal(t, 24*time.Hour, cfg.Auth.RefreshTokenDuration)
+	require.Equal(t, time.Date(2024, 11, 1, 10, 0, 0, 0, time.UTC), cfg.Auth.PreviousKeyRetiredAt)
+	require.Equal(t, 5*time.Minute, cfg.Database.ConnMaxLifetime)
+	require.Equal(t, "EUR", cfg.Pricing.Currency)
+	require.Equal(t, 0.2, cfg.Pricing.TaxRate)
+	require.Equal(t, 4.5, cfg.Pricing.ShippingPrice)
+	require.Equal(t, 100.0, cfg.Pricing.FreeShippingThreshold)
+	require.Equal(t, "fake", cfg.Payments.Gateway)
+	require.Equal(t, []string{"4111111111111111", "4242424242424242:stolen_card"}, cfg.Payments.DeclineCards)
+	require.Equal(t, time.Minute, cfg.Payments.WebhookTolerance)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	t.Setenv("ECOMM_CONFIG", "")
+
+	_, _, err := Load(nil)
+	require.ErrorContains(t, err, "database dsn must be set")
+	require.ErrorContains(t, err, "jwt secret key must be set")
+
+	_, _, err = Load([]string{"-dsn", "ecomm.db", "-db", "oracle", "-jwt-secret-key", "secret"})
+	require.ErrorContains(t, err, `unknown database driver "oracle"`)
+
+	_, _, err = Load([]string{"-dsn", "ecomm.db", "-jwt-secret-key", "secret", "-access-token-duration", "soon"})
+	require.ErrorContains(t, err, "invalid value for -access-token-duration")
+
+	_, _, err = Load([]string{"-dsn", "ecomm.db", "-jwt-secret-key", "secret", "-tax-rate", "1.5"})
+	require.ErrorContains(t, err, "tax rate must be in [0, 1)")
+
+	_, _, err = Load([]string{"-dsn", "ecomm.db", "-jwt-secret-key", "secret", "-currency", "XYZ"})
+	require.ErrorContains(t, err, `unsupported currency "XYZ"`)
+
+	_, _, err = Load([]string{"-dsn", "ecomm.db", "-jwt-secret-key", "secret", "-payment-gateway", "paypal"})
+	require.ErrorContains(t, err, `unknown payment gateway "paypal"`)
+
+	_, _, err = Load([]string{"-dsn", "ecomm.db", "-jwt-signing-key-file", "jwt.pem", "-jwt-signing-key-id", "2", "-jwt-previous-key-file", "old.pem", "-jwt-previous-key-id", "1"})
+	require.ErrorContains(t, err, "jwt previous key retired at must be set with the previous key file")
+
+	_, _, err = Load([]string{"-dsn", "ecomm.db", "-jwt-secret-key", "secret", "-jwt-previous-key-retired-at", "yesterday"})
+	require.ErrorContains(t, err, "invalid value for -jwt-previous-key-retired-at")
+
+	t.Setenv("ECOMM_DB_MAX_OPEN_CONNS", "many")
+	_, _, err = Load([]string{"-dsn", "ecomm.db", "-jwt-secret-key", "secret"})
+	require.ErrorContains(t, err, "invalid value for ECOMM_DB_MAX_OPEN_CONNS")
+}
+
+func TestSecretRedaction(t *testing.T) {
+	cfg := Default()
+	cfg.Database.DSN = "root:password@tcp(localhost:3306)/ecomm"
+	cfg.Auth.SecretKey = "super secret"
+	cfg.Payments.WebhookSecret = "webhook secret"
+
+	for _, format := range []string{"%v", "%+v", "%#v"} {
+		out := fmt.Sprintf(format, *cfg)
+		require.NotContains(t, out, "password")
+		require.NotContains(t, out, "super secret")
+		require.NotContains(t, out, "webhook secret")
+	}
+}
@@ -3,17 +3,40 @@ package db
 import (
 	"fmt"
 
+	"github.com/gauss2302/ecomm-service/config"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
 )
 
 type Database struct {
 	db *sqlx.DB
 }
 
-func NewDatabase() (*Database, error) {
+// NewDatabase connects to the database described by cfg and applies its
+// connection pool settings.
+func NewDatabase(cfg config.DatabaseConfig) (*Database, error) {
+	if cfg.Driver == "sqlite" {
+		// the pool is fixed to a single connection for SQLite
+		return NewSQLiteDatabase(string(cfg.DSN))
+	}
+
+	d, err := Open(cfg.Driver, string(cfg.DSN))
+	if err != nil {
+		return nil, err
+	}
+
+	d.db.SetMaxOpenConns(cfg.MaxOpenConns)
+	d.db.SetMaxIdleConns(cfg.MaxIdleConns)
+	d.db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	return d, nil
+}
 
-	db, err := sqlx.Open("mysql", "root:nick23@tcp(localhost:3307)/ecomm?parseTime=true")
+// Open connects to the database identified by driverName ("mysql" or
+// "postgres") and dsn.
+func Open(driverName, dsn string) (*Database, error) {
+	db, err := sqlx.Open(driverName, dsn)
 
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %w", err)
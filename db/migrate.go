@@ -0,0 +1,379 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed migrations/*.sql migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// migrationLockName identifies the lock held while migrations run, so that
+// several instances starting at once do not apply the same migration twice.
+const migrationLockName = "ecomm_schema_migrations"
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Checksum covers both scripts, so editing either once the migration is
+	// applied is detected.
+	Checksum string
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type appliedMigration struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+type dialect struct {
+	dir    string
+	lock   func(ctx context.Context, conn *sqlx.Conn) error
+	unlock func(ctx context.Context, conn *sqlx.Conn, failed bool) error
+	// transactional is false for MySQL, where DDL statements commit implicitly.
+	transactional bool
+}
+
+var dialects = map[string]dialect{
+	"mysql": {
+		dir: "migrations",
+		lock: func(ctx context.Context, conn *sqlx.Conn) error {
+			var ok int
+			if err := conn.GetContext(ctx, &ok, "SELECT GET_LOCK(?, 60)", migrationLockName); err != nil {
+				return err
+			}
+			if ok != 1 {
+				return fmt.Errorf("timed out waiting for migration lock")
+			}
+			return nil
+		},
+		unlock: func(ctx context.Context, conn *sqlx.Conn, _ bool) error {
+			_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", migrationLockName)
+			return err
+		},
+	},
+	"postgres": {
+		dir: "migrations/postgres",
+		lock: func(ctx context.Context, conn *sqlx.Conn) error {
+			_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", migrationLockName)
+			return err
+		},
+		unlock: func(ctx context.Context, conn *sqlx.Conn, _ bool) error {
+			_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1))", migrationLockName)
+			return err
+		},
+		transactional: true,
+	},
+	"sqlite": {
+		dir: "migrations/sqlite",
+		// an immediate transaction takes the database write lock for the
+		// whole run, so the run is also applied atomically
+		lock: func(ctx context.Context, conn *sqlx.Conn) error {
+			_, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+			return err
+		},
+		unlock: func(ctx context.Context, conn *sqlx.Conn, failed bool) error {
+			if failed {
+				_, err := conn.ExecContext(ctx, "ROLLBACK")
+				return err
+			}
+			_, err := conn.ExecContext(ctx, "COMMIT")
+			return err
+		},
+	},
+}
+
+// MigrateUp applies every migration that has not been applied yet.
+func (d *Database) MigrateUp(ctx context.Context) error {
+	return d.runMigrations(ctx, func(m *migrator) error {
+		return m.migrateTo(ctx, math.MaxInt64)
+	})
+}
+
+// MigrateDown rolls back the most recently applied migration.
+func (d *Database) MigrateDown(ctx context.Context) error {
+	return d.runMigrations(ctx, func(m *migrator) error {
+		versions := m.appliedVersions()
+		if len(versions) == 0 {
+			return nil
+		}
+
+		var target int64
+		if len(versions) > 1 {
+			target = versions[len(versions)-2]
+		}
+		return m.migrateTo(ctx, target)
+	})
+}
+
+// MigrateTo applies or rolls back migrations so that exactly the migrations
+// up to and including version are applied.
+func (d *Database) MigrateTo(ctx context.Context, version int64) error {
+	return d.runMigrations(ctx, func(m *migrator) error {
+		if version != 0 && m.find(version) == nil {
+			return fmt.Errorf("unknown migration version %d", version)
+		}
+		return m.migrateTo(ctx, version)
+	})
+}
+
+// MigrationStatus lists the known migrations and when they were applied.
+func (d *Database) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := d.runMigrations(ctx, func(m *migrator) error {
+		for _, mig := range m.migrations {
+			s := MigrationStatus{Migration: mig}
+			if am, ok := m.applied[mig.Version]; ok {
+				appliedAt := am.AppliedAt
+				s.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+type migrator struct {
+	conn       *sqlx.Conn
+	dialect    dialect
+	migrations []Migration
+	applied    map[int64]appliedMigration
+}
+
+// runMigrations pins a connection, takes the migration lock, and verifies
+// the applied migrations against the embedded files before running fn.
+func (d *Database) runMigrations(ctx context.Context, fn func(*migrator) error) (err error) {
+	dl, ok := dialects[d.db.DriverName()]
+	if !ok {
+		return fmt.Errorf("migrations are not supported for driver %q", d.db.DriverName())
+	}
+
+	migrations, err := loadMigrations(dl.dir)
+	if err != nil {
+		return err
+	}
+
+	conn, err := d.db.Connx(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting connection: %w", err)
+	}
+	defer conn.Close()
+
+	if err := dl.lock(ctx, conn); err != nil {
+		return fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+	defer func() {
+		if uErr := dl.unlock(context.Background(), conn, err != nil); uErr != nil && err == nil {
+			err = fmt.Errorf("error releasing migration lock: %w", uErr)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, checksum VARCHAR(64) NOT NULL, applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+
+	var rows []appliedMigration
+	if err := conn.SelectContext(ctx, &rows, "SELECT version, name, checksum, applied_at FROM schema_migrations"); err != nil {
+		return fmt.Errorf("error getting applied migrations: %w", err)
+	}
+
+	m := &migrator{
+		conn:       conn,
+		dialect:    dl,
+		migrations: migrations,
+		applied:    make(map[int64]appliedMigration, len(rows)),
+	}
+	for _, am := range rows {
+		mig := m.find(am.Version)
+		if mig == nil {
+			return fmt.Errorf("applied migration %d_%s is missing", am.Version, am.Name)
+		}
+		if mig.Checksum != am.Checksum {
+			return fmt.Errorf("checksum mismatch for migration %d_%s: it was modified after being applied", am.Version, am.Name)
+		}
+		m.applied[am.Version] = am
+	}
+
+	return fn(m)
+}
+
+func (m *migrator) find(version int64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+func (m *migrator) appliedVersions() []int64 {
+	versions := make([]int64, 0, len(m.applied))
+	for v := range m.applied {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+func (m *migrator) migrateTo(ctx context.Context, target int64) error {
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if _, ok := m.applied[mig.Version]; ok && mig.Version > target {
+			if mig.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down migration", mig.Version, mig.Name)
+			}
+			if err := m.exec(ctx, mig.Down, "DELETE FROM schema_migrations WHERE version=?", mig.Version); err != nil {
+				return fmt.Errorf("error rolling back migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			delete(m.applied, mig.Version)
+		}
+	}
+
+	for _, mig := range m.migrations {
+		if _, ok := m.applied[mig.Version]; !ok && mig.Version <= target {
+			if err := m.exec(ctx, mig.Up, "INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)", mig.Version, mig.Name, mig.Checksum); err != nil {
+				return fmt.Errorf("error applying migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			m.applied[mig.Version] = appliedMigration{Version: mig.Version, Name: mig.Name, Checksum: mig.Checksum, AppliedAt: time.Now()}
+		}
+	}
+
+	return nil
+}
+
+// exec runs the statements of a migration file followed by the bookkeeping
+// query, inside a transaction when the dialect supports transactional DDL.
+func (m *migrator) exec(ctx context.Context, script string, query string, args ...interface{}) error {
+	var ext sqlx.ExecerContext = m.conn
+	var tx *sqlx.Tx
+	if m.dialect.transactional {
+		var err error
+		tx, err = m.conn.BeginTxx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("error beginning transaction: %w", err)
+		}
+		defer tx.Rollback()
+		ext = tx
+	}
+
+	for _, stmt := range splitStatements(script) {
+		if _, err := ext.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	if _, err := ext.ExecContext(ctx, m.conn.Rebind(query), args...); err != nil {
+		return fmt.Errorf("error recording migration: %w", err)
+	}
+
+	if tx != nil {
+		return tx.Commit()
+	}
+	return nil
+}
+
+func splitStatements(script string) []string {
+	var stmts []string
+	for _, stmt := range strings.Split(script, ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+// migrationChecksum hashes the up and down scripts of a migration. A NUL byte,
+// which never appears in a script, separates them so that moving a statement
+// from one to the other changes the sum.
+func migrationChecksum(up, down string) string {
+	h := sha256.New()
+	h.Write([]byte(up))
+	h.Write([]byte{0})
+	h.Write([]byte(down))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// loadMigrations reads the <version>_<name>.up.sql and .down.sql pairs in dir.
+func loadMigrations(dir string) ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, path.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, f := range files {
+		base := path.Base(f)
+
+		var up bool
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			up = true
+		case strings.HasSuffix(base, ".down.sql"):
+		default:
+			continue
+		}
+
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(strings.TrimSuffix(base, ".up.sql"), ".down.sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %s", base)
+		}
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", base, err)
+		}
+
+		data, err := migrationFiles.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", base, err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: name}
+			byVersion[version] = mig
+		}
+		if up {
+			mig.Up = string(data)
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up migration", mig.Version, mig.Name)
+		}
+		mig.Checksum = migrationChecksum(mig.Up, mig.Down)
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
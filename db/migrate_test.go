@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+
+	d, err := NewSQLiteDatabase(":memory:")
+	require.NoError(t, err)
+	defer d.Close()
+	require.NoError(t, d.MigrateUp(ctx))
+
+	statuses, err := d.MigrationStatus(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, statuses)
+	for _, s := range statuses {
+		require.NotNil(t, s.AppliedAt, "migration %d not applied", s.Version)
+	}
+	latest := statuses[len(statuses)-1].Version
+
+	// running again is a no-op
+	require.NoError(t, d.MigrateUp(ctx))
+
+	require.NoError(t, d.MigrateDown(ctx))
+	statuses, err = d.MigrationStatus(ctx)
+	require.NoError(t, err)
+	require.Nil(t, statuses[len(statuses)-1].AppliedAt)
+	require.NotNil(t, statuses[len(statuses)-2].AppliedAt)
+
+	require.NoError(t, d.MigrateTo(ctx, statuses[0].Version))
+	statuses, err = d.MigrationStatus(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, statuses[0].AppliedAt)
+	require.Nil(t, statuses[1].AppliedAt)
+
+	var n int
+	err = d.GetDB().Get(&n, "SELECT COUNT(*) FROM sqlite_master WHERE name IN ('revoked_tokens', 'users_email_idx')")
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	require.NoError(t, d.MigrateTo(ctx, latest))
+	err = d.GetDB().Get(&n, "SELECT COUNT(*) FROM revoked_tokens")
+	require.NoError(t, err)
+	err = d.GetDB().Get(&n, "SELECT COUNT(*) FROM sqlite_master WHERE type='index' AND name='users_email_idx'")
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	require.Error(t, d.MigrateTo(ctx, 1))
+}
+
+func TestMigrateChecksumMismatch(t *testing.T) {
+	ctx := context.Background()
+
+	d, err := NewSQLiteDatabase(":memory:")
+	require.NoError(t, err)
+	defer d.Close()
+	require.NoError(t, d.MigrateUp(ctx))
+
+	_, err = d.GetDB().Exec("UPDATE schema_migrations SET checksum='tampered' WHERE version=(SELECT MIN(version) FROM schema_migrations)")
+	require.NoError(t, err)
+
+	err = d.MigrateUp(ctx)
+	require.ErrorContains(t, err, "checksum mismatch")
+}
+
+func TestMigrationChecksum(t *testing.T) {
+	sum := migrationChecksum("CREATE TABLE t (id INT);", "DROP TABLE t;")
+	require.Len(t, sum, 64)
+	require.Equal(t, sum, migrationChecksum("CREATE TABLE t (id INT);", "DROP TABLE t;"))
+
+	// editing the down script is detected as well
+	require.NotEqual(t, sum, migrationChecksum("CREATE TABLE t (id INT);", "DROP TABLE IF EXISTS t;"))
+	require.NotEqual(t, sum, migrationChecksum("CREATE TABLE t (id INT);DROP TABLE t;", ""))
+}
+
+func TestLoadMigrations(t *testing.T) {
+	for _, dl := range dialects {
+		migrations, err := loadMigrations(dl.dir)
+		require.NoError(t, err)
+		require.NotEmpty(t, migrations)
+
+		for i, m := range migrations {
+			require.NotEmpty(t, m.Down, "migration %d_%s in %s has no down migration", m.Version, m.Name, dl.dir)
+			if i > 0 {
+				require.Greater(t, m.Version, migrations[i-1].Version)
+			}
+		}
+	}
+}
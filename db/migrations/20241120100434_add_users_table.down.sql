@@ -0,0 +1,5 @@
+ALTER TABLE `orders` DROP FOREIGN KEY `user_id_fk`;
+
+ALTER TABLE `orders` DROP COLUMN `user_id`;
+
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,12 @@
+CREATE TABLE `sessions` (
+    `id` VARCHAR(255) PRIMARY KEY NOT NULL,
+    `family_id` VARCHAR(255) NOT NULL,
+    `user_email` VARCHAR(255) NOT NULL,
+    `refresh_token` VARCHAR(512) NOT NULL,
+    `is_revoked` BOOLEAN NOT NULL DEFAULT FALSE,
+    `replaced_by` VARCHAR(255),
+    `created_at` DATETIME DEFAULT(now()),
+    `expires_at` DATETIME NOT NULL
+);
+
+CREATE INDEX `sessions_family_id_idx` ON `sessions` (`family_id`);
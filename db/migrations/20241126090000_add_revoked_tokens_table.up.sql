@@ -0,0 +1,7 @@
+CREATE TABLE `revoked_tokens` (
+    `id` VARCHAR(255) PRIMARY KEY NOT NULL,
+    `expires_at` DATETIME NOT NULL,
+    `created_at` DATETIME DEFAULT(now())
+);
+
+CREATE INDEX `revoked_tokens_expires_at_idx` ON `revoked_tokens` (`expires_at`);
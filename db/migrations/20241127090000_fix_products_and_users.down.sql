@@ -0,0 +1,8 @@
+ALTER TABLE `orders` DROP FOREIGN KEY `user_id_fk`;
+
+ALTER TABLE `users` MODIFY `id` INT NOT NULL;
+
+ALTER TABLE `orders`
+ADD CONSTRAINT `user_id_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`);
+
+ALTER TABLE `products` DROP COLUMN `category`;
@@ -0,0 +1,9 @@
+ALTER TABLE `products`
+ADD COLUMN `category` VARCHAR(255) NOT NULL DEFAULT '' AFTER `image`;
+
+ALTER TABLE `orders` DROP FOREIGN KEY `user_id_fk`;
+
+ALTER TABLE `users` MODIFY `id` INT NOT NULL AUTO_INCREMENT;
+
+ALTER TABLE `orders`
+ADD CONSTRAINT `user_id_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`);
@@ -0,0 +1,5 @@
+ALTER TABLE `products`
+ADD COLUMN `is_active` BOOLEAN NOT NULL DEFAULT TRUE AFTER `count_in_stock`;
+
+ALTER TABLE `orders`
+ADD COLUMN `items_price` decimal(10, 2) NOT NULL DEFAULT 0 AFTER `payment_method`;
@@ -0,0 +1,14 @@
+ALTER TABLE `orders`
+ADD COLUMN `status` varchar(32) NOT NULL DEFAULT 'pending' AFTER `id`;
+
+CREATE TABLE `order_status_history` (
+    `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `order_id` int NOT NULL,
+    `from_status` varchar(32) NOT NULL,
+    `to_status` varchar(32) NOT NULL,
+    `actor` varchar(255) NOT NULL,
+    `created_at` datetime NOT NULL DEFAULT(now())
+);
+
+ALTER TABLE `order_status_history`
+ADD FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`);
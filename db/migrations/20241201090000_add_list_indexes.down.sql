@@ -0,0 +1,9 @@
+DROP INDEX `users_name_idx` ON `users`;
+DROP INDEX `orders_total_price_idx` ON `orders`;
+DROP INDEX `orders_created_at_idx` ON `orders`;
+DROP INDEX `orders_status_idx` ON `orders`;
+DROP INDEX `orders_user_id_idx` ON `orders`;
+DROP INDEX `products_rating_idx` ON `products`;
+DROP INDEX `products_name_idx` ON `products`;
+DROP INDEX `products_price_idx` ON `products`;
+DROP INDEX `products_category_price_idx` ON `products`;
@@ -0,0 +1,5 @@
+ALTER TABLE `products`
+ADD COLUMN `currency` char(3) NOT NULL DEFAULT 'USD' AFTER `price`;
+
+ALTER TABLE `orders`
+ADD COLUMN `currency` char(3) NOT NULL DEFAULT 'USD' AFTER `payment_method`;
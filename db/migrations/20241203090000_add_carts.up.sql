@@ -0,0 +1,18 @@
+CREATE TABLE `carts` (
+    `id` VARCHAR(36) PRIMARY KEY NOT NULL,
+    `user_id` int,
+    `created_at` datetime NOT NULL DEFAULT(now()),
+    UNIQUE KEY `carts_user_id_key` (`user_id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `cart_items` (
+    `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `cart_id` VARCHAR(36) NOT NULL,
+    `product_id` int NOT NULL,
+    `quantity` int NOT NULL,
+    `created_at` datetime NOT NULL DEFAULT(now()),
+    UNIQUE KEY `cart_items_cart_id_product_id_key` (`cart_id`, `product_id`),
+    FOREIGN KEY (`cart_id`) REFERENCES `carts` (`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`product_id`) REFERENCES `products` (`id`) ON DELETE CASCADE
+);
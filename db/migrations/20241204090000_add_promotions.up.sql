@@ -0,0 +1,38 @@
+CREATE TABLE `promotions` (
+    `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `code` varchar(64) NOT NULL,
+    `description` varchar(255) NOT NULL DEFAULT '',
+    `kind` varchar(32) NOT NULL,
+    `percent_off` int NOT NULL DEFAULT 0,
+    `amount_off` decimal(10, 2) NOT NULL DEFAULT 0,
+    `currency` char(3) NOT NULL DEFAULT 'USD',
+    `buy_quantity` int NOT NULL DEFAULT 0,
+    `get_quantity` int NOT NULL DEFAULT 0,
+    `category` varchar(255) NOT NULL DEFAULT '',
+    `min_subtotal` decimal(10, 2) NOT NULL DEFAULT 0,
+    `max_uses` int,
+    `max_uses_per_user` int,
+    `uses` int NOT NULL DEFAULT 0,
+    `stackable` BOOLEAN NOT NULL DEFAULT FALSE,
+    `is_active` BOOLEAN NOT NULL DEFAULT TRUE,
+    `starts_at` datetime,
+    `ends_at` datetime,
+    `created_at` datetime NOT NULL DEFAULT(now()),
+    UNIQUE KEY `promotions_code_key` (`code`)
+);
+
+CREATE TABLE `order_discounts` (
+    `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `order_id` int NOT NULL,
+    `promotion_id` int NOT NULL,
+    `code` varchar(64) NOT NULL,
+    `description` varchar(255) NOT NULL DEFAULT '',
+    `amount` decimal(10, 2) NOT NULL,
+    FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`),
+    FOREIGN KEY (`promotion_id`) REFERENCES `promotions` (`id`)
+);
+
+CREATE INDEX `order_discounts_promotion_id_idx` ON `order_discounts` (`promotion_id`, `order_id`);
+
+ALTER TABLE `orders`
+ADD COLUMN `discount_price` decimal(10, 2) NOT NULL DEFAULT 0 AFTER `shipping_price`;
@@ -0,0 +1,15 @@
+CREATE TABLE `payments` (
+    `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `order_id` int NOT NULL,
+    `provider` varchar(32) NOT NULL,
+    `operation` varchar(32) NOT NULL,
+    `status` varchar(32) NOT NULL,
+    `amount` decimal(10, 2) NOT NULL,
+    `currency` char(3) NOT NULL,
+    `reference` varchar(255) NOT NULL DEFAULT '',
+    `error` varchar(255) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT(now()),
+    FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`)
+);
+
+CREATE INDEX `payments_reference_idx` ON `payments` (`provider`, `reference`);
@@ -0,0 +1,15 @@
+CREATE TABLE `webhook_events` (
+    `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `provider` varchar(32) NOT NULL,
+    `event_id` varchar(255) NOT NULL,
+    `type` varchar(64) NOT NULL,
+    `payload` text NOT NULL,
+    `status` varchar(32) NOT NULL,
+    `error` varchar(255) NOT NULL DEFAULT '',
+    `attempts` int NOT NULL DEFAULT 0,
+    `created_at` datetime NOT NULL DEFAULT(now()),
+    `processed_at` datetime,
+    UNIQUE KEY `webhook_events_provider_event_id_key` (`provider`, `event_id`)
+);
+
+CREATE INDEX `webhook_events_status_idx` ON `webhook_events` (`status`);
@@ -0,0 +1,27 @@
+CREATE TABLE `refunds` (
+    `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `order_id` int NOT NULL,
+    `amount` decimal(10, 2) NOT NULL,
+    `shipping_amount` decimal(10, 2) NOT NULL DEFAULT 0,
+    `currency` char(3) NOT NULL,
+    `reason` varchar(32) NOT NULL,
+    `note` varchar(255) NOT NULL DEFAULT '',
+    `restock` BOOLEAN NOT NULL DEFAULT FALSE,
+    `reference` varchar(255) NOT NULL DEFAULT '',
+    `actor` varchar(255) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT(now()),
+    FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`)
+);
+
+CREATE TABLE `refund_items` (
+    `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `refund_id` int NOT NULL,
+    `order_item_id` int NOT NULL,
+    `quantity` int NOT NULL,
+    `amount` decimal(10, 2) NOT NULL,
+    FOREIGN KEY (`refund_id`) REFERENCES `refunds` (`id`),
+    FOREIGN KEY (`order_item_id`) REFERENCES `order_items` (`id`)
+);
+
+ALTER TABLE `orders`
+ADD COLUMN `refunded_price` decimal(10, 2) NOT NULL DEFAULT 0 AFTER `total_price`;
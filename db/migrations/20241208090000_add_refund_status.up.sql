@@ -0,0 +1,2 @@
+ALTER TABLE `refunds`
+ADD COLUMN `status` varchar(16) NOT NULL DEFAULT 'succeeded' AFTER `order_id`;
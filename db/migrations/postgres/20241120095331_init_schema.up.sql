@@ -0,0 +1,32 @@
+CREATE TABLE "products" (
+    "id" BIGSERIAL PRIMARY KEY,
+    "name" VARCHAR(255) NOT NULL,
+    "image" VARCHAR(255) NOT NULL,
+    "description" TEXT,
+    "rating" INT NOT NULL,
+    "num_reviews" INT NOT NULL DEFAULT 0,
+    "price" NUMERIC(10, 2) NOT NULL,
+    "count_in_stock" INT NOT NULL,
+    "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+    "updated_at" TIMESTAMP
+);
+
+CREATE TABLE "orders" (
+    "id" BIGSERIAL PRIMARY KEY,
+    "payment_method" VARCHAR(255) NOT NULL,
+    "tax_price" NUMERIC(10, 2) NOT NULL,
+    "shipping_price" NUMERIC(10, 2) NOT NULL,
+    "total_price" NUMERIC(10, 2) NOT NULL,
+    "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+    "updated_at" TIMESTAMP
+);
+
+CREATE TABLE "order_items" (
+    "id" BIGSERIAL PRIMARY KEY,
+    "order_id" BIGINT NOT NULL REFERENCES "orders" ("id"),
+    "product_id" BIGINT NOT NULL REFERENCES "products" ("id"),
+    "name" VARCHAR(255) NOT NULL,
+    "quantity" INT NOT NULL,
+    "image" VARCHAR(255) NOT NULL,
+    "price" NUMERIC(10, 2) NOT NULL
+);
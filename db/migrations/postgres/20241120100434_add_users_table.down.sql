@@ -0,0 +1,3 @@
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "user_id";
+
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,13 @@
+CREATE TABLE "users" (
+    "id" BIGSERIAL PRIMARY KEY,
+    "name" VARCHAR(255) NOT NULL,
+    "email" VARCHAR(255) NOT NULL,
+    "password" VARCHAR(255) NOT NULL,
+    "is_admin" BOOLEAN NOT NULL DEFAULT FALSE,
+    "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+    "updated_at" TIMESTAMP DEFAULT now()
+);
+
+ALTER TABLE "orders"
+ADD COLUMN "user_id" BIGINT NOT NULL,
+ADD CONSTRAINT "user_id_fk" FOREIGN KEY ("user_id") REFERENCES "users" ("id");
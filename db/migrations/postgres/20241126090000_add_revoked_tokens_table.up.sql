@@ -0,0 +1,7 @@
+CREATE TABLE "revoked_tokens" (
+    "id" VARCHAR(255) PRIMARY KEY,
+    "expires_at" TIMESTAMP NOT NULL,
+    "created_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "revoked_tokens_expires_at_idx" ON "revoked_tokens" ("expires_at");
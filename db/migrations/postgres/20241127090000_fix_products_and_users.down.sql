@@ -0,0 +1 @@
+ALTER TABLE "products" DROP COLUMN "category";
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE "products"
+ADD COLUMN "category" VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+ALTER TABLE "products"
+ADD COLUMN "is_active" BOOLEAN NOT NULL DEFAULT TRUE;
+
+ALTER TABLE "orders"
+ADD COLUMN "items_price" NUMERIC(10, 2) NOT NULL DEFAULT 0;
@@ -0,0 +1,13 @@
+ALTER TABLE "orders"
+ADD COLUMN "status" VARCHAR(32) NOT NULL DEFAULT 'pending';
+
+CREATE TABLE "order_status_history" (
+    "id" BIGSERIAL PRIMARY KEY,
+    "order_id" BIGINT NOT NULL REFERENCES "orders" ("id"),
+    "from_status" VARCHAR(32) NOT NULL,
+    "to_status" VARCHAR(32) NOT NULL,
+    "actor" VARCHAR(255) NOT NULL,
+    "created_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "order_status_history_order_id_idx" ON "order_status_history" ("order_id");
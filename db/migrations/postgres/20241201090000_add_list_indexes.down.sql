@@ -0,0 +1,9 @@
+DROP INDEX "users_name_idx";
+DROP INDEX "orders_total_price_idx";
+DROP INDEX "orders_created_at_idx";
+DROP INDEX "orders_status_idx";
+DROP INDEX "orders_user_id_idx";
+DROP INDEX "products_rating_idx";
+DROP INDEX "products_name_idx";
+DROP INDEX "products_price_idx";
+DROP INDEX "products_category_price_idx";
@@ -0,0 +1,9 @@
+CREATE INDEX "products_category_price_idx" ON "products" ("category", "price", "id");
+CREATE INDEX "products_price_idx" ON "products" ("price", "id");
+CREATE INDEX "products_name_idx" ON "products" ("name", "id");
+CREATE INDEX "products_rating_idx" ON "products" ("rating", "id");
+CREATE INDEX "orders_user_id_idx" ON "orders" ("user_id", "id");
+CREATE INDEX "orders_status_idx" ON "orders" ("status", "id");
+CREATE INDEX "orders_created_at_idx" ON "orders" ("created_at");
+CREATE INDEX "orders_total_price_idx" ON "orders" ("total_price", "id");
+CREATE INDEX "users_name_idx" ON "users" ("name", "id");
@@ -0,0 +1,3 @@
+ALTER TABLE "orders" DROP COLUMN "currency";
+
+ALTER TABLE "products" DROP COLUMN "currency";
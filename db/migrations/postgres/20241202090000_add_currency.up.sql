@@ -0,0 +1,5 @@
+ALTER TABLE "products"
+ADD COLUMN "currency" CHAR(3) NOT NULL DEFAULT 'USD';
+
+ALTER TABLE "orders"
+ADD COLUMN "currency" CHAR(3) NOT NULL DEFAULT 'USD';
@@ -0,0 +1,14 @@
+CREATE TABLE "carts" (
+    "id" VARCHAR(36) PRIMARY KEY,
+    "user_id" BIGINT UNIQUE REFERENCES "users" ("id") ON DELETE CASCADE,
+    "created_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE TABLE "cart_items" (
+    "id" BIGSERIAL PRIMARY KEY,
+    "cart_id" VARCHAR(36) NOT NULL REFERENCES "carts" ("id") ON DELETE CASCADE,
+    "product_id" BIGINT NOT NULL REFERENCES "products" ("id") ON DELETE CASCADE,
+    "quantity" BIGINT NOT NULL,
+    "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+    UNIQUE ("cart_id", "product_id")
+);
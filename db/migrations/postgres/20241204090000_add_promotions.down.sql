@@ -0,0 +1,5 @@
+ALTER TABLE "orders" DROP COLUMN "discount_price";
+
+DROP TABLE "order_discounts";
+
+DROP TABLE "promotions";
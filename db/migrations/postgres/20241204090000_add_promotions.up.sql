@@ -0,0 +1,35 @@
+CREATE TABLE "promotions" (
+    "id" BIGSERIAL PRIMARY KEY,
+    "code" VARCHAR(64) NOT NULL UNIQUE,
+    "description" VARCHAR(255) NOT NULL DEFAULT '',
+    "kind" VARCHAR(32) NOT NULL,
+    "percent_off" BIGINT NOT NULL DEFAULT 0,
+    "amount_off" NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    "currency" CHAR(3) NOT NULL DEFAULT 'USD',
+    "buy_quantity" BIGINT NOT NULL DEFAULT 0,
+    "get_quantity" BIGINT NOT NULL DEFAULT 0,
+    "category" VARCHAR(255) NOT NULL DEFAULT '',
+    "min_subtotal" NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    "max_uses" BIGINT,
+    "max_uses_per_user" BIGINT,
+    "uses" BIGINT NOT NULL DEFAULT 0,
+    "stackable" BOOLEAN NOT NULL DEFAULT FALSE,
+    "is_active" BOOLEAN NOT NULL DEFAULT TRUE,
+    "starts_at" TIMESTAMP,
+    "ends_at" TIMESTAMP,
+    "created_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE TABLE "order_discounts" (
+    "id" BIGSERIAL PRIMARY KEY,
+    "order_id" BIGINT NOT NULL REFERENCES "orders" ("id"),
+    "promotion_id" BIGINT NOT NULL REFERENCES "promotions" ("id"),
+    "code" VARCHAR(64) NOT NULL,
+    "description" VARCHAR(255) NOT NULL DEFAULT '',
+    "amount" NUMERIC(10, 2) NOT NULL
+);
+
+CREATE INDEX "order_discounts_promotion_id_idx" ON "order_discounts" ("promotion_id", "order_id");
+
+ALTER TABLE "orders"
+ADD COLUMN "discount_price" NUMERIC(10, 2) NOT NULL DEFAULT 0;
@@ -0,0 +1,16 @@
+CREATE TABLE "payments" (
+    "id" BIGSERIAL PRIMARY KEY,
+    "order_id" BIGINT NOT NULL REFERENCES "orders" ("id"),
+    "provider" VARCHAR(32) NOT NULL,
+    "operation" VARCHAR(32) NOT NULL,
+    "status" VARCHAR(32) NOT NULL,
+    "amount" NUMERIC(10, 2) NOT NULL,
+    "currency" CHAR(3) NOT NULL,
+    "reference" VARCHAR(255) NOT NULL DEFAULT '',
+    "error" VARCHAR(255) NOT NULL DEFAULT '',
+    "created_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "payments_order_id_idx" ON "payments" ("order_id");
+
+CREATE INDEX "payments_reference_idx" ON "payments" ("provider", "reference");
@@ -0,0 +1,5 @@
+ALTER TABLE "orders" DROP COLUMN "refunded_price";
+
+DROP TABLE "refund_items";
+
+DROP TABLE "refunds";
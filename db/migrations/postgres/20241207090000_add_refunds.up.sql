@@ -0,0 +1,30 @@
+CREATE TABLE "refunds" (
+    "id" BIGSERIAL PRIMARY KEY,
+    "order_id" BIGINT NOT NULL REFERENCES "orders" ("id"),
+    "amount" NUMERIC(10, 2) NOT NULL,
+    "shipping_amount" NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    "currency" CHAR(3) NOT NULL,
+    "reason" VARCHAR(32) NOT NULL,
+    "note" VARCHAR(255) NOT NULL DEFAULT '',
+    "restock" BOOLEAN NOT NULL DEFAULT FALSE,
+    "reference" VARCHAR(255) NOT NULL DEFAULT '',
+    "actor" VARCHAR(255) NOT NULL DEFAULT '',
+    "created_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "refunds_order_id_idx" ON "refunds" ("order_id");
+
+CREATE TABLE "refund_items" (
+    "id" BIGSERIAL PRIMARY KEY,
+    "refund_id" BIGINT NOT NULL REFERENCES "refunds" ("id"),
+    "order_item_id" BIGINT NOT NULL REFERENCES "order_items" ("id"),
+    "quantity" BIGINT NOT NULL,
+    "amount" NUMERIC(10, 2) NOT NULL
+);
+
+CREATE INDEX "refund_items_refund_id_idx" ON "refund_items" ("refund_id");
+
+CREATE INDEX "refund_items_order_item_id_idx" ON "refund_items" ("order_item_id");
+
+ALTER TABLE "orders"
+ADD COLUMN "refunded_price" NUMERIC(10, 2) NOT NULL DEFAULT 0;
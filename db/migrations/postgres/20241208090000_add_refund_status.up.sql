@@ -0,0 +1,2 @@
+ALTER TABLE "refunds"
+ADD COLUMN "status" VARCHAR(16) NOT NULL DEFAULT 'succeeded';
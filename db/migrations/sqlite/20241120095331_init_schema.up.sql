@@ -0,0 +1,34 @@
+CREATE TABLE `products` (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `name` VARCHAR(255) NOT NULL,
+    `image` VARCHAR(255) NOT NULL,
+    `category` VARCHAR(255) NOT NULL DEFAULT '',
+    `description` TEXT,
+    `rating` INTEGER NOT NULL,
+    `num_reviews` INTEGER NOT NULL DEFAULT 0,
+    `price` NUMERIC(10, 2) NOT NULL,
+    `count_in_stock` INTEGER NOT NULL,
+    `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` DATETIME
+);
+
+CREATE TABLE `orders` (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `payment_method` VARCHAR(255) NOT NULL,
+    `tax_price` NUMERIC(10, 2) NOT NULL,
+    `shipping_price` NUMERIC(10, 2) NOT NULL,
+    `total_price` NUMERIC(10, 2) NOT NULL,
+    `user_id` INTEGER NOT NULL REFERENCES `users` (`id`),
+    `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` DATETIME
+);
+
+CREATE TABLE `order_items` (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `order_id` INTEGER NOT NULL REFERENCES `orders` (`id`),
+    `product_id` INTEGER NOT NULL REFERENCES `products` (`id`),
+    `name` VARCHAR(255) NOT NULL,
+    `quantity` INTEGER NOT NULL,
+    `image` VARCHAR(255) NOT NULL,
+    `price` NUMERIC(10, 2) NOT NULL
+);
@@ -0,0 +1,3 @@
+ALTER TABLE `products` ADD COLUMN `is_active` BOOLEAN NOT NULL DEFAULT 1;
+
+ALTER TABLE `orders` ADD COLUMN `items_price` NUMERIC(10, 2) NOT NULL DEFAULT 0;
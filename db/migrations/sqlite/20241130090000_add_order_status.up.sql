@@ -0,0 +1,12 @@
+ALTER TABLE `orders` ADD COLUMN `status` VARCHAR(32) NOT NULL DEFAULT 'pending';
+
+CREATE TABLE `order_status_history` (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `order_id` INTEGER NOT NULL REFERENCES `orders` (`id`),
+    `from_status` VARCHAR(32) NOT NULL,
+    `to_status` VARCHAR(32) NOT NULL,
+    `actor` VARCHAR(255) NOT NULL,
+    `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX `order_status_history_order_id_idx` ON `order_status_history` (`order_id`);
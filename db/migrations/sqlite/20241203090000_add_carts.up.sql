@@ -0,0 +1,14 @@
+CREATE TABLE `carts` (
+    `id` VARCHAR(36) PRIMARY KEY NOT NULL,
+    `user_id` INTEGER UNIQUE REFERENCES `users` (`id`) ON DELETE CASCADE,
+    `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE `cart_items` (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `cart_id` VARCHAR(36) NOT NULL REFERENCES `carts` (`id`) ON DELETE CASCADE,
+    `product_id` INTEGER NOT NULL REFERENCES `products` (`id`) ON DELETE CASCADE,
+    `quantity` INTEGER NOT NULL,
+    `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (`cart_id`, `product_id`)
+);
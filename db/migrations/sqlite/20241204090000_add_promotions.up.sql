@@ -0,0 +1,34 @@
+CREATE TABLE `promotions` (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `code` VARCHAR(64) NOT NULL UNIQUE,
+    `description` VARCHAR(255) NOT NULL DEFAULT '',
+    `kind` VARCHAR(32) NOT NULL,
+    `percent_off` INTEGER NOT NULL DEFAULT 0,
+    `amount_off` NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    `currency` CHAR(3) NOT NULL DEFAULT 'USD',
+    `buy_quantity` INTEGER NOT NULL DEFAULT 0,
+    `get_quantity` INTEGER NOT NULL DEFAULT 0,
+    `category` VARCHAR(255) NOT NULL DEFAULT '',
+    `min_subtotal` NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    `max_uses` INTEGER,
+    `max_uses_per_user` INTEGER,
+    `uses` INTEGER NOT NULL DEFAULT 0,
+    `stackable` BOOLEAN NOT NULL DEFAULT 0,
+    `is_active` BOOLEAN NOT NULL DEFAULT 1,
+    `starts_at` DATETIME,
+    `ends_at` DATETIME,
+    `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE `order_discounts` (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `order_id` INTEGER NOT NULL REFERENCES `orders` (`id`),
+    `promotion_id` INTEGER NOT NULL REFERENCES `promotions` (`id`),
+    `code` VARCHAR(64) NOT NULL,
+    `description` VARCHAR(255) NOT NULL DEFAULT '',
+    `amount` NUMERIC(10, 2) NOT NULL
+);
+
+CREATE INDEX `order_discounts_promotion_id_idx` ON `order_discounts` (`promotion_id`, `order_id`);
+
+ALTER TABLE `orders` ADD COLUMN `discount_price` NUMERIC(10, 2) NOT NULL DEFAULT 0;
@@ -0,0 +1,15 @@
+CREATE TABLE `webhook_events` (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `provider` VARCHAR(32) NOT NULL,
+    `event_id` VARCHAR(255) NOT NULL,
+    `type` VARCHAR(64) NOT NULL,
+    `payload` TEXT NOT NULL,
+    `status` VARCHAR(32) NOT NULL,
+    `error` VARCHAR(255) NOT NULL DEFAULT '',
+    `attempts` INTEGER NOT NULL DEFAULT 0,
+    `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `processed_at` DATETIME,
+    UNIQUE (`provider`, `event_id`)
+);
+
+CREATE INDEX `webhook_events_status_idx` ON `webhook_events` (`status`);
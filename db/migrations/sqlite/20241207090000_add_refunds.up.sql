@@ -0,0 +1,29 @@
+CREATE TABLE `refunds` (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `order_id` INTEGER NOT NULL REFERENCES `orders` (`id`),
+    `amount` NUMERIC(10, 2) NOT NULL,
+    `shipping_amount` NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    `currency` CHAR(3) NOT NULL,
+    `reason` VARCHAR(32) NOT NULL,
+    `note` VARCHAR(255) NOT NULL DEFAULT '',
+    `restock` BOOLEAN NOT NULL DEFAULT 0,
+    `reference` VARCHAR(255) NOT NULL DEFAULT '',
+    `actor` VARCHAR(255) NOT NULL DEFAULT '',
+    `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX `refunds_order_id_idx` ON `refunds` (`order_id`);
+
+CREATE TABLE `refund_items` (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `refund_id` INTEGER NOT NULL REFERENCES `refunds` (`id`),
+    `order_item_id` INTEGER NOT NULL REFERENCES `order_items` (`id`),
+    `quantity` INTEGER NOT NULL,
+    `amount` NUMERIC(10, 2) NOT NULL
+);
+
+CREATE INDEX `refund_items_refund_id_idx` ON `refund_items` (`refund_id`);
+
+CREATE INDEX `refund_items_order_item_id_idx` ON `refund_items` (`order_item_id`);
+
+ALTER TABLE `orders` ADD COLUMN `refunded_price` NUMERIC(10, 2) NOT NULL DEFAULT 0;
@@ -0,0 +1,22 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	_ "modernc.org/sqlite"
+)
+
+// NewSQLiteDatabase opens the SQLite database at path, which may be
+// ":memory:". The schema is created by MigrateUp.
+func NewSQLiteDatabase(path string) (*Database, error) {
+	db, err := sqlx.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite")
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+	// SQLite allows a single writer, and every connection to an in-memory
+	// database would otherwise get its own empty database.
+	db.SetMaxOpenConns(1)
+
+	return &Database{db: db}, nil
+}
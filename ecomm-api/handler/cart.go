@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gauss2302/ecomm-service/ecomm-api/server"
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/go-chi/chi"
+)
+
+// cartIDHeader carries the ID of an anonymous cart. Responses about an
+// anonymous cart set it, and clients send it back with later cart requests
+// and when logging in, to merge the cart into that of the user.
+const cartIDHeader = "X-Cart-ID"
+
+// cartOwner identifies the cart of a request: the cart of the logged in user,
+// or else the anonymous cart named by the X-Cart-ID header, if any.
+func cartOwner(r *http.Request) (int64, string) {
+	if claims, ok := claimsFromContext(r.Context()); ok {
+		return claims.ID, ""
+	}
+	return 0, r.Header.Get(cartIDHeader)
+}
+
+// getCart shows the cart of the request, which is empty if it has none yet.
+func (h *handler) getCart(w http.ResponseWriter, r *http.Request) {
+	userID, cartID := cartOwner(r)
+	c, err := h.server.FindCart(r.Context(), userID, cartID)
+	if err != nil {
+		if !errors.Is(err, storer.ErrNotFound) {
+			writeError(w, r, err, "error getting cart")
+			return
+		}
+		c = &storer.Cart{}
+	}
+
+	h.writeCart(w, r, c)
+}
+
+// addCartItem adds a product to the cart of the request, starting a cart if
+// it has none.
+func (h *handler) addCartItem(w http.ResponseWriter, r *http.Request) {
+	var req CartItemReq
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	userID, cartID := cartOwner(r)
+	c, err := h.server.OpenCart(r.Context(), userID, cartID)
+	if err != nil {
+		writeError(w, r, err, "error opening cart")
+		return
+	}
+
+	if err := h.server.AddCartItem(r.Context(), c, req.ProductID, req.Quantity); err != nil {
+		writeError(w, r, err, "error adding cart item")
+		return
+	}
+
+	h.reloadCart(w, r, userID, c.ID)
+}
+
+// updateCartItem sets the quantity of a product in the cart.
+func (h *handler) updateCartItem(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.ParseInt(chi.URLParam(r, "productID"), 10, 64)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "error parsing product ID")
+		return
+	}
+
+	var req UpdateCartItemReq
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	userID, cartID := cartOwner(r)
+	c, err := h.server.FindCart(r.Context(), userID, cartID)
+	if err != nil {
+		writeError(w, r, err, "error getting cart")
+		return
+	}
+
+	if err := h.server.UpdateCartItem(r.Context(), c, productID, req.Quantity); err != nil {
+		writeError(w, r, err, "error updating cart item")
+		return
+	}
+
+	h.reloadCart(w, r, userID, c.ID)
+}
+
+func (h *handler) removeCartItem(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.ParseInt(chi.URLParam(r, "productID"), 10, 64)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "error parsing product ID")
+		return
+	}
+
+	userID, cartID := cartOwner(r)
+	c, err := h.server.FindCart(r.Context(), userID, cartID)
+	if err != nil {
+		writeError(w, r, err, "error getting cart")
+		return
+	}
+
+	if err := h.server.RemoveCartItem(r.Context(), c, productID); err != nil {
+		writeError(w, r, err, "error removing cart item")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkoutCart places an order for the items in the cart of the user.
+func (h *handler) checkoutCart(w http.ResponseWriter, r *http.Request) {
+	var req CheckoutReq
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	c, err := h.server.FindCart(r.Context(), claims.ID, "")
+	if err != nil {
+		if !errors.Is(err, storer.ErrNotFound) {
+			writeError(w, r, err, "error getting cart")
+			return
+		}
+		c = &storer.Cart{}
+	}
+
+	order, err := h.server.Checkout(r.Context(), c, claims.ID, req.PaymentMethod, req.Coupons...)
+	if err != nil {
+		writeError(w, r, err, "error checking out cart")
+		return
+	}
+
+	res := toOrderRes(order)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+// reloadCart writes the cart cartID as it is after a change.
+func (h *handler) reloadCart(w http.ResponseWriter, r *http.Request, userID int64, cartID string) {
+	c, err := h.server.FindCart(r.Context(), userID, cartID)
+	if err != nil {
+		writeError(w, r, err, "error getting cart")
+		return
+	}
+
+	h.writeCart(w, r, c)
+}
+
+func (h *handler) writeCart(w http.ResponseWriter, r *http.Request, c *storer.Cart) {
+	v, err := h.server.ViewCart(r.Context(), c)
+	if err != nil {
+		writeError(w, r, err, "error pricing cart")
+		return
+	}
+
+	res := toCartRes(v)
+	if res.ID != "" {
+		w.Header().Set(cartIDHeader, res.ID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func toCartRes(v *server.CartView) CartRes {
+	res := CartRes{
+		Items:      []CartItemRes{},
+		Currency:   v.ItemsPrice.Currency,
+		ItemsPrice: v.ItemsPrice,
+	}
+	if v.Cart.UserID == nil {
+		res.ID = v.Cart.ID
+	}
+
+	for _, l := range v.Lines {
+		item := CartItemRes{
+			ProductID: l.Item.ProductID,
+			Quantity:  l.Item.Quantity,
+			Price:     l.Price,
+			Warning:   string(l.Warning),
+		}
+		if p := l.Product; p != nil {
+			item.Name = p.Name
+			item.Image = p.Image
+			item.UnitPrice = p.Price
+			item.CountInStock = p.CountInStock
+		}
+		res.Items = append(res.Items, item)
+	}
+
+	return res
+}
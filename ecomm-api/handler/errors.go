@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/payments"
+)
+
+// statusClientClosedRequest is the non-standard status, borrowed from nginx,
+// logged when the client went away before a response could be written.
+const statusClientClosedRequest = 499
+
+// problem is an RFC 7807 problem details object.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Errors lists the rejected fields of an invalid request.
+	Errors []fieldError `json:"errors,omitempty"`
+	// Shortfalls lists the order items that are out of stock.
+	Shortfalls []stockShortfall `json:"shortfalls,omitempty"`
+}
+
+type stockShortfall struct {
+	ProductID int64 `json:"product_id"`
+	Requested int64 `json:"requested"`
+	Available int64 `json:"available"`
+}
+
+// writeProblem writes an application/problem+json response. Problems carry
+// no type of their own, so the title is the reason phrase of status.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	encodeProblem(w, r, problem{Status: status, Detail: detail})
+}
+
+// writeFieldProblem writes a 422 problem listing the rejected fields.
+func writeFieldProblem(w http.ResponseWriter, r *http.Request, detail string, fields []fieldError) {
+	encodeProblem(w, r, problem{
+		Status: http.StatusUnprocessableEntity,
+		Detail: detail,
+		Errors: fields,
+	})
+}
+
+func encodeProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+	if p.Status == statusClientClosedRequest {
+		p.Title = "Client Closed Request"
+	}
+	p.Instance = r.URL.Path
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeError writes detail as a problem with a status derived from err.
+// Errors that are not classified by the storer are logged and reported as
+// 500 without exposing err to the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("%s %s: %s: %v", r.Method, r.URL.Path, detail, err)
+	}
+	p := problem{Status: status, Detail: detail}
+
+	var verr *storer.ValidationError
+	if errors.As(err, &verr) {
+		p.Detail = fmt.Sprintf("%s: %s", detail, verr.Reason)
+	}
+
+	var cerr *storer.ConflictError
+	if errors.As(err, &cerr) {
+		p.Detail = fmt.Sprintf("%s: %s", detail, cerr.Reason)
+	}
+
+	var derr *payments.DeclinedError
+	if errors.As(err, &derr) {
+		p.Detail = fmt.Sprintf("%s: %s", detail, derr.Reason)
+	}
+
+	var stockErr *storer.InsufficientStockError
+	if errors.As(err, &stockErr) {
+		p.Detail = fmt.Sprintf("%s: insufficient stock", detail)
+		for _, s := range stockErr.Shortfalls {
+			p.Shortfalls = append(p.Shortfalls, stockShortfall{
+				ProductID: s.ProductID,
+				Requested: s.Requested,
+				Available: s.Available,
+			})
+		}
+	}
+
+	encodeProblem(w, r, p)
+}
+
+// errorStatus maps the errors returned by the storer, and declined payments,
+// to HTTP statuses.
+// Queries abandoned because the request was canceled or timed out are not
+// server faults and are reported as 499 and 504 respectively.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, storer.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storer.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, storer.ErrValidation), errors.Is(err, storer.ErrConstraint):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, payments.ErrDeclined):
+		return http.StatusPaymentRequired
+	case errors.Is(err, payments.ErrInvalidSignature):
+		return http.StatusUnauthorized
+	case errors.Is(err, storer.ErrCanceled):
+		if errors.Is(err, context.DeadlineExceeded) {
+			return http.StatusGatewayTimeout
+		}
+		return statusClientClosedRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -3,27 +3,35 @@ package handler
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"github.com/gauss2302/ecomm-service/utils"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/gauss2302/ecomm-service/config"
 	"github.com/gauss2302/ecomm-service/ecomm-api/server"
 	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/token"
+	"github.com/gauss2302/ecomm-service/utils"
 	"github.com/go-chi/chi"
 )
 
 type handler struct {
-	ctx    context.Context
-	server *server.Server
+	server               *server.Server
+	tokenMaker           token.Maker
+	accessTokenDuration  time.Duration
+	refreshTokenDuration time.Duration
+	payments             config.PaymentsConfig
 }
 
-func NewHandler(server *server.Server) *handler {
+func NewHandler(server *server.Server, tokenMaker token.Maker, accessTokenDuration, refreshTokenDuration time.Duration, payments config.PaymentsConfig) *handler {
 	return &handler{
-		ctx:    context.Background(),
-		server: server,
+		server:               server,
+		tokenMaker:           tokenMaker,
+		accessTokenDuration:  accessTokenDuration,
+		refreshTokenDuration: refreshTokenDuration,
+		payments:             payments,
 	}
 }
 
@@ -31,9 +39,7 @@ func (h *handler) createProduct(w http.ResponseWriter, r *http.Request) {
 	log.Println("Starting createProduct handler")
 
 	var p ProductReq
-	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
-		log.Printf("Error decoding request body: %v", err)
-		http.Error(w, fmt.Sprintf("error decoding request body: %v", err), http.StatusBadRequest)
+	if !decodeAndValidate(w, r, &p) {
 		return
 	}
 
@@ -42,10 +48,10 @@ func (h *handler) createProduct(w http.ResponseWriter, r *http.Request) {
 	product := toStorerProduct(p)
 	log.Printf("Converted to storer product: %+v", product)
 
-	createdProduct, err := h.server.CreateProduct(h.ctx, product)
+	createdProduct, err := h.server.CreateProduct(r.Context(), product)
 	if err != nil {
 		log.Printf("Error in server.CreateProduct: %v", err)
-		http.Error(w, fmt.Sprintf("error creating product: %v", err), http.StatusInternalServerError)
+		writeError(w, r, err, "error creating product")
 		return
 	}
 
@@ -55,8 +61,8 @@ func (h *handler) createProduct(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(res); err != nil {
+		// the status line has already been sent, so all we can do is log
 		log.Printf("Error encoding response: %v", err)
-		http.Error(w, "error encoding response", http.StatusInternalServerError)
 		return
 	}
 
@@ -67,13 +73,13 @@ func (h *handler) getProduct(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	i, err := strconv.ParseInt(id, 10, 64)
 	if err != nil {
-		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "error parsing ID")
 		return
 	}
 
-	product, err := h.server.GetProduct(h.ctx, i)
+	product, err := h.server.GetProduct(r.Context(), i)
 	if err != nil {
-		http.Error(w, "error getting product", http.StatusInternalServerError)
+		writeError(w, r, err, "error getting product")
 		return
 	}
 
@@ -83,18 +89,33 @@ func (h *handler) getProduct(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+// listProducts returns a page of the catalogue, filtered by category, price
+// range and stock.
 func (h *handler) listProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := h.server.ListProducts(h.ctx)
+	q := newQueryParams(r)
+	f := storer.ProductFilter{
+		Category: q.values.Get("category"),
+		MinPrice: q.money("min_price"),
+		MaxPrice: q.money("max_price"),
+		InStock:  q.bool("in_stock"),
+		Page:     q.page(),
+	}
+	if !q.valid(w, r) {
+		return
+	}
+
+	products, next, err := h.server.ListProducts(r.Context(), f)
 	if err != nil {
-		http.Error(w, "error listing products", http.StatusInternalServerError)
+		writeError(w, r, err, "error listing products")
 		return
 	}
 
-	var res []ProductRes
+	res := ListProductsRes{Products: []ProductRes{}, NextCursor: encodeCursor(next)}
 	for _, p := range products {
-		res = append(res, toProductRes(&p))
+		res.Products = append(res.Products, toProductRes(&p))
 	}
 
+	setNextLink(w, r, res.NextCursor)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(res)
@@ -104,31 +125,40 @@ func (h *handler) updateProduct(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	i, err := strconv.ParseInt(id, 10, 64)
 	if err != nil {
-		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "error parsing ID")
 		return
 	}
 
 	var p ProductReq
-	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
-		http.Error(w, "error decoding request body", http.StatusBadRequest)
+	if !decodeAndValidatePatch(w, r, &p) {
 		return
 	}
 
-	product, err := h.server.GetProduct(h.ctx, i)
+	product, err := h.server.GetProduct(r.Context(), i)
 	if err != nil {
-		http.Error(w, "error getting product", http.StatusInternalServerError)
+		writeError(w, r, err, "error getting product")
 		return
 	}
 
 	// patch our product request
 	patchProductReq(product, p)
 
-	updated, err := h.server.UpdateProduct(h.ctx, product)
+	updated, err := h.server.UpdateProduct(r.Context(), product)
 	if err != nil {
-		http.Error(w, "error updating product", http.StatusInternalServerError)
+		writeError(w, r, err, "error updating product")
 		return
 	}
 
+	// the stock is only written when it is set, so that editing a product
+	// does not undo the stock taken by the orders placed since it was read
+	if p.CountInStock != nil {
+		if err := h.server.SetProductStock(r.Context(), i, *p.CountInStock); err != nil {
+			writeError(w, r, err, "error setting product stock")
+			return
+		}
+		updated.CountInStock = *p.CountInStock
+	}
+
 	res := toProductRes(updated)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -139,12 +169,12 @@ func (h *handler) deleteProduct(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	i, err := strconv.ParseInt(id, 10, 64)
 	if err != nil {
-		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "error parsing ID")
 		return
 	}
 
-	if err := h.server.DeleteProduct(h.ctx, i); err != nil {
-		http.Error(w, "error deleting product", http.StatusInternalServerError)
+	if err := h.server.DeleteProduct(r.Context(), i); err != nil {
+		writeError(w, r, err, "error deleting product")
 		return
 	}
 
@@ -152,6 +182,15 @@ func (h *handler) deleteProduct(w http.ResponseWriter, r *http.Request) {
 }
 
 func toStorerProduct(p ProductReq) *storer.Product {
+	isActive := true
+	if p.IsActive != nil {
+		isActive = *p.IsActive
+	}
+	var countInStock int64
+	if p.CountInStock != nil {
+		countInStock = *p.CountInStock
+	}
+
 	return &storer.Product{
 		Name:         p.Name,
 		Image:        p.Image,
@@ -160,7 +199,9 @@ func toStorerProduct(p ProductReq) *storer.Product {
 		Rating:       p.Rating,
 		NumReviews:   p.NumReviews,
 		Price:        p.Price,
-		CountInStock: p.CountInStock,
+		Currency:     p.Currency,
+		CountInStock: countInStock,
+		IsActive:     isActive,
 	}
 }
 
@@ -174,7 +215,9 @@ func toProductRes(p *storer.Product) ProductRes {
 		Rating:       p.Rating,
 		NumReviews:   p.NumReviews,
 		Price:        p.Price,
+		Currency:     p.Currency,
 		CountInStock: p.CountInStock,
+		IsActive:     p.IsActive,
 		CreatedAt:    p.CreatedAt,
 		UpdatedAt:    p.UpdatedAt,
 	}
@@ -199,11 +242,14 @@ func patchProductReq(product *storer.Product, p ProductReq) {
 	if p.NumReviews != 0 {
 		product.NumReviews = p.NumReviews
 	}
-	if p.Price != 0 {
+	if !p.Price.IsZero() {
 		product.Price = p.Price
 	}
-	if p.CountInStock != 0 {
-		product.CountInStock = p.CountInStock
+	if p.Currency != "" {
+		product.Currency = p.Currency
+	}
+	if p.IsActive != nil {
+		product.IsActive = *p.IsActive
 	}
 	product.UpdatedAt = toTimePtr(time.Now())
 }
@@ -214,14 +260,22 @@ func toTimePtr(t time.Time) *time.Time {
 
 func (h *handler) createOrder(w http.ResponseWriter, r *http.Request) {
 	var o OrderReq
-	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+	if !decodeAndValidate(w, r, &o) {
+		return
+	}
+
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "unauthorized")
 		return
 	}
 
-	created, err := h.server.CreateOrder(h.ctx, toStorerOrder(o))
+	so := toStorerOrder(o)
+	so.UserID = claims.ID
+
+	created, err := h.server.CreateOrder(r.Context(), so, o.Coupons...)
 	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeError(w, r, err, "error creating order")
 		return
 	}
 
@@ -231,16 +285,17 @@ func (h *handler) createOrder(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+// getOrder returns an order to its owner or an admin.
 func (h *handler) getOrder(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	i, err := strconv.ParseInt(id, 10, 64)
 	if err != nil {
-		panic(err)
+		writeProblem(w, r, http.StatusBadRequest, "error parsing ID")
+		return
 	}
 
-	order, err := h.server.GetOrder(h.ctx, i)
-	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+	order, ok := h.getOwnOrder(w, r, i)
+	if !ok {
 		return
 	}
 
@@ -249,56 +304,189 @@ func (h *handler) getOrder(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+// getOwnOrder gets order id if it belongs to the caller or the caller is an
+// admin. The orders of other users are reported as not found, so that their
+// IDs are not given away; false is returned once the response is written.
+func (h *handler) getOwnOrder(w http.ResponseWriter, r *http.Request, id int64) (*storer.Order, bool) {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+
+	order, err := h.server.GetOrder(r.Context(), id)
+	if err == nil && !claims.IsAdmin && order.UserID != claims.ID {
+		err = storer.ErrNotFound
+	}
+	if err != nil {
+		writeError(w, r, err, "error getting order")
+		return nil, false
+	}
+
+	return order, true
+}
+
+// listOrders returns a page of orders, filtered by user, status and creation
+// time. Users other than admins only see their own orders.
 func (h *handler) listOrders(w http.ResponseWriter, r *http.Request) {
-	orders, err := h.server.ListOrders(h.ctx)
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	q := newQueryParams(r)
+	f := storer.OrderFilter{
+		UserID:      q.int64("user_id"),
+		Status:      storer.OrderStatus(q.values.Get("status")),
+		CreatedFrom: q.time("created_from"),
+		CreatedTo:   q.time("created_to"),
+		Page:        q.page(),
+	}
+	if f.Status != "" && !f.Status.Valid() {
+		q.fail("status", "is not a known order status")
+	}
+	if !q.valid(w, r) {
+		return
+	}
+
+	if !claims.IsAdmin {
+		if f.UserID != 0 && f.UserID != claims.ID {
+			writeProblem(w, r, http.StatusForbidden, "only admins can list the orders of other users")
+			return
+		}
+		f.UserID = claims.ID
+	}
+
+	orders, next, err := h.server.ListOrders(r.Context(), f)
 	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeError(w, r, err, "error listing orders")
 		return
 	}
 
-	var res []OrderRes
+	res := ListOrdersRes{Orders: []OrderRes{}, NextCursor: encodeCursor(next)}
 	for _, o := range orders {
-		res = append(res, toOrderRes(&o))
+		res.Orders = append(res.Orders, toOrderRes(&o))
 	}
 
+	setNextLink(w, r, res.NextCursor)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 }
 
+// deleteOrder deletes an order of the caller, or any order for an admin.
 func (h *handler) deleteOrder(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	i, err := strconv.ParseInt(id, 10, 64)
 	if err != nil {
-		panic(err)
+		writeProblem(w, r, http.StatusBadRequest, "error parsing ID")
+		return
+	}
+
+	if _, ok := h.getOwnOrder(w, r, i); !ok {
+		return
 	}
 
-	err = h.server.DeleteOrder(h.ctx, i)
+	err = h.server.DeleteOrder(r.Context(), i)
 	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeError(w, r, err, "error deleting order")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// updateOrderStatus moves an order through its lifecycle. Admins may make any
+// transition the server allows; other users may only cancel their own orders.
+func (h *handler) updateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "error parsing ID")
+		return
+	}
+
+	var req OrderStatusReq
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	status := storer.OrderStatus(req.Status)
+	if !claims.IsAdmin {
+		order, err := h.server.GetOrder(r.Context(), i)
+		if err != nil {
+			writeError(w, r, err, "error getting order")
+			return
+		}
+		if order.UserID != claims.ID || status != storer.OrderStatusCancelled {
+			writeProblem(w, r, http.StatusForbidden, "only admins can change the status of an order other than cancelling their own")
+			return
+		}
+	}
+
+	updated, err := h.server.UpdateOrderStatus(r.Context(), i, status, claims.Email)
+	if err != nil {
+		writeError(w, r, err, "error updating order status")
+		return
+	}
+
+	res := toOrderRes(updated)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// listOrderStatusHistory returns the status changes of an order, oldest
+// first, to its owner or an admin.
+func (h *handler) listOrderStatusHistory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "error parsing ID")
+		return
+	}
+
+	if _, ok := h.getOwnOrder(w, r, i); !ok {
+		return
+	}
+
+	changes, err := h.server.ListOrderStatusHistory(r.Context(), i)
+	if err != nil {
+		writeError(w, r, err, "error listing order status history")
+		return
+	}
+
+	res := []OrderStatusChangeRes{}
+	for _, c := range changes {
+		res = append(res, OrderStatusChangeRes{
+			FromStatus: string(c.FromStatus),
+			ToStatus:   string(c.ToStatus),
+			Actor:      c.Actor,
+			CreatedAt:  c.CreatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 func toStorerOrder(o OrderReq) *storer.Order {
 	return &storer.Order{
 		PaymentMethod: o.PaymentMethod,
-		TaxPrice:      o.TaxPrice,
-		ShippingPrice: o.ShippingPrice,
-		TotalPrice:    o.TotalPrice,
 		Items:         toStorerOrderItems(o.Items),
 	}
 }
 
-func toStorerOrderItems(items []OrderItem) []storer.OrderItem {
+func toStorerOrderItems(items []OrderItemReq) []storer.OrderItem {
 	var res []storer.OrderItem
 	for _, i := range items {
 		res = append(res, storer.OrderItem{
-			Name:      i.Name,
 			Quantity:  i.Quantity,
-			Image:     i.Image,
-			Price:     i.Price,
 			ProductID: i.ProductID,
 		})
 	}
@@ -308,20 +496,40 @@ func toStorerOrderItems(items []OrderItem) []storer.OrderItem {
 func toOrderRes(o *storer.Order) OrderRes {
 	return OrderRes{
 		ID:            o.ID,
+		Status:        string(o.Status),
 		Items:         toOrderItems(o.Items),
 		PaymentMethod: o.PaymentMethod,
+		Currency:      o.Currency,
+		ItemsPrice:    o.ItemsPrice,
 		TaxPrice:      o.TaxPrice,
 		ShippingPrice: o.ShippingPrice,
+		DiscountPrice: o.DiscountPrice,
+		Discounts:     toOrderDiscounts(o.Discounts),
 		TotalPrice:    o.TotalPrice,
+		RefundedPrice: o.RefundedPrice,
+		Refunds:       toRefunds(o.Refunds),
 		CreatedAt:     o.CreatedAt,
 		UpdatedAt:     o.UpdatedAt,
 	}
 }
 
+func toOrderDiscounts(discounts []storer.OrderDiscount) []OrderDiscountRes {
+	res := []OrderDiscountRes{}
+	for _, d := range discounts {
+		res = append(res, OrderDiscountRes{
+			Code:        d.Code,
+			Description: d.Description,
+			Amount:      d.Amount,
+		})
+	}
+	return res
+}
+
 func toOrderItems(items []storer.OrderItem) []OrderItem {
 	var res []OrderItem
 	for _, i := range items {
 		res = append(res, OrderItem{
+			ID:        i.ID,
 			Name:      i.Name,
 			Quantity:  i.Quantity,
 			Image:     i.Image,
@@ -332,26 +540,31 @@ func toOrderItems(items []storer.OrderItem) []OrderItem {
 	return res
 }
 
+// createUser signs a user up. Only an admin, calling with their token, may
+// create another admin; is_admin is ignored for everyone else.
 func (h *handler) createUser(w http.ResponseWriter, r *http.Request) {
 	var u UserReq
-	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+	if !decodeAndValidate(w, r, &u) {
 		return
 	}
 
+	if claims, ok := claimsFromContext(r.Context()); !ok || !claims.IsAdmin {
+		u.IsAdmin = false
+	}
+
 	// Hashing passoword
 	hashedPassword, err := utils.HashPassword(u.Password)
 
 	if err != nil {
-		http.Error(w, "error hashing password", http.StatusInternalServerError)
+		writeError(w, r, err, "error hashing password")
 		return
 	}
 
 	u.Password = hashedPassword
 
-	createdUser, err := h.server.CreateUser(h.ctx, toStorerUser(u))
+	createdUser, err := h.server.CreateUser(r.Context(), toStorerUser(u))
 	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeError(w, r, err, "error creating user")
 		return
 	}
 
@@ -380,44 +593,63 @@ func toUserRes(u *storer.User) UserRes {
 }
 
 func (h *handler) listUsers(w http.ResponseWriter, r *http.Request) {
-	listedUsers, err := h.server.ListUsers(h.ctx)
+	q := newQueryParams(r)
+	f := storer.UserFilter{Page: q.page()}
+	if !q.valid(w, r) {
+		return
+	}
+
+	listedUsers, next, err := h.server.ListUsers(r.Context(), f)
 	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeError(w, r, err, "error listing users")
 		return
 	}
 
-	var res ListUserRes
+	res := ListUserRes{Users: []UserRes{}, NextCursor: encodeCursor(next)}
 
 	for _, u := range listedUsers {
 		res.Users = append(res.Users, toUserRes(&u))
 	}
 
+	setNextLink(w, r, res.NextCursor)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 
 }
 
 func (h *handler) updateUser(w http.ResponseWriter, r *http.Request) {
-	// Implement token payload in the future
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
 	var u UserReq
-	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+	if !decodeAndValidatePatch(w, r, &u) {
 		return
 	}
 
-	updatedUser, err := h.server.GetUser(h.ctx, u.Email)
+	// only admins may grant admin rights
+	if !claims.IsAdmin {
+		u.IsAdmin = false
+	}
+
+	updatedUser, err := h.server.GetUser(r.Context(), claims.Email)
 
 	if err != nil {
-		http.Error(w, "error getting user", http.StatusInternalServerError)
+		writeError(w, r, err, "error getting user")
 		return
 	}
 
-	patchUserReq(updatedUser, u)
+	if err := patchUserReq(updatedUser, u); err != nil {
+		writeError(w, r, err, "error hashing password")
+		return
+	}
 
-	updatedUser, err = h.server.UpdateUser(h.ctx, updatedUser)
+	updatedUser, err = h.server.UpdateUser(r.Context(), updatedUser)
 
 	if err != nil {
-		http.Error(w, "error updating user", http.StatusInternalServerError)
+		writeError(w, r, err, "error updating user")
 		return
 	}
 
@@ -427,7 +659,7 @@ func (h *handler) updateUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
-func patchUserReq(user *storer.User, u UserReq) {
+func patchUserReq(user *storer.User, u UserReq) error {
 	if u.Name != "" {
 		user.Name = u.Name
 	}
@@ -437,8 +669,7 @@ func patchUserReq(user *storer.User, u UserReq) {
 	if u.Password != "" {
 		hashedPassword, err := utils.HashPassword(u.Password)
 		if err != nil {
-			log.Println("error hashing password")
-			panic(err)
+			return err
 		}
 		user.Password = hashedPassword
 	}
@@ -446,6 +677,8 @@ func patchUserReq(user *storer.User, u UserReq) {
 		user.IsAdmin = u.IsAdmin
 	}
 	user.UpdatedAt = toTimePtr(time.Now())
+
+	return nil
 }
 
 func (h *handler) deleteUser(w http.ResponseWriter, r *http.Request) {
@@ -453,15 +686,236 @@ func (h *handler) deleteUser(w http.ResponseWriter, r *http.Request) {
 	i, err := strconv.ParseInt(id, 10, 64)
 
 	if err != nil {
-		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "error parsing ID")
 		return
 	}
 
-	err = h.server.DeleteUser(h.ctx, i)
+	err = h.server.DeleteUser(r.Context(), i)
 	if err != nil {
-		http.Error(w, "error deleting user", http.StatusInternalServerError)
+		writeError(w, r, err, "error deleting user")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (h *handler) loginUser(w http.ResponseWriter, r *http.Request) {
+	var u LoginUserReq
+	if !decodeAndValidate(w, r, &u) {
+		return
+	}
+
+	gu, err := h.server.GetUser(r.Context(), u.Email)
+	if err != nil {
+		if errors.Is(err, storer.ErrNotFound) {
+			writeProblem(w, r, http.StatusUnauthorized, "invalid email or password")
+			return
+		}
+		writeError(w, r, err, "error getting user")
+		return
+	}
+
+	if err := utils.CheckPassword(u.Password, gu.Password); err != nil {
+		writeProblem(w, r, http.StatusUnauthorized, "invalid email or password")
+		return
+	}
+
+	// the cart filled before logging in carries over to the user
+	if cartID := r.Header.Get(cartIDHeader); cartID != "" {
+		if err := h.server.MergeCart(r.Context(), cartID, gu.ID); err != nil {
+			writeError(w, r, err, "error merging cart")
+			return
+		}
+	}
+
+	accessToken, accessClaims, err := h.tokenMaker.CreateToken(gu.ID, gu.Email, gu.IsAdmin, token.AccessToken, h.accessTokenDuration)
+	if err != nil {
+		writeError(w, r, err, "error creating token")
+		return
+	}
+
+	refreshToken, refreshClaims, err := h.tokenMaker.CreateToken(gu.ID, gu.Email, gu.IsAdmin, token.RefreshToken, h.refreshTokenDuration)
+	if err != nil {
+		writeError(w, r, err, "error creating token")
+		return
+	}
+
+	// the first session of a login starts a new rotation family
+	session, err := h.server.CreateSession(r.Context(), &storer.Session{
+		ID:           refreshClaims.RegisteredClaims.ID,
+		FamilyID:     refreshClaims.RegisteredClaims.ID,
+		UserEmail:    gu.Email,
+		RefreshToken: refreshToken,
+		IsRevoked:    false,
+		ExpiresAt:    refreshClaims.RegisteredClaims.ExpiresAt.Time,
+	})
+	if err != nil {
+		writeError(w, r, err, "error creating session")
+		return
+	}
+
+	res := LoginUserRes{
+		SessionID:             session.ID,
+		AccessToken:           accessToken,
+		RefreshToken:          refreshToken,
+		AccessTokenExpiresAt:  accessClaims.RegisteredClaims.ExpiresAt.Time,
+		RefreshTokenExpiresAt: refreshClaims.RegisteredClaims.ExpiresAt.Time,
+		User:                  toUserRes(gu),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) refreshToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshTokenReq
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	refreshClaims, err := h.tokenMaker.VerifyToken(r.Context(), req.RefreshToken)
+	if err != nil || refreshClaims.Type != token.RefreshToken {
+		writeProblem(w, r, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	session, err := h.server.GetSession(r.Context(), refreshClaims.RegisteredClaims.ID)
+	if err != nil {
+		if errors.Is(err, storer.ErrNotFound) {
+			writeProblem(w, r, http.StatusUnauthorized, "invalid refresh token")
+			return
+		}
+		writeError(w, r, err, "error getting session")
+		return
+	}
+
+	if session.IsRevoked || session.UserEmail != refreshClaims.Email {
+		writeProblem(w, r, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	// a refresh token that was already exchanged is being replayed, so the
+	// whole family is considered compromised
+	if session.ReplacedBy != nil {
+		h.revokeSessionFamily(w, r, session.FamilyID)
+		return
+	}
+
+	// the user is read again so that the new tokens carry its current admin
+	// rights, and a deleted user cannot refresh
+	u, err := h.server.GetUser(r.Context(), session.UserEmail)
+	if err != nil {
+		if errors.Is(err, storer.ErrNotFound) {
+			writeProblem(w, r, http.StatusUnauthorized, "invalid refresh token")
+			return
+		}
+		writeError(w, r, err, "error getting user")
+		return
+	}
+	if u.ID != refreshClaims.ID {
+		writeProblem(w, r, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	accessToken, accessClaims, err := h.tokenMaker.CreateToken(u.ID, u.Email, u.IsAdmin, token.AccessToken, h.accessTokenDuration)
+	if err != nil {
+		writeError(w, r, err, "error creating token")
+		return
+	}
+
+	newRefreshToken, newRefreshClaims, err := h.tokenMaker.CreateToken(u.ID, u.Email, u.IsAdmin, token.RefreshToken, h.refreshTokenDuration)
+	if err != nil {
+		writeError(w, r, err, "error creating token")
+		return
+	}
+
+	rotated, err := h.server.RotateSession(r.Context(), session.ID, &storer.Session{
+		ID:           newRefreshClaims.RegisteredClaims.ID,
+		FamilyID:     session.FamilyID,
+		UserEmail:    session.UserEmail,
+		RefreshToken: newRefreshToken,
+		IsRevoked:    false,
+		ExpiresAt:    newRefreshClaims.RegisteredClaims.ExpiresAt.Time,
+	})
+	if err != nil {
+		if errors.Is(err, storer.ErrSessionRotated) {
+			h.revokeSessionFamily(w, r, session.FamilyID)
+			return
+		}
+		writeError(w, r, err, "error rotating session")
+		return
+	}
+
+	res := RefreshTokenRes{
+		SessionID:             rotated.ID,
+		AccessToken:           accessToken,
+		RefreshToken:          newRefreshToken,
+		AccessTokenExpiresAt:  accessClaims.RegisteredClaims.ExpiresAt.Time,
+		RefreshTokenExpiresAt: newRefreshClaims.RegisteredClaims.ExpiresAt.Time,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) revokeSessionFamily(w http.ResponseWriter, r *http.Request, familyID string) {
+	if err := h.server.RevokeSessionFamily(r.Context(), familyID); err != nil {
+		writeError(w, r, err, "error revoking session")
+		return
+	}
+
+	writeProblem(w, r, http.StatusUnauthorized, "refresh token reuse detected")
+}
+
+func (h *handler) logoutUser(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req LogoutUserReq
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.tokenMaker.RevokeToken(r.Context(), claims); err != nil {
+		writeError(w, r, err, "error revoking token")
+		return
+	}
+
+	// also end the refresh token session so it cannot mint new access tokens
+	if req.RefreshToken != "" {
+		refreshClaims, err := h.tokenMaker.VerifyToken(r.Context(), req.RefreshToken)
+		if err != nil || refreshClaims.Type != token.RefreshToken || refreshClaims.Email != claims.Email {
+			writeProblem(w, r, http.StatusUnauthorized, "invalid refresh token")
+			return
+		}
+
+		if err := h.revokeSession(r.Context(), refreshClaims.RegisteredClaims.ID); err != nil {
+			writeError(w, r, err, "error revoking session")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) revokeSession(ctx context.Context, id string) error {
+	session, err := h.server.GetSession(ctx, id)
+	if err != nil {
+		if errors.Is(err, storer.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	return h.server.RevokeSessionFamily(ctx, session.FamilyID)
+}
+
+func (h *handler) getJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.tokenMaker.JWKS())
+}
@@ -0,0 +1,1057 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gauss2302/ecomm-service/config"
+	"github.com/gauss2302/ecomm-service/db"
+	"github.com/gauss2302/ecomm-service/ecomm-api/server"
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/gauss2302/ecomm-service/payments"
+	"github.com/gauss2302/ecomm-service/token"
+	"github.com/gauss2302/ecomm-service/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func usd(amount int64) money.Money {
+	return money.New(amount, "USD")
+}
+
+func toInt64Ptr(n int64) *int64 {
+	return &n
+}
+
+var testPaymentsConfig = config.PaymentsConfig{Gateway: "fake", WebhookSecret: "test webhook secret", WebhookTolerance: 5 * time.Minute}
+
+// newTestRouter builds the full router on top of an in-memory SQLite
+// database, so the tests go through the real SQL code paths. The database
+// holds an admin, admin@example.com with the password "password", as admins
+// cannot sign up.
+func newTestRouter(t *testing.T) http.Handler {
+	database, err := db.NewSQLiteDatabase(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.MigrateUp(context.Background()))
+
+	st := storer.NewSQLiteStorer(database.GetDB())
+	hashed, err := utils.HashPassword("password")
+	require.NoError(t, err)
+	_, err = st.CreateUser(context.Background(), &storer.User{Name: "admin", Email: "admin@example.com", Password: hashed, IsAdmin: true})
+	require.NoError(t, err)
+
+	tokenMaker := token.NewKeyRingMaker(token.NewHMACKey("test", []byte("test secret key")), time.Hour, st)
+	return RegisterRoutes(NewHandler(server.NewServer(st, config.PricingConfig{Currency: "USD", TaxRate: 0.15, ShippingPrice: 10, FreeShippingThreshold: 100}, payments.NewFakeGateway(nil)), tokenMaker, 15*time.Minute, 24*time.Hour, testPaymentsConfig))
+}
+
+func doRequest(t *testing.T, h http.Handler, method, path, accessToken string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestLoginRefreshLogout(t *testing.T) {
+	h := newTestRouter(t)
+
+	rec := doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "test", Email: "test@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPost, "/users/login", "", LoginUserReq{Email: "test@example.com", Password: "wrong"})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPost, "/users/login", "", LoginUserReq{Email: "missing@example.com", Password: "password"})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPost, "/users/login", "", LoginUserReq{Email: "test@example.com", Password: "password"})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var login LoginUserRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&login))
+	require.NotEmpty(t, login.AccessToken)
+	require.NotEmpty(t, login.RefreshToken)
+
+	// neither token stands in for the other
+	rec = doRequest(t, h, http.MethodGet, "/orders", login.RefreshToken, nil)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = doRequest(t, h, http.MethodPost, "/tokens/refresh", "", RefreshTokenReq{RefreshToken: login.AccessToken})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// non-admins cannot list users
+	rec = doRequest(t, h, http.MethodGet, "/users", login.AccessToken, nil)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPost, "/tokens/refresh", "", RefreshTokenReq{RefreshToken: login.RefreshToken})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var refreshed RefreshTokenRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&refreshed))
+	require.NotEqual(t, login.RefreshToken, refreshed.RefreshToken)
+
+	// replaying the rotated refresh token revokes the whole family
+	rec = doRequest(t, h, http.MethodPost, "/tokens/refresh", "", RefreshTokenReq{RefreshToken: login.RefreshToken})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPost, "/tokens/refresh", "", RefreshTokenReq{RefreshToken: refreshed.RefreshToken})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPost, "/users/logout", refreshed.AccessToken, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPatch, "/users", refreshed.AccessToken, UserReq{Name: "new name"})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestRefreshDeletedUser(t *testing.T) {
+	h := newTestRouter(t)
+
+	rec := doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "test", Email: "test@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPost, "/users/login", "", LoginUserReq{Email: "test@example.com", Password: "password"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	var res LoginUserRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+
+	// the user follows the seeded admin, so its ID is 2
+	rec = doRequest(t, h, http.MethodDelete, "/users/2", login(t, h, "admin@example.com", "password"), nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPost, "/tokens/refresh", "", RefreshTokenReq{RefreshToken: res.RefreshToken})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// nor does a new user signing up with the same email inherit the session
+	rec = doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "test", Email: "test@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPost, "/tokens/refresh", "", RefreshTokenReq{RefreshToken: res.RefreshToken})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestSignUpAdmin(t *testing.T) {
+	h := newTestRouter(t)
+
+	// is_admin is ignored when signing up
+	rec := doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "user", Email: "user@example.com", Password: "password", IsAdmin: true})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var u UserRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&u))
+	require.False(t, u.IsAdmin)
+
+	userToken := login(t, h, "user@example.com", "password")
+	rec = doRequest(t, h, http.MethodGet, "/users", userToken, nil)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	// and by users who are not admins
+	rec = doRequest(t, h, http.MethodPost, "/users", userToken, UserReq{Name: "other", Email: "other@example.com", Password: "password", IsAdmin: true})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&u))
+	require.False(t, u.IsAdmin)
+
+	adminToken := login(t, h, "admin@example.com", "password")
+	rec = doRequest(t, h, http.MethodPost, "/users", adminToken, UserReq{Name: "admin2", Email: "admin2@example.com", Password: "password", IsAdmin: true})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&u))
+	require.True(t, u.IsAdmin)
+
+	rec = doRequest(t, h, http.MethodGet, "/users", login(t, h, "admin2@example.com", "password"), nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func login(t *testing.T, h http.Handler, email, password string) string {
+	rec := doRequest(t, h, http.MethodPost, "/users/login", "", LoginUserReq{Email: email, Password: password})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var res LoginUserRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	return res.AccessToken
+}
+
+func TestProductsAndOrders(t *testing.T) {
+	h := newTestRouter(t)
+
+	rec := doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "user", Email: "user@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	adminToken := login(t, h, "admin@example.com", "password")
+	userToken := login(t, h, "user@example.com", "password")
+
+	product := ProductReq{Name: "test product", Image: "test.jpg", Category: "test", Rating: 5, Price: usd(1000), CountInStock: toInt64Ptr(5)}
+	rec = doRequest(t, h, http.MethodPost, "/products", userToken, product)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPost, "/products", adminToken, product)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var pr ProductRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+	require.NotZero(t, pr.ID)
+	require.Equal(t, "USD", pr.Currency)
+	require.Equal(t, "10.00", pr.Price.String())
+
+	// prices are exact decimals in the store currency
+	for _, body := range []map[string]interface{}{
+		{"name": "free", "price": "0.00", "count_in_stock": 1},
+		{"name": "foreign", "price": "10.00", "currency": "EUR", "count_in_stock": 1},
+		{"name": "unknown currency", "price": "10.00", "currency": "XXQ", "count_in_stock": 1},
+		{"name": "too expensive", "price": "100000000.00", "count_in_stock": 1},
+		{"name": "too many", "price": "10.00", "count_in_stock": 1 << 40},
+	} {
+		rec = doRequest(t, h, http.MethodPost, "/products", adminToken, body)
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code, body["name"])
+	}
+	rec = doRequest(t, h, http.MethodPost, "/products", adminToken, map[string]interface{}{"name": "fractional cents", "price": "10.001", "count_in_stock": 1})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doRequest(t, h, http.MethodGet, "/products", "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	order := OrderReq{
+		Items:         []OrderItemReq{{ProductID: pr.ID, Quantity: 2}},
+		PaymentMethod: "card",
+	}
+	rec = doRequest(t, h, http.MethodPost, "/orders", "", order)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPost, "/orders", userToken, order)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var or OrderRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&or))
+	require.NotZero(t, or.ID)
+
+	// prices are computed from the catalogue: 2*10 items, 15% tax, and
+	// shipping as the items are below the free shipping threshold
+	require.Equal(t, "test product", or.Items[0].Name)
+	require.Equal(t, "USD", or.Currency)
+	require.Equal(t, "10.00", or.Items[0].Price.String())
+	require.Equal(t, "20.00", or.ItemsPrice.String())
+	require.Equal(t, "3.00", or.TaxPrice.String())
+	require.Equal(t, "10.00", or.ShippingPrice.String())
+	require.Equal(t, "33.00", or.TotalPrice.String())
+
+	// only 3 of the 5 products are left
+	rec = doRequest(t, h, http.MethodPost, "/orders", userToken, OrderReq{
+		Items:         []OrderItemReq{{ProductID: pr.ID, Quantity: 4}},
+		PaymentMethod: "card",
+	})
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	var p problem
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&p))
+	require.Equal(t, []stockShortfall{{ProductID: pr.ID, Requested: 4, Available: 3}}, p.Shortfalls)
+
+	// clients cannot set totals
+	rec = doRequest(t, h, http.MethodPost, "/orders", userToken, map[string]interface{}{
+		"items":          []OrderItemReq{{ProductID: pr.ID, Quantity: 2}},
+		"payment_method": "card",
+		"total_price":    1,
+	})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// inactive products cannot be ordered
+	rec = doRequest(t, h, http.MethodPatch, fmt.Sprintf("/products/%d", pr.ID), adminToken, map[string]interface{}{"is_active": false})
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(t, h, http.MethodPost, "/orders", userToken, order)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = doRequest(t, h, http.MethodGet, fmt.Sprintf("/orders/%d", or.ID), userToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&or))
+	require.Len(t, or.Items, 1)
+	require.Equal(t, int64(2), or.Items[0].Quantity)
+
+	rec = doRequest(t, h, http.MethodDelete, fmt.Sprintf("/orders/%d", or.ID), userToken, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	// deleting the order put its items back into stock
+	rec = doRequest(t, h, http.MethodGet, fmt.Sprintf("/products/%d", pr.ID), "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+	require.Equal(t, int64(5), pr.CountInStock)
+
+	// a patch leaves the stock alone unless it sets it, also to zero
+	rec = doRequest(t, h, http.MethodPatch, fmt.Sprintf("/products/%d", pr.ID), adminToken, map[string]interface{}{"name": "renamed product"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+	require.Equal(t, int64(5), pr.CountInStock)
+
+	rec = doRequest(t, h, http.MethodPatch, fmt.Sprintf("/products/%d", pr.ID), adminToken, map[string]interface{}{"count_in_stock": 0})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+	require.Equal(t, int64(0), pr.CountInStock)
+
+	rec = doRequest(t, h, http.MethodGet, fmt.Sprintf("/products/%d", pr.ID), "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+	require.Equal(t, int64(0), pr.CountInStock)
+	require.Equal(t, "renamed product", pr.Name)
+
+	rec = doRequest(t, h, http.MethodPatch, fmt.Sprintf("/products/%d", pr.ID), adminToken, map[string]interface{}{"count_in_stock": -1})
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestOrderStatus(t *testing.T) {
+	h := newTestRouter(t)
+
+	rec := doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "user", Email: "user@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	rec = doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "other", Email: "other@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	adminToken := login(t, h, "admin@example.com", "password")
+	userToken := login(t, h, "user@example.com", "password")
+	otherToken := login(t, h, "other@example.com", "password")
+
+	rec = doRequest(t, h, http.MethodPost, "/products", adminToken, ProductReq{Name: "test product", Image: "test.jpg", Category: "test", Price: usd(1000), CountInStock: toInt64Ptr(5)})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var pr ProductRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+
+	createOrder := func(t *testing.T) OrderRes {
+		rec := doRequest(t, h, http.MethodPost, "/orders", userToken, OrderReq{
+			Items:         []OrderItemReq{{ProductID: pr.ID, Quantity: 2}},
+			PaymentMethod: "card",
+		})
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		var or OrderRes
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&or))
+		require.Equal(t, "pending", or.Status)
+		return or
+	}
+
+	or := createOrder(t)
+	statusPath := fmt.Sprintf("/orders/%d/status", or.ID)
+
+	tcs := []struct {
+		name   string
+		token  string
+		status string
+		code   int
+	}{
+		{name: "unknown status", token: adminToken, status: "lost", code: http.StatusUnprocessableEntity},
+		{name: "paid is set by paying", token: adminToken, status: "paid", code: http.StatusUnprocessableEntity},
+		{name: "refunded is set by refunding", token: adminToken, status: "refunded", code: http.StatusUnprocessableEntity},
+		{name: "illegal transition", token: adminToken, status: "processing", code: http.StatusConflict},
+		{name: "other user cannot cancel", token: otherToken, status: "cancelled", code: http.StatusForbidden},
+		{name: "owner cancels", token: userToken, status: "cancelled", code: http.StatusOK},
+		{name: "cancelled is final", token: adminToken, status: "processing", code: http.StatusConflict},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := doRequest(t, h, http.MethodPatch, statusPath, tc.token, OrderStatusReq{Status: tc.status})
+			require.Equal(t, tc.code, rec.Code)
+			if tc.code != http.StatusOK {
+				return
+			}
+
+			var res OrderRes
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+			require.Equal(t, tc.status, res.Status)
+		})
+	}
+
+	rec = doRequest(t, h, http.MethodGet, fmt.Sprintf("/orders/%d/history", or.ID), userToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var history []OrderStatusChangeRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&history))
+	require.Len(t, history, 1)
+	require.Equal(t, OrderStatusChangeRes{FromStatus: "pending", ToStatus: "cancelled", Actor: "user@example.com", CreatedAt: history[0].CreatedAt}, history[0])
+
+	// the cancellation put the items back into stock
+	rec = doRequest(t, h, http.MethodGet, fmt.Sprintf("/products/%d", pr.ID), "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+	require.Equal(t, int64(5), pr.CountInStock)
+
+	// other users cannot tell the orders of someone else exist
+	or = createOrder(t)
+	orderPath := fmt.Sprintf("/orders/%d", or.ID)
+	for _, req := range []struct{ method, path string }{
+		{method: http.MethodGet, path: orderPath},
+		{method: http.MethodGet, path: orderPath + "/history"},
+		{method: http.MethodDelete, path: orderPath},
+	} {
+		rec = doRequest(t, h, req.method, req.path, otherToken, nil)
+		require.Equal(t, http.StatusNotFound, rec.Code, req)
+	}
+
+	rec = doRequest(t, h, http.MethodGet, orderPath, adminToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(t, h, http.MethodGet, orderPath+"/history", adminToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(t, h, http.MethodGet, fmt.Sprintf("/products/%d", pr.ID), "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+	require.Equal(t, int64(3), pr.CountInStock)
+
+	rec = doRequest(t, h, http.MethodDelete, orderPath, userToken, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	rec = doRequest(t, h, http.MethodGet, orderPath, userToken, nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	// a paid order moves on, and is refunded rather than cancelled
+	or = createOrder(t)
+	statusPath = fmt.Sprintf("/orders/%d/status", or.ID)
+	rec = doRequest(t, h, http.MethodPost, fmt.Sprintf("/orders/%d/pay", or.ID), userToken, PayOrderReq{Source: "4242424242424242"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(t, h, http.MethodPatch, statusPath, userToken, OrderStatusReq{Status: "processing"})
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	rec = doRequest(t, h, http.MethodPatch, statusPath, adminToken, OrderStatusReq{Status: "processing"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(t, h, http.MethodPatch, statusPath, userToken, OrderStatusReq{Status: "cancelled"})
+	require.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestListPagination(t *testing.T) {
+	h := newTestRouter(t)
+
+	rec := doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "user", Email: "user@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	adminToken := login(t, h, "admin@example.com", "password")
+	userToken := login(t, h, "user@example.com", "password")
+
+	var productIDs []int64
+	for i, price := range []int64{2000, 1000, 3000, 4000} {
+		rec = doRequest(t, h, http.MethodPost, "/products", adminToken, ProductReq{Name: fmt.Sprintf("product %d", i), Image: "test.jpg", Category: "test", Price: usd(price), CountInStock: toInt64Ptr(5)})
+		require.Equal(t, http.StatusCreated, rec.Code)
+		var pr ProductRes
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+		productIDs = append(productIDs, pr.ID)
+	}
+
+	var prices []string
+	path := "/products?max_price=30.00&sort=-price&limit=2"
+	for pages := 0; path != ""; pages++ {
+		require.Less(t, pages, 2)
+
+		rec = doRequest(t, h, http.MethodGet, path, "", nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var res ListProductsRes
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		for _, p := range res.Products {
+			prices = append(prices, p.Price.String())
+		}
+
+		path = ""
+		if res.NextCursor != "" {
+			link := rec.Header().Get("Link")
+			require.Contains(t, link, `rel="next"`)
+			path = link[1:strings.Index(link, ">")]
+		}
+	}
+	require.Equal(t, []string{"30.00", "20.00", "10.00"}, prices)
+
+	// each user only sees their own orders unless they are an admin
+	rec = doRequest(t, h, http.MethodPost, "/orders", userToken, OrderReq{Items: []OrderItemReq{{ProductID: productIDs[0], Quantity: 1}}, PaymentMethod: "card"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	rec = doRequest(t, h, http.MethodPost, "/orders", adminToken, OrderReq{Items: []OrderItemReq{{ProductID: productIDs[1], Quantity: 1}}, PaymentMethod: "card"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = doRequest(t, h, http.MethodGet, "/orders?status=pending", userToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var orders ListOrdersRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&orders))
+	require.Len(t, orders.Orders, 1)
+	require.Empty(t, orders.NextCursor)
+
+	rec = doRequest(t, h, http.MethodGet, "/orders", adminToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&orders))
+	require.Len(t, orders.Orders, 2)
+
+	tcs := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{name: "limit too large", path: "/products?limit=1000", status: http.StatusUnprocessableEntity},
+		{name: "malformed price", path: "/products?min_price=cheap", status: http.StatusUnprocessableEntity},
+		{name: "fractional cents", path: "/products?min_price=1.999", status: http.StatusUnprocessableEntity},
+		{name: "unknown sort field", path: "/products?sort=description", status: http.StatusUnprocessableEntity},
+		{name: "malformed cursor", path: "/products?cursor=not-a-cursor", status: http.StatusUnprocessableEntity},
+		{name: "unknown status", path: "/orders?status=lost", status: http.StatusUnprocessableEntity},
+		{name: "malformed date", path: "/orders?created_from=yesterday", status: http.StatusUnprocessableEntity},
+		{name: "orders of another user", path: "/orders?user_id=1", status: http.StatusForbidden},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := doRequest(t, h, http.MethodGet, tc.path, userToken, nil)
+			require.Equal(t, tc.status, rec.Code)
+		})
+	}
+}
+
+func TestCanceledRequest(t *testing.T) {
+	h := newTestRouter(t)
+
+	tcs := []struct {
+		name   string
+		ctx    func() (context.Context, context.CancelFunc)
+		status int
+	}{
+		{
+			name: "client went away",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			status: statusClientClosedRequest,
+		},
+		{
+			name: "deadline exceeded",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+			},
+			status: http.StatusGatewayTimeout,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := tc.ctx()
+			defer cancel()
+
+			req := httptest.NewRequest(http.MethodGet, "/products", nil).WithContext(ctx)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			require.Equal(t, tc.status, rec.Code)
+		})
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	h := newTestRouter(t)
+
+	rec := doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "user", Email: "user@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	userToken := login(t, h, "user@example.com", "password")
+
+	tcs := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{
+			name:   "malformed ID",
+			method: http.MethodGet,
+			path:   "/orders/abc",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "missing order",
+			method: http.MethodGet,
+			path:   "/orders/999",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "duplicate email",
+			method: http.MethodPost,
+			path:   "/users",
+			body:   UserReq{Name: "other", Email: "user@example.com", Password: "password"},
+			status: http.StatusConflict,
+		},
+		{
+			name:   "order for missing product",
+			method: http.MethodPost,
+			path:   "/orders",
+			body:   OrderReq{Items: []OrderItemReq{{ProductID: 999, Quantity: 1}}, PaymentMethod: "card"},
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "order quantity out of range",
+			method: http.MethodPost,
+			path:   "/orders",
+			body:   OrderReq{Items: []OrderItemReq{{ProductID: 1, Quantity: 1 << 62}}, PaymentMethod: "card"},
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "cart quantity out of range",
+			method: http.MethodPost,
+			path:   "/cart/items",
+			body:   CartItemReq{ProductID: 1, Quantity: 10001},
+			status: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := doRequest(t, h, tc.method, tc.path, userToken, tc.body)
+			require.Equal(t, tc.status, rec.Code)
+			require.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+
+			var p problem
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&p))
+			require.Equal(t, tc.status, p.Status)
+			require.Equal(t, http.StatusText(tc.status), p.Title)
+			require.Equal(t, tc.path, p.Instance)
+		})
+	}
+}
+
+// doCartRequest makes an anonymous request for the cart cartID.
+func doCartRequest(t *testing.T, h http.Handler, method, path, cartID string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	if cartID != "" {
+		req.Header.Set(cartIDHeader, cartID)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func decodeCart(t *testing.T, rec *httptest.ResponseRecorder) CartRes {
+	var cart CartRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&cart))
+	return cart
+}
+
+func TestCart(t *testing.T) {
+	h := newTestRouter(t)
+
+	rec := doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "user", Email: "user@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	adminToken := login(t, h, "admin@example.com", "password")
+
+	var productIDs []int64
+	for i, stock := range []int64{5, 1} {
+		rec = doRequest(t, h, http.MethodPost, "/products", adminToken, ProductReq{Name: fmt.Sprintf("product %d", i), Image: "test.jpg", Price: usd(1000), CountInStock: toInt64Ptr(stock)})
+		require.Equal(t, http.StatusCreated, rec.Code)
+		var pr ProductRes
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+		productIDs = append(productIDs, pr.ID)
+	}
+
+	// an anonymous shopper gets a cart ID with their first item
+	rec = doCartRequest(t, h, http.MethodGet, "/cart", "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	cart := decodeCart(t, rec)
+	require.Empty(t, cart.ID)
+	require.Empty(t, cart.Items)
+
+	rec = doCartRequest(t, h, http.MethodPost, "/cart/items", "", CartItemReq{ProductID: productIDs[0], Quantity: 2})
+	require.Equal(t, http.StatusOK, rec.Code)
+	cartID := rec.Header().Get(cartIDHeader)
+	require.NotEmpty(t, cartID)
+
+	rec = doCartRequest(t, h, http.MethodPost, "/cart/items", cartID, CartItemReq{ProductID: productIDs[1], Quantity: 2})
+	require.Equal(t, http.StatusOK, rec.Code)
+	cart = decodeCart(t, rec)
+	require.Equal(t, cartID, cart.ID)
+	require.Len(t, cart.Items, 2)
+	require.Equal(t, "40.00", cart.ItemsPrice.String())
+	require.Empty(t, cart.Items[0].Warning)
+	require.Equal(t, "insufficient_stock", cart.Items[1].Warning)
+
+	rec = doCartRequest(t, h, http.MethodPost, "/cart/items", cartID, CartItemReq{ProductID: productIDs[0]})
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = doCartRequest(t, h, http.MethodGet, "/cart", "no-such-cart", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = doCartRequest(t, h, http.MethodPost, "/cart/items", "no-such-cart", CartItemReq{ProductID: productIDs[0], Quantity: 1})
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = doCartRequest(t, h, http.MethodPost, "/cart/checkout", cartID, CheckoutReq{PaymentMethod: "card"})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// logging in merges the anonymous cart into the cart of the user
+	rec = doCartRequest(t, h, http.MethodPost, "/users/login", cartID, LoginUserReq{Email: "user@example.com", Password: "password"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	var lr LoginUserRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&lr))
+	userToken := lr.AccessToken
+
+	rec = doCartRequest(t, h, http.MethodGet, "/cart", cartID, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	cart = decodeCart(t, rec)
+	require.Empty(t, cart.Items)
+
+	rec = doRequest(t, h, http.MethodGet, "/cart", userToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Empty(t, rec.Header().Get(cartIDHeader))
+	cart = decodeCart(t, rec)
+	require.Empty(t, cart.ID)
+	require.Len(t, cart.Items, 2)
+
+	rec = doRequest(t, h, http.MethodPatch, fmt.Sprintf("/cart/items/%d", productIDs[1]), userToken, UpdateCartItemReq{Quantity: 1})
+	require.Equal(t, http.StatusOK, rec.Code)
+	cart = decodeCart(t, rec)
+	require.Empty(t, cart.Items[1].Warning)
+	require.Equal(t, "30.00", cart.ItemsPrice.String())
+
+	rec = doRequest(t, h, http.MethodPatch, "/cart/items/999", userToken, UpdateCartItemReq{Quantity: 1})
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	// checking out places the order through the usual pricing and empties
+	// the cart
+	rec = doRequest(t, h, http.MethodPost, "/cart/checkout", userToken, CheckoutReq{PaymentMethod: "card"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var or OrderRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&or))
+	require.Len(t, or.Items, 2)
+	require.Equal(t, "44.50", or.TotalPrice.String())
+
+	rec = doRequest(t, h, http.MethodGet, "/cart", userToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	cart = decodeCart(t, rec)
+	require.Empty(t, cart.Items)
+
+	rec = doRequest(t, h, http.MethodPost, "/cart/checkout", userToken, CheckoutReq{PaymentMethod: "card"})
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPost, "/cart/items", userToken, CartItemReq{ProductID: productIDs[0], Quantity: 1})
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(t, h, http.MethodDelete, fmt.Sprintf("/cart/items/%d", productIDs[0]), userToken, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	rec = doRequest(t, h, http.MethodGet, "/cart", userToken, nil)
+	cart = decodeCart(t, rec)
+	require.Empty(t, cart.Items)
+}
+
+func TestPromotions(t *testing.T) {
+	h := newTestRouter(t)
+
+	rec := doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "user", Email: "user@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	adminToken := login(t, h, "admin@example.com", "password")
+	userToken := login(t, h, "user@example.com", "password")
+
+	rec = doRequest(t, h, http.MethodPost, "/products", adminToken, ProductReq{Name: "test product", Image: "test.jpg", Category: "test", Price: usd(2000), CountInStock: toInt64Ptr(10)})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var pr ProductRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+
+	once := int64(1)
+	promotion := PromotionReq{Code: "welcome10", Description: "10% off", Kind: "percentage", PercentOff: 10, MaxUsesPerUser: &once}
+	rec = doRequest(t, h, http.MethodPost, "/promotions", userToken, promotion)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	for _, body := range []interface{}{
+		map[string]interface{}{"code": "BOGUS", "kind": "bogus"},
+		map[string]interface{}{"code": "NOTHING", "kind": "percentage"},
+		map[string]interface{}{"code": "NEVER", "kind": "free_shipping", "max_uses": 0, "max_uses_per_user": -1},
+	} {
+		rec = doRequest(t, h, http.MethodPost, "/promotions", adminToken, body)
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(t, h, http.MethodPost, "/promotions", adminToken, promotion)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var prom PromotionRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&prom))
+	require.Equal(t, "WELCOME10", prom.Code)
+	require.True(t, prom.IsActive)
+	require.False(t, prom.Stackable)
+
+	rec = doRequest(t, h, http.MethodPost, "/promotions", adminToken, promotion)
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = doRequest(t, h, http.MethodGet, "/promotions", adminToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var promotions []PromotionRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&promotions))
+	require.Len(t, promotions, 1)
+
+	order := OrderReq{Items: []OrderItemReq{{ProductID: pr.ID, Quantity: 2}}, PaymentMethod: "card", Coupons: []string{"welcome10"}}
+	rec = doRequest(t, h, http.MethodPost, "/orders", userToken, order)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var or OrderRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&or))
+	// 15% tax on 40.00 less 4.00, plus 10.00 shipping
+	require.Equal(t, "4.00", or.DiscountPrice.String())
+	require.Equal(t, "5.40", or.TaxPrice.String())
+	require.Equal(t, "51.40", or.TotalPrice.String())
+	require.Len(t, or.Discounts, 1)
+	require.Equal(t, "WELCOME10", or.Discounts[0].Code)
+	require.Equal(t, "10% off", or.Discounts[0].Description)
+
+	rec = doRequest(t, h, http.MethodGet, fmt.Sprintf("/orders/%d", or.ID), userToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got OrderRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+	require.Equal(t, "4.00", got.DiscountPrice.String())
+	require.Len(t, got.Discounts, 1)
+	require.Equal(t, "4.00", got.Discounts[0].Amount.String())
+
+	// the coupon can only be used once per user
+	rec = doRequest(t, h, http.MethodPost, "/orders", userToken, order)
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	order.Coupons = []string{"NOPE"}
+	rec = doRequest(t, h, http.MethodPost, "/orders", userToken, order)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPatch, fmt.Sprintf("/promotions/%d", prom.ID), adminToken, map[string]interface{}{"is_active": false})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&prom))
+	require.False(t, prom.IsActive)
+	require.Equal(t, int64(1), prom.Uses)
+
+	rec = doRequest(t, h, http.MethodGet, "/promotions/999", adminToken, nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestPayOrder(t *testing.T) {
+	h := newTestRouter(t)
+
+	rec := doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "user", Email: "user@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	rec = doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "other", Email: "other@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	adminToken := login(t, h, "admin@example.com", "password")
+	userToken := login(t, h, "user@example.com", "password")
+	otherToken := login(t, h, "other@example.com", "password")
+
+	rec = doRequest(t, h, http.MethodPost, "/products", adminToken, ProductReq{Name: "test product", Image: "test.jpg", Category: "test", Price: usd(1000), CountInStock: toInt64Ptr(5)})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var pr ProductRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+
+	rec = doRequest(t, h, http.MethodPost, "/orders", userToken, OrderReq{
+		Items:         []OrderItemReq{{ProductID: pr.ID, Quantity: 2}},
+		PaymentMethod: "card",
+	})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var or OrderRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&or))
+	payPath := fmt.Sprintf("/orders/%d/pay", or.ID)
+
+	tcs := []struct {
+		name   string
+		token  string
+		source string
+		code   int
+		detail string
+	}{
+		{name: "no source", token: userToken, code: http.StatusUnprocessableEntity},
+		{name: "other user", token: otherToken, source: "4242424242424242", code: http.StatusNotFound},
+		{name: "declined", token: userToken, source: "4000000000009995", code: http.StatusPaymentRequired, detail: "error paying order: insufficient_funds"},
+		{name: "invalid number", token: userToken, source: "1234", code: http.StatusPaymentRequired, detail: "error paying order: invalid_number"},
+		{name: "owner pays", token: userToken, source: "4242424242424242", code: http.StatusOK},
+		{name: "already paid", token: userToken, source: "4242424242424242", code: http.StatusConflict},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := doRequest(t, h, http.MethodPost, payPath, tc.token, PayOrderReq{Source: tc.source})
+			require.Equal(t, tc.code, rec.Code)
+			if tc.code != http.StatusOK {
+				var p problem
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&p))
+				if tc.detail != "" {
+					require.Equal(t, tc.detail, p.Detail)
+				}
+				return
+			}
+
+			var res OrderRes
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+			require.Equal(t, "paid", res.Status)
+		})
+	}
+
+	rec = doRequest(t, h, http.MethodGet, fmt.Sprintf("/orders/%d/payments", or.ID), otherToken, nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = doRequest(t, h, http.MethodGet, fmt.Sprintf("/orders/%d/payments", or.ID), userToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var payments []PaymentRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&payments))
+	require.Len(t, payments, 4)
+	require.Equal(t, "declined", payments[0].Status)
+	require.Equal(t, "insufficient_funds", payments[0].Error)
+	require.Equal(t, "declined", payments[1].Status)
+	require.Equal(t, []string{"authorize", "capture"}, []string{payments[2].Operation, payments[3].Operation})
+	require.Equal(t, "succeeded", payments[3].Status)
+	require.Equal(t, "fake", payments[3].Provider)
+	require.Equal(t, int64(3300), payments[3].Amount.Amount)
+	require.Equal(t, "USD", payments[3].Currency)
+}
+
+func TestRefundOrder(t *testing.T) {
+	h := newTestRouter(t)
+
+	rec := doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "user", Email: "user@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	adminToken := login(t, h, "admin@example.com", "password")
+	userToken := login(t, h, "user@example.com", "password")
+
+	rec = doRequest(t, h, http.MethodPost, "/products", adminToken, ProductReq{Name: "test product", Image: "test.jpg", Category: "test", Price: usd(1000), CountInStock: toInt64Ptr(5)})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var pr ProductRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+
+	rec = doRequest(t, h, http.MethodPost, "/orders", userToken, OrderReq{
+		Items:         []OrderItemReq{{ProductID: pr.ID, Quantity: 2}},
+		PaymentMethod: "card",
+	})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var or OrderRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&or))
+	itemID := or.Items[0].ID
+	require.NotZero(t, itemID)
+	refundsPath := fmt.Sprintf("/orders/%d/refunds", or.ID)
+
+	rec = doRequest(t, h, http.MethodPost, refundsPath, adminToken, RefundReq{Full: true, Reason: "other"})
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = doRequest(t, h, http.MethodPost, fmt.Sprintf("/orders/%d/pay", or.ID), userToken, PayOrderReq{Source: "4242424242424242"})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	tcs := []struct {
+		name  string
+		token string
+		req   RefundReq
+		code  int
+	}{
+		{name: "not an admin", token: userToken, req: RefundReq{Full: true, Reason: "other"}, code: http.StatusForbidden},
+		{name: "no reason", token: adminToken, req: RefundReq{Full: true}, code: http.StatusUnprocessableEntity},
+		{name: "unknown reason", token: adminToken, req: RefundReq{Full: true, Reason: "bored"}, code: http.StatusUnprocessableEntity},
+		{name: "no quantity", token: adminToken, req: RefundReq{Items: []RefundItemReq{{OrderItemID: itemID}}, Reason: "other"}, code: http.StatusUnprocessableEntity},
+		{name: "more than ordered", token: adminToken, req: RefundReq{Items: []RefundItemReq{{OrderItemID: itemID, Quantity: 3}}, Reason: "other"}, code: http.StatusConflict},
+		{name: "one item", token: adminToken, req: RefundReq{Items: []RefundItemReq{{OrderItemID: itemID, Quantity: 1}}, Reason: "damaged", Note: "broken handle", Restock: true}, code: http.StatusCreated},
+		{name: "the rest", token: adminToken, req: RefundReq{Full: true, Reason: "customer_request"}, code: http.StatusCreated},
+		{name: "nothing left", token: adminToken, req: RefundReq{Full: true, Reason: "other"}, code: http.StatusConflict},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := doRequest(t, h, http.MethodPost, refundsPath, tc.token, tc.req)
+			require.Equal(t, tc.code, rec.Code)
+			if tc.code != http.StatusCreated {
+				return
+			}
+
+			var res RefundRes
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+			require.NotZero(t, res.ID)
+			require.Equal(t, tc.req.Reason, res.Reason)
+			require.Equal(t, "admin@example.com", res.Actor)
+			require.NotEmpty(t, res.Reference)
+			require.Equal(t, "succeeded", res.Status)
+			require.Len(t, res.Items, 1)
+		})
+	}
+
+	rec = doRequest(t, h, http.MethodGet, fmt.Sprintf("/orders/%d", or.ID), userToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got OrderRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+	require.Equal(t, "refunded", got.Status)
+	require.Equal(t, got.TotalPrice.Amount, got.RefundedPrice.Amount)
+	require.Len(t, got.Refunds, 2)
+	require.Equal(t, "broken handle", got.Refunds[0].Note)
+	require.Equal(t, got.TotalPrice.Amount, got.Refunds[0].Amount.Amount+got.Refunds[1].Amount.Amount)
+
+	// only the item refunded with restock went back into stock
+	rec = doRequest(t, h, http.MethodGet, fmt.Sprintf("/products/%d", pr.ID), "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var gotProduct ProductRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&gotProduct))
+	require.Equal(t, int64(4), gotProduct.CountInStock)
+}
+
+func TestPaymentWebhooks(t *testing.T) {
+	h := newTestRouter(t)
+
+	adminToken := login(t, h, "admin@example.com", "password")
+
+	rec := doRequest(t, h, http.MethodPost, "/products", adminToken, ProductReq{Name: "test product", Image: "test.jpg", Category: "test", Price: usd(1000), CountInStock: toInt64Ptr(5)})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var pr ProductRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&pr))
+
+	rec = doRequest(t, h, http.MethodPost, "/orders", adminToken, OrderReq{Items: []OrderItemReq{{ProductID: pr.ID, Quantity: 1}}, PaymentMethod: "card"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var or OrderRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&or))
+
+	rec = doRequest(t, h, http.MethodPost, fmt.Sprintf("/orders/%d/pay", or.ID), adminToken, PayOrderReq{Source: "4242424242424242"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(t, h, http.MethodGet, fmt.Sprintf("/orders/%d/payments", or.ID), adminToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var ps []PaymentRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&ps))
+
+	// 10.00 + 15% tax + 10.00 shipping
+	refund := fmt.Sprintf(`{"id":"evt_1","type":"payment.refunded","reference":%q,"amount":"21.50","currency":"USD"}`, ps[0].Reference)
+	secret := []byte(testPaymentsConfig.WebhookSecret)
+
+	tcs := []struct {
+		name      string
+		provider  string
+		payload   string
+		signature string
+		code      int
+		status    string
+		duplicate bool
+	}{
+		{name: "no signature", provider: "fake", payload: refund, code: http.StatusUnauthorized},
+		{name: "wrong secret", provider: "fake", payload: refund, signature: payments.SignWebhook([]byte("other"), []byte(refund), time.Now()), code: http.StatusUnauthorized},
+		{name: "expired signature", provider: "fake", payload: refund, signature: payments.SignWebhook(secret, []byte(refund), time.Now().Add(-time.Hour)), code: http.StatusUnauthorized},
+		{name: "unknown provider", provider: "other", payload: refund, signature: payments.SignWebhook(secret, []byte(refund), time.Now()), code: http.StatusNotFound},
+		{name: "malformed event", provider: "fake", payload: `{"type":"payment.refunded"}`, code: http.StatusUnprocessableEntity},
+		{name: "refund", provider: "fake", payload: refund, code: http.StatusOK, status: "processed"},
+		{name: "duplicate", provider: "fake", payload: refund, code: http.StatusOK, status: "processed", duplicate: true},
+		{name: "unknown reference", provider: "fake", payload: `{"id":"evt_2","type":"payment.captured","reference":"fake_999","amount":"21.50","currency":"USD"}`, code: http.StatusOK, status: "failed"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			signature := tc.signature
+			if signature == "" && tc.code != http.StatusUnauthorized {
+				signature = payments.SignWebhook(secret, []byte(tc.payload), time.Now())
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/webhooks/payments/"+tc.provider, strings.NewReader(tc.payload))
+			req.Header.Set(payments.SignatureHeader, signature)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			require.Equal(t, tc.code, rec.Code)
+			if tc.code != http.StatusOK {
+				return
+			}
+
+			var res WebhookEventRes
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+			require.Equal(t, tc.status, res.Status)
+			require.Equal(t, tc.duplicate, res.Duplicate)
+		})
+	}
+
+	rec = doRequest(t, h, http.MethodGet, fmt.Sprintf("/orders/%d", or.ID), adminToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&or))
+	require.Equal(t, "refunded", or.Status)
+}
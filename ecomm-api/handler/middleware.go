@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gauss2302/ecomm-service/token"
+)
+
+type authKey struct{}
+
+var (
+	errMissingAuthHeader = errors.New("authorization header is missing")
+	errInvalidAuthHeader = errors.New("invalid authorization header format")
+	errNotAccessToken    = errors.New("token is not an access token")
+)
+
+// GetAuthMiddlewareFunc returns a middleware that verifies the bearer token in
+// the Authorization header and stores its claims in the request context.
+func GetAuthMiddlewareFunc(tokenMaker token.Maker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := verifyClaimsFromAuthHeader(r, tokenMaker)
+			if err != nil {
+				writeProblem(w, r, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), authKey{}, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetOptionalAuthMiddlewareFunc is GetAuthMiddlewareFunc for routes that also
+// serve anonymous requests: a request without an Authorization header passes
+// without claims, but an invalid token is still rejected.
+func GetOptionalAuthMiddlewareFunc(tokenMaker token.Maker) func(http.Handler) http.Handler {
+	auth := GetAuthMiddlewareFunc(tokenMaker)
+	return func(next http.Handler) http.Handler {
+		authNext := auth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			authNext.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Timeout bounds the context of every request by d, so that storer queries
+// still running once the deadline passes are canceled. A non-positive d leaves
+// requests unbounded.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Recoverer turns a panicking handler into a 500 problem response instead of
+// tearing down the connection, and logs the stack trace.
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// the server uses this panic to abort a response on purpose
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+			writeProblem(w, r, http.StatusInternalServerError, "internal server error")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdmin rejects requests whose claims are not marked as admin. It must
+// be mounted after the middleware returned by GetAuthMiddlewareFunc.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r.Context())
+		if !ok {
+			writeProblem(w, r, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if !claims.IsAdmin {
+			writeProblem(w, r, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func verifyClaimsFromAuthHeader(r *http.Request, tokenMaker token.Maker) (*token.UserClaims, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, errMissingAuthHeader
+	}
+
+	fields := strings.Fields(authHeader)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "bearer") {
+		return nil, errInvalidAuthHeader
+	}
+
+	claims, err := tokenMaker.VerifyToken(r.Context(), fields[1])
+	if err != nil {
+		return nil, err
+	}
+	// refresh tokens live much longer and must not authenticate requests
+	if claims.Type != token.AccessToken {
+		return nil, errNotAccessToken
+	}
+
+	return claims, nil
+}
+
+func claimsFromContext(ctx context.Context) (*token.UserClaims, bool) {
+	claims, ok := ctx.Value(authKey{}).(*token.UserClaims)
+	return claims, ok
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gauss2302/ecomm-service/token"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	tokenMaker := token.NewJWTMaker("test secret key", nil)
+
+	userToken, _, err := tokenMaker.CreateToken(1, "user@example.com", false, token.AccessToken, time.Minute)
+	require.NoError(t, err)
+	adminToken, _, err := tokenMaker.CreateToken(2, "admin@example.com", true, token.AccessToken, time.Minute)
+	require.NoError(t, err)
+	expiredToken, _, err := tokenMaker.CreateToken(1, "user@example.com", false, token.AccessToken, -time.Minute)
+	require.NoError(t, err)
+	refreshToken, _, err := tokenMaker.CreateToken(1, "user@example.com", false, token.RefreshToken, time.Hour)
+	require.NoError(t, err)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, found := claimsFromContext(r.Context())
+		require.True(t, found)
+		require.NotEmpty(t, claims.Email)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tcs := []struct {
+		name       string
+		header     string
+		admin      bool
+		wantStatus int
+	}{
+		{name: "missing header", wantStatus: http.StatusUnauthorized},
+		{name: "malformed header", header: userToken, wantStatus: http.StatusUnauthorized},
+		{name: "expired token", header: "Bearer " + expiredToken, wantStatus: http.StatusUnauthorized},
+		{name: "refresh token", header: "Bearer " + refreshToken, wantStatus: http.StatusUnauthorized},
+		{name: "valid token", header: "Bearer " + userToken, wantStatus: http.StatusOK},
+		{name: "non-admin on admin route", header: "Bearer " + userToken, admin: true, wantStatus: http.StatusForbidden},
+		{name: "admin on admin route", header: "Bearer " + adminToken, admin: true, wantStatus: http.StatusOK},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var next http.Handler = ok
+			if tc.admin {
+				next = RequireAdmin(next)
+			}
+			h := GetAuthMiddlewareFunc(tokenMaker)(next)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok = r.Context().Deadline()
+	})
+
+	Timeout(time.Minute)(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+
+	Timeout(0)(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	require.False(t, ok)
+}
+
+func TestRecoverer(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	rec := httptest.NewRecorder()
+	Recoverer(next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
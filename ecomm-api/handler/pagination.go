@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/money"
+)
+
+// queryParams reads typed query parameters, collecting a field error for each
+// one that is malformed instead of stopping at the first.
+type queryParams struct {
+	values url.Values
+	errs   []fieldError
+}
+
+func newQueryParams(r *http.Request) *queryParams {
+	return &queryParams{values: r.URL.Query()}
+}
+
+func (q *queryParams) fail(name, message string) {
+	q.errs = append(q.errs, fieldError{Field: name, Message: message})
+}
+
+func (q *queryParams) int64(name string) int64 {
+	v := q.values.Get(name)
+	if v == "" {
+		return 0
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || i <= 0 {
+		q.fail(name, "must be a positive integer")
+		return 0
+	}
+	return i
+}
+
+// money reads a non-negative amount with at most two decimals. The currency
+// is left empty, filters compare amounts in the store currency.
+func (q *queryParams) money(name string) *money.Money {
+	v := q.values.Get(name)
+	if v == "" {
+		return nil
+	}
+	m, err := money.Parse(v, "")
+	if err != nil || m.Amount < 0 {
+		q.fail(name, "must be a non-negative amount with at most two decimals")
+		return nil
+	}
+	return &m
+}
+
+func (q *queryParams) bool(name string) bool {
+	v := q.values.Get(name)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		q.fail(name, "must be true or false")
+		return false
+	}
+	return b
+}
+
+func (q *queryParams) time(name string) *time.Time {
+	v := q.values.Get(name)
+	if v == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		q.fail(name, "must be an RFC 3339 timestamp")
+		return nil
+	}
+	return &t
+}
+
+// page reads the limit, sort and cursor parameters. A sort field prefixed
+// with "-" sorts in descending order.
+func (q *queryParams) page() storer.Page {
+	var p storer.Page
+	if v := q.values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > storer.MaxPageLimit {
+			q.fail("limit", fmt.Sprintf("must be between 1 and %d", storer.MaxPageLimit))
+		}
+		p.Limit = limit
+	}
+
+	p.Sort = q.values.Get("sort")
+	if strings.HasPrefix(p.Sort, "-") {
+		p.Sort, p.Desc = p.Sort[1:], true
+	}
+
+	if v := q.values.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			q.fail("cursor", "is not a valid cursor")
+		}
+		p.Cursor = c
+	}
+
+	return p
+}
+
+// valid writes a problem listing the malformed parameters, if any, and
+// reports whether there were none.
+func (q *queryParams) valid(w http.ResponseWriter, r *http.Request) bool {
+	if len(q.errs) == 0 {
+		return true
+	}
+	writeFieldProblem(w, r, "query parameters failed validation", q.errs)
+	return false
+}
+
+// encodeCursor makes c opaque to clients, they only hand it back to get the
+// next page.
+func encodeCursor(c *storer.Cursor) string {
+	if c == nil {
+		return ""
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*storer.Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var c storer.Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// setNextLink points the Link header at the next page, which is the request
+// URL with its cursor replaced by next.
+func setNextLink(w http.ResponseWriter, r *http.Request, next string) {
+	if next == "" {
+		return
+	}
+
+	u := *r.URL
+	q := u.Query()
+	q.Set("cursor", next)
+	u.RawQuery = q.Encode()
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+}
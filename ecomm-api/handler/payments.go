@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/payments"
+	"github.com/go-chi/chi"
+)
+
+// payOrder pays a pending order through the payment gateway and moves it to
+// paid. Only the owner of the order or an admin may pay it, as getOwnOrder
+// checks; a declined payment is reported as 402 with the reason of the
+// provider.
+func (h *handler) payOrder(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "error parsing ID")
+		return
+	}
+
+	var req PayOrderReq
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if _, ok := h.getOwnOrder(w, r, i); !ok {
+		return
+	}
+
+	paid, err := h.server.PayOrder(r.Context(), i, req.Source, claims.Email)
+	if err != nil {
+		writeError(w, r, err, "error paying order")
+		return
+	}
+
+	res := toOrderRes(paid)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// listPayments returns every payment attempt of an order, oldest first, to its
+// owner or an admin.
+func (h *handler) listPayments(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "error parsing ID")
+		return
+	}
+
+	if _, ok := h.getOwnOrder(w, r, i); !ok {
+		return
+	}
+
+	orderPayments, err := h.server.ListPayments(r.Context(), i)
+	if err != nil {
+		writeError(w, r, err, "error listing payments")
+		return
+	}
+
+	res := []PaymentRes{}
+	for _, p := range orderPayments {
+		res = append(res, toPaymentRes(p))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// receivePaymentWebhook stores and applies a webhook of a payment provider,
+// once its signature is verified. Every event that is stored is acknowledged
+// with a 200, including those that could not be applied and are left to be
+// replayed, so that the provider stops sending them; other errors make the
+// provider retry.
+func (h *handler) receivePaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must not be larger than %d bytes", maxBytesErr.Limit))
+			return
+		}
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("error reading request body: %v", err))
+		return
+	}
+
+	err = payments.VerifyWebhook([]byte(h.payments.WebhookSecret), r.Header.Get(payments.SignatureHeader), payload, time.Now(), h.payments.WebhookTolerance)
+	if err != nil {
+		writeError(w, r, err, "error verifying webhook")
+		return
+	}
+
+	e, duplicate, err := h.server.ReceiveWebhook(r.Context(), chi.URLParam(r, "provider"), payload)
+	if err != nil {
+		writeError(w, r, err, "error receiving webhook")
+		return
+	}
+
+	res := WebhookEventRes{
+		ID:        e.EventID,
+		Status:    string(e.Status),
+		Error:     e.Error,
+		Duplicate: duplicate,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func toPaymentRes(p storer.Payment) PaymentRes {
+	return PaymentRes{
+		ID:        p.ID,
+		Provider:  p.Provider,
+		Operation: p.Operation,
+		Status:    string(p.Status),
+		Amount:    p.Amount,
+		Currency:  p.Currency,
+		Reference: p.Reference,
+		Error:     p.Error,
+		CreatedAt: p.CreatedAt,
+	}
+}
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/go-chi/chi"
+)
+
+func (h *handler) createPromotion(w http.ResponseWriter, r *http.Request) {
+	var req PromotionReq
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	created, err := h.server.CreatePromotion(r.Context(), toStorerPromotion(req))
+	if err != nil {
+		writeError(w, r, err, "error creating promotion")
+		return
+	}
+
+	res := toPromotionRes(created)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) getPromotion(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "error parsing ID")
+		return
+	}
+
+	p, err := h.server.GetPromotion(r.Context(), i)
+	if err != nil {
+		writeError(w, r, err, "error getting promotion")
+		return
+	}
+
+	res := toPromotionRes(p)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) listPromotions(w http.ResponseWriter, r *http.Request) {
+	promotions, err := h.server.ListPromotions(r.Context())
+	if err != nil {
+		writeError(w, r, err, "error listing promotions")
+		return
+	}
+
+	res := []PromotionRes{}
+	for _, p := range promotions {
+		res = append(res, toPromotionRes(&p))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// updatePromotion changes the rules of a promotion, e.g. to deactivate it.
+// Orders already placed keep the discounts they were given.
+func (h *handler) updatePromotion(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "error parsing ID")
+		return
+	}
+
+	var req PromotionReq
+	if !decodeAndValidatePatch(w, r, &req) {
+		return
+	}
+
+	p, err := h.server.GetPromotion(r.Context(), i)
+	if err != nil {
+		writeError(w, r, err, "error getting promotion")
+		return
+	}
+
+	patchPromotionReq(p, req)
+
+	updated, err := h.server.UpdatePromotion(r.Context(), p)
+	if err != nil {
+		writeError(w, r, err, "error updating promotion")
+		return
+	}
+
+	res := toPromotionRes(updated)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func toStorerPromotion(req PromotionReq) *storer.Promotion {
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	return &storer.Promotion{
+		Code:           req.Code,
+		Description:    req.Description,
+		Kind:           storer.PromotionKind(req.Kind),
+		PercentOff:     req.PercentOff,
+		AmountOff:      req.AmountOff,
+		Currency:       req.Currency,
+		BuyQuantity:    req.BuyQuantity,
+		GetQuantity:    req.GetQuantity,
+		Category:       req.Category,
+		MinSubtotal:    req.MinSubtotal,
+		MaxUses:        req.MaxUses,
+		MaxUsesPerUser: req.MaxUsesPerUser,
+		Stackable:      req.Stackable != nil && *req.Stackable,
+		IsActive:       isActive,
+		StartsAt:       req.StartsAt,
+		EndsAt:         req.EndsAt,
+	}
+}
+
+func patchPromotionReq(p *storer.Promotion, req PromotionReq) {
+	if req.Code != "" {
+		p.Code = req.Code
+	}
+	if req.Description != "" {
+		p.Description = req.Description
+	}
+	if req.Kind != "" {
+		p.Kind = storer.PromotionKind(req.Kind)
+	}
+	if req.PercentOff != 0 {
+		p.PercentOff = req.PercentOff
+	}
+	if !req.AmountOff.IsZero() {
+		p.AmountOff = req.AmountOff
+	}
+	if req.Currency != "" {
+		p.Currency = req.Currency
+	}
+	if req.BuyQuantity != 0 {
+		p.BuyQuantity = req.BuyQuantity
+	}
+	if req.GetQuantity != 0 {
+		p.GetQuantity = req.GetQuantity
+	}
+	if req.Category != "" {
+		p.Category = req.Category
+	}
+	if !req.MinSubtotal.IsZero() {
+		p.MinSubtotal = req.MinSubtotal
+	}
+	if req.MaxUses != nil {
+		p.MaxUses = req.MaxUses
+	}
+	if req.MaxUsesPerUser != nil {
+		p.MaxUsesPerUser = req.MaxUsesPerUser
+	}
+	if req.Stackable != nil {
+		p.Stackable = *req.Stackable
+	}
+	if req.IsActive != nil {
+		p.IsActive = *req.IsActive
+	}
+	if req.StartsAt != nil {
+		p.StartsAt = req.StartsAt
+	}
+	if req.EndsAt != nil {
+		p.EndsAt = req.EndsAt
+	}
+}
+
+func toPromotionRes(p *storer.Promotion) PromotionRes {
+	return PromotionRes{
+		ID:             p.ID,
+		Code:           p.Code,
+		Description:    p.Description,
+		Kind:           string(p.Kind),
+		PercentOff:     p.PercentOff,
+		AmountOff:      p.AmountOff,
+		Currency:       p.Currency,
+		BuyQuantity:    p.BuyQuantity,
+		GetQuantity:    p.GetQuantity,
+		Category:       p.Category,
+		MinSubtotal:    p.MinSubtotal,
+		MaxUses:        p.MaxUses,
+		MaxUsesPerUser: p.MaxUsesPerUser,
+		Uses:           p.Uses,
+		Stackable:      p.Stackable,
+		IsActive:       p.IsActive,
+		StartsAt:       p.StartsAt,
+		EndsAt:         p.EndsAt,
+		CreatedAt:      p.CreatedAt,
+	}
+}
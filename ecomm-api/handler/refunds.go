@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gauss2302/ecomm-service/ecomm-api/server"
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/go-chi/chi"
+)
+
+// refundOrder gives back part or all of what was paid for an order, through
+// the payment gateway if it was paid through it. Admins only; a refund
+// declined by the provider is reported as 402 with its reason.
+func (h *handler) refundOrder(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "error parsing ID")
+		return
+	}
+
+	var req RefundReq
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	refund, err := h.server.RefundOrder(r.Context(), i, toRefundRequest(req), claims.Email)
+	if err != nil {
+		writeError(w, r, err, "error refunding order")
+		return
+	}
+
+	res := toRefundRes(*refund)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+func toRefundRequest(req RefundReq) server.RefundRequest {
+	items := make([]server.RefundRequestItem, len(req.Items))
+	for i, ri := range req.Items {
+		items[i] = server.RefundRequestItem{OrderItemID: ri.OrderItemID, Quantity: ri.Quantity}
+	}
+
+	return server.RefundRequest{
+		Full:     req.Full,
+		Items:    items,
+		Shipping: req.Shipping,
+		Reason:   storer.RefundReason(req.Reason),
+		Note:     req.Note,
+		Restock:  req.Restock,
+	}
+}
+
+func toRefunds(refunds []storer.Refund) []RefundRes {
+	res := []RefundRes{}
+	for _, r := range refunds {
+		res = append(res, toRefundRes(r))
+	}
+	return res
+}
+
+func toRefundRes(r storer.Refund) RefundRes {
+	items := []RefundItemRes{}
+	for _, ri := range r.Items {
+		items = append(items, RefundItemRes{
+			OrderItemID: ri.OrderItemID,
+			Quantity:    ri.Quantity,
+			Amount:      ri.Amount,
+		})
+	}
+
+	return RefundRes{
+		ID:             r.ID,
+		Status:         string(r.Status),
+		Amount:         r.Amount,
+		ShippingAmount: r.ShippingAmount,
+		Currency:       r.Currency,
+		Reason:         string(r.Reason),
+		Note:           r.Note,
+		Restock:        r.Restock,
+		Reference:      r.Reference,
+		Actor:          r.Actor,
+		Items:          items,
+		CreatedAt:      r.CreatedAt,
+	}
+}
@@ -1,49 +1,145 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/gauss2302/ecomm-service/config"
 	"github.com/go-chi/chi"
 )
 
-var r *chi.Mux
-
 func RegisterRoutes(handler *handler) *chi.Mux {
-	r := chi.NewRouter() // Changed from = to :=
+	r := chi.NewRouter()
+	r.Use(Recoverer)
+	authMiddleware := GetAuthMiddlewareFunc(handler.tokenMaker)
 
 	r.Route("/products", func(r chi.Router) {
-		r.Post("/", handler.createProduct)
 		r.Get("/", handler.listProducts)
+		r.With(authMiddleware, RequireAdmin).Post("/", handler.createProduct)
 
 		r.Route("/{id}", func(r chi.Router) {
 			r.Get("/", handler.getProduct)
-			r.Patch("/", handler.updateProduct)
-			r.Delete("/", handler.deleteProduct)
+
+			r.Group(func(r chi.Router) {
+				r.Use(authMiddleware, RequireAdmin)
+				r.Patch("/", handler.updateProduct)
+				r.Delete("/", handler.deleteProduct)
+			})
 		})
 	})
 
 	r.Route("/orders", func(r chi.Router) {
+		r.Use(authMiddleware)
 		r.Post("/", handler.createOrder)
 		r.Get("/", handler.listOrders)
 
 		r.Route("/{id}", func(r chi.Router) {
 			r.Get("/", handler.getOrder)
 			r.Delete("/", handler.deleteOrder)
+			r.Patch("/status", handler.updateOrderStatus)
+			r.Get("/history", handler.listOrderStatusHistory)
+			r.Post("/pay", handler.payOrder)
+			r.Get("/payments", handler.listPayments)
+			r.With(RequireAdmin).Post("/refunds", handler.refundOrder)
 		})
 	})
 
+	r.Route("/cart", func(r chi.Router) {
+		r.Use(GetOptionalAuthMiddlewareFunc(handler.tokenMaker))
+		r.Get("/", handler.getCart)
+		r.Post("/items", handler.addCartItem)
+		r.Patch("/items/{productID}", handler.updateCartItem)
+		r.Delete("/items/{productID}", handler.removeCartItem)
+		r.With(authMiddleware).Post("/checkout", handler.checkoutCart)
+	})
+
+	r.Route("/promotions", func(r chi.Router) {
+		r.Use(authMiddleware, RequireAdmin)
+		r.Post("/", handler.createPromotion)
+		r.Get("/", handler.listPromotions)
+		r.Get("/{id}", handler.getPromotion)
+		r.Patch("/{id}", handler.updatePromotion)
+	})
+
 	r.Route("/users", func(r chi.Router) {
-		r.Post("/", handler.createUser)
-		r.Patch("/", handler.updateUser)
-		r.Get("/", handler.listUsers)
+		// signing up is public, the token of an admin allows creating admins
+		r.With(GetOptionalAuthMiddlewareFunc(handler.tokenMaker)).Post("/", handler.createUser)
+		r.Post("/login", handler.loginUser)
 
-		r.Route("/{id}", func(r chi.Router) {
-			r.Delete("/", handler.deleteUser)
+		r.Group(func(r chi.Router) {
+			r.Use(authMiddleware)
+			r.Patch("/", handler.updateUser)
+			r.Post("/logout", handler.logoutUser)
+
+			r.Group(func(r chi.Router) {
+				r.Use(RequireAdmin)
+				r.Get("/", handler.listUsers)
+				r.Delete("/{id}", handler.deleteUser)
+			})
 		})
 	})
 
+	// webhooks are authenticated by their signature rather than a token
+	r.Post("/webhooks/payments/{provider}", handler.receivePaymentWebhook)
+
+	r.Get("/.well-known/jwks.json", handler.getJWKS)
+
+	r.Route("/tokens", func(r chi.Router) {
+		r.Post("/refresh", handler.refreshToken)
+	})
+
 	return r
 }
-func Start(addr string) error {
-	return http.ListenAndServe(addr, r)
+
+// Start serves the routes of handler on cfg.Addr until ctx is cancelled, then
+// stops accepting connections and waits up to cfg.ShutdownTimeout for
+// in-flight requests to finish.
+func Start(ctx context.Context, cfg config.ServerConfig, handler *handler) error {
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", cfg.Addr, err)
+	}
+
+	srv := &http.Server{
+		Handler:      Timeout(cfg.RequestTimeout)(RegisterRoutes(handler)),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	return serve(ctx, srv, ln, cfg.ShutdownTimeout)
+}
+
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, shutdownTimeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("error serving: %w", err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down server, waiting up to %s for in-flight requests", shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("error shutting down server: %w", err)
+	}
+
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error serving: %w", err)
+	}
+
+	return nil
 }
@@ -1,64 +1,240 @@
 package handler
 
-import "time"
+import (
+	"time"
+
+	"github.com/gauss2302/ecomm-service/money"
+)
 
 type ProductReq struct {
-	Name         string  `json:"name"`
-	Image        string  `json:"image"`
-	Category     string  `json:"category"`
-	Description  string  `json:"description"`
-	Rating       int64   `json:"rating"`
-	NumReviews   int64   `json:"num_reviews"`
-	Price        float64 `json:"price"`
-	CountInStock int64   `json:"count_in_stock"`
+	Name        string      `json:"name" validate:"required,max=255"`
+	Image       string      `json:"image" validate:"max=255"`
+	Category    string      `json:"category" validate:"max=255"`
+	Description string      `json:"description" validate:"max=2000"`
+	Rating      int64       `json:"rating" validate:"gte=0,lte=5"`
+	NumReviews  int64       `json:"num_reviews" validate:"gte=0"`
+	Price       money.Money `json:"price" validate:"gt=0,lte=9999999999"`
+	// Currency defaults to the store currency, which is the only one
+	// accepted.
+	Currency string `json:"currency" validate:"omitempty,iso4217"`
+	// CountInStock is a pointer so that a patch can set the stock to zero.
+	CountInStock *int64 `json:"count_in_stock" validate:"omitempty,gte=0,lte=1000000000"`
+	// IsActive defaults to true for new products; inactive products cannot
+	// be ordered.
+	IsActive *bool `json:"is_active"`
 }
 
 type ProductRes struct {
-	ID           int64      `json:"id"`
-	Name         string     `json:"name"`
-	Image        string     `json:"image"`
-	Category     string     `json:"category"`
-	Description  string     `json:"description"`
-	Rating       int64      `json:"rating"`
-	NumReviews   int64      `json:"num_reviews"`
-	Price        float64    `json:"price"`
-	CountInStock int64      `json:"count_in_stock"`
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    *time.Time `json:"updated_at"`
+	ID           int64       `json:"id"`
+	Name         string      `json:"name"`
+	Image        string      `json:"image"`
+	Category     string      `json:"category"`
+	Description  string      `json:"description"`
+	Rating       int64       `json:"rating"`
+	NumReviews   int64       `json:"num_reviews"`
+	Price        money.Money `json:"price"`
+	Currency     string      `json:"currency"`
+	CountInStock int64       `json:"count_in_stock"`
+	IsActive     bool        `json:"is_active"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    *time.Time  `json:"updated_at"`
+}
+
+type ListProductsRes struct {
+	Products   []ProductRes `json:"products"`
+	NextCursor string       `json:"next_cursor,omitempty"`
 }
 
+// OrderReq only carries what the client chooses; names, prices and totals
+// are computed server-side.
 type OrderReq struct {
-	Items         []OrderItem `json:"items"`
-	PaymentMethod string      `json:"payment_method"`
-	TaxPrice      float64     `json:"tax_price"`
-	ShippingPrice float64     `json:"shipping_price"`
-	TotalPrice    float64     `json:"total_price"`
-	UserID        int64       `json:"user_id"`
+	Items         []OrderItemReq `json:"items" validate:"required,min=1,max=100,dive"`
+	PaymentMethod string         `json:"payment_method" validate:"required,max=255"`
+	// Coupons are the codes of the promotions to apply to the order.
+	Coupons []string `json:"coupons" validate:"max=5,dive,required,max=64"`
+}
+
+type OrderItemReq struct {
+	ProductID int64 `json:"product_id" validate:"gt=0"`
+	Quantity  int64 `json:"quantity" validate:"gt=0,lte=10000"`
 }
 
 type OrderItem struct {
-	Name      string  `json:"name"`
-	Quantity  int64   `json:"quantity"`
-	Image     string  `json:"image"`
-	Price     float64 `json:"price"`
-	ProductID int64   `json:"product_id"`
+	ID        int64       `json:"id"`
+	Name      string      `json:"name"`
+	Quantity  int64       `json:"quantity"`
+	Image     string      `json:"image"`
+	Price     money.Money `json:"price"`
+	ProductID int64       `json:"product_id"`
 }
 
 type OrderRes struct {
 	ID            int64       `json:"id"`
+	Status        string      `json:"status"`
 	Items         []OrderItem `json:"items"`
 	PaymentMethod string      `json:"payment_method"`
-	TaxPrice      float64     `json:"tax_price"`
-	ShippingPrice float64     `json:"shipping_price"`
-	TotalPrice    float64     `json:"total_price"`
+	Currency      string      `json:"currency"`
+	ItemsPrice    money.Money `json:"items_price"`
+	TaxPrice      money.Money `json:"tax_price"`
+	ShippingPrice money.Money `json:"shipping_price"`
+	// DiscountPrice is the sum of the Discounts, already taken off the
+	// TotalPrice.
+	DiscountPrice money.Money        `json:"discount_price"`
+	Discounts     []OrderDiscountRes `json:"discounts"`
+	TotalPrice    money.Money        `json:"total_price"`
+	// RefundedPrice is the sum of the Refunds, given back out of the
+	// TotalPrice.
+	RefundedPrice money.Money `json:"refunded_price"`
+	Refunds       []RefundRes `json:"refunds"`
 	CreatedAt     time.Time   `json:"created_at"`
 	UpdatedAt     *time.Time  `json:"updated_at"`
 }
 
+type OrderDiscountRes struct {
+	Code        string      `json:"code"`
+	Description string      `json:"description"`
+	Amount      money.Money `json:"amount"`
+}
+
+type ListOrdersRes struct {
+	Orders     []OrderRes `json:"orders"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// OrderStatusReq moves an order along its lifecycle. Orders are paid with
+// POST /orders/{id}/pay and refunded with POST /orders/{id}/refunds, so paid
+// and refunded cannot be set here.
+type OrderStatusReq struct {
+	Status string `json:"status" validate:"required,oneof=processing shipped delivered cancelled"`
+}
+
+type OrderStatusChangeRes struct {
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Actor      string    `json:"actor"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// PayOrderReq pays an order. Source is what the customer pays with: a card
+// number for the fake gateway, or a token of the provider.
+type PayOrderReq struct {
+	Source string `json:"source" validate:"required,max=255"`
+}
+
+type PaymentRes struct {
+	ID        int64       `json:"id"`
+	Provider  string      `json:"provider"`
+	Operation string      `json:"operation"`
+	Status    string      `json:"status"`
+	Amount    money.Money `json:"amount"`
+	Currency  string      `json:"currency"`
+	Reference string      `json:"reference,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// RefundReq refunds an order: Quantity units of each of the Items, and the
+// shipping if Shipping is set, or everything not refunded yet if Full is set.
+// Restock puts the refunded items back into stock.
+type RefundReq struct {
+	Full     bool            `json:"full"`
+	Items    []RefundItemReq `json:"items" validate:"max=100,dive"`
+	Shipping bool            `json:"shipping"`
+	Reason   string          `json:"reason" validate:"required,oneof=customer_request damaged defective wrong_item not_delivered duplicate fraudulent other"`
+	Note     string          `json:"note" validate:"max=255"`
+	Restock  bool            `json:"restock"`
+}
+
+type RefundItemReq struct {
+	OrderItemID int64 `json:"order_item_id" validate:"gt=0"`
+	Quantity    int64 `json:"quantity" validate:"gt=0"`
+}
+
+type RefundRes struct {
+	ID             int64           `json:"id"`
+	Status         string          `json:"status"`
+	Amount         money.Money     `json:"amount"`
+	ShippingAmount money.Money     `json:"shipping_amount"`
+	Currency       string          `json:"currency"`
+	Reason         string          `json:"reason"`
+	Note           string          `json:"note,omitempty"`
+	Restock        bool            `json:"restock"`
+	Reference      string          `json:"reference,omitempty"`
+	Actor          string          `json:"actor"`
+	Items          []RefundItemRes `json:"items"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+type RefundItemRes struct {
+	OrderItemID int64       `json:"order_item_id"`
+	Quantity    int64       `json:"quantity"`
+	Amount      money.Money `json:"amount"`
+}
+
+// WebhookEventRes acknowledges a webhook. Duplicate is set for an event
+// received before, which is not applied again.
+type WebhookEventRes struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Duplicate bool   `json:"duplicate"`
+}
+
+// PromotionReq describes a coupon. Which amounts are needed depends on the
+// kind: percent_off for "percentage", amount_off for "fixed_amount", and
+// buy_quantity and get_quantity for "buy_x_get_y".
+type PromotionReq struct {
+	Code        string      `json:"code" validate:"required,max=64"`
+	Description string      `json:"description" validate:"max=255"`
+	Kind        string      `json:"kind" validate:"required,oneof=percentage fixed_amount free_shipping buy_x_get_y"`
+	PercentOff  int64       `json:"percent_off" validate:"gte=0,lte=100"`
+	AmountOff   money.Money `json:"amount_off" validate:"gte=0"`
+	// Currency defaults to the store currency, which is the only one
+	// accepted.
+	Currency    string `json:"currency" validate:"omitempty,iso4217"`
+	BuyQuantity int64  `json:"buy_quantity" validate:"gte=0"`
+	GetQuantity int64  `json:"get_quantity" validate:"gte=0"`
+	// Category restricts the promotion to the items of a category.
+	Category    string      `json:"category" validate:"max=255"`
+	MinSubtotal money.Money `json:"min_subtotal" validate:"gte=0"`
+	// MaxUses and MaxUsesPerUser are unlimited if not set.
+	MaxUses        *int64 `json:"max_uses" validate:"omitempty,gt=0"`
+	MaxUsesPerUser *int64 `json:"max_uses_per_user" validate:"omitempty,gt=0"`
+	// Stackable defaults to false, and IsActive to true for new promotions.
+	Stackable *bool      `json:"stackable"`
+	IsActive  *bool      `json:"is_active"`
+	StartsAt  *time.Time `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at"`
+}
+
+type PromotionRes struct {
+	ID             int64       `json:"id"`
+	Code           string      `json:"code"`
+	Description    string      `json:"description"`
+	Kind           string      `json:"kind"`
+	PercentOff     int64       `json:"percent_off"`
+	AmountOff      money.Money `json:"amount_off"`
+	Currency       string      `json:"currency"`
+	BuyQuantity    int64       `json:"buy_quantity"`
+	GetQuantity    int64       `json:"get_quantity"`
+	Category       string      `json:"category"`
+	MinSubtotal    money.Money `json:"min_subtotal"`
+	MaxUses        *int64      `json:"max_uses"`
+	MaxUsesPerUser *int64      `json:"max_uses_per_user"`
+	Uses           int64       `json:"uses"`
+	Stackable      bool        `json:"stackable"`
+	IsActive       bool        `json:"is_active"`
+	StartsAt       *time.Time  `json:"starts_at"`
+	EndsAt         *time.Time  `json:"ends_at"`
+	CreatedAt      time.Time   `json:"created_at"`
+}
+
+// UserReq is validated against the password policy: at least 8 characters,
+// and at most 72 as bcrypt ignores anything beyond that.
 type UserReq struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Name     string `json:"name" validate:"required,max=255"`
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
 	IsAdmin  bool   `json:"is_admin"`
 }
 
@@ -69,5 +245,72 @@ type UserRes struct {
 }
 
 type ListUserRes struct {
-	Users []UserRes `json:"users"`
+	Users      []UserRes `json:"users"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+type LoginUserReq struct {
+	Email    string `json:"email" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type LoginUserRes struct {
+	SessionID             string    `json:"session_id"`
+	AccessToken           string    `json:"access_token"`
+	RefreshToken          string    `json:"refresh_token"`
+	AccessTokenExpiresAt  time.Time `json:"access_token_expires_at"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+	User                  UserRes   `json:"user"`
+}
+
+type LogoutUserReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshTokenReq struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type RefreshTokenRes struct {
+	SessionID             string    `json:"session_id"`
+	AccessToken           string    `json:"access_token"`
+	RefreshToken          string    `json:"refresh_token"`
+	AccessTokenExpiresAt  time.Time `json:"access_token_expires_at"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+type CartItemReq struct {
+	ProductID int64 `json:"product_id" validate:"gt=0"`
+	Quantity  int64 `json:"quantity" validate:"gt=0,lte=10000"`
+}
+
+type UpdateCartItemReq struct {
+	Quantity int64 `json:"quantity" validate:"gt=0,lte=10000"`
+}
+
+type CheckoutReq struct {
+	PaymentMethod string   `json:"payment_method" validate:"required,max=255"`
+	Coupons       []string `json:"coupons" validate:"max=5,dive,required,max=64"`
+}
+
+// CartRes shows a cart at the current catalogue prices. ID is only set for
+// anonymous carts, which clients send back in the X-Cart-ID header.
+type CartRes struct {
+	ID         string        `json:"id,omitempty"`
+	Items      []CartItemRes `json:"items"`
+	Currency   string        `json:"currency"`
+	ItemsPrice money.Money   `json:"items_price"`
+}
+
+type CartItemRes struct {
+	ProductID    int64       `json:"product_id"`
+	Name         string      `json:"name"`
+	Image        string      `json:"image"`
+	Quantity     int64       `json:"quantity"`
+	UnitPrice    money.Money `json:"unit_price"`
+	Price        money.Money `json:"price"`
+	CountInStock int64       `json:"count_in_stock"`
+	// Warning is set if the item cannot be ordered as it is: the product is
+	// "unavailable", "out_of_stock", or has "insufficient_stock".
+	Warning string `json:"warning,omitempty"`
 }
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/go-playground/validator/v10"
+)
+
+// maxBodyBytes caps the size of request bodies decoded by decodeJSON.
+const maxBodyBytes = 1 << 20
+
+// validate checks the `validate` tags of the request types and reports fields
+// by their JSON names.
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	// amounts are validated in minor units, e.g. gt=0 rejects "0.00"
+	v.RegisterCustomTypeFunc(func(f reflect.Value) interface{} {
+		return f.Interface().(money.Money).Amount
+	}, money.Money{})
+	return v
+}
+
+// fieldError describes why a single request field was rejected.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// decodeJSON decodes the request body into dst, rejecting unknown fields,
+// trailing data and bodies larger than maxBodyBytes. It writes a problem and
+// returns false if the body cannot be decoded.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("body must contain a single JSON value")
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must not be larger than %d bytes", maxBytesErr.Limit))
+			return false
+		}
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return false
+	}
+
+	return true
+}
+
+// decodeAndValidate decodes the request body into dst and validates it.
+func decodeAndValidate(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	return decodeJSON(w, r, dst) && validateReq(w, r, validate.Struct(dst))
+}
+
+// decodeAndValidatePatch is decodeAndValidate for partial updates: only the
+// fields that are set in dst are validated, as zero values leave the stored
+// record unchanged.
+func decodeAndValidatePatch(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	if !decodeJSON(w, r, dst) {
+		return false
+	}
+
+	var fields []string
+	v := reflect.ValueOf(dst).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Field(i).IsZero() {
+			fields = append(fields, v.Type().Field(i).Name)
+		}
+	}
+	if len(fields) == 0 {
+		return true
+	}
+
+	return validateReq(w, r, validate.StructPartial(dst, fields...))
+}
+
+// validateReq writes a 422 problem listing the rejected fields if err is a
+// validation error.
+func validateReq(w http.ResponseWriter, r *http.Request, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("error validating request body: %v", err))
+		return false
+	}
+
+	fields := make([]fieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		fields = append(fields, fieldError{
+			Field:   fieldPath(fe.Namespace()),
+			Message: fieldMessage(fe),
+		})
+	}
+	writeFieldProblem(w, r, "request body failed validation", fields)
+	return false
+}
+
+// fieldPath strips the request type name from a validator namespace, e.g.
+// "OrderReq.items[0].quantity" becomes "items[0].quantity".
+func fieldPath(namespace string) string {
+	_, path, _ := strings.Cut(namespace, ".")
+	return path
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	kind := fe.Kind()
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "iso4217":
+		return "must be an ISO 4217 currency code"
+	case "gt":
+		if kind == reflect.Slice {
+			return fmt.Sprintf("must contain more than %s items", fe.Param())
+		}
+		return fmt.Sprintf("must be greater than %s", fe.Param())
+	case "gte":
+		return fmt.Sprintf("must be greater than or equal to %s", fe.Param())
+	case "lte":
+		return fmt.Sprintf("must be less than or equal to %s", fe.Param())
+	case "min":
+		switch kind {
+		case reflect.String:
+			return fmt.Sprintf("must be at least %s characters long", fe.Param())
+		case reflect.Slice:
+			return fmt.Sprintf("must contain at least %s items", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		switch kind {
+		case reflect.String:
+			return fmt.Sprintf("must be at most %s characters long", fe.Param())
+		case reflect.Slice:
+			return fmt.Sprintf("must contain at most %s items", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	default:
+		return fmt.Sprintf("failed the %q check", fe.Tag())
+	}
+}
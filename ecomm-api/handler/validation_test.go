@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestValidation(t *testing.T) {
+	h := newTestRouter(t)
+
+	rec := doRequest(t, h, http.MethodPost, "/users", "", UserReq{Name: "user", Email: "user@example.com", Password: "password"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	userToken := login(t, h, "user@example.com", "password")
+
+	tcs := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		status int
+		fields []fieldError
+	}{
+		{
+			name:   "invalid user",
+			method: http.MethodPost,
+			path:   "/users",
+			body:   UserReq{Email: "not an email", Password: "short"},
+			status: http.StatusUnprocessableEntity,
+			fields: []fieldError{
+				{Field: "name", Message: "is required"},
+				{Field: "email", Message: "must be a valid email address"},
+				{Field: "password", Message: "must be at least 8 characters long"},
+			},
+		},
+		{
+			name:   "invalid order item",
+			method: http.MethodPost,
+			path:   "/orders",
+			body:   OrderReq{PaymentMethod: "card", Items: []OrderItemReq{{ProductID: 1, Quantity: 0}}},
+			status: http.StatusUnprocessableEntity,
+			fields: []fieldError{
+				{Field: "items[0].quantity", Message: "must be greater than 0"},
+			},
+		},
+		{
+			name:   "order without items",
+			method: http.MethodPost,
+			path:   "/orders",
+			body:   OrderReq{PaymentMethod: "card"},
+			status: http.StatusUnprocessableEntity,
+			fields: []fieldError{
+				{Field: "items", Message: "is required"},
+			},
+		},
+		{
+			name:   "partial update only checks set fields",
+			method: http.MethodPatch,
+			path:   "/users",
+			body:   UserReq{Password: "short"},
+			status: http.StatusUnprocessableEntity,
+			fields: []fieldError{
+				{Field: "password", Message: "must be at least 8 characters long"},
+			},
+		},
+		{
+			name:   "unknown field",
+			method: http.MethodPost,
+			path:   "/users",
+			body:   map[string]string{"name": "user", "email": "other@example.com", "password": "password", "role": "admin"},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "body too large",
+			method: http.MethodPost,
+			path:   "/users",
+			body:   UserReq{Name: strings.Repeat("a", maxBodyBytes), Email: "other@example.com", Password: "password"},
+			status: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := doRequest(t, h, tc.method, tc.path, userToken, tc.body)
+			require.Equal(t, tc.status, rec.Code)
+
+			var p problem
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&p))
+			require.Equal(t, tc.fields, p.Errors)
+		})
+	}
+
+	// a partial update with valid fields goes through
+	rec = doRequest(t, h, http.MethodPatch, "/users", userToken, UserReq{Name: "new name"})
+	require.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/google/uuid"
+)
+
+// MaxCartItems is the number of distinct products a cart may hold, the same
+// as the number of items an order may have.
+const MaxCartItems = 100
+
+// MaxCartQuantity is the quantity of a product a cart may hold, the same as
+// the quantity of an order item.
+const MaxCartQuantity = 10000
+
+// CartWarning tells the shopper why an item of their cart cannot be ordered
+// as it is.
+type CartWarning string
+
+const (
+	// CartWarningUnavailable marks a product that can no longer be ordered.
+	CartWarningUnavailable CartWarning = "unavailable"
+	// CartWarningOutOfStock marks a product with no stock left.
+	CartWarningOutOfStock CartWarning = "out_of_stock"
+	// CartWarningInsufficientStock marks a product with less stock left than
+	// the quantity in the cart.
+	CartWarningInsufficientStock CartWarning = "insufficient_stock"
+)
+
+// CartView is a cart priced from the current catalogue.
+type CartView struct {
+	Cart  *storer.Cart
+	Lines []CartLine
+	// ItemsPrice is the price of the lines that can be ordered, before tax
+	// and shipping.
+	ItemsPrice money.Money
+}
+
+// CartLine is an item of a cart with its product as it is now. Product is nil
+// if the product no longer exists.
+type CartLine struct {
+	Item    storer.CartItem
+	Product *storer.Product
+	// Price is the price of the quantity in the cart, zero if the product is
+	// unavailable.
+	Price   money.Money
+	Warning CartWarning
+}
+
+// FindCart returns the cart of user userID or, for an anonymous shopper
+// (userID 0), the anonymous cart cartID. The cart of a user is never returned
+// for its ID alone. storer.ErrNotFound is returned if there is no such cart.
+func (s *Server) FindCart(ctx context.Context, userID int64, cartID string) (*storer.Cart, error) {
+	if userID != 0 {
+		return s.storer.GetUserCart(ctx, userID)
+	}
+	if cartID == "" {
+		return nil, fmt.Errorf("error getting cart: %w", storer.ErrNotFound)
+	}
+
+	c, err := s.storer.GetCart(ctx, cartID)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserID != nil {
+		return nil, fmt.Errorf("error getting cart: %w", storer.ErrNotFound)
+	}
+
+	return c, nil
+}
+
+// OpenCart is FindCart creating the cart of the user, or a new anonymous cart
+// if cartID is empty, when there is none yet. An unknown anonymous cartID is
+// reported as storer.ErrNotFound rather than replaced, so the client learns
+// its cart is gone.
+func (s *Server) OpenCart(ctx context.Context, userID int64, cartID string) (*storer.Cart, error) {
+	c, err := s.FindCart(ctx, userID, cartID)
+	if err == nil || !errors.Is(err, storer.ErrNotFound) || (userID == 0 && cartID != "") {
+		return c, err
+	}
+
+	nc := &storer.Cart{ID: uuid.NewString()}
+	if userID != 0 {
+		nc.UserID = &userID
+	}
+
+	c, err = s.storer.CreateCart(ctx, nc)
+	if errors.Is(err, storer.ErrConflict) && userID != 0 {
+		// a concurrent request created the cart of the user first
+		return s.storer.GetUserCart(ctx, userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating cart: %w", err)
+	}
+
+	return c, nil
+}
+
+// AddCartItem adds quantity of a product to the cart. Only active products
+// can be added; the stock is not reserved until checkout, ViewCart warns
+// about shortfalls instead.
+func (s *Server) AddCartItem(ctx context.Context, c *storer.Cart, productID, quantity int64) error {
+	p, err := s.storer.GetProduct(ctx, productID)
+	if err != nil {
+		if errors.Is(err, storer.ErrNotFound) {
+			return &storer.ValidationError{Reason: fmt.Sprintf("product %d does not exist", productID)}
+		}
+		return fmt.Errorf("error getting product: %w", err)
+	}
+	if !p.IsActive {
+		return &storer.ValidationError{Reason: fmt.Sprintf("product %d is not available", productID)}
+	}
+
+	held, ok := cartQuantity(c, productID)
+	if len(c.Items) >= MaxCartItems && !ok {
+		return &storer.ValidationError{Reason: fmt.Sprintf("a cart holds at most %d products", MaxCartItems)}
+	}
+	if quantity > MaxCartQuantity-held {
+		return &storer.ValidationError{Reason: fmt.Sprintf("a cart holds at most %d of a product", MaxCartQuantity)}
+	}
+
+	return s.storer.AddCartItem(ctx, c.ID, productID, quantity)
+}
+
+// cartQuantity returns the quantity of the product in c, and whether c holds
+// it at all.
+func cartQuantity(c *storer.Cart, productID int64) (int64, bool) {
+	for _, ci := range c.Items {
+		if ci.ProductID == productID {
+			return ci.Quantity, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Server) UpdateCartItem(ctx context.Context, c *storer.Cart, productID, quantity int64) error {
+	return s.storer.UpdateCartItem(ctx, c.ID, productID, quantity)
+}
+
+func (s *Server) RemoveCartItem(ctx context.Context, c *storer.Cart, productID int64) error {
+	return s.storer.RemoveCartItem(ctx, c.ID, productID)
+}
+
+// ViewCart prices the items of c at the current catalogue prices and flags
+// the ones that cannot be ordered as they are.
+func (s *Server) ViewCart(ctx context.Context, c *storer.Cart) (*CartView, error) {
+	currency := s.pricing.Currency
+	v := &CartView{Cart: c, ItemsPrice: money.New(0, currency)}
+
+	for _, ci := range c.Items {
+		line := CartLine{Item: ci, Price: money.New(0, currency)}
+
+		p, err := s.storer.GetProduct(ctx, ci.ProductID)
+		if err != nil && !errors.Is(err, storer.ErrNotFound) {
+			return nil, fmt.Errorf("error getting product: %w", err)
+		}
+
+		switch {
+		case err != nil:
+			line.Warning = CartWarningUnavailable
+		case !p.IsActive || p.Price.Currency != currency:
+			line.Product = p
+			line.Warning = CartWarningUnavailable
+		default:
+			line.Product = p
+			if line.Price, err = p.Price.Mul(ci.Quantity); err != nil {
+				return nil, &storer.ValidationError{Reason: fmt.Sprintf("price of %d of product %d is out of range", ci.Quantity, ci.ProductID)}
+			}
+			v.ItemsPrice = v.ItemsPrice.Add(line.Price)
+
+			if p.CountInStock <= 0 {
+				line.Warning = CartWarningOutOfStock
+			} else if p.CountInStock < ci.Quantity {
+				line.Warning = CartWarningInsufficientStock
+			}
+		}
+
+		v.Lines = append(v.Lines, line)
+	}
+
+	return v, nil
+}
+
+// Checkout turns the cart into an order of user userID through CreateOrder,
+// so it is priced, discounted by the coupons and its stock reserved like any
+// other order, and empties the cart once the order is placed.
+func (s *Server) Checkout(ctx context.Context, c *storer.Cart, userID int64, paymentMethod string, coupons ...string) (*storer.Order, error) {
+	if len(c.Items) == 0 {
+		return nil, &storer.ValidationError{Reason: "cart is empty"}
+	}
+
+	o := &storer.Order{PaymentMethod: paymentMethod, UserID: userID}
+	for _, ci := range c.Items {
+		o.Items = append(o.Items, storer.OrderItem{ProductID: ci.ProductID, Quantity: ci.Quantity})
+	}
+
+	order, err := s.CreateOrder(ctx, o, coupons...)
+	if err != nil {
+		return nil, err
+	}
+
+	// the order is placed, failing now would only invite a second checkout
+	if err := s.storer.ClearCart(ctx, c.ID); err != nil {
+		log.Printf("error clearing cart %s after order %d: %v", c.ID, order.ID, err)
+	}
+
+	return order, nil
+}
+
+// MergeCart moves the items of the anonymous cart cartID into the cart of
+// user userID, e.g. when the shopper logs in. An unknown cartID, or one that
+// is not anonymous, is ignored. Carts that would hold more than MaxCartItems
+// products or MaxCartQuantity of a product together are rejected with
+// storer.ErrValidation and left as they are.
+func (s *Server) MergeCart(ctx context.Context, cartID string, userID int64) error {
+	anon, err := s.FindCart(ctx, 0, cartID)
+	if err != nil {
+		if errors.Is(err, storer.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("error getting cart: %w", err)
+	}
+
+	uc, err := s.OpenCart(ctx, userID, "")
+	if err != nil {
+		return fmt.Errorf("error opening user cart: %w", err)
+	}
+
+	products := len(uc.Items)
+	for _, ci := range anon.Items {
+		held, ok := cartQuantity(uc, ci.ProductID)
+		if !ok {
+			products++
+		}
+		if ci.Quantity > MaxCartQuantity-held {
+			return &storer.ValidationError{Reason: fmt.Sprintf("a cart holds at most %d of a product", MaxCartQuantity)}
+		}
+	}
+	if products > MaxCartItems {
+		return &storer.ValidationError{Reason: fmt.Sprintf("a cart holds at most %d products", MaxCartItems)}
+	}
+
+	return s.storer.MergeCarts(ctx, anon.ID, uc.ID)
+}
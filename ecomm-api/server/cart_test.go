@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gauss2302/ecomm-service/config"
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/payments"
+	"github.com/stretchr/testify/require"
+)
+
+func TestViewCart(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	srv := NewServer(st, config.PricingConfig{Currency: "USD"}, payments.NewFakeGateway(nil))
+
+	plenty, err := st.CreateProduct(ctx, &storer.Product{Name: "plenty", Price: usd(1000), Currency: "USD", CountInStock: 10, IsActive: true})
+	require.NoError(t, err)
+	scarce, err := st.CreateProduct(ctx, &storer.Product{Name: "scarce", Price: usd(250), Currency: "USD", CountInStock: 1, IsActive: true})
+	require.NoError(t, err)
+	soldOut, err := st.CreateProduct(ctx, &storer.Product{Name: "sold out", Price: usd(100), Currency: "USD", IsActive: true})
+	require.NoError(t, err)
+	retired, err := st.CreateProduct(ctx, &storer.Product{Name: "retired", Price: usd(100), Currency: "USD", CountInStock: 10, IsActive: true})
+	require.NoError(t, err)
+
+	c, err := srv.OpenCart(ctx, 0, "")
+	require.NoError(t, err)
+	require.Nil(t, c.UserID)
+
+	for _, p := range []*storer.Product{plenty, scarce, soldOut, retired} {
+		require.NoError(t, srv.AddCartItem(ctx, c, p.ID, 2))
+	}
+
+	// prices and availability are live, not those at the time of adding
+	retired.IsActive = false
+	_, err = st.UpdateProduct(ctx, retired)
+	require.NoError(t, err)
+	plenty.Price = usd(1200)
+	_, err = st.UpdateProduct(ctx, plenty)
+	require.NoError(t, err)
+
+	c, err = srv.FindCart(ctx, 0, c.ID)
+	require.NoError(t, err)
+	v, err := srv.ViewCart(ctx, c)
+	require.NoError(t, err)
+
+	warnings := make(map[int64]CartWarning)
+	for _, l := range v.Lines {
+		warnings[l.Item.ProductID] = l.Warning
+	}
+	require.Equal(t, map[int64]CartWarning{
+		plenty.ID:  "",
+		scarce.ID:  CartWarningInsufficientStock,
+		soldOut.ID: CartWarningOutOfStock,
+		retired.ID: CartWarningUnavailable,
+	}, warnings)
+	// 2*12.00 + 2*2.50 + 2*1.00, without the retired product
+	require.Equal(t, usd(3100), v.ItemsPrice)
+
+	err = srv.AddCartItem(ctx, c, retired.ID, 1)
+	require.ErrorIs(t, err, storer.ErrValidation)
+}
+
+func TestCheckoutCart(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	srv := NewServer(st, config.PricingConfig{Currency: "USD", TaxRate: 0.1}, payments.NewFakeGateway(nil))
+
+	p, err := st.CreateProduct(ctx, &storer.Product{Name: "product", Price: usd(1000), Currency: "USD", CountInStock: 5, IsActive: true})
+	require.NoError(t, err)
+	u, err := st.CreateUser(ctx, &storer.User{Name: "user", Email: "user@example.com"})
+	require.NoError(t, err)
+
+	// the anonymous cart is merged into the cart of the user on login
+	anon, err := srv.OpenCart(ctx, 0, "")
+	require.NoError(t, err)
+	require.NoError(t, srv.AddCartItem(ctx, anon, p.ID, 1))
+
+	uc, err := srv.OpenCart(ctx, u.ID, "")
+	require.NoError(t, err)
+	require.NoError(t, srv.AddCartItem(ctx, uc, p.ID, 2))
+
+	require.NoError(t, srv.MergeCart(ctx, anon.ID, u.ID))
+	_, err = srv.FindCart(ctx, 0, anon.ID)
+	require.ErrorIs(t, err, storer.ErrNotFound)
+
+	// the cart of a user cannot be opened anonymously by its ID
+	_, err = srv.OpenCart(ctx, 0, uc.ID)
+	require.ErrorIs(t, err, storer.ErrNotFound)
+
+	uc, err = srv.OpenCart(ctx, u.ID, "")
+	require.NoError(t, err)
+	require.Len(t, uc.Items, 1)
+	require.Equal(t, int64(3), uc.Items[0].Quantity)
+
+	o, err := srv.Checkout(ctx, uc, u.ID, "card")
+	require.NoError(t, err)
+	require.Equal(t, u.ID, o.UserID)
+	require.Equal(t, usd(3300), o.TotalPrice)
+	requireProductStock(t, st, p.ID, 2)
+
+	uc, err = srv.FindCart(ctx, u.ID, "")
+	require.NoError(t, err)
+	require.Empty(t, uc.Items)
+
+	_, err = srv.Checkout(ctx, uc, u.ID, "card")
+	require.ErrorIs(t, err, storer.ErrValidation)
+
+	// a cart asking for more than is in stock is left as it is
+	require.NoError(t, srv.AddCartItem(ctx, uc, p.ID, 3))
+	uc, err = srv.FindCart(ctx, u.ID, "")
+	require.NoError(t, err)
+	_, err = srv.Checkout(ctx, uc, u.ID, "card")
+	require.ErrorIs(t, err, storer.ErrConflict)
+
+	uc, err = srv.FindCart(ctx, u.ID, "")
+	require.NoError(t, err)
+	require.Len(t, uc.Items, 1)
+}
+
+func TestCartLimits(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	srv := NewServer(st, config.PricingConfig{Currency: "USD"}, payments.NewFakeGateway(nil))
+
+	var products []*storer.Product
+	for i := 0; i <= MaxCartItems; i++ {
+		p, err := st.CreateProduct(ctx, &storer.Product{Name: "product", Price: usd(100), Currency: "USD", CountInStock: 10, IsActive: true})
+		require.NoError(t, err)
+		products = append(products, p)
+	}
+	u, err := st.CreateUser(ctx, &storer.User{Name: "user", Email: "user@example.com"})
+	require.NoError(t, err)
+
+	// repeated adds cannot get past the quantity of an order item
+	uc, err := srv.OpenCart(ctx, u.ID, "")
+	require.NoError(t, err)
+	require.NoError(t, srv.AddCartItem(ctx, uc, products[0].ID, MaxCartQuantity-1))
+	uc, err = srv.FindCart(ctx, u.ID, "")
+	require.NoError(t, err)
+	err = srv.AddCartItem(ctx, uc, products[0].ID, 2)
+	require.ErrorIs(t, err, storer.ErrValidation)
+	require.NoError(t, srv.AddCartItem(ctx, uc, products[0].ID, 1))
+
+	// nor can merging an anonymous cart
+	anon, err := srv.OpenCart(ctx, 0, "")
+	require.NoError(t, err)
+	require.NoError(t, srv.AddCartItem(ctx, anon, products[0].ID, 1))
+	err = srv.MergeCart(ctx, anon.ID, u.ID)
+	require.ErrorIs(t, err, storer.ErrValidation)
+
+	// the carts are left as they are
+	anon, err = srv.FindCart(ctx, 0, anon.ID)
+	require.NoError(t, err)
+	require.Len(t, anon.Items, 1)
+	uc, err = srv.FindCart(ctx, u.ID, "")
+	require.NoError(t, err)
+	require.Equal(t, int64(MaxCartQuantity), uc.Items[0].Quantity)
+
+	// nor hold more products than an order may have
+	require.NoError(t, srv.RemoveCartItem(ctx, anon, products[0].ID))
+	for _, p := range products[1:] {
+		anon, err = srv.FindCart(ctx, 0, anon.ID)
+		require.NoError(t, err)
+		require.NoError(t, srv.AddCartItem(ctx, anon, p.ID, 1))
+	}
+	err = srv.MergeCart(ctx, anon.ID, u.ID)
+	require.ErrorIs(t, err, storer.ErrValidation)
+
+	uc, err = srv.FindCart(ctx, u.ID, "")
+	require.NoError(t, err)
+	require.Len(t, uc.Items, 1)
+}
+
+func requireProductStock(t *testing.T, st storer.Storer, productID, want int64) {
+	t.Helper()
+
+	p, err := st.GetProduct(context.Background(), productID)
+	require.NoError(t, err)
+	require.Equal(t, want, p.CountInStock)
+}
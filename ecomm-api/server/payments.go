@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/gauss2302/ecomm-service/payments"
+)
+
+// PayOrder pays the total price of pending order id from source through the
+// gateway and moves the order to paid on behalf of actor. The amount is
+// authorized and captured at once; a capture that fails voids the
+// authorization, and a payment for an order that can no longer be marked paid,
+// e.g. because it was cancelled concurrently, is refunded. An order marked
+// paid in the meantime by the capture webhook of this same payment is paid
+// already and returned as such. Every operation is recorded as a
+// storer.Payment.
+//
+// A payment declined by the provider is rejected with a
+// *payments.DeclinedError, and an order that is not pending with a
+// *storer.ConflictError.
+func (s *Server) PayOrder(ctx context.Context, id int64, source, actor string) (*storer.Order, error) {
+	o, err := s.storer.GetOrder(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+
+	if o.Status != storer.OrderStatusPending {
+		return nil, &storer.ConflictError{Reason: fmt.Sprintf("order is %s, only pending orders can be paid", o.Status)}
+	}
+
+	// nothing to collect, e.g. when coupons cover the whole order
+	if o.TotalPrice.IsZero() {
+		return s.markPaid(ctx, id, actor)
+	}
+
+	auth, err := s.gateway.Authorize(ctx, payments.AuthorizeRequest{OrderID: o.ID, Amount: o.TotalPrice, Source: source})
+	s.recordPayment(ctx, o.ID, payments.OperationAuthorize, o.TotalPrice, auth, err)
+	if err != nil {
+		return nil, fmt.Errorf("error authorizing payment: %w", err)
+	}
+	if !auth.Approved {
+		return nil, &payments.DeclinedError{Reason: auth.DeclineReason}
+	}
+
+	capture, err := s.gateway.Capture(ctx, auth.Reference, o.TotalPrice)
+	s.recordPayment(ctx, o.ID, payments.OperationCapture, o.TotalPrice, capture, err)
+	if err != nil || !capture.Approved {
+		res, voidErr := s.gateway.Void(ctx, auth.Reference)
+		s.recordPayment(ctx, o.ID, payments.OperationVoid, o.TotalPrice, res, voidErr)
+		if err != nil {
+			return nil, fmt.Errorf("error capturing payment: %w", err)
+		}
+		return nil, &payments.DeclinedError{Reason: capture.DeclineReason}
+	}
+
+	paid, err := s.markPaid(ctx, id, actor)
+	if err != nil {
+		if paid, ok := s.paidBy(ctx, id, auth.Reference); ok {
+			return paid, nil
+		}
+		res, refundErr := s.gateway.Refund(ctx, auth.Reference, o.TotalPrice)
+		s.recordPayment(ctx, o.ID, payments.OperationRefund, o.TotalPrice, res, refundErr)
+		return nil, err
+	}
+
+	return paid, nil
+}
+
+// paidBy returns order id if it was paid by the authorization with the given
+// reference, whose capture the provider notified, e.g. when the webhook of
+// the capture arrives before PayOrder marks the order paid.
+func (s *Server) paidBy(ctx context.Context, id int64, reference string) (*storer.Order, bool) {
+	o, err := s.storer.GetOrder(ctx, id)
+	if err != nil {
+		log.Printf("error getting order %d: %v", id, err)
+		return nil, false
+	}
+	switch o.Status {
+	case storer.OrderStatusPaid, storer.OrderStatusProcessing, storer.OrderStatusShipped, storer.OrderStatusDelivered:
+	default:
+		return nil, false
+	}
+
+	ps, err := s.storer.ListPayments(ctx, id)
+	if err != nil {
+		log.Printf("error listing payments of order %d: %v", id, err)
+		return nil, false
+	}
+	for _, p := range ps {
+		if p.Operation == string(payments.OperationCapture) && p.Status == storer.PaymentSucceeded && p.Reference == reference {
+			return o, true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *Server) ListPayments(ctx context.Context, orderID int64) ([]storer.Payment, error) {
+	return s.storer.ListPayments(ctx, orderID)
+}
+
+// recordPayment stores the outcome of an operation made with the gateway,
+// res or err. The money has already moved by then, so failing to record it
+// is logged rather than failing the payment.
+func (s *Server) recordPayment(ctx context.Context, orderID int64, op payments.Operation, amount money.Money, res *payments.Result, err error) {
+	p := &storer.Payment{
+		OrderID:   orderID,
+		Provider:  s.gateway.Name(),
+		Operation: string(op),
+		Amount:    amount,
+		Currency:  amount.Currency,
+	}
+	switch {
+	case err != nil:
+		p.Status = storer.PaymentFailed
+		p.Error = truncate(err.Error(), 255)
+	case res.Approved:
+		p.Status = storer.PaymentSucceeded
+		p.Reference = res.Reference
+	default:
+		p.Status = storer.PaymentDeclined
+		p.Reference = res.Reference
+		p.Error = res.DeclineReason
+	}
+
+	if _, err := s.storer.CreatePayment(ctx, p); err != nil {
+		log.Printf("error recording %s payment %q of order %d: %v", op, p.Reference, orderID, err)
+	}
+}
+
+// truncate cuts s to at most n bytes, for columns of a limited size.
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/gauss2302/ecomm-service/config"
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/gauss2302/ecomm-service/payments"
+	"github.com/stretchr/testify/require"
+)
+
+// captureGateway is the fake gateway with its captures replaced.
+type captureGateway struct {
+	*payments.FakeGateway
+	capture func(ctx context.Context, reference string, amount money.Money) (*payments.Result, error)
+}
+
+func (g *captureGateway) Capture(ctx context.Context, reference string, amount money.Money) (*payments.Result, error) {
+	return g.capture(ctx, reference, amount)
+}
+
+func TestPayOrder(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	fake := payments.NewFakeGateway([]string{"4000000000000002:do_not_honor"})
+	gateway := &captureGateway{FakeGateway: fake, capture: fake.Capture}
+	srv := NewServer(st, config.PricingConfig{Currency: "USD"}, gateway)
+
+	p, err := st.CreateProduct(ctx, &storer.Product{Name: "product", Price: usd(1000), CountInStock: 100, IsActive: true})
+	require.NoError(t, err)
+
+	newOrder := func(t *testing.T) *storer.Order {
+		o, err := srv.CreateOrder(ctx, &storer.Order{PaymentMethod: "card", UserID: 1, Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}})
+		require.NoError(t, err)
+		return o
+	}
+
+	requirePayments := func(t *testing.T, orderID int64, want ...string) []storer.Payment {
+		t.Helper()
+
+		ps, err := srv.ListPayments(ctx, orderID)
+		require.NoError(t, err)
+		var got []string
+		for _, p := range ps {
+			got = append(got, p.Operation+" "+string(p.Status))
+		}
+		require.Equal(t, want, got)
+		return ps
+	}
+
+	t.Run("success", func(t *testing.T) {
+		o := newOrder(t)
+		paid, err := srv.PayOrder(ctx, o.ID, "4242424242424242", "user@example.com")
+		require.NoError(t, err)
+		require.Equal(t, storer.OrderStatusPaid, paid.Status)
+
+		ps := requirePayments(t, o.ID, "authorize succeeded", "capture succeeded")
+		require.Equal(t, "fake", ps[0].Provider)
+		require.Equal(t, usd(1000), ps[1].Amount)
+		require.NotEmpty(t, ps[1].Reference)
+
+		_, err = srv.PayOrder(ctx, o.ID, "4242424242424242", "user@example.com")
+		require.ErrorIs(t, err, storer.ErrConflict)
+	})
+
+	t.Run("declined", func(t *testing.T) {
+		o := newOrder(t)
+		_, err := srv.PayOrder(ctx, o.ID, "4000000000000002", "user@example.com")
+		require.ErrorIs(t, err, payments.ErrDeclined)
+		var derr *payments.DeclinedError
+		require.ErrorAs(t, err, &derr)
+		require.Equal(t, "do_not_honor", derr.Reason)
+
+		ps := requirePayments(t, o.ID, "authorize declined")
+		require.Equal(t, "do_not_honor", ps[0].Error)
+
+		got, err := srv.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+		require.Equal(t, storer.OrderStatusPending, got.Status)
+	})
+
+	t.Run("declined capture voids the authorization", func(t *testing.T) {
+		gateway.capture = func(context.Context, string, money.Money) (*payments.Result, error) {
+			return &payments.Result{DeclineReason: "processing_error"}, nil
+		}
+		defer func() { gateway.capture = fake.Capture }()
+
+		o := newOrder(t)
+		_, err := srv.PayOrder(ctx, o.ID, "4242424242424242", "user@example.com")
+		require.ErrorIs(t, err, payments.ErrDeclined)
+		requirePayments(t, o.ID, "authorize succeeded", "capture declined", "void succeeded")
+	})
+
+	t.Run("order cancelled while paying is refunded", func(t *testing.T) {
+		o := newOrder(t)
+		gateway.capture = func(ctx context.Context, reference string, amount money.Money) (*payments.Result, error) {
+			_, err := srv.UpdateOrderStatus(ctx, o.ID, storer.OrderStatusCancelled, "user@example.com")
+			require.NoError(t, err)
+			return fake.Capture(ctx, reference, amount)
+		}
+		defer func() { gateway.capture = fake.Capture }()
+
+		_, err := srv.PayOrder(ctx, o.ID, "4242424242424242", "user@example.com")
+		require.ErrorIs(t, err, storer.ErrConflict)
+		requirePayments(t, o.ID, "authorize succeeded", "capture succeeded", "refund succeeded")
+	})
+
+	t.Run("order paid by the capture webhook meanwhile", func(t *testing.T) {
+		o := newOrder(t)
+		gateway.capture = func(ctx context.Context, reference string, amount money.Money) (*payments.Result, error) {
+			payload := fmt.Sprintf(`{"id":"evt_%d","type":"payment.captured","reference":%q,"amount":"10.00","currency":"USD"}`, o.ID, reference)
+			e, _, err := srv.ReceiveWebhook(ctx, "fake", []byte(payload))
+			require.NoError(t, err)
+			require.Equal(t, storer.WebhookEventProcessed, e.Status)
+			return fake.Capture(ctx, reference, amount)
+		}
+		defer func() { gateway.capture = fake.Capture }()
+
+		paid, err := srv.PayOrder(ctx, o.ID, "4242424242424242", "user@example.com")
+		require.NoError(t, err)
+		require.Equal(t, storer.OrderStatusPaid, paid.Status)
+		requirePayments(t, o.ID, "authorize succeeded", "capture succeeded", "capture succeeded")
+	})
+
+	_, err = srv.PayOrder(ctx, 999, "4242424242424242", "user@example.com")
+	require.ErrorIs(t, err, storer.ErrNotFound)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/money"
+)
+
+// priceOrder replaces the client supplied details of o with ones computed from
+// the product catalogue, and applies the promotions of the coupons. Only the
+// product ID and quantity of each item are kept; items for the same product
+// are merged. An order for a product that does not exist, is inactive or is
+// priced in another currency than the store, or with a coupon that does not
+// apply, is rejected with storer.ErrValidation, as is an order whose
+// quantities or prices are out of range.
+//
+// All amounts are exact: the tax is computed on the items price less the
+// item discounts and rounded half away from zero to the cent, once.
+func (s *Server) priceOrder(ctx context.Context, o *storer.Order, coupons []string) error {
+	currency := s.pricing.Currency
+
+	promotions, err := s.findPromotions(ctx, coupons)
+	if err != nil {
+		return err
+	}
+
+	var items []storer.OrderItem
+	categories := make(map[int64]string)
+	index := make(map[int64]int)
+	for _, oi := range o.Items {
+		if oi.Quantity <= 0 {
+			return &storer.ValidationError{Reason: fmt.Sprintf("quantity of product %d must be positive", oi.ProductID)}
+		}
+		if i, ok := index[oi.ProductID]; ok {
+			if oi.Quantity > math.MaxInt64-items[i].Quantity {
+				return &storer.ValidationError{Reason: fmt.Sprintf("quantity of product %d is out of range", oi.ProductID)}
+			}
+			items[i].Quantity += oi.Quantity
+			continue
+		}
+
+		p, err := s.storer.GetProduct(ctx, oi.ProductID)
+		if err != nil {
+			if errors.Is(err, storer.ErrNotFound) {
+				return &storer.ValidationError{Reason: fmt.Sprintf("product %d does not exist", oi.ProductID)}
+			}
+			return fmt.Errorf("error getting product: %w", err)
+		}
+		if !p.IsActive {
+			return &storer.ValidationError{Reason: fmt.Sprintf("product %d is not available", oi.ProductID)}
+		}
+		if p.Price.Currency != currency {
+			return &storer.ValidationError{Reason: fmt.Sprintf("product %d is priced in %s, not %s", oi.ProductID, p.Price.Currency, currency)}
+		}
+
+		index[oi.ProductID] = len(items)
+		categories[p.ID] = p.Category
+		items = append(items, storer.OrderItem{
+			Name:      p.Name,
+			Quantity:  oi.Quantity,
+			Image:     p.Image,
+			Price:     p.Price,
+			ProductID: p.ID,
+		})
+	}
+
+	itemsPrice := money.New(0, currency)
+	for _, oi := range items {
+		line, err := oi.Price.Mul(oi.Quantity)
+		if err != nil || line.Amount > money.MaxAmount {
+			return &storer.ValidationError{Reason: fmt.Sprintf("price of %d of product %d is out of range", oi.Quantity, oi.ProductID)}
+		}
+		// both are at most MaxAmount, so the sum cannot overflow
+		itemsPrice = itemsPrice.Add(line)
+		if itemsPrice.Amount > money.MaxAmount {
+			return &storer.ValidationError{Reason: "items price is out of range"}
+		}
+	}
+
+	shippingPrice := money.FromFloat(s.pricing.ShippingPrice, currency)
+	threshold := money.FromFloat(s.pricing.FreeShippingThreshold, currency)
+	if !threshold.IsZero() && itemsPrice.Cmp(threshold) >= 0 {
+		shippingPrice = money.New(0, currency)
+	}
+
+	// item discounts are capped at the items price, the lines applied last
+	// taking what is left
+	itemsDiscount := money.New(0, currency)
+	shippingDiscount := money.New(0, currency)
+	var discounts []storer.OrderDiscount
+	for i := range promotions {
+		p := &promotions[i]
+		amount, err := discount(p, items, categories, shippingPrice)
+		if err != nil {
+			return err
+		}
+
+		if p.Kind == storer.PromotionFreeShipping {
+			shippingDiscount = amount
+		} else {
+			if left := itemsPrice.Sub(itemsDiscount); amount.Cmp(left) > 0 {
+				amount = left
+			}
+			itemsDiscount = itemsDiscount.Add(amount)
+		}
+
+		discounts = append(discounts, storer.OrderDiscount{
+			PromotionID: p.ID,
+			Code:        p.Code,
+			Description: p.Description,
+			Amount:      amount,
+		})
+	}
+
+	taxPrice := itemsPrice.Sub(itemsDiscount).MulRate(s.pricing.TaxRate)
+	discountPrice := itemsDiscount.Add(shippingDiscount)
+
+	o.Items = items
+	o.Discounts = discounts
+	o.Currency = currency
+	o.ItemsPrice = itemsPrice
+	o.TaxPrice = taxPrice
+	o.ShippingPrice = shippingPrice
+	o.DiscountPrice = discountPrice
+	o.TotalPrice = itemsPrice.Add(taxPrice).Add(shippingPrice).Sub(discountPrice)
+	if o.TotalPrice.Amount > money.MaxAmount {
+		return &storer.ValidationError{Reason: "total price is out of range"}
+	}
+
+	return nil
+}
+
+// checkProductCurrency prices p in the store currency unless it names one, and
+// rejects products priced in any other currency, as orders could not be
+// priced from them.
+func (s *Server) checkProductCurrency(p *storer.Product) error {
+	if p.Currency == "" {
+		p.Currency = s.pricing.Currency
+	}
+	if p.Currency != s.pricing.Currency {
+		return &storer.ValidationError{Reason: fmt.Sprintf("products must be priced in %s", s.pricing.Currency)}
+	}
+	p.Price.Currency = p.Currency
+
+	return nil
+}
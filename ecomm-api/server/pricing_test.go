@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gauss2302/ecomm-service/config"
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/gauss2302/ecomm-service/payments"
+	"github.com/stretchr/testify/require"
+)
+
+func usd(amount int64) money.Money {
+	return money.New(amount, "USD")
+}
+
+func TestCreateOrderPricing(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	srv := NewServer(st, config.PricingConfig{Currency: "USD", TaxRate: 0.15, ShippingPrice: 10, FreeShippingThreshold: 100}, payments.NewFakeGateway(nil))
+
+	cheap, err := st.CreateProduct(ctx, &storer.Product{Name: "cheap", Image: "cheap.jpg", Price: money.New(999, "USD"), Currency: "USD", CountInStock: 10, IsActive: true})
+	require.NoError(t, err)
+	pricey, err := st.CreateProduct(ctx, &storer.Product{Name: "pricey", Image: "pricey.jpg", Price: money.New(12000, "USD"), Currency: "USD", CountInStock: 10, IsActive: true})
+	require.NoError(t, err)
+	inactive, err := st.CreateProduct(ctx, &storer.Product{Name: "inactive", Price: money.New(500, "USD"), Currency: "USD", CountInStock: 10})
+	require.NoError(t, err)
+	foreign, err := st.CreateProduct(ctx, &storer.Product{Name: "foreign", Price: money.New(500, "EUR"), Currency: "EUR", CountInStock: 10, IsActive: true})
+	require.NoError(t, err)
+
+	tcs := []struct {
+		name  string
+		items []storer.OrderItem
+		want  storer.Order
+		err   error
+	}{
+		{
+			name: "client prices are ignored and lines are merged",
+			items: []storer.OrderItem{
+				{ProductID: cheap.ID, Quantity: 1, Name: "fake", Price: money.New(1, "USD")},
+				{ProductID: cheap.ID, Quantity: 2},
+			},
+			// 15% of 29.97 is 4.4955, rounded half up to 4.50
+			want: storer.Order{ItemsPrice: usd(2997), TaxPrice: usd(450), ShippingPrice: usd(1000), TotalPrice: usd(4447)},
+		},
+		{
+			name:  "free shipping",
+			items: []storer.OrderItem{{ProductID: pricey.ID, Quantity: 1}},
+			want:  storer.Order{ItemsPrice: usd(12000), TaxPrice: usd(1800), ShippingPrice: usd(0), TotalPrice: usd(13800)},
+		},
+		{
+			name:  "unknown product",
+			items: []storer.OrderItem{{ProductID: 999, Quantity: 1}},
+			err:   storer.ErrValidation,
+		},
+		{
+			name:  "inactive product",
+			items: []storer.OrderItem{{ProductID: inactive.ID, Quantity: 1}},
+			err:   storer.ErrValidation,
+		},
+		{
+			name:  "product in another currency",
+			items: []storer.OrderItem{{ProductID: foreign.ID, Quantity: 1}},
+			err:   storer.ErrValidation,
+		},
+		{
+			name:  "price out of range",
+			items: []storer.OrderItem{{ProductID: pricey.ID, Quantity: 1 << 60}},
+			err:   storer.ErrValidation,
+		},
+		{
+			name:  "merged quantity out of range",
+			items: []storer.OrderItem{{ProductID: cheap.ID, Quantity: 1 << 62}, {ProductID: cheap.ID, Quantity: 1 << 62}},
+			err:   storer.ErrValidation,
+		},
+		{
+			name:  "items price out of range",
+			items: []storer.OrderItem{{ProductID: pricey.ID, Quantity: 1_000_000}},
+			err:   storer.ErrValidation,
+		},
+		{
+			name:  "no quantity",
+			items: []storer.OrderItem{{ProductID: cheap.ID, Quantity: 0}},
+			err:   storer.ErrValidation,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			o, err := srv.CreateOrder(ctx, &storer.Order{PaymentMethod: "card", Items: tc.items})
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Equal(t, "USD", o.Currency)
+			require.Equal(t, tc.want.ItemsPrice, o.ItemsPrice)
+			require.Equal(t, tc.want.TaxPrice, o.TaxPrice)
+			require.Equal(t, tc.want.ShippingPrice, o.ShippingPrice)
+			require.Equal(t, tc.want.TotalPrice, o.TotalPrice)
+			for _, oi := range o.Items {
+				p, err := st.GetProduct(ctx, oi.ProductID)
+				require.NoError(t, err)
+				require.Equal(t, p.Name, oi.Name)
+				require.Equal(t, p.Price, oi.Price)
+			}
+		})
+	}
+}
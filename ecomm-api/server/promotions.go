@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/money"
+)
+
+// CreatePromotion checks p, see checkPromotion, before storing it.
+func (s *Server) CreatePromotion(ctx context.Context, p *storer.Promotion) (*storer.Promotion, error) {
+	if err := s.checkPromotion(p); err != nil {
+		return nil, fmt.Errorf("error checking promotion: %w", err)
+	}
+
+	return s.storer.CreatePromotion(ctx, p)
+}
+
+func (s *Server) GetPromotion(ctx context.Context, id int64) (*storer.Promotion, error) {
+	return s.storer.GetPromotion(ctx, id)
+}
+
+func (s *Server) ListPromotions(ctx context.Context) ([]storer.Promotion, error) {
+	return s.storer.ListPromotions(ctx)
+}
+
+func (s *Server) UpdatePromotion(ctx context.Context, p *storer.Promotion) (*storer.Promotion, error) {
+	if err := s.checkPromotion(p); err != nil {
+		return nil, fmt.Errorf("error checking promotion: %w", err)
+	}
+
+	return s.storer.UpdatePromotion(ctx, p)
+}
+
+// checkPromotion normalizes the code of p and rejects rules that could never
+// apply: a kind without the amounts it needs, a validity window ending before
+// it starts, or amounts in another currency than the store.
+func (s *Server) checkPromotion(p *storer.Promotion) error {
+	p.Code = normalizeCoupon(p.Code)
+	if p.Code == "" {
+		return &storer.ValidationError{Reason: "code is required"}
+	}
+
+	if p.Currency == "" {
+		p.Currency = s.pricing.Currency
+	}
+	if p.Currency != s.pricing.Currency {
+		return &storer.ValidationError{Reason: fmt.Sprintf("promotions must be in %s", s.pricing.Currency)}
+	}
+	p.AmountOff.Currency = p.Currency
+	p.MinSubtotal.Currency = p.Currency
+
+	switch p.Kind {
+	case storer.PromotionPercentage:
+		if p.PercentOff < 1 || p.PercentOff > 100 {
+			return &storer.ValidationError{Reason: "percent_off must be between 1 and 100"}
+		}
+	case storer.PromotionFixedAmount:
+		if p.AmountOff.Amount <= 0 {
+			return &storer.ValidationError{Reason: "amount_off must be greater than 0"}
+		}
+	case storer.PromotionBuyXGetY:
+		if p.BuyQuantity < 1 || p.GetQuantity < 1 {
+			return &storer.ValidationError{Reason: "buy_quantity and get_quantity must be at least 1"}
+		}
+	case storer.PromotionFreeShipping:
+	default:
+		return &storer.ValidationError{Reason: fmt.Sprintf("unknown promotion kind %q", p.Kind)}
+	}
+
+	if p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt) {
+		return &storer.ValidationError{Reason: "ends_at must be after starts_at"}
+	}
+
+	return nil
+}
+
+// normalizeCoupon makes coupon codes case insensitive.
+func normalizeCoupon(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// findPromotions looks up the promotions of the coupon codes, ignoring
+// duplicates, and checks they can be used together now. Unknown, inactive or
+// expired coupons are rejected with storer.ErrValidation. Usage limits are
+// left to the storer, which checks them as it creates the order.
+func (s *Server) findPromotions(ctx context.Context, codes []string) ([]storer.Promotion, error) {
+	now := time.Now()
+	seen := make(map[string]bool)
+
+	var promotions []storer.Promotion
+	for _, code := range codes {
+		code = normalizeCoupon(code)
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+
+		p, err := s.storer.GetPromotionByCode(ctx, code)
+		if err != nil {
+			if errors.Is(err, storer.ErrNotFound) {
+				return nil, &storer.ValidationError{Reason: fmt.Sprintf("coupon %s does not exist", code)}
+			}
+			return nil, fmt.Errorf("error getting promotion: %w", err)
+		}
+
+		if !p.IsActive || p.Currency != s.pricing.Currency ||
+			(p.StartsAt != nil && now.Before(*p.StartsAt)) ||
+			(p.EndsAt != nil && !now.Before(*p.EndsAt)) {
+			return nil, &storer.ValidationError{Reason: fmt.Sprintf("coupon %s is not valid", code)}
+		}
+
+		promotions = append(promotions, *p)
+	}
+
+	if len(promotions) > 1 {
+		for _, p := range promotions {
+			if !p.Stackable {
+				return nil, &storer.ValidationError{Reason: fmt.Sprintf("coupon %s cannot be combined with other coupons", p.Code)}
+			}
+		}
+	}
+
+	return promotions, nil
+}
+
+// discount computes what promotion p takes off an order of items, whose
+// products are in the given categories, and shipping price. Every promotion
+// is computed on the undiscounted order; priceOrder caps their sum. A
+// promotion that does not apply to the order is rejected with
+// storer.ErrValidation, rather than silently taking nothing off.
+func discount(p *storer.Promotion, items []storer.OrderItem, categories map[int64]string, shipping money.Money) (money.Money, error) {
+	currency := shipping.Currency
+
+	var eligible []storer.OrderItem
+	subtotal := money.New(0, currency)
+	for _, oi := range items {
+		if p.Category == "" || categories[oi.ProductID] == p.Category {
+			eligible = append(eligible, oi)
+			line, err := oi.Price.Mul(oi.Quantity)
+			if err != nil {
+				return money.Money{}, err
+			}
+			subtotal = subtotal.Add(line)
+		}
+	}
+
+	if len(eligible) == 0 {
+		return money.Money{}, &storer.ValidationError{Reason: fmt.Sprintf("coupon %s does not apply to any item", p.Code)}
+	}
+	if subtotal.Cmp(p.MinSubtotal) < 0 {
+		return money.Money{}, &storer.ValidationError{Reason: fmt.Sprintf("coupon %s requires a subtotal of at least %s", p.Code, p.MinSubtotal)}
+	}
+
+	amount := money.New(0, currency)
+	switch p.Kind {
+	case storer.PromotionPercentage:
+		// exact, rounded half up to the cent
+		amount = money.New((subtotal.Amount*p.PercentOff+50)/100, currency)
+	case storer.PromotionFixedAmount:
+		amount = p.AmountOff
+		if amount.Cmp(subtotal) > 0 {
+			amount = subtotal
+		}
+	case storer.PromotionBuyXGetY:
+		for _, oi := range eligible {
+			free := oi.Quantity / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+			line, err := oi.Price.Mul(free)
+			if err != nil {
+				return money.Money{}, err
+			}
+			amount = amount.Add(line)
+		}
+		if amount.IsZero() {
+			return money.Money{}, &storer.ValidationError{Reason: fmt.Sprintf("coupon %s requires buying %d of an item", p.Code, p.BuyQuantity+p.GetQuantity)}
+		}
+	case storer.PromotionFreeShipping:
+		if shipping.IsZero() {
+			return money.Money{}, &storer.ValidationError{Reason: fmt.Sprintf("coupon %s does not apply, shipping is already free", p.Code)}
+		}
+		amount = shipping
+	}
+
+	return amount, nil
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gauss2302/ecomm-service/config"
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/payments"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateOrderPromotions(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	srv := NewServer(st, config.PricingConfig{Currency: "USD", TaxRate: 0.1, ShippingPrice: 10, FreeShippingThreshold: 100}, payments.NewFakeGateway(nil))
+
+	shirt, err := st.CreateProduct(ctx, &storer.Product{Name: "shirt", Category: "apparel", Price: usd(2000), Currency: "USD", CountInStock: 100, IsActive: true})
+	require.NoError(t, err)
+	mug, err := st.CreateProduct(ctx, &storer.Product{Name: "mug", Category: "kitchen", Price: usd(500), Currency: "USD", CountInStock: 100, IsActive: true})
+	require.NoError(t, err)
+
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	for _, p := range []storer.Promotion{
+		{Code: "TENOFF", Kind: storer.PromotionPercentage, PercentOff: 10, Stackable: true, IsActive: true},
+		{Code: "FIVE", Kind: storer.PromotionFixedAmount, AmountOff: usd(500), Stackable: true, IsActive: true},
+		{Code: "SHIP", Kind: storer.PromotionFreeShipping, Stackable: true, IsActive: true},
+		{Code: "MUGS", Kind: storer.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Category: "kitchen", IsActive: true},
+		{Code: "APPAREL", Kind: storer.PromotionPercentage, PercentOff: 50, Category: "apparel", IsActive: true},
+		{Code: "BIG", Kind: storer.PromotionFixedAmount, AmountOff: usd(10000), MinSubtotal: usd(5000), IsActive: true},
+		{Code: "OLD", Kind: storer.PromotionPercentage, PercentOff: 10, EndsAt: &past, IsActive: true},
+		{Code: "SOON", Kind: storer.PromotionPercentage, PercentOff: 10, StartsAt: &future, IsActive: true},
+		{Code: "OFF", Kind: storer.PromotionPercentage, PercentOff: 10},
+	} {
+		_, err := srv.CreatePromotion(ctx, &p)
+		require.NoError(t, err)
+	}
+
+	tcs := []struct {
+		name      string
+		items     []storer.OrderItem
+		coupons   []string
+		want      storer.Order
+		discounts map[string]int64
+		err       error
+	}{
+		{
+			name:    "percentage",
+			items:   []storer.OrderItem{{ProductID: shirt.ID, Quantity: 2}},
+			coupons: []string{"TENOFF"},
+			// tax is 10% of 40.00 less 4.00
+			want:      storer.Order{ItemsPrice: usd(4000), TaxPrice: usd(360), ShippingPrice: usd(1000), DiscountPrice: usd(400), TotalPrice: usd(4960)},
+			discounts: map[string]int64{"TENOFF": 400},
+		},
+		{
+			name:      "codes are case insensitive and applied once",
+			items:     []storer.OrderItem{{ProductID: shirt.ID, Quantity: 2}},
+			coupons:   []string{"tenoff", " TENOFF "},
+			want:      storer.Order{ItemsPrice: usd(4000), TaxPrice: usd(360), ShippingPrice: usd(1000), DiscountPrice: usd(400), TotalPrice: usd(4960)},
+			discounts: map[string]int64{"TENOFF": 400},
+		},
+		{
+			name:    "stacked discounts are capped at the items price",
+			items:   []storer.OrderItem{{ProductID: mug.ID, Quantity: 1}},
+			coupons: []string{"TENOFF", "FIVE"},
+			// both are computed on 5.00, the second only takes what is left
+			want:      storer.Order{ItemsPrice: usd(500), TaxPrice: usd(0), ShippingPrice: usd(1000), DiscountPrice: usd(500), TotalPrice: usd(1000)},
+			discounts: map[string]int64{"TENOFF": 50, "FIVE": 450},
+		},
+		{
+			name:      "free shipping",
+			items:     []storer.OrderItem{{ProductID: mug.ID, Quantity: 1}},
+			coupons:   []string{"SHIP"},
+			want:      storer.Order{ItemsPrice: usd(500), TaxPrice: usd(50), ShippingPrice: usd(1000), DiscountPrice: usd(1000), TotalPrice: usd(550)},
+			discounts: map[string]int64{"SHIP": 1000},
+		},
+		{
+			name:    "free shipping when shipping is already free",
+			items:   []storer.OrderItem{{ProductID: shirt.ID, Quantity: 5}},
+			coupons: []string{"SHIP"},
+			err:     storer.ErrValidation,
+		},
+		{
+			name:    "buy two get one",
+			items:   []storer.OrderItem{{ProductID: mug.ID, Quantity: 7}},
+			coupons: []string{"MUGS"},
+			// two of seven mugs are free
+			want:      storer.Order{ItemsPrice: usd(3500), TaxPrice: usd(250), ShippingPrice: usd(1000), DiscountPrice: usd(1000), TotalPrice: usd(3750)},
+			discounts: map[string]int64{"MUGS": 1000},
+		},
+		{
+			name:    "buy two get one without enough items",
+			items:   []storer.OrderItem{{ProductID: mug.ID, Quantity: 2}},
+			coupons: []string{"MUGS"},
+			err:     storer.ErrValidation,
+		},
+		{
+			name:      "category restricted",
+			items:     []storer.OrderItem{{ProductID: shirt.ID, Quantity: 1}, {ProductID: mug.ID, Quantity: 2}},
+			coupons:   []string{"APPAREL"},
+			want:      storer.Order{ItemsPrice: usd(3000), TaxPrice: usd(200), ShippingPrice: usd(1000), DiscountPrice: usd(1000), TotalPrice: usd(3200)},
+			discounts: map[string]int64{"APPAREL": 1000},
+		},
+		{
+			name:    "category not in the order",
+			items:   []storer.OrderItem{{ProductID: mug.ID, Quantity: 1}},
+			coupons: []string{"APPAREL"},
+			err:     storer.ErrValidation,
+		},
+		{
+			name:      "minimum subtotal met",
+			items:     []storer.OrderItem{{ProductID: shirt.ID, Quantity: 3}},
+			coupons:   []string{"BIG"},
+			want:      storer.Order{ItemsPrice: usd(6000), TaxPrice: usd(0), ShippingPrice: usd(1000), DiscountPrice: usd(6000), TotalPrice: usd(1000)},
+			discounts: map[string]int64{"BIG": 6000},
+		},
+		{
+			name:    "minimum subtotal not met",
+			items:   []storer.OrderItem{{ProductID: shirt.ID, Quantity: 2}},
+			coupons: []string{"BIG"},
+			err:     storer.ErrValidation,
+		},
+		{
+			name:    "not stackable",
+			items:   []storer.OrderItem{{ProductID: shirt.ID, Quantity: 3}},
+			coupons: []string{"TENOFF", "BIG"},
+			err:     storer.ErrValidation,
+		},
+		{
+			name:    "expired",
+			items:   []storer.OrderItem{{ProductID: shirt.ID, Quantity: 1}},
+			coupons: []string{"OLD"},
+			err:     storer.ErrValidation,
+		},
+		{
+			name:    "not started",
+			items:   []storer.OrderItem{{ProductID: shirt.ID, Quantity: 1}},
+			coupons: []string{"SOON"},
+			err:     storer.ErrValidation,
+		},
+		{
+			name:    "inactive",
+			items:   []storer.OrderItem{{ProductID: shirt.ID, Quantity: 1}},
+			coupons: []string{"OFF"},
+			err:     storer.ErrValidation,
+		},
+		{
+			name:    "unknown",
+			items:   []storer.OrderItem{{ProductID: shirt.ID, Quantity: 1}},
+			coupons: []string{"NOPE"},
+			err:     storer.ErrValidation,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			o, err := srv.CreateOrder(ctx, &storer.Order{PaymentMethod: "card", UserID: 1, Items: tc.items}, tc.coupons...)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Equal(t, tc.want.ItemsPrice, o.ItemsPrice)
+			require.Equal(t, tc.want.TaxPrice, o.TaxPrice)
+			require.Equal(t, tc.want.ShippingPrice, o.ShippingPrice)
+			require.Equal(t, tc.want.DiscountPrice, o.DiscountPrice)
+			require.Equal(t, tc.want.TotalPrice, o.TotalPrice)
+
+			discounts := make(map[string]int64)
+			for _, d := range o.Discounts {
+				discounts[d.Code] = d.Amount.Amount
+			}
+			require.Equal(t, tc.discounts, discounts)
+		})
+	}
+}
+
+func TestCreateOrderCouponLimit(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	srv := NewServer(st, config.PricingConfig{Currency: "USD"}, payments.NewFakeGateway(nil))
+
+	p, err := st.CreateProduct(ctx, &storer.Product{Name: "product", Price: usd(1000), Currency: "USD", CountInStock: 10, IsActive: true})
+	require.NoError(t, err)
+
+	once := int64(1)
+	_, err = srv.CreatePromotion(ctx, &storer.Promotion{Code: "once", Kind: storer.PromotionPercentage, PercentOff: 10, MaxUsesPerUser: &once, IsActive: true})
+	require.NoError(t, err)
+
+	newOrder := func(userID int64) *storer.Order {
+		return &storer.Order{PaymentMethod: "card", UserID: userID, Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}}
+	}
+
+	o, err := srv.CreateOrder(ctx, newOrder(1), "ONCE")
+	require.NoError(t, err)
+
+	_, err = srv.CreateOrder(ctx, newOrder(1), "ONCE")
+	require.ErrorIs(t, err, storer.ErrConflict)
+	requireProductStock(t, st, p.ID, 9)
+
+	_, err = srv.CreateOrder(ctx, newOrder(2), "ONCE")
+	require.NoError(t, err)
+
+	// a cancelled order gives the coupon back
+	_, err = srv.UpdateOrderStatus(ctx, o.ID, storer.OrderStatusCancelled, "test")
+	require.NoError(t, err)
+	_, err = srv.CreateOrder(ctx, newOrder(1), "ONCE")
+	require.NoError(t, err)
+}
+
+func TestCheckPromotion(t *testing.T) {
+	srv := NewServer(storer.NewMemoryStorer(), config.PricingConfig{Currency: "USD"}, payments.NewFakeGateway(nil))
+	start := time.Now()
+	end := start.Add(-time.Hour)
+
+	tcs := []struct {
+		name string
+		p    storer.Promotion
+	}{
+		{name: "no code", p: storer.Promotion{Code: " ", Kind: storer.PromotionFreeShipping}},
+		{name: "unknown kind", p: storer.Promotion{Code: "X", Kind: "bogus"}},
+		{name: "percentage over 100", p: storer.Promotion{Code: "X", Kind: storer.PromotionPercentage, PercentOff: 101}},
+		{name: "fixed amount without amount", p: storer.Promotion{Code: "X", Kind: storer.PromotionFixedAmount}},
+		{name: "buy x get y without get", p: storer.Promotion{Code: "X", Kind: storer.PromotionBuyXGetY, BuyQuantity: 2}},
+		{name: "other currency", p: storer.Promotion{Code: "X", Kind: storer.PromotionFreeShipping, Currency: "EUR"}},
+		{name: "ends before it starts", p: storer.Promotion{Code: "X", Kind: storer.PromotionFreeShipping, StartsAt: &start, EndsAt: &end}},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := srv.CreatePromotion(context.Background(), &tc.p)
+			require.ErrorIs(t, err, storer.ErrValidation)
+		})
+	}
+}
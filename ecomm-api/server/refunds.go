@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"slices"
+
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/gauss2302/ecomm-service/payments"
+)
+
+// RefundRequest is what to give back of an order: Quantity units of each of
+// the Items, and the shipping if Shipping is set, or everything not refunded
+// yet if Full is set.
+type RefundRequest struct {
+	Full     bool
+	Items    []RefundRequestItem
+	Shipping bool
+	Reason   storer.RefundReason
+	Note     string
+	// Restock puts the refunded items back into stock, e.g. when they were
+	// returned in a sellable state.
+	Restock bool
+}
+
+// RefundRequestItem is a number of units of an order item to refund.
+type RefundRequestItem struct {
+	OrderItemID int64
+	Quantity    int64
+}
+
+// RefundOrder gives back part or all of what was paid for order id on behalf
+// of actor. Each item is refunded its share of what the items were paid, so
+// discounts and tax are given back in proportion, and the shipping what was
+// paid for it; the last units of the order take what is left, so rounding
+// never refunds more than was paid.
+//
+// The money is refunded through the gateway if the order was paid through
+// it, and only recorded otherwise. The refund is recorded as pending first
+// and settled once the gateway answered, so that only one of concurrent
+// refunds of what is left reaches the gateway. An order refunded in full is
+// moved to refunded if its status allows it.
+//
+// Only orders that were paid and not cancelled or refunded can be refunded;
+// others are rejected with a *storer.ConflictError, as are refunds of more
+// than is left. A refund declined by the provider is rejected with a
+// *payments.DeclinedError.
+func (s *Server) RefundOrder(ctx context.Context, id int64, req RefundRequest, actor string) (*storer.Refund, error) {
+	if !req.Reason.Valid() {
+		return nil, &storer.ValidationError{Reason: fmt.Sprintf("unknown refund reason %q", req.Reason)}
+	}
+
+	o, err := s.storer.GetOrder(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+
+	switch o.Status {
+	case storer.OrderStatusPaid, storer.OrderStatusProcessing, storer.OrderStatusShipped, storer.OrderStatusDelivered:
+	default:
+		return nil, &storer.ConflictError{Reason: fmt.Sprintf("order is %s, only paid orders can be refunded", o.Status)}
+	}
+
+	r, err := s.priceRefund(ctx, o, req)
+	if err != nil {
+		return nil, err
+	}
+	r.Actor = actor
+
+	ps, err := s.storer.ListPayments(ctx, o.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing payments: %w", err)
+	}
+	auth := s.capturedAuthorization(ps)
+	r.Status = storer.RefundSucceeded
+	if auth != nil {
+		r.Status = storer.RefundPending
+	}
+
+	// the refund takes its share of the order before the gateway is asked
+	// for the money, so concurrent refunds never give back more than was paid
+	r, err = s.storer.CreateRefund(ctx, r)
+	if err != nil {
+		return nil, fmt.Errorf("error creating refund: %w", err)
+	}
+	if auth != nil {
+		r, err = s.sendRefund(ctx, r, auth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.markRefunded(ctx, o.ID, actor)
+	return r, nil
+}
+
+// sendRefund pays pending refund r back through the gateway, against the
+// authorization auth, and completes it, or fails it if the gateway did not
+// pay it back.
+func (s *Server) sendRefund(ctx context.Context, r *storer.Refund, auth *storer.Payment) (*storer.Refund, error) {
+	res, err := s.gateway.Refund(ctx, auth.Reference, r.Amount)
+	s.recordPayment(ctx, r.OrderID, payments.OperationRefund, r.Amount, res, err)
+	if err != nil {
+		s.failRefund(ctx, r)
+		return nil, fmt.Errorf("error refunding payment: %w", err)
+	}
+	if !res.Approved {
+		s.failRefund(ctx, r)
+		return nil, &payments.DeclinedError{Reason: res.DeclineReason}
+	}
+
+	cr, err := s.storer.CompleteRefund(ctx, r.ID, res.Reference)
+	if err != nil {
+		// the money is back with the customer by now
+		log.Printf("error completing refund %d (%q) of %s for order %d: %v", r.ID, res.Reference, r.Amount, r.OrderID, err)
+		return nil, fmt.Errorf("error completing refund: %w", err)
+	}
+
+	return cr, nil
+}
+
+// failRefund gives the share of the order taken by refund r back to later
+// refunds. The refund is left pending if that fails, which only logs it.
+func (s *Server) failRefund(ctx context.Context, r *storer.Refund) {
+	if err := s.storer.FailRefund(ctx, r.ID); err != nil {
+		log.Printf("error failing refund %d of %s for order %d: %v", r.ID, r.Amount, r.OrderID, err)
+	}
+}
+
+// markRefunded moves order id to refunded once it is refunded in full with no
+// refund pending, if its status allows it. The refunds are recorded by then,
+// so an order that cannot be moved is only logged.
+func (s *Server) markRefunded(ctx context.Context, id int64, actor string) {
+	o, err := s.storer.GetOrder(ctx, id)
+	if err != nil {
+		log.Printf("error getting refunded order %d: %v", id, err)
+		return
+	}
+	if o.RefundedPrice.Amount != o.TotalPrice.Amount || !CanTransition(o.Status, storer.OrderStatusRefunded) {
+		return
+	}
+	if slices.ContainsFunc(o.Refunds, func(r storer.Refund) bool { return r.Status == storer.RefundPending }) {
+		return
+	}
+
+	if _, err := s.storer.UpdateOrderStatus(ctx, id, o.Status, storer.OrderStatusRefunded, actor); err != nil {
+		log.Printf("error moving order %d to %s: %v", id, storer.OrderStatusRefunded, err)
+	}
+}
+
+// priceRefund works out the refund of o that req asks for.
+func (s *Server) priceRefund(ctx context.Context, o *storer.Order, req RefundRequest) (*storer.Refund, error) {
+	itemsPaid, shippingPaid, err := s.paidShares(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+
+	// what the refunds so far gave back, per item and in all
+	refundedUnits := make(map[int64]int64)
+	refundedAmounts := make(map[int64]money.Money)
+	itemsRefunded := money.New(0, o.Currency)
+	shippingRefunded := money.New(0, o.Currency)
+	for _, r := range o.Refunds {
+		shippingRefunded = shippingRefunded.Add(r.ShippingAmount)
+		for _, ri := range r.Items {
+			refundedUnits[ri.OrderItemID] += ri.Quantity
+			amount, ok := refundedAmounts[ri.OrderItemID]
+			if !ok {
+				amount = money.New(0, o.Currency)
+			}
+			refundedAmounts[ri.OrderItemID] = amount.Add(ri.Amount)
+			itemsRefunded = itemsRefunded.Add(ri.Amount)
+		}
+	}
+
+	lines := req.Items
+	shipping := req.Shipping
+	if req.Full {
+		lines = nil
+		for _, oi := range o.Items {
+			if left := oi.Quantity - refundedUnits[oi.ID]; left > 0 {
+				lines = append(lines, RefundRequestItem{OrderItemID: oi.ID, Quantity: left})
+			}
+		}
+		shipping = shippingRefunded.Cmp(shippingPaid) < 0
+	}
+
+	r := &storer.Refund{
+		OrderID:        o.ID,
+		Amount:         money.New(0, o.Currency),
+		ShippingAmount: money.New(0, o.Currency),
+		Currency:       o.Currency,
+		Reason:         req.Reason,
+		Note:           req.Note,
+		Restock:        req.Restock,
+	}
+
+	// the units of every item once this refund is made, to find out whether
+	// it refunds the last of them
+	units := make(map[int64]int64, len(o.Items))
+	for id, n := range refundedUnits {
+		units[id] = n
+	}
+	seen := make(map[int64]bool, len(lines))
+	for _, l := range lines {
+		if seen[l.OrderItemID] {
+			return nil, &storer.ValidationError{Reason: fmt.Sprintf("item %d is refunded twice", l.OrderItemID)}
+		}
+		seen[l.OrderItemID] = true
+
+		i := slices.IndexFunc(o.Items, func(oi storer.OrderItem) bool { return oi.ID == l.OrderItemID })
+		if i < 0 {
+			return nil, &storer.ValidationError{Reason: fmt.Sprintf("item %d is not part of order %d", l.OrderItemID, o.ID)}
+		}
+		oi := o.Items[i]
+		if l.Quantity <= 0 {
+			return nil, &storer.ValidationError{Reason: fmt.Sprintf("quantity of item %d must be positive", oi.ID)}
+		}
+		if left := oi.Quantity - units[oi.ID]; l.Quantity > left {
+			return nil, &storer.ConflictError{Reason: fmt.Sprintf("only %d of item %d are left to refund", left, oi.ID)}
+		}
+		units[oi.ID] += l.Quantity
+
+		line, err := oi.Price.Mul(oi.Quantity)
+		if err != nil {
+			return nil, fmt.Errorf("error pricing item %d: %w", oi.ID, err)
+		}
+		linePaid := prorate(itemsPaid, line.Amount, o.ItemsPrice.Amount)
+		amount := prorate(linePaid, l.Quantity, oi.Quantity)
+		if units[oi.ID] == oi.Quantity {
+			amount = linePaid
+			if refunded, ok := refundedAmounts[oi.ID]; ok {
+				amount = linePaid.Sub(refunded)
+			}
+		}
+		r.Items = append(r.Items, storer.RefundItem{OrderItemID: oi.ID, Quantity: l.Quantity, Amount: amount})
+		r.Amount = r.Amount.Add(amount)
+	}
+
+	// the shares of the lines are rounded one by one, the refund of the last
+	// units makes up the difference
+	if len(r.Items) > 0 && allRefunded(o.Items, units) {
+		diff := itemsPaid.Sub(itemsRefunded).Sub(r.Amount)
+		last := &r.Items[len(r.Items)-1]
+		if adjusted := last.Amount.Add(diff); adjusted.Amount >= 0 {
+			last.Amount = adjusted
+			r.Amount = r.Amount.Add(diff)
+		}
+	}
+
+	if shipping {
+		left := shippingPaid.Sub(shippingRefunded)
+		if left.IsZero() {
+			return nil, &storer.ConflictError{Reason: "no shipping is left to refund"}
+		}
+		r.ShippingAmount = left
+		r.Amount = r.Amount.Add(left)
+	}
+
+	if r.Amount.IsZero() {
+		if req.Full {
+			return nil, &storer.ConflictError{Reason: "order has nothing left to refund"}
+		}
+		return nil, &storer.ValidationError{Reason: "nothing to refund"}
+	}
+
+	return r, nil
+}
+
+// paidShares splits the total price paid for o between the items, with their
+// tax and less their discounts, and the shipping, less the free shipping
+// discounts.
+func (s *Server) paidShares(ctx context.Context, o *storer.Order) (items, shipping money.Money, err error) {
+	shipping = o.ShippingPrice
+	for _, d := range o.Discounts {
+		p, err := s.storer.GetPromotion(ctx, d.PromotionID)
+		if err != nil {
+			return money.Money{}, money.Money{}, fmt.Errorf("error getting promotion: %w", err)
+		}
+		if p.Kind == storer.PromotionFreeShipping {
+			shipping = shipping.Sub(d.Amount)
+		}
+	}
+
+	return o.TotalPrice.Sub(shipping), shipping, nil
+}
+
+// capturedAuthorization returns the authorization made with the gateway
+// whose capture paid for an order, given the payments of the order oldest
+// first, or nil if the order was not paid through the gateway.
+func (s *Server) capturedAuthorization(ps []storer.Payment) *storer.Payment {
+	var auth, captured *storer.Payment
+	for i := range ps {
+		p := &ps[i]
+		if p.Provider != s.gateway.Name() || p.Status != storer.PaymentSucceeded {
+			continue
+		}
+		switch payments.Operation(p.Operation) {
+		case payments.OperationAuthorize:
+			auth = p
+		case payments.OperationCapture:
+			if auth != nil {
+				captured = auth
+			}
+		}
+	}
+
+	return captured
+}
+
+// prorate returns the share part/whole of amount, rounded half up to the
+// cent. The product is computed exactly, it may not fit in an int64.
+func prorate(amount money.Money, part, whole int64) money.Money {
+	if whole == 0 {
+		return money.New(0, amount.Currency)
+	}
+
+	n := new(big.Int).Mul(big.NewInt(amount.Amount), big.NewInt(2*part))
+	n.Add(n, big.NewInt(whole))
+	n.Quo(n, big.NewInt(2*whole))
+
+	return money.New(n.Int64(), amount.Currency)
+}
+
+// allRefunded reports whether all the units of items are refunded, given the
+// refunded units per item.
+func allRefunded(items []storer.OrderItem, units map[int64]int64) bool {
+	for _, oi := range items {
+		if units[oi.ID] < oi.Quantity {
+			return false
+		}
+	}
+	return true
+}
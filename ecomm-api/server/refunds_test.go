@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gauss2302/ecomm-service/config"
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/gauss2302/ecomm-service/payments"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefundOrder(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	srv := NewServer(st, config.PricingConfig{Currency: "USD", TaxRate: 0.1, ShippingPrice: 5}, payments.NewFakeGateway(nil))
+
+	mug, err := st.CreateProduct(ctx, &storer.Product{Name: "mug", Price: usd(1000), CountInStock: 100, IsActive: true})
+	require.NoError(t, err)
+	pen, err := st.CreateProduct(ctx, &storer.Product{Name: "pen", Price: usd(333), CountInStock: 100, IsActive: true})
+	require.NoError(t, err)
+	_, err = srv.CreatePromotion(ctx, &storer.Promotion{Code: "SHIP", Kind: storer.PromotionFreeShipping, IsActive: true})
+	require.NoError(t, err)
+
+	newOrder := func(t *testing.T, items []storer.OrderItem, coupons ...string) *storer.Order {
+		o, err := srv.CreateOrder(ctx, &storer.Order{PaymentMethod: "card", UserID: 1, Items: items}, coupons...)
+		require.NoError(t, err)
+		return o
+	}
+	payOrder := func(t *testing.T, items []storer.OrderItem, coupons ...string) *storer.Order {
+		o := newOrder(t, items, coupons...)
+		o, err := srv.PayOrder(ctx, o.ID, "4242424242424242", "user@example.com")
+		require.NoError(t, err)
+		return o
+	}
+	requireStock := func(t *testing.T, productID, want int64) {
+		t.Helper()
+
+		p, err := st.GetProduct(ctx, productID)
+		require.NoError(t, err)
+		require.Equal(t, want, p.CountInStock)
+	}
+
+	t.Run("partial refunds add up to the total", func(t *testing.T) {
+		// items 33.33, tax 3.33 and shipping 5.00: the items were paid 36.66
+		o := payOrder(t, []storer.OrderItem{{ProductID: mug.ID, Quantity: 3}, {ProductID: pen.ID, Quantity: 1}})
+		require.Equal(t, usd(4166), o.TotalPrice)
+		mugItem, penItem := o.Items[0], o.Items[1]
+
+		r, err := srv.RefundOrder(ctx, o.ID, RefundRequest{
+			Items:   []RefundRequestItem{{OrderItemID: mugItem.ID, Quantity: 1}},
+			Reason:  storer.RefundDamaged,
+			Restock: true,
+		}, "admin@example.com")
+		require.NoError(t, err)
+		require.Equal(t, usd(1100), r.Amount)
+		require.NotEmpty(t, r.Reference)
+		requireStock(t, mug.ID, 98)
+
+		r, err = srv.RefundOrder(ctx, o.ID, RefundRequest{
+			Items:    []RefundRequestItem{{OrderItemID: mugItem.ID, Quantity: 2}},
+			Shipping: true,
+			Reason:   storer.RefundCustomerRequest,
+		}, "admin@example.com")
+		require.NoError(t, err)
+		require.Equal(t, usd(2700), r.Amount)
+		require.Equal(t, usd(500), r.ShippingAmount)
+		requireStock(t, mug.ID, 98)
+
+		_, err = srv.RefundOrder(ctx, o.ID, RefundRequest{Items: []RefundRequestItem{{OrderItemID: mugItem.ID, Quantity: 1}}, Reason: storer.RefundOther}, "admin@example.com")
+		require.ErrorIs(t, err, storer.ErrConflict)
+		_, err = srv.RefundOrder(ctx, o.ID, RefundRequest{Shipping: true, Reason: storer.RefundOther}, "admin@example.com")
+		require.ErrorIs(t, err, storer.ErrConflict)
+
+		r, err = srv.RefundOrder(ctx, o.ID, RefundRequest{Full: true, Reason: storer.RefundOther}, "admin@example.com")
+		require.NoError(t, err)
+		require.Equal(t, usd(366), r.Amount)
+		require.Equal(t, []storer.RefundItem{{ID: r.Items[0].ID, RefundID: r.ID, OrderItemID: penItem.ID, Quantity: 1, Amount: usd(366)}}, r.Items)
+
+		got, err := srv.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+		require.Equal(t, storer.OrderStatusRefunded, got.Status)
+		require.Equal(t, o.TotalPrice, got.RefundedPrice)
+		require.Len(t, got.Refunds, 3)
+
+		ps, err := srv.ListPayments(ctx, o.ID)
+		require.NoError(t, err)
+		require.Len(t, ps, 5)
+		for _, p := range ps[2:] {
+			require.Equal(t, string(payments.OperationRefund), p.Operation)
+			require.Equal(t, storer.PaymentSucceeded, p.Status)
+		}
+
+		_, err = srv.RefundOrder(ctx, o.ID, RefundRequest{Full: true, Reason: storer.RefundOther}, "admin@example.com")
+		require.ErrorIs(t, err, storer.ErrConflict)
+	})
+
+	t.Run("free shipping is not refunded", func(t *testing.T) {
+		o := payOrder(t, []storer.OrderItem{{ProductID: mug.ID, Quantity: 1}}, "SHIP")
+		require.Equal(t, usd(1100), o.TotalPrice)
+
+		_, err := srv.RefundOrder(ctx, o.ID, RefundRequest{Shipping: true, Reason: storer.RefundOther}, "admin@example.com")
+		require.ErrorIs(t, err, storer.ErrConflict)
+
+		r, err := srv.RefundOrder(ctx, o.ID, RefundRequest{Full: true, Reason: storer.RefundOther}, "admin@example.com")
+		require.NoError(t, err)
+		require.Equal(t, usd(1100), r.Amount)
+		require.True(t, r.ShippingAmount.IsZero())
+	})
+
+	t.Run("order paid outside the gateway", func(t *testing.T) {
+		o := newOrder(t, []storer.OrderItem{{ProductID: mug.ID, Quantity: 1}})
+		_, err := srv.markPaid(ctx, o.ID, "admin@example.com")
+		require.NoError(t, err)
+
+		r, err := srv.RefundOrder(ctx, o.ID, RefundRequest{Full: true, Reason: storer.RefundDuplicate}, "admin@example.com")
+		require.NoError(t, err)
+		require.Empty(t, r.Reference)
+
+		ps, err := srv.ListPayments(ctx, o.ID)
+		require.NoError(t, err)
+		require.Empty(t, ps)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		pending := newOrder(t, []storer.OrderItem{{ProductID: mug.ID, Quantity: 1}})
+		paid := payOrder(t, []storer.OrderItem{{ProductID: mug.ID, Quantity: 2}})
+		itemID := paid.Items[0].ID
+
+		tcs := []struct {
+			name string
+			id   int64
+			req  RefundRequest
+			err  error
+		}{
+			{name: "pending order", id: pending.ID, req: RefundRequest{Full: true, Reason: storer.RefundOther}, err: storer.ErrConflict},
+			{name: "unknown order", id: 999, req: RefundRequest{Full: true, Reason: storer.RefundOther}, err: storer.ErrNotFound},
+			{name: "unknown reason", id: paid.ID, req: RefundRequest{Full: true, Reason: "bored"}, err: storer.ErrValidation},
+			{name: "nothing", id: paid.ID, req: RefundRequest{Reason: storer.RefundOther}, err: storer.ErrValidation},
+			{name: "item of another order", id: paid.ID, req: RefundRequest{Items: []RefundRequestItem{{OrderItemID: pending.Items[0].ID, Quantity: 1}}, Reason: storer.RefundOther}, err: storer.ErrValidation},
+			{name: "no quantity", id: paid.ID, req: RefundRequest{Items: []RefundRequestItem{{OrderItemID: itemID}}, Reason: storer.RefundOther}, err: storer.ErrValidation},
+			{name: "item twice", id: paid.ID, req: RefundRequest{Items: []RefundRequestItem{{OrderItemID: itemID, Quantity: 1}, {OrderItemID: itemID, Quantity: 1}}, Reason: storer.RefundOther}, err: storer.ErrValidation},
+			{name: "more than ordered", id: paid.ID, req: RefundRequest{Items: []RefundRequestItem{{OrderItemID: itemID, Quantity: 3}}, Reason: storer.RefundOther}, err: storer.ErrConflict},
+		}
+
+		for _, tc := range tcs {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := srv.RefundOrder(ctx, tc.id, tc.req, "admin@example.com")
+				require.ErrorIs(t, err, tc.err)
+			})
+		}
+
+		got, err := srv.GetOrder(ctx, paid.ID)
+		require.NoError(t, err)
+		require.Empty(t, got.Refunds)
+	})
+}
+
+// refundGateway is the fake gateway with its refunds counted, and declined
+// while decline is set.
+type refundGateway struct {
+	*payments.FakeGateway
+	refunds atomic.Int64
+	decline atomic.Bool
+}
+
+func (g *refundGateway) Refund(ctx context.Context, reference string, amount money.Money) (*payments.Result, error) {
+	g.refunds.Add(1)
+	if g.decline.Load() {
+		return &payments.Result{Reference: reference, DeclineReason: "processing_error"}, nil
+	}
+	return g.FakeGateway.Refund(ctx, reference, amount)
+}
+
+// barrierStorer holds the first n reads of an order until they are all made,
+// so that concurrent refunds all price the order before any is recorded.
+type barrierStorer struct {
+	storer.Storer
+	mu      sync.Mutex
+	n       int
+	release chan struct{}
+}
+
+func (s *barrierStorer) GetOrder(ctx context.Context, id int64) (*storer.Order, error) {
+	o, err := s.Storer.GetOrder(ctx, id)
+
+	s.mu.Lock()
+	s.n--
+	if s.n == 0 {
+		close(s.release)
+	}
+	s.mu.Unlock()
+
+	<-s.release
+	return o, err
+}
+
+func TestRefundOrderSettles(t *testing.T) {
+	ctx := context.Background()
+	st := &barrierStorer{Storer: storer.NewMemoryStorer(), release: make(chan struct{})}
+	gateway := &refundGateway{FakeGateway: payments.NewFakeGateway(nil)}
+	srv := NewServer(st, config.PricingConfig{Currency: "USD"}, gateway)
+
+	p, err := st.CreateProduct(ctx, &storer.Product{Name: "mug", Price: usd(1000), CountInStock: 100, IsActive: true})
+	require.NoError(t, err)
+	o, err := srv.CreateOrder(ctx, &storer.Order{PaymentMethod: "card", UserID: 1, Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 2}}})
+	require.NoError(t, err)
+	// paying reads the order once
+	st.n = 1
+	_, err = srv.PayOrder(ctx, o.ID, "4242424242424242", "user@example.com")
+	require.NoError(t, err)
+
+	t.Run("a declined refund can be made again", func(t *testing.T) {
+		st.release = make(chan struct{})
+		st.n = 1
+		gateway.decline.Store(true)
+		defer gateway.decline.Store(false)
+
+		req := RefundRequest{Items: []RefundRequestItem{{OrderItemID: o.Items[0].ID, Quantity: 1}}, Reason: storer.RefundOther, Restock: true}
+		_, err := srv.RefundOrder(ctx, o.ID, req, "admin@example.com")
+		var declined *payments.DeclinedError
+		require.ErrorAs(t, err, &declined)
+
+		got, err := srv.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+		require.True(t, got.RefundedPrice.IsZero())
+		require.Empty(t, got.Refunds)
+		requireProductStock(t, st, p.ID, 98)
+	})
+
+	t.Run("only one of concurrent refunds reaches the gateway", func(t *testing.T) {
+		st.release = make(chan struct{})
+		st.n = 2
+		gateway.refunds.Store(0)
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = srv.RefundOrder(ctx, o.ID, RefundRequest{Full: true, Reason: storer.RefundOther, Restock: true}, "admin@example.com")
+			}()
+		}
+		wg.Wait()
+
+		require.Equal(t, int64(1), gateway.refunds.Load())
+		if errs[0] != nil {
+			errs[0], errs[1] = errs[1], errs[0]
+		}
+		require.NoError(t, errs[0])
+		require.ErrorIs(t, errs[1], storer.ErrConflict)
+
+		got, err := srv.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+		require.Equal(t, storer.OrderStatusRefunded, got.Status)
+		require.Equal(t, got.TotalPrice, got.RefundedPrice)
+		require.Len(t, got.Refunds, 1)
+		require.Equal(t, storer.RefundSucceeded, got.Refunds[0].Status)
+		requireProductStock(t, st, p.ID, 100)
+	})
+}
+
+func TestProrate(t *testing.T) {
+	tcs := []struct {
+		name   string
+		amount money.Money
+		part   int64
+		whole  int64
+		want   money.Money
+	}{
+		{name: "exact", amount: usd(1000), part: 1, whole: 2, want: usd(500)},
+		{name: "rounded down", amount: usd(1000), part: 1, whole: 3, want: usd(333)},
+		{name: "rounded up", amount: usd(1000), part: 2, whole: 3, want: usd(667)},
+		{name: "half up", amount: usd(5), part: 1, whole: 2, want: usd(3)},
+		{name: "no whole", amount: usd(1000), part: 1, whole: 0, want: usd(0)},
+		{name: "large", amount: usd(999999999999), part: 999999999, whole: 1000000000, want: usd(999999998999)},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, prorate(tc.amount, tc.part, tc.whole))
+		})
+	}
+}
@@ -2,21 +2,33 @@ package server
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/gauss2302/ecomm-service/config"
 	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/payments"
 )
 
 type Server struct {
-	storer *storer.MySQLStorer
+	storer  storer.Storer
+	pricing config.PricingConfig
+	gateway payments.Gateway
 }
 
-func NewServer(storer *storer.MySQLStorer) *Server {
+func NewServer(storer storer.Storer, pricing config.PricingConfig, gateway payments.Gateway) *Server {
 	return &Server{
-		storer: storer,
+		storer:  storer,
+		pricing: pricing,
+		gateway: gateway,
 	}
 }
 
+// CreateProduct prices p in the store currency, see checkProductCurrency.
 func (s *Server) CreateProduct(ctx context.Context, p *storer.Product) (*storer.Product, error) {
+	if err := s.checkProductCurrency(p); err != nil {
+		return nil, fmt.Errorf("error checking product currency: %w", err)
+	}
+
 	return s.storer.CreateProduct(ctx, p)
 }
 
@@ -24,18 +36,36 @@ func (s *Server) GetProduct(ctx context.Context, id int64) (*storer.Product, err
 	return s.storer.GetProduct(ctx, id)
 }
 
-func (s *Server) ListProducts(ctx context.Context) ([]storer.Product, error) {
-	return s.storer.ListProducts(ctx)
+func (s *Server) ListProducts(ctx context.Context, f storer.ProductFilter) ([]storer.Product, *storer.Cursor, error) {
+	return s.storer.ListProducts(ctx, f)
 }
 
 func (s *Server) UpdateProduct(ctx context.Context, p *storer.Product) (*storer.Product, error) {
+	if err := s.checkProductCurrency(p); err != nil {
+		return nil, fmt.Errorf("error checking product currency: %w", err)
+	}
+
 	return s.storer.UpdateProduct(ctx, p)
 }
 
+// SetProductStock sets the stock of product id to count. Unlike UpdateProduct
+// it overwrites what orders took in the meantime, so it is only used when the
+// stock is explicitly set.
+func (s *Server) SetProductStock(ctx context.Context, id, count int64) error {
+	return s.storer.SetProductStock(ctx, id, count)
+}
+
 func (s *Server) DeleteProduct(ctx context.Context, id int64) error {
 	return s.storer.DeleteProduct(ctx, id)
 }
-func (s *Server) CreateOrder(ctx context.Context, o *storer.Order) (*storer.Order, error) {
+
+// CreateOrder prices o server-side with the promotions of the coupons, see
+// priceOrder, before storing it.
+func (s *Server) CreateOrder(ctx context.Context, o *storer.Order, coupons ...string) (*storer.Order, error) {
+	if err := s.priceOrder(ctx, o, coupons); err != nil {
+		return nil, fmt.Errorf("error pricing order: %w", err)
+	}
+
 	return s.storer.CreateOrder(ctx, o)
 }
 
@@ -43,8 +73,8 @@ func (s *Server) GetOrder(ctx context.Context, id int64) (*storer.Order, error)
 	return s.storer.GetOrder(ctx, id)
 }
 
-func (s *Server) ListOrders(ctx context.Context) ([]storer.Order, error) {
-	return s.storer.ListOrders(ctx)
+func (s *Server) ListOrders(ctx context.Context, f storer.OrderFilter) ([]storer.Order, *storer.Cursor, error) {
+	return s.storer.ListOrders(ctx, f)
 }
 
 func (s *Server) DeleteOrder(ctx context.Context, id int64) error {
@@ -59,8 +89,8 @@ func (s *Server) GetUser(ctx context.Context, email string) (*storer.User, error
 	return s.storer.GetUser(ctx, email)
 }
 
-func (s *Server) ListUsers(ctx context.Context) ([]storer.User, error) {
-	return s.storer.ListUsers(ctx)
+func (s *Server) ListUsers(ctx context.Context, f storer.UserFilter) ([]storer.User, *storer.Cursor, error) {
+	return s.storer.ListUsers(ctx, f)
 }
 
 func (s *Server) UpdateUser(ctx context.Context, u *storer.User) (*storer.User, error) {
@@ -70,3 +100,19 @@ func (s *Server) UpdateUser(ctx context.Context, u *storer.User) (*storer.User,
 func (s *Server) DeleteUser(ctx context.Context, id int64) error {
 	return s.storer.DeleteUser(ctx, id)
 }
+
+func (s *Server) CreateSession(ctx context.Context, sess *storer.Session) (*storer.Session, error) {
+	return s.storer.CreateSession(ctx, sess)
+}
+
+func (s *Server) GetSession(ctx context.Context, id string) (*storer.Session, error) {
+	return s.storer.GetSession(ctx, id)
+}
+
+func (s *Server) RotateSession(ctx context.Context, oldID string, sess *storer.Session) (*storer.Session, error) {
+	return s.storer.RotateSession(ctx, oldID, sess)
+}
+
+func (s *Server) RevokeSessionFamily(ctx context.Context, familyID string) error {
+	return s.storer.RevokeSessionFamily(ctx, familyID)
+}
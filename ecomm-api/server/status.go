@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+)
+
+// orderTransitions lists the statuses an order may be moved to from each
+// status with UpdateOrderStatus. Orders are only paid and refunded by paying
+// and refunding them, see paymentTransitions, and paid orders are cancelled
+// by refunding them; cancelled and refunded orders are final.
+var orderTransitions = map[storer.OrderStatus][]storer.OrderStatus{
+	storer.OrderStatusPending:    {storer.OrderStatusCancelled},
+	storer.OrderStatusPaid:       {storer.OrderStatusProcessing, storer.OrderStatusCancelled},
+	storer.OrderStatusProcessing: {storer.OrderStatusShipped, storer.OrderStatusCancelled},
+	storer.OrderStatusShipped:    {storer.OrderStatusDelivered},
+}
+
+// paymentTransitions lists the statuses PayOrder, RefundOrder and the payment
+// webhooks move an order to. Delivered orders can only be refunded.
+var paymentTransitions = map[storer.OrderStatus][]storer.OrderStatus{
+	storer.OrderStatusPending:   {storer.OrderStatusPaid},
+	storer.OrderStatusPaid:      {storer.OrderStatusRefunded},
+	storer.OrderStatusDelivered: {storer.OrderStatusRefunded},
+}
+
+// CanTransition reports whether an order may move from one status to another,
+// either with UpdateOrderStatus or by being paid or refunded.
+func CanTransition(from, to storer.OrderStatus) bool {
+	return slices.Contains(orderTransitions[from], to) || slices.Contains(paymentTransitions[from], to)
+}
+
+// UpdateOrderStatus moves order id to the status to on behalf of actor. Paid
+// and refunded are only set by paying and refunding the order and are
+// rejected with a *storer.ValidationError. A transition not allowed by
+// orderTransitions is rejected with a *storer.ConflictError, as is the
+// cancellation of an order with money left to refund.
+func (s *Server) UpdateOrderStatus(ctx context.Context, id int64, to storer.OrderStatus, actor string) (*storer.Order, error) {
+	switch to {
+	case storer.OrderStatusPaid:
+		return nil, &storer.ValidationError{Reason: "orders are paid by paying them"}
+	case storer.OrderStatusRefunded:
+		return nil, &storer.ValidationError{Reason: "orders are refunded by refunding them"}
+	}
+
+	o, err := s.storer.GetOrder(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+
+	if !slices.Contains(orderTransitions[o.Status], to) {
+		return nil, &storer.ConflictError{Reason: fmt.Sprintf("cannot change order status from %s to %s", o.Status, to)}
+	}
+	if to == storer.OrderStatusCancelled && o.Status != storer.OrderStatusPending && o.RefundedPrice.Amount < o.TotalPrice.Amount {
+		return nil, &storer.ConflictError{Reason: fmt.Sprintf("order is %s, refund it instead of cancelling it", o.Status)}
+	}
+
+	return s.storer.UpdateOrderStatus(ctx, id, o.Status, to, actor)
+}
+
+// markPaid moves order id from pending to paid on behalf of actor, once
+// its payment is collected.
+func (s *Server) markPaid(ctx context.Context, id int64, actor string) (*storer.Order, error) {
+	return s.storer.UpdateOrderStatus(ctx, id, storer.OrderStatusPending, storer.OrderStatusPaid, actor)
+}
+
+func (s *Server) ListOrderStatusHistory(ctx context.Context, orderID int64) ([]storer.OrderStatusChange, error) {
+	return s.storer.ListOrderStatusHistory(ctx, orderID)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gauss2302/ecomm-service/config"
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/payments"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateOrderStatus(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	srv := NewServer(st, config.PricingConfig{Currency: "USD"}, payments.NewFakeGateway(nil))
+
+	p, err := st.CreateProduct(ctx, &storer.Product{Name: "product", Price: usd(1000), CountInStock: 100, IsActive: true})
+	require.NoError(t, err)
+
+	newOrder := func(t *testing.T) *storer.Order {
+		o, err := srv.CreateOrder(ctx, &storer.Order{PaymentMethod: "card", Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}})
+		require.NoError(t, err)
+		require.Equal(t, storer.OrderStatusPending, o.Status)
+		return o
+	}
+
+	tcs := []struct {
+		name string
+		path []storer.OrderStatus
+		to   storer.OrderStatus
+		err  error
+	}{
+		{name: "pay", to: storer.OrderStatusPaid, err: storer.ErrValidation},
+		{name: "cancel pending", to: storer.OrderStatusCancelled},
+		{name: "ship unpaid", to: storer.OrderStatusShipped, err: storer.ErrConflict},
+		{name: "refund", path: []storer.OrderStatus{storer.OrderStatusPaid}, to: storer.OrderStatusRefunded, err: storer.ErrValidation},
+		{name: "back to pending", path: []storer.OrderStatus{storer.OrderStatusPaid}, to: storer.OrderStatusPending, err: storer.ErrConflict},
+		{name: "cancel paid", path: []storer.OrderStatus{storer.OrderStatusPaid}, to: storer.OrderStatusCancelled, err: storer.ErrConflict},
+		{name: "cancel shipped", path: []storer.OrderStatus{storer.OrderStatusPaid, storer.OrderStatusProcessing, storer.OrderStatusShipped}, to: storer.OrderStatusCancelled, err: storer.ErrConflict},
+		{name: "deliver", path: []storer.OrderStatus{storer.OrderStatusPaid, storer.OrderStatusProcessing, storer.OrderStatusShipped}, to: storer.OrderStatusDelivered},
+		{name: "reopen cancelled", path: []storer.OrderStatus{storer.OrderStatusCancelled}, to: storer.OrderStatusProcessing, err: storer.ErrConflict},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			o := newOrder(t)
+			for _, s := range tc.path {
+				var err error
+				if s == storer.OrderStatusPaid {
+					_, err = srv.markPaid(ctx, o.ID, "admin@example.com")
+				} else {
+					_, err = srv.UpdateOrderStatus(ctx, o.ID, s, "admin@example.com")
+				}
+				require.NoError(t, err)
+			}
+
+			updated, err := srv.UpdateOrderStatus(ctx, o.ID, tc.to, "admin@example.com")
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.to, updated.Status)
+
+			history, err := srv.ListOrderStatusHistory(ctx, o.ID)
+			require.NoError(t, err)
+			require.Len(t, history, len(tc.path)+1)
+			last := history[len(history)-1]
+			require.Equal(t, tc.to, last.ToStatus)
+			require.Equal(t, "admin@example.com", last.Actor)
+		})
+	}
+
+	_, err = srv.UpdateOrderStatus(ctx, 999, storer.OrderStatusCancelled, "admin@example.com")
+	require.ErrorIs(t, err, storer.ErrNotFound)
+}
+
+func TestCancelOrderRestoresStock(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	srv := NewServer(st, config.PricingConfig{Currency: "USD"}, payments.NewFakeGateway(nil))
+
+	p, err := st.CreateProduct(ctx, &storer.Product{Name: "product", Price: usd(1000), CountInStock: 5, IsActive: true})
+	require.NoError(t, err)
+
+	o, err := srv.CreateOrder(ctx, &storer.Order{PaymentMethod: "card", Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 2}}})
+	require.NoError(t, err)
+
+	_, err = srv.UpdateOrderStatus(ctx, o.ID, storer.OrderStatusCancelled, "user@example.com")
+	require.NoError(t, err)
+
+	p, err = st.GetProduct(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), p.CountInStock)
+
+	// deleting the cancelled order does not restore the stock again
+	require.NoError(t, srv.DeleteOrder(ctx, o.ID))
+	p, err = st.GetProduct(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), p.CountInStock)
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/gauss2302/ecomm-service/payments"
+)
+
+// ReceiveWebhook stores the event of a payment webhook from provider, whose
+// signature must already be verified, and applies it, see applyWebhookEvent.
+// An event received before is not applied again: it is returned as stored,
+// with duplicate set.
+//
+// An event that cannot be applied is stored as failed, to be replayed, and
+// returned without an error: the provider has delivered it.
+func (s *Server) ReceiveWebhook(ctx context.Context, provider string, payload []byte) (e *storer.WebhookEvent, duplicate bool, err error) {
+	if provider != s.gateway.Name() {
+		return nil, false, fmt.Errorf("error receiving webhook: %w: unknown payment provider %q", storer.ErrNotFound, provider)
+	}
+
+	ev, err := payments.ParseEvent(payload)
+	if err != nil {
+		return nil, false, &storer.ValidationError{Reason: err.Error()}
+	}
+
+	e, err = s.storer.CreateWebhookEvent(ctx, &storer.WebhookEvent{
+		Provider: provider,
+		EventID:  ev.ID,
+		Type:     string(ev.Type),
+		Payload:  string(payload),
+		Status:   storer.WebhookEventPending,
+	})
+	if errors.Is(err, storer.ErrConflict) {
+		e, err = s.storer.GetWebhookEvent(ctx, provider, ev.ID)
+		if err != nil {
+			return nil, false, fmt.Errorf("error getting webhook event: %w", err)
+		}
+		return e, true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error storing webhook event: %w", err)
+	}
+
+	e, err = s.applyWebhookEvent(ctx, e, ev)
+	return e, false, err
+}
+
+// ReplayWebhookEvents applies again the stored events of the payment provider
+// with the given event IDs, or all the failed ones if there are none, and
+// returns them as they end up. Events already processed are left alone.
+func (s *Server) ReplayWebhookEvents(ctx context.Context, eventIDs ...string) ([]storer.WebhookEvent, error) {
+	provider := s.gateway.Name()
+
+	var events []storer.WebhookEvent
+	if len(eventIDs) == 0 {
+		failed, err := s.storer.ListWebhookEvents(ctx, provider, storer.WebhookEventFailed)
+		if err != nil {
+			return nil, fmt.Errorf("error listing webhook events: %w", err)
+		}
+		events = failed
+	}
+	for _, id := range eventIDs {
+		e, err := s.storer.GetWebhookEvent(ctx, provider, id)
+		if err != nil {
+			return nil, fmt.Errorf("error getting webhook event %s: %w", id, err)
+		}
+		events = append(events, *e)
+	}
+
+	replayed := make([]storer.WebhookEvent, 0, len(events))
+	for _, e := range events {
+		if e.Status == storer.WebhookEventProcessed {
+			replayed = append(replayed, e)
+			continue
+		}
+
+		// the payload was parsed when the event was received
+		ev, err := payments.ParseEvent([]byte(e.Payload))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing webhook event %s: %w", e.EventID, err)
+		}
+
+		applied, err := s.applyWebhookEvent(ctx, &e, ev)
+		if err != nil {
+			return nil, err
+		}
+		replayed = append(replayed, *applied)
+	}
+
+	return replayed, nil
+}
+
+// applyWebhookEvent applies the change of stored event e, parsed as ev, and
+// marks it processed, or failed with the reason it could not be applied.
+func (s *Server) applyWebhookEvent(ctx context.Context, e *storer.WebhookEvent, ev *payments.Event) (*storer.WebhookEvent, error) {
+	change, err := s.webhookChange(ctx, ev)
+	if err == nil {
+		err = s.storer.ProcessWebhookEvent(ctx, e.ID, change)
+	}
+	if err != nil {
+		if err := s.storer.FailWebhookEvent(ctx, e.ID, truncate(err.Error(), 255)); err != nil {
+			return nil, fmt.Errorf("error failing webhook event: %w", err)
+		}
+	}
+
+	applied, err := s.storer.GetWebhookEvent(ctx, e.Provider, e.EventID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting webhook event: %w", err)
+	}
+
+	return applied, nil
+}
+
+// webhookChange works out what event ev changes. The payment it names is
+// recorded, and:
+//   - a capture pays its order if it is still pending; a capture of an order
+//     that is no longer pending, e.g. already paid by PayOrder, changes nothing
+//   - a refund made at the provider is recorded as a refund of the order, of
+//     part or all of what is left, which refunds the order once refunded in
+//     full if it may be refunded; a refund made by RefundOrder, known by its
+//     reference, is recorded already and changes nothing
+//
+// Events of other types change nothing.
+func (s *Server) webhookChange(ctx context.Context, ev *payments.Event) (*storer.PaymentChange, error) {
+	switch ev.Type {
+	case payments.EventPaymentCaptured, payments.EventPaymentFailed, payments.EventPaymentVoided, payments.EventPaymentRefunded:
+	default:
+		return nil, nil
+	}
+
+	auth, err := s.storer.GetPaymentByReference(ctx, s.gateway.Name(), ev.Reference)
+	if err != nil {
+		if errors.Is(err, storer.ErrNotFound) {
+			return nil, &storer.ValidationError{Reason: fmt.Sprintf("unknown payment reference %q", ev.Reference)}
+		}
+		return nil, fmt.Errorf("error getting payment: %w", err)
+	}
+
+	o, err := s.storer.GetOrder(ctx, auth.OrderID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+	if ev.Currency != o.Currency {
+		return nil, &storer.ValidationError{Reason: fmt.Sprintf("event is in %s, order is in %s", ev.Currency, o.Currency)}
+	}
+
+	change := &storer.PaymentChange{
+		OrderID: o.ID,
+		Payment: &storer.Payment{
+			OrderID:   o.ID,
+			Provider:  s.gateway.Name(),
+			Status:    storer.PaymentSucceeded,
+			Amount:    ev.Amount,
+			Currency:  ev.Currency,
+			Reference: ev.Reference,
+		},
+		From:  o.Status,
+		Actor: "webhook:" + s.gateway.Name(),
+	}
+
+	switch ev.Type {
+	case payments.EventPaymentCaptured:
+		if o.Status != storer.OrderStatusPending {
+			return nil, nil
+		}
+		if ev.Amount.Cmp(o.TotalPrice) != 0 {
+			return nil, &storer.ValidationError{Reason: fmt.Sprintf("captured %s of an order of %s", ev.Amount, o.TotalPrice)}
+		}
+		change.Payment.Operation = string(payments.OperationCapture)
+		change.To = storer.OrderStatusPaid
+	case payments.EventPaymentFailed:
+		change.Payment.Operation = string(payments.OperationCapture)
+		change.Payment.Status = storer.PaymentDeclined
+		change.Payment.Error = ev.Reason
+	case payments.EventPaymentVoided:
+		change.Payment.Operation = string(payments.OperationVoid)
+	case payments.EventPaymentRefunded:
+		for _, r := range o.Refunds {
+			if r.Status == storer.RefundPending {
+				// the refund may be this one, whose reference is only known
+				// once RefundOrder completes it
+				return nil, &storer.ConflictError{Reason: fmt.Sprintf("refund %d of order %d is pending", r.ID, o.ID)}
+			}
+			if ev.RefundReference != "" && r.Reference == ev.RefundReference {
+				return nil, nil
+			}
+		}
+		change.Payment.Operation = string(payments.OperationRefund)
+		change.Refund = &storer.Refund{
+			OrderID:        o.ID,
+			Status:         storer.RefundSucceeded,
+			Amount:         ev.Amount,
+			ShippingAmount: money.New(0, o.Currency),
+			Currency:       o.Currency,
+			Reason:         storer.RefundOther,
+			Note:           "refunded at " + s.gateway.Name(),
+			Reference:      ev.RefundReference,
+			Actor:          change.Actor,
+		}
+		if o.RefundedPrice.Amount+ev.Amount.Amount == o.TotalPrice.Amount && CanTransition(o.Status, storer.OrderStatusRefunded) {
+			change.To = storer.OrderStatusRefunded
+		}
+	}
+
+	return change, nil
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/gauss2302/ecomm-service/config"
+	storer "github.com/gauss2302/ecomm-service/ecomm-api/store"
+	"github.com/gauss2302/ecomm-service/payments"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiveWebhook(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	gateway := payments.NewFakeGateway(nil)
+	srv := NewServer(st, config.PricingConfig{Currency: "USD"}, gateway)
+
+	p, err := st.CreateProduct(ctx, &storer.Product{Name: "product", Price: usd(1000), CountInStock: 100, IsActive: true})
+	require.NoError(t, err)
+
+	// authorize orders the way a provider capturing asynchronously would be
+	// used, leaving them pending until the capture is notified
+	authorize := func(t *testing.T) (*storer.Order, string) {
+		o, err := srv.CreateOrder(ctx, &storer.Order{PaymentMethod: "card", UserID: 1, Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}})
+		require.NoError(t, err)
+
+		res, err := gateway.Authorize(ctx, payments.AuthorizeRequest{OrderID: o.ID, Amount: o.TotalPrice, Source: "4242424242424242"})
+		require.NoError(t, err)
+		srv.recordPayment(ctx, o.ID, payments.OperationAuthorize, o.TotalPrice, res, nil)
+		return o, res.Reference
+	}
+
+	event := func(id string, typ payments.EventType, reference, amount string) []byte {
+		return []byte(fmt.Sprintf(`{"id":%q,"type":%q,"reference":%q,"amount":%q,"currency":"USD"}`, id, typ, reference, amount))
+	}
+
+	requireOrderStatus := func(t *testing.T, id int64, want storer.OrderStatus) {
+		t.Helper()
+
+		o, err := st.GetOrder(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, want, o.Status)
+	}
+
+	t.Run("capture pays the order once", func(t *testing.T) {
+		o, ref := authorize(t)
+
+		e, duplicate, err := srv.ReceiveWebhook(ctx, "fake", event("evt_capture", payments.EventPaymentCaptured, ref, "10.00"))
+		require.NoError(t, err)
+		require.False(t, duplicate)
+		require.Equal(t, storer.WebhookEventProcessed, e.Status)
+		requireOrderStatus(t, o.ID, storer.OrderStatusPaid)
+
+		e, duplicate, err = srv.ReceiveWebhook(ctx, "fake", event("evt_capture", payments.EventPaymentCaptured, ref, "10.00"))
+		require.NoError(t, err)
+		require.True(t, duplicate)
+		require.Equal(t, int64(1), e.Attempts)
+
+		ps, err := st.ListPayments(ctx, o.ID)
+		require.NoError(t, err)
+		require.Len(t, ps, 2)
+		require.Equal(t, "capture", ps[1].Operation)
+
+		history, err := st.ListOrderStatusHistory(ctx, o.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		require.Equal(t, "webhook:fake", history[0].Actor)
+
+		// a full refund refunds the order
+		_, _, err = srv.ReceiveWebhook(ctx, "fake", event("evt_refund", payments.EventPaymentRefunded, ref, "10.00"))
+		require.NoError(t, err)
+		requireOrderStatus(t, o.ID, storer.OrderStatusRefunded)
+		refunded, err := st.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+		require.Equal(t, refunded.TotalPrice, refunded.RefundedPrice)
+		require.Len(t, refunded.Refunds, 1)
+	})
+
+	t.Run("capture of an order paid already", func(t *testing.T) {
+		o, err := srv.CreateOrder(ctx, &storer.Order{PaymentMethod: "card", UserID: 1, Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}})
+		require.NoError(t, err)
+		_, err = srv.PayOrder(ctx, o.ID, "4242424242424242", "user@example.com")
+		require.NoError(t, err)
+		ps, err := st.ListPayments(ctx, o.ID)
+		require.NoError(t, err)
+
+		e, _, err := srv.ReceiveWebhook(ctx, "fake", event("evt_paid", payments.EventPaymentCaptured, ps[0].Reference, "10.00"))
+		require.NoError(t, err)
+		require.Equal(t, storer.WebhookEventProcessed, e.Status)
+
+		after, err := st.ListPayments(ctx, o.ID)
+		require.NoError(t, err)
+		require.Len(t, after, len(ps))
+	})
+
+	t.Run("failed events are replayed", func(t *testing.T) {
+		o, ref := authorize(t)
+
+		e, _, err := srv.ReceiveWebhook(ctx, "fake", event("evt_short", payments.EventPaymentCaptured, ref, "5.00"))
+		require.NoError(t, err)
+		require.Equal(t, storer.WebhookEventFailed, e.Status)
+		require.Contains(t, e.Error, "captured 5.00 of an order of 10.00")
+
+		e, _, err = srv.ReceiveWebhook(ctx, "fake", event("evt_unknown", payments.EventPaymentCaptured, "fake_999", "10.00"))
+		require.NoError(t, err)
+		require.Equal(t, storer.WebhookEventFailed, e.Status)
+		requireOrderStatus(t, o.ID, storer.OrderStatusPending)
+
+		// the order is paid some other way, after which the events are moot
+		_, err = srv.markPaid(ctx, o.ID, "admin@example.com")
+		require.NoError(t, err)
+
+		replayed, err := srv.ReplayWebhookEvents(ctx, "evt_short")
+		require.NoError(t, err)
+		require.Len(t, replayed, 1)
+		require.Equal(t, storer.WebhookEventProcessed, replayed[0].Status)
+		require.Equal(t, int64(2), replayed[0].Attempts)
+
+		replayed, err = srv.ReplayWebhookEvents(ctx)
+		require.NoError(t, err)
+		require.Len(t, replayed, 1)
+		require.Equal(t, "evt_unknown", replayed[0].EventID)
+		require.Equal(t, storer.WebhookEventFailed, replayed[0].Status)
+
+		_, err = srv.ReplayWebhookEvents(ctx, "evt_missing")
+		require.ErrorIs(t, err, storer.ErrNotFound)
+	})
+
+	t.Run("refunds", func(t *testing.T) {
+		o, err := srv.CreateOrder(ctx, &storer.Order{PaymentMethod: "card", UserID: 1, Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 2}}})
+		require.NoError(t, err)
+		_, err = srv.PayOrder(ctx, o.ID, "4242424242424242", "user@example.com")
+		require.NoError(t, err)
+		ps, err := st.ListPayments(ctx, o.ID)
+		require.NoError(t, err)
+		ref := ps[0].Reference
+
+		refundEvent := func(id, amount, refundRef string) []byte {
+			return []byte(fmt.Sprintf(`{"id":%q,"type":"payment.refunded","reference":%q,"amount":%q,"currency":"USD","refund_reference":%q}`, id, ref, amount, refundRef))
+		}
+		requireEvent := func(t *testing.T, payload []byte, want storer.WebhookEventStatus) *storer.WebhookEvent {
+			t.Helper()
+
+			e, _, err := srv.ReceiveWebhook(ctx, "fake", payload)
+			require.NoError(t, err)
+			require.Equal(t, want, e.Status, e.Error)
+			return e
+		}
+
+		// a refund made through the API is recorded already
+		r, err := srv.RefundOrder(ctx, o.ID, RefundRequest{Items: []RefundRequestItem{{OrderItemID: o.Items[0].ID, Quantity: 1}}, Reason: storer.RefundOther}, "admin@example.com")
+		require.NoError(t, err)
+		requireEvent(t, refundEvent("evt_api_refund", "10.00", r.Reference), storer.WebhookEventProcessed)
+
+		// refunds made at the provider are recorded, in part or in full
+		requireEvent(t, refundEvent("evt_partial", "4.00", "re_1"), storer.WebhookEventProcessed)
+		e := requireEvent(t, refundEvent("evt_too_much", "7.00", "re_2"), storer.WebhookEventFailed)
+		require.Contains(t, e.Error, "exceeds the 6.00 left to refund")
+
+		// a refund pending in the meantime may be the one notified
+		pending, err := st.CreateRefund(ctx, &storer.Refund{OrderID: o.ID, Status: storer.RefundPending, Amount: usd(100), Currency: "USD", Reason: storer.RefundOther})
+		require.NoError(t, err)
+		requireEvent(t, refundEvent("evt_rest", "6.00", "re_3"), storer.WebhookEventFailed)
+		require.NoError(t, st.FailRefund(ctx, pending.ID))
+		replayed, err := srv.ReplayWebhookEvents(ctx, "evt_rest")
+		require.NoError(t, err)
+		require.Equal(t, storer.WebhookEventProcessed, replayed[0].Status)
+
+		got, err := st.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+		require.Equal(t, storer.OrderStatusRefunded, got.Status)
+		require.Equal(t, got.TotalPrice, got.RefundedPrice)
+		require.Len(t, got.Refunds, 3)
+		require.Equal(t, "re_1", got.Refunds[1].Reference)
+		require.Equal(t, usd(400), got.Refunds[1].Amount)
+		require.Equal(t, "webhook:fake", got.Refunds[1].Actor)
+	})
+
+	t.Run("ignored event type", func(t *testing.T) {
+		e, _, err := srv.ReceiveWebhook(ctx, "fake", []byte(`{"id":"evt_dispute","type":"dispute.created"}`))
+		require.NoError(t, err)
+		require.Equal(t, storer.WebhookEventProcessed, e.Status)
+	})
+
+	_, _, err = srv.ReceiveWebhook(ctx, "other", event("evt_other", payments.EventPaymentCaptured, "fake_1", "10.00"))
+	require.ErrorIs(t, err, storer.ErrNotFound)
+
+	_, _, err = srv.ReceiveWebhook(ctx, "fake", []byte(`{"type":"payment.captured"}`))
+	require.ErrorIs(t, err, storer.ErrValidation)
+}
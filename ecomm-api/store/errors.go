@@ -0,0 +1,163 @@
+package storer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+)
+
+// Errors returned by the storers are classified by wrapping one of these
+// sentinels, so callers can use errors.Is regardless of the backend.
+var (
+	// ErrNotFound is returned when the requested record does not exist. It is
+	// always returned alongside sql.ErrNoRows.
+	ErrNotFound = errors.New("record not found")
+
+	// ErrConflict is returned when a write clashes with existing data, i.e. a
+	// duplicate unique key.
+	ErrConflict = errors.New("record conflicts with existing data")
+
+	// ErrValidation is returned when a record is rejected before it reaches
+	// the database.
+	ErrValidation = errors.New("invalid record")
+
+	// ErrConstraint is returned on a foreign key violation: a record refers
+	// to data that does not exist, or is deleted while still referenced.
+	ErrConstraint = errors.New("constraint violation")
+
+	// ErrCanceled is returned when a query is abandoned because its context
+	// was canceled or timed out. The context error is wrapped alongside it, so
+	// callers can tell a client that went away (context.Canceled) from a
+	// deadline (context.DeadlineExceeded).
+	ErrCanceled = errors.New("query canceled")
+
+	// ErrSessionRotated is returned when a refresh token session has already
+	// been exchanged for a new one, which indicates the refresh token is being
+	// replayed.
+	ErrSessionRotated = errors.New("session has already been rotated")
+)
+
+// ValidationError is returned when a record is rejected before it reaches the
+// database. It matches ErrValidation, and Reason is meant for the client.
+type ValidationError struct {
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrValidation, e.Reason)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// ConflictError is returned when a write is rejected because of the current
+// state of a record. It matches ErrConflict, and Reason is meant for the
+// client.
+type ConflictError struct {
+	Reason string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrConflict, e.Reason)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// StockShortfall describes an order item that cannot be fulfilled.
+type StockShortfall struct {
+	ProductID int64
+	Requested int64
+	Available int64
+}
+
+// InsufficientStockError is returned when an order asks for more of a product
+// than is in stock. It matches ErrConflict.
+type InsufficientStockError struct {
+	Shortfalls []StockShortfall
+}
+
+func (e *InsufficientStockError) Error() string {
+	msg := "insufficient stock"
+	for i, s := range e.Shortfalls {
+		sep := ", "
+		if i == 0 {
+			sep = ": "
+		}
+		msg += fmt.Sprintf("%sproduct %d requested %d available %d", sep, s.ProductID, s.Requested, s.Available)
+	}
+	return msg
+}
+
+func (e *InsufficientStockError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// errNoRows is what the memory storer returns for missing records, mirroring
+// what dbError makes of sql.ErrNoRows.
+var errNoRows = fmt.Errorf("%w: %w", ErrNotFound, sql.ErrNoRows)
+
+// MySQL, PostgreSQL and SQLite error codes that dbError translates.
+const (
+	mysqlDupEntry          = 1062
+	mysqlRowIsReferenced   = 1451
+	mysqlNoReferencedRow   = 1452
+	pqUniqueViolation      = "23505"
+	pqForeignKeyViolation  = "23503"
+	sqliteConstraintFK     = 787
+	sqliteConstraintPK     = 1555
+	sqliteConstraintUnique = 2067
+)
+
+// dbError classifies an error returned by the database driver by wrapping the
+// matching sentinel. Drivers report a canceled query in different ways, e.g.
+// MySQL may surface it as a broken connection, so the context is checked
+// first as the only reliable signal.
+func dbError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %w", ErrCanceled, ctxErr)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case mysqlDupEntry:
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		case mysqlRowIsReferenced, mysqlNoReferencedRow:
+			return fmt.Errorf("%w: %w", ErrConstraint, err)
+		}
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case pqUniqueViolation:
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		case pqForeignKeyViolation:
+			return fmt.Errorf("%w: %w", ErrConstraint, err)
+		}
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqliteConstraintPK, sqliteConstraintUnique:
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		case sqliteConstraintFK:
+			return fmt.Errorf("%w: %w", ErrConstraint, err)
+		}
+	}
+
+	return err
+}
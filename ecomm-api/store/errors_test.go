@@ -0,0 +1,78 @@
+package storer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBError(t *testing.T) {
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tcs := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want error
+	}{
+		{
+			name: "no rows",
+			ctx:  context.Background(),
+			err:  sql.ErrNoRows,
+			want: ErrNotFound,
+		},
+		{
+			name: "mysql duplicate entry",
+			ctx:  context.Background(),
+			err:  &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"},
+			want: ErrConflict,
+		},
+		{
+			name: "mysql missing referenced row",
+			ctx:  context.Background(),
+			err:  &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"},
+			want: ErrConstraint,
+		},
+		{
+			name: "mysql row still referenced",
+			ctx:  context.Background(),
+			err:  &mysql.MySQLError{Number: 1451, Message: "Cannot delete or update a parent row"},
+			want: ErrConstraint,
+		},
+		{
+			name: "postgres unique violation",
+			ctx:  context.Background(),
+			err:  &pq.Error{Code: "23505"},
+			want: ErrConflict,
+		},
+		{
+			name: "postgres foreign key violation",
+			ctx:  context.Background(),
+			err:  fmt.Errorf("error inserting: %w", &pq.Error{Code: "23503"}),
+			want: ErrConstraint,
+		},
+		{
+			name: "canceled",
+			ctx:  canceledCtx,
+			err:  &mysql.MySQLError{Number: 1317, Message: "Query execution was interrupted"},
+			want: ErrCanceled,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := dbError(tc.ctx, tc.err)
+			require.ErrorIs(t, err, tc.want)
+		})
+	}
+
+	other := errors.New("other error")
+	require.Equal(t, other, dbError(context.Background(), other))
+}
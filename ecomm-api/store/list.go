@@ -0,0 +1,333 @@
+package storer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gauss2302/ecomm-service/money"
+)
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 100
+)
+
+// Page selects one page of a keyset paginated list. Records are ordered by
+// the Sort field, then by id to break ties, and the page starts right after
+// the record Cursor points at.
+type Page struct {
+	Limit  int
+	Sort   string
+	Desc   bool
+	Cursor *Cursor
+}
+
+func (p Page) limit() int {
+	switch {
+	case p.Limit <= 0:
+		return DefaultPageLimit
+	case p.Limit > MaxPageLimit:
+		return MaxPageLimit
+	default:
+		return p.Limit
+	}
+}
+
+// Cursor points at the last record of a page by the value of its sort field
+// and its id. It is only valid for a list with the same sort.
+type Cursor struct {
+	Sort  string      `json:"s"`
+	Desc  bool        `json:"d,omitempty"`
+	Value interface{} `json:"v"`
+	ID    int64       `json:"id"`
+}
+
+type ProductFilter struct {
+	Category string
+	MinPrice *money.Money
+	MaxPrice *money.Money
+	InStock  bool
+	Page
+}
+
+type OrderFilter struct {
+	UserID int64
+	Status OrderStatus
+	// CreatedFrom and CreatedTo bound the creation time of the orders, the
+	// former inclusive and the latter exclusive.
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	Page
+}
+
+type UserFilter struct {
+	Page
+}
+
+// sortKey is a column a list can be sorted by. Sorting by id needs no other
+// column; creation order follows it too. The cursor value of a numeric key is
+// a float64, and for a money key the amount in minor units, so it compares
+// exactly.
+type sortKey struct {
+	column  string
+	numeric bool
+	money   bool
+}
+
+var (
+	productSortKeys = map[string]sortKey{
+		"id":     {column: "id"},
+		"name":   {column: "name"},
+		"price":  {column: "price", numeric: true, money: true},
+		"rating": {column: "rating", numeric: true},
+	}
+	orderSortKeys = map[string]sortKey{
+		"id":          {column: "id"},
+		"total_price": {column: "total_price", numeric: true, money: true},
+	}
+	userSortKeys = map[string]sortKey{
+		"id":    {column: "id"},
+		"name":  {column: "name"},
+		"email": {column: "email"},
+	}
+)
+
+// sortKey returns the key p is sorted by, checking that its cursor belongs to
+// the same sort.
+func (p Page) sortKey(keys map[string]sortKey) (sortKey, error) {
+	name := p.Sort
+	if name == "" {
+		name = "id"
+	}
+	key, ok := keys[name]
+	if !ok {
+		return sortKey{}, &ValidationError{Reason: fmt.Sprintf("cannot sort by %q", p.Sort)}
+	}
+
+	if c := p.Cursor; c != nil {
+		if c.Sort != name || c.Desc != p.Desc {
+			return sortKey{}, &ValidationError{Reason: "cursor does not match the sort order"}
+		}
+		switch v := c.Value.(type) {
+		case float64:
+			if !key.numeric {
+				return sortKey{}, &ValidationError{Reason: "invalid cursor"}
+			}
+		case string:
+			if key.numeric || key.column == "id" {
+				return sortKey{}, &ValidationError{Reason: "invalid cursor"}
+			}
+		case nil:
+			if key.column != "id" {
+				return sortKey{}, &ValidationError{Reason: "invalid cursor"}
+			}
+		default:
+			return sortKey{}, &ValidationError{Reason: fmt.Sprintf("invalid cursor value %v", v)}
+		}
+	}
+
+	return key, nil
+}
+
+// listQuery builds the SELECT of one page of a table from the filters added
+// to it. The query uses ? placeholders and has to be rebound for the driver.
+type listQuery struct {
+	where []string
+	args  []interface{}
+}
+
+func (q *listQuery) filter(cond string, args ...interface{}) {
+	q.where = append(q.where, cond)
+	q.args = append(q.args, args...)
+}
+
+// build returns the query selecting the page p of table, sorted by one of
+// keys. One more record than the page holds is selected so pageOf can tell
+// whether there is a next page.
+func (q *listQuery) build(table string, p Page, keys map[string]sortKey) (string, []interface{}, error) {
+	key, err := p.sortKey(keys)
+	if err != nil {
+		return "", nil, err
+	}
+
+	op, dir := ">", "ASC"
+	if p.Desc {
+		op, dir = "<", "DESC"
+	}
+
+	if c := p.Cursor; c != nil {
+		if key.column == "id" {
+			q.filter("id"+op+"?", c.ID)
+		} else {
+			v := c.Value
+			if key.money {
+				v = money.New(int64(v.(float64)), "")
+			}
+			q.filter(fmt.Sprintf("(%[1]s%[2]s? OR (%[1]s=? AND id%[2]s?))", key.column, op), v, v, c.ID)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("SELECT * FROM " + table)
+	if len(q.where) > 0 {
+		sb.WriteString(" WHERE " + strings.Join(q.where, " AND "))
+	}
+	if key.column == "id" {
+		sb.WriteString(" ORDER BY id " + dir)
+	} else {
+		sb.WriteString(fmt.Sprintf(" ORDER BY %s %s, id %s", key.column, dir, dir))
+	}
+	sb.WriteString(" LIMIT ?")
+
+	return sb.String(), append(q.args, p.limit()+1), nil
+}
+
+func productFilterQuery(f ProductFilter) *listQuery {
+	q := &listQuery{}
+	if f.Category != "" {
+		q.filter("category=?", f.Category)
+	}
+	if f.MinPrice != nil {
+		q.filter("price>=?", *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		q.filter("price<=?", *f.MaxPrice)
+	}
+	if f.InStock {
+		q.filter("count_in_stock>0")
+	}
+	return q
+}
+
+func orderFilterQuery(f OrderFilter) *listQuery {
+	q := &listQuery{}
+	if f.UserID != 0 {
+		q.filter("user_id=?", f.UserID)
+	}
+	if f.Status != "" {
+		q.filter("status=?", f.Status)
+	}
+	if f.CreatedFrom != nil {
+		q.filter("created_at>=?", f.CreatedFrom.UTC())
+	}
+	if f.CreatedTo != nil {
+		q.filter("created_at<?", f.CreatedTo.UTC())
+	}
+	return q
+}
+
+// productSortValue returns the value of column for p and its id. Numbers are
+// float64 as that is what a cursor decoded from JSON holds.
+func productSortValue(p Product, column string) (interface{}, int64) {
+	switch column {
+	case "name":
+		return p.Name, p.ID
+	case "price":
+		return float64(p.Price.Amount), p.ID
+	case "rating":
+		return float64(p.Rating), p.ID
+	default:
+		return nil, p.ID
+	}
+}
+
+func orderSortValue(o Order, column string) (interface{}, int64) {
+	if column == "total_price" {
+		return float64(o.TotalPrice.Amount), o.ID
+	}
+	return nil, o.ID
+}
+
+func userSortValue(u User, column string) (interface{}, int64) {
+	switch column {
+	case "name":
+		return u.Name, u.ID
+	case "email":
+		return u.Email, u.ID
+	default:
+		return nil, u.ID
+	}
+}
+
+// pageOf trims the extra record selected by listQuery.build off records and
+// returns the cursor of the next page, or nil if records is the last page.
+func pageOf[T any](records []T, p Page, keys map[string]sortKey, sortValue func(T, string) (interface{}, int64)) ([]T, *Cursor) {
+	limit := p.limit()
+	if len(records) <= limit {
+		return records, nil
+	}
+	records = records[:limit]
+
+	name := p.Sort
+	if name == "" {
+		name = "id"
+	}
+	value, id := sortValue(records[limit-1], keys[name].column)
+	return records, &Cursor{Sort: name, Desc: p.Desc, Value: value, ID: id}
+}
+
+// memoryPage sorts records as listQuery.build would and selects the page p of
+// them, plus one record for pageOf.
+func memoryPage[T any](records []T, p Page, keys map[string]sortKey, sortValue func(T, string) (interface{}, int64)) ([]T, error) {
+	key, err := p.sortKey(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// less orders by the sort value, then id, flipped for descending lists
+	less := func(av interface{}, aid int64, bv interface{}, bid int64) bool {
+		c := compareSortValues(av, bv)
+		if c == 0 {
+			c = compareSortValues(aid, bid)
+		}
+		if p.Desc {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		iv, iid := sortValue(records[i], key.column)
+		jv, jid := sortValue(records[j], key.column)
+		return less(iv, iid, jv, jid)
+	})
+
+	if c := p.Cursor; c != nil {
+		start := sort.Search(len(records), func(i int) bool {
+			v, id := sortValue(records[i], key.column)
+			return less(c.Value, c.ID, v, id)
+		})
+		records = records[start:]
+	}
+
+	if len(records) > p.limit()+1 {
+		records = records[:p.limit()+1]
+	}
+	return records, nil
+}
+
+func compareSortValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case int64:
+		bv := b.(int64)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+	case float64:
+		bv := b.(float64)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+	case string:
+		return strings.Compare(av, b.(string))
+	}
+	return 0
+}
@@ -0,0 +1,1192 @@
+package storer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStorer is a thread-safe in-memory Storer. It returns the same
+// classified errors as the SQL backed storers, and lookups of missing records
+// wrap sql.ErrNoRows as well.
+type MemoryStorer struct {
+	mu sync.RWMutex
+
+	products   map[int64]Product
+	orders     map[int64]Order
+	users      map[int64]User
+	sessions   map[string]Session
+	revoked    map[string]time.Time
+	history    map[int64][]OrderStatusChange
+	carts      map[string]Cart
+	promotions map[int64]Promotion
+	payments   map[int64][]Payment
+	// failedRefunds are kept apart from the refunds of their orders, which
+	// list what was given back
+	failedRefunds map[int64][]Refund
+	// webhookEvents are unique per provider and event ID, as in the
+	// webhook_events table
+	webhookEvents map[webhookEventKey]WebhookEvent
+
+	lastProductID      int64
+	lastOrderID        int64
+	lastOrderItemID    int64
+	lastChangeID       int64
+	lastCartItemID     int64
+	lastPromotionID    int64
+	lastDiscountID     int64
+	lastPaymentID      int64
+	lastWebhookEventID int64
+	lastRefundID       int64
+	lastRefundItemID   int64
+	lastUserID         int64
+}
+
+type webhookEventKey struct {
+	provider string
+	eventID  string
+}
+
+func NewMemoryStorer() *MemoryStorer {
+	return &MemoryStorer{
+		products:      make(map[int64]Product),
+		orders:        make(map[int64]Order),
+		users:         make(map[int64]User),
+		sessions:      make(map[string]Session),
+		revoked:       make(map[string]time.Time),
+		history:       make(map[int64][]OrderStatusChange),
+		carts:         make(map[string]Cart),
+		promotions:    make(map[int64]Promotion),
+		payments:      make(map[int64][]Payment),
+		failedRefunds: make(map[int64][]Refund),
+		webhookEvents: make(map[webhookEventKey]WebhookEvent),
+	}
+}
+
+func (ms *MemoryStorer) CreateProduct(_ context.Context, p *Product) (*Product, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.lastProductID++
+	np := *p
+	np.ID = ms.lastProductID
+	np.CreatedAt = time.Now()
+	np.UpdatedAt = nil
+	ms.products[np.ID] = np
+
+	return &np, nil
+}
+
+func (ms *MemoryStorer) GetProduct(_ context.Context, id int64) (*Product, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	p, ok := ms.products[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting product: %w", errNoRows)
+	}
+
+	return &p, nil
+}
+
+func (ms *MemoryStorer) ListProducts(_ context.Context, f ProductFilter) ([]Product, *Cursor, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	products := make([]Product, 0, len(ms.products))
+	for _, p := range ms.products {
+		switch {
+		case f.Category != "" && p.Category != f.Category,
+			f.MinPrice != nil && p.Price.Amount < f.MinPrice.Amount,
+			f.MaxPrice != nil && p.Price.Amount > f.MaxPrice.Amount,
+			f.InStock && p.CountInStock <= 0:
+			continue
+		}
+		products = append(products, p)
+	}
+
+	products, err := memoryPage(products, f.Page, productSortKeys, productSortValue)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing products: %w", err)
+	}
+
+	products, next := pageOf(products, f.Page, productSortKeys, productSortValue)
+	return products, next, nil
+}
+
+func (ms *MemoryStorer) UpdateProduct(_ context.Context, p *Product) (*Product, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	old, ok := ms.products[p.ID]
+	if !ok {
+		return nil, fmt.Errorf("error updating product: %w", errNoRows)
+	}
+
+	np := *p
+	np.CreatedAt = old.CreatedAt
+	np.CountInStock = old.CountInStock
+	ms.products[np.ID] = np
+
+	return p, nil
+}
+
+func (ms *MemoryStorer) SetProductStock(_ context.Context, id, count int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	p, ok := ms.products[id]
+	if !ok {
+		return fmt.Errorf("error setting product stock: %w", errNoRows)
+	}
+	p.CountInStock = count
+	ms.products[id] = p
+
+	return nil
+}
+
+func (ms *MemoryStorer) DeleteProduct(_ context.Context, id int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, o := range ms.orders {
+		for _, oi := range o.Items {
+			if oi.ProductID == id {
+				return fmt.Errorf("error deleting product: %w: referenced by order %d", ErrConstraint, o.ID)
+			}
+		}
+	}
+
+	// cart items of the product go with it, as ON DELETE CASCADE does
+	for cartID, c := range ms.carts {
+		items := c.Items[:0]
+		for _, ci := range c.Items {
+			if ci.ProductID != id {
+				items = append(items, ci)
+			}
+		}
+		c.Items = items
+		ms.carts[cartID] = c
+	}
+
+	delete(ms.products, id)
+	return nil
+}
+
+func (ms *MemoryStorer) CreateOrder(_ context.Context, o *Order) (*Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	demand, err := stockDemand(o.Items)
+	if err != nil {
+		return nil, fmt.Errorf("error creating order: %w", err)
+	}
+	var shortfalls []StockShortfall
+	for _, d := range demand {
+		p, ok := ms.products[d.ProductID]
+		if !ok {
+			return nil, fmt.Errorf("error creating order: %w: product %d does not exist", ErrConstraint, d.ProductID)
+		}
+		if p.CountInStock < d.Quantity {
+			shortfalls = append(shortfalls, StockShortfall{ProductID: d.ProductID, Requested: d.Quantity, Available: p.CountInStock})
+		}
+	}
+	if len(shortfalls) > 0 {
+		return nil, fmt.Errorf("error creating order: %w", &InsufficientStockError{Shortfalls: shortfalls})
+	}
+	for _, d := range o.Discounts {
+		if err := ms.claimPromotion(d, o.UserID); err != nil {
+			return nil, fmt.Errorf("error creating order: %w", err)
+		}
+	}
+	ms.adjustStock(demand, -1)
+	for _, d := range o.Discounts {
+		p := ms.promotions[d.PromotionID]
+		p.Uses++
+		ms.promotions[p.ID] = p
+	}
+
+	if o.Status == "" {
+		o.Status = OrderStatusPending
+	}
+	ms.lastOrderID++
+	o.ID = ms.lastOrderID
+	o.CreatedAt = time.Now()
+	for i := range o.Items {
+		ms.lastOrderItemID++
+		o.Items[i].ID = ms.lastOrderItemID
+		o.Items[i].OrderID = o.ID
+	}
+	for i := range o.Discounts {
+		ms.lastDiscountID++
+		o.Discounts[i].ID = ms.lastDiscountID
+		o.Discounts[i].OrderID = o.ID
+	}
+	ms.orders[o.ID] = copyOrder(o)
+
+	return o, nil
+}
+
+func (ms *MemoryStorer) GetOrder(_ context.Context, id int64) (*Order, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	o, ok := ms.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting order: %w", errNoRows)
+	}
+
+	co := copyOrder(&o)
+	return &co, nil
+}
+
+func (ms *MemoryStorer) ListOrders(_ context.Context, f OrderFilter) ([]Order, *Cursor, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	orders := make([]Order, 0, len(ms.orders))
+	for _, o := range ms.orders {
+		switch {
+		case f.UserID != 0 && o.UserID != f.UserID,
+			f.Status != "" && o.Status != f.Status,
+			f.CreatedFrom != nil && o.CreatedAt.Before(*f.CreatedFrom),
+			f.CreatedTo != nil && !o.CreatedAt.Before(*f.CreatedTo):
+			continue
+		}
+		orders = append(orders, copyOrder(&o))
+	}
+
+	orders, err := memoryPage(orders, f.Page, orderSortKeys, orderSortValue)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing orders: %w", err)
+	}
+
+	orders, next := pageOf(orders, f.Page, orderSortKeys, orderSortValue)
+	return orders, next, nil
+}
+
+// UpdateOrderStatus moves the order from one status to another, recording
+// actor in its status history. Cancelling an order puts its items back into
+// stock and gives back the uses of its promotions.
+func (ms *MemoryStorer) UpdateOrderStatus(_ context.Context, id int64, from, to OrderStatus, actor string) (*Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.updateOrderStatus(id, from, to, actor)
+}
+
+func (ms *MemoryStorer) updateOrderStatus(id int64, from, to OrderStatus, actor string) (*Order, error) {
+	o, ok := ms.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("error updating order status: %w", errNoRows)
+	}
+	if o.Status != from {
+		return nil, fmt.Errorf("error updating order status: %w", &ConflictError{Reason: fmt.Sprintf("order %d is no longer %s", id, from)})
+	}
+
+	if to == OrderStatusCancelled && from.HoldsStock() {
+		ms.adjustStock(o.Items, 1)
+	}
+	if to == OrderStatusCancelled {
+		ms.releasePromotions(&o)
+	}
+
+	now := time.Now()
+	o.Status = to
+	o.UpdatedAt = &now
+	ms.orders[id] = o
+
+	ms.lastChangeID++
+	ms.history[id] = append(ms.history[id], OrderStatusChange{
+		ID:         ms.lastChangeID,
+		OrderID:    id,
+		FromStatus: from,
+		ToStatus:   to,
+		Actor:      actor,
+		CreatedAt:  now,
+	})
+
+	co := copyOrder(&o)
+	return &co, nil
+}
+
+func (ms *MemoryStorer) ListOrderStatusHistory(_ context.Context, orderID int64) ([]OrderStatusChange, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return append([]OrderStatusChange(nil), ms.history[orderID]...), nil
+}
+
+// DeleteOrder deletes the order and, unless it already shipped or was
+// cancelled, puts its items back into stock. Unless it was cancelled, the
+// uses of its promotions are given back.
+func (ms *MemoryStorer) DeleteOrder(_ context.Context, id int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	o, ok := ms.orders[id]
+	if !ok {
+		return fmt.Errorf("error getting order status: %w", errNoRows)
+	}
+	if len(ms.payments[id]) > 0 {
+		return &ConflictError{Reason: fmt.Sprintf("order %d has payments", id)}
+	}
+	if len(o.Refunds) > 0 || len(ms.failedRefunds[id]) > 0 {
+		return &ConflictError{Reason: fmt.Sprintf("order %d has refunds", id)}
+	}
+
+	if o.Status.HoldsStock() {
+		ms.adjustStock(o.Items, 1)
+	}
+	if o.Status != OrderStatusCancelled {
+		ms.releasePromotions(&o)
+	}
+	delete(ms.orders, id)
+	delete(ms.history, id)
+	return nil
+}
+
+// adjustStock adds sign times the quantity of each item to the stock of its
+// product.
+func (ms *MemoryStorer) adjustStock(items []OrderItem, sign int64) {
+	for _, oi := range items {
+		if p, ok := ms.products[oi.ProductID]; ok {
+			p.CountInStock += sign * oi.Quantity
+			ms.products[oi.ProductID] = p
+		}
+	}
+}
+
+// claimPromotion checks that the promotion of d has a use left for user
+// userID, as the UPDATE of claimPromotion does for the SQL storers.
+func (ms *MemoryStorer) claimPromotion(d OrderDiscount, userID int64) error {
+	p, ok := ms.promotions[d.PromotionID]
+	if !ok {
+		return fmt.Errorf("%w: promotion %d does not exist", ErrConstraint, d.PromotionID)
+	}
+	if p.MaxUses != nil && p.Uses >= *p.MaxUses {
+		return &ConflictError{Reason: fmt.Sprintf("coupon %s has reached its usage limit", d.Code)}
+	}
+
+	if p.MaxUsesPerUser != nil {
+		var uses int64
+		for _, o := range ms.orders {
+			if o.UserID != userID || o.Status == OrderStatusCancelled {
+				continue
+			}
+			for _, od := range o.Discounts {
+				if od.PromotionID == p.ID {
+					uses++
+				}
+			}
+		}
+		if uses >= *p.MaxUsesPerUser {
+			return &ConflictError{Reason: fmt.Sprintf("coupon %s has reached its usage limit", d.Code)}
+		}
+	}
+
+	return nil
+}
+
+// releasePromotions gives back the uses the discounts of o took.
+func (ms *MemoryStorer) releasePromotions(o *Order) {
+	for _, d := range o.Discounts {
+		if p, ok := ms.promotions[d.PromotionID]; ok {
+			p.Uses--
+			ms.promotions[p.ID] = p
+		}
+	}
+}
+
+func copyOrder(o *Order) Order {
+	co := *o
+	co.Items = append([]OrderItem(nil), o.Items...)
+	co.Discounts = append([]OrderDiscount(nil), o.Discounts...)
+	co.Refunds = make([]Refund, len(o.Refunds))
+	for i, r := range o.Refunds {
+		co.Refunds[i] = r
+		co.Refunds[i].Items = append([]RefundItem(nil), r.Items...)
+	}
+	if len(co.Refunds) == 0 {
+		co.Refunds = nil
+	}
+	return co
+}
+
+func (ms *MemoryStorer) CreatePromotion(_ context.Context, p *Promotion) (*Promotion, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.codeTaken(p.Code, 0) {
+		return nil, fmt.Errorf("error inserting promotion: %w: duplicate code %q", ErrConflict, p.Code)
+	}
+
+	ms.lastPromotionID++
+	np := *p
+	np.ID = ms.lastPromotionID
+	np.Uses = 0
+	np.CreatedAt = time.Now()
+	np.setCurrency()
+	ms.promotions[np.ID] = np
+
+	return &np, nil
+}
+
+func (ms *MemoryStorer) GetPromotion(_ context.Context, id int64) (*Promotion, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	p, ok := ms.promotions[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting promotion: %w", errNoRows)
+	}
+
+	return &p, nil
+}
+
+func (ms *MemoryStorer) GetPromotionByCode(_ context.Context, code string) (*Promotion, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, p := range ms.promotions {
+		if p.Code == code {
+			return &p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("error getting promotion: %w", errNoRows)
+}
+
+func (ms *MemoryStorer) ListPromotions(_ context.Context) ([]Promotion, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	promotions := make([]Promotion, 0, len(ms.promotions))
+	for _, p := range ms.promotions {
+		promotions = append(promotions, p)
+	}
+	sort.Slice(promotions, func(i, j int) bool { return promotions[i].ID < promotions[j].ID })
+
+	return promotions, nil
+}
+
+// UpdatePromotion updates the rules of the promotion. Its uses are only ever
+// counted by CreateOrder and left alone.
+func (ms *MemoryStorer) UpdatePromotion(_ context.Context, p *Promotion) (*Promotion, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	old, ok := ms.promotions[p.ID]
+	if !ok {
+		return nil, fmt.Errorf("error updating promotion: %w", errNoRows)
+	}
+	if ms.codeTaken(p.Code, p.ID) {
+		return nil, fmt.Errorf("error updating promotion: %w: duplicate code %q", ErrConflict, p.Code)
+	}
+
+	np := *p
+	np.Uses = old.Uses
+	np.CreatedAt = old.CreatedAt
+	np.setCurrency()
+	ms.promotions[np.ID] = np
+
+	return &np, nil
+}
+
+// codeTaken reports whether a promotion other than exceptID has the code.
+func (ms *MemoryStorer) codeTaken(code string, exceptID int64) bool {
+	for _, p := range ms.promotions {
+		if p.Code == code && p.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (ms *MemoryStorer) CreatePayment(_ context.Context, p *Payment) (*Payment, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.createPayment(p)
+}
+
+func (ms *MemoryStorer) createPayment(p *Payment) (*Payment, error) {
+	if _, ok := ms.orders[p.OrderID]; !ok {
+		return nil, fmt.Errorf("error inserting payment: %w: order %d does not exist", ErrConstraint, p.OrderID)
+	}
+
+	ms.lastPaymentID++
+	np := *p
+	np.ID = ms.lastPaymentID
+	np.CreatedAt = time.Now()
+	np.setCurrency()
+	ms.payments[np.OrderID] = append(ms.payments[np.OrderID], np)
+
+	return &np, nil
+}
+
+func (ms *MemoryStorer) ListPayments(_ context.Context, orderID int64) ([]Payment, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return append([]Payment(nil), ms.payments[orderID]...), nil
+}
+
+// GetPaymentByReference returns the first payment recorded with the reference
+// of provider, i.e. the authorization that named it.
+func (ms *MemoryStorer) GetPaymentByReference(_ context.Context, provider, reference string) (*Payment, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var found *Payment
+	for _, payments := range ms.payments {
+		for _, p := range payments {
+			if p.Provider == provider && p.Reference == reference && (found == nil || p.ID < found.ID) {
+				found = &p
+			}
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("error getting payment: %w", errNoRows)
+	}
+
+	return found, nil
+}
+
+func (ms *MemoryStorer) CreateWebhookEvent(_ context.Context, e *WebhookEvent) (*WebhookEvent, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := webhookEventKey{e.Provider, e.EventID}
+	if _, ok := ms.webhookEvents[key]; ok {
+		return nil, fmt.Errorf("error inserting webhook event: %w: duplicate event %q", ErrConflict, e.EventID)
+	}
+
+	ms.lastWebhookEventID++
+	ne := *e
+	ne.ID = ms.lastWebhookEventID
+	ne.Error = ""
+	ne.Attempts = 0
+	ne.CreatedAt = time.Now()
+	ne.ProcessedAt = nil
+	ms.webhookEvents[key] = ne
+
+	return &ne, nil
+}
+
+func (ms *MemoryStorer) GetWebhookEvent(_ context.Context, provider, eventID string) (*WebhookEvent, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	e, ok := ms.webhookEvents[webhookEventKey{provider, eventID}]
+	if !ok {
+		return nil, fmt.Errorf("error getting webhook event: %w", errNoRows)
+	}
+
+	return &e, nil
+}
+
+func (ms *MemoryStorer) ListWebhookEvents(_ context.Context, provider string, status WebhookEventStatus) ([]WebhookEvent, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var events []WebhookEvent
+	for _, e := range ms.webhookEvents {
+		if e.Provider == provider && e.Status == status {
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+	return events, nil
+}
+
+// ProcessWebhookEvent marks the event processed and applies its change. The
+// change is checked first so that nothing is applied if any of it fails.
+func (ms *MemoryStorer) ProcessWebhookEvent(_ context.Context, id int64, change *PaymentChange) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key, ok := ms.webhookEventKey(id)
+	if !ok || ms.webhookEvents[key].Status == WebhookEventProcessed {
+		return fmt.Errorf("error processing webhook event: %w", &ConflictError{Reason: fmt.Sprintf("webhook event %d is already processed", id)})
+	}
+
+	if change != nil {
+		o, ok := ms.orders[change.OrderID]
+		if !ok {
+			return fmt.Errorf("error processing webhook event: %w: order %d does not exist", ErrConstraint, change.OrderID)
+		}
+		if change.To != "" && o.Status != change.From {
+			return fmt.Errorf("error processing webhook event: %w", &ConflictError{Reason: fmt.Sprintf("order %d is no longer %s", change.OrderID, change.From)})
+		}
+
+		if change.Refund != nil {
+			if err := ms.createRefund(change.Refund); err != nil {
+				return fmt.Errorf("error processing webhook event: %w", err)
+			}
+		}
+		if change.Payment != nil {
+			if _, err := ms.createPayment(change.Payment); err != nil {
+				return fmt.Errorf("error processing webhook event: %w", err)
+			}
+		}
+		if change.To != "" {
+			if _, err := ms.updateOrderStatus(change.OrderID, change.From, change.To, change.Actor); err != nil {
+				return fmt.Errorf("error processing webhook event: %w", err)
+			}
+		}
+	}
+
+	now := time.Now()
+	e := ms.webhookEvents[key]
+	e.Status = WebhookEventProcessed
+	e.Error = ""
+	e.Attempts++
+	e.ProcessedAt = &now
+	ms.webhookEvents[key] = e
+
+	return nil
+}
+
+func (ms *MemoryStorer) FailWebhookEvent(_ context.Context, id int64, reason string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key, ok := ms.webhookEventKey(id)
+	if !ok || ms.webhookEvents[key].Status == WebhookEventProcessed {
+		return nil
+	}
+
+	e := ms.webhookEvents[key]
+	e.Status = WebhookEventFailed
+	e.Error = reason
+	e.Attempts++
+	ms.webhookEvents[key] = e
+
+	return nil
+}
+
+// webhookEventKey looks up the key of webhook event id.
+func (ms *MemoryStorer) webhookEventKey(id int64) (webhookEventKey, bool) {
+	for key, e := range ms.webhookEvents {
+		if e.ID == id {
+			return key, true
+		}
+	}
+	return webhookEventKey{}, false
+}
+
+// CreateRefund records refund r with its items, with the checks of
+// applyRefund for the SQL storers.
+func (ms *MemoryStorer) CreateRefund(_ context.Context, r *Refund) (*Refund, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if err := ms.createRefund(r); err != nil {
+		return nil, fmt.Errorf("error creating refund: %w", err)
+	}
+
+	return r, nil
+}
+
+// createRefund records refund r, leaving everything as it is if r cannot be
+// recorded.
+func (ms *MemoryStorer) createRefund(r *Refund) error {
+	o, ok := ms.orders[r.OrderID]
+	if !ok {
+		return errNoRows
+	}
+	o.setCurrency()
+	if r.Currency != o.Currency {
+		return &ValidationError{Reason: fmt.Sprintf("refund is in %s, order is in %s", r.Currency, o.Currency)}
+	}
+	r.setCurrency()
+	if r.Status == "" {
+		r.Status = RefundSucceeded
+	}
+	refunded := o.RefundedPrice.Add(r.Amount)
+	if refunded.Cmp(o.TotalPrice) > 0 {
+		return &ConflictError{Reason: fmt.Sprintf("refund of %s exceeds the %s left to refund", r.Amount, o.TotalPrice.Sub(o.RefundedPrice))}
+	}
+
+	stock := make([]OrderItem, 0, len(r.Items))
+	seen := make(map[int64]bool, len(r.Items))
+	for _, ri := range r.Items {
+		if seen[ri.OrderItemID] {
+			return &ValidationError{Reason: fmt.Sprintf("item %d is refunded twice", ri.OrderItemID)}
+		}
+		seen[ri.OrderItemID] = true
+
+		i := slices.IndexFunc(o.Items, func(oi OrderItem) bool { return oi.ID == ri.OrderItemID })
+		if i < 0 {
+			return &ValidationError{Reason: fmt.Sprintf("item %d is not part of order %d", ri.OrderItemID, r.OrderID)}
+		}
+		oi := o.Items[i]
+
+		var quantity int64
+		for _, or := range o.Refunds {
+			for _, ori := range or.Items {
+				if ori.OrderItemID == oi.ID {
+					quantity += ori.Quantity
+				}
+			}
+		}
+		if ri.Quantity > oi.Quantity-quantity {
+			return &ConflictError{Reason: fmt.Sprintf("only %d of item %d are left to refund", oi.Quantity-quantity, oi.ID)}
+		}
+
+		oi.Quantity = ri.Quantity
+		stock = append(stock, oi)
+	}
+
+	if r.Status == RefundSucceeded && r.Restock {
+		ms.adjustStock(stock, 1)
+	}
+
+	ms.lastRefundID++
+	r.ID = ms.lastRefundID
+	r.CreatedAt = time.Now()
+	for i := range r.Items {
+		ms.lastRefundItemID++
+		r.Items[i].ID = ms.lastRefundItemID
+		r.Items[i].RefundID = r.ID
+	}
+
+	now := time.Now()
+	o.RefundedPrice = refunded
+	o.UpdatedAt = &now
+	o.Refunds = append(o.Refunds, *r)
+	ms.orders[o.ID] = copyOrder(&o)
+
+	return nil
+}
+
+// CompleteRefund marks pending refund id succeeded with the reference of the
+// provider and puts its items back into stock if it restocks.
+func (ms *MemoryStorer) CompleteRefund(_ context.Context, id int64, reference string) (*Refund, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	o, i, err := ms.pendingRefund(id)
+	if err != nil {
+		return nil, fmt.Errorf("error completing refund: %w", err)
+	}
+
+	r := &o.Refunds[i]
+	r.Status = RefundSucceeded
+	r.Reference = reference
+	if r.Restock {
+		stock := make([]OrderItem, 0, len(r.Items))
+		for _, ri := range r.Items {
+			j := slices.IndexFunc(o.Items, func(oi OrderItem) bool { return oi.ID == ri.OrderItemID })
+			oi := o.Items[j]
+			oi.Quantity = ri.Quantity
+			stock = append(stock, oi)
+		}
+		ms.adjustStock(stock, 1)
+	}
+
+	cr := *r
+	cr.Items = append([]RefundItem(nil), r.Items...)
+	ms.orders[o.ID] = copyOrder(o)
+
+	return &cr, nil
+}
+
+// FailRefund marks pending refund id failed and takes it off the refunded
+// price of its order.
+func (ms *MemoryStorer) FailRefund(_ context.Context, id int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	o, i, err := ms.pendingRefund(id)
+	if err != nil {
+		return fmt.Errorf("error failing refund: %w", err)
+	}
+
+	r := o.Refunds[i]
+	r.Status = RefundFailed
+	ms.failedRefunds[o.ID] = append(ms.failedRefunds[o.ID], r)
+
+	now := time.Now()
+	o.RefundedPrice = o.RefundedPrice.Sub(r.Amount)
+	o.UpdatedAt = &now
+	o.Refunds = slices.Delete(o.Refunds, i, i+1)
+	ms.orders[o.ID] = copyOrder(o)
+
+	return nil
+}
+
+// pendingRefund looks up refund id, returning its order and its index in the
+// refunds of the order, and checks that it is pending.
+func (ms *MemoryStorer) pendingRefund(id int64) (*Order, int, error) {
+	for _, o := range ms.orders {
+		i := slices.IndexFunc(o.Refunds, func(r Refund) bool { return r.ID == id })
+		if i < 0 {
+			continue
+		}
+		if o.Refunds[i].Status != RefundPending {
+			return nil, 0, &ConflictError{Reason: fmt.Sprintf("refund %d is %s", id, o.Refunds[i].Status)}
+		}
+		co := copyOrder(&o)
+		return &co, i, nil
+	}
+	for _, failed := range ms.failedRefunds {
+		if slices.ContainsFunc(failed, func(r Refund) bool { return r.ID == id }) {
+			return nil, 0, &ConflictError{Reason: fmt.Sprintf("refund %d is %s", id, RefundFailed)}
+		}
+	}
+
+	return nil, 0, errNoRows
+}
+
+func (ms *MemoryStorer) CreateUser(_ context.Context, u *User) (*User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.emailTaken(u.Email, 0) {
+		return nil, fmt.Errorf("error inserting user: %w: duplicate email %q", ErrConflict, u.Email)
+	}
+
+	ms.lastUserID++
+	u.ID = ms.lastUserID
+	u.CreatedAt = time.Now()
+	ms.users[u.ID] = *u
+
+	return u, nil
+}
+
+func (ms *MemoryStorer) GetUser(_ context.Context, email string) (*User, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, u := range ms.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+
+	return nil, fmt.Errorf("error getting user: %w", errNoRows)
+}
+
+func (ms *MemoryStorer) ListUsers(_ context.Context, f UserFilter) ([]User, *Cursor, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	users := make([]User, 0, len(ms.users))
+	for _, u := range ms.users {
+		users = append(users, u)
+	}
+
+	users, err := memoryPage(users, f.Page, userSortKeys, userSortValue)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing users: %w", err)
+	}
+
+	users, next := pageOf(users, f.Page, userSortKeys, userSortValue)
+	return users, next, nil
+}
+
+func (ms *MemoryStorer) UpdateUser(_ context.Context, u *User) (*User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	old, ok := ms.users[u.ID]
+	if !ok {
+		return nil, fmt.Errorf("error updating user: %w", errNoRows)
+	}
+	if ms.emailTaken(u.Email, u.ID) {
+		return nil, fmt.Errorf("error updating user: %w: duplicate email %q", ErrConflict, u.Email)
+	}
+
+	nu := *u
+	nu.CreatedAt = old.CreatedAt
+	ms.users[nu.ID] = nu
+
+	return u, nil
+}
+
+// emailTaken reports whether a user other than exceptID has the given email.
+func (ms *MemoryStorer) emailTaken(email string, exceptID int64) bool {
+	for _, u := range ms.users {
+		if u.Email == email && u.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (ms *MemoryStorer) DeleteUser(_ context.Context, id int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, o := range ms.orders {
+		if o.UserID == id {
+			return fmt.Errorf("error deleting user: %w: referenced by order %d", ErrConstraint, o.ID)
+		}
+	}
+
+	if c, ok := ms.userCart(id); ok {
+		delete(ms.carts, c.ID)
+	}
+
+	delete(ms.users, id)
+	return nil
+}
+
+func (ms *MemoryStorer) CreateCart(_ context.Context, c *Cart) (*Cart, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.carts[c.ID]; ok {
+		return nil, fmt.Errorf("error inserting cart: %w: duplicate id %q", ErrConflict, c.ID)
+	}
+	if c.UserID != nil {
+		if _, ok := ms.userCart(*c.UserID); ok {
+			return nil, fmt.Errorf("error inserting cart: %w: user %d already has a cart", ErrConflict, *c.UserID)
+		}
+	}
+
+	nc := Cart{ID: c.ID, UserID: c.UserID, CreatedAt: time.Now()}
+	ms.carts[nc.ID] = nc
+
+	return &nc, nil
+}
+
+func (ms *MemoryStorer) GetCart(_ context.Context, id string) (*Cart, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	c, ok := ms.carts[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting cart: %w", errNoRows)
+	}
+
+	cc := copyCart(&c)
+	return &cc, nil
+}
+
+func (ms *MemoryStorer) GetUserCart(_ context.Context, userID int64) (*Cart, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	c, ok := ms.userCart(userID)
+	if !ok {
+		return nil, fmt.Errorf("error getting cart: %w", errNoRows)
+	}
+
+	cc := copyCart(&c)
+	return &cc, nil
+}
+
+func (ms *MemoryStorer) userCart(userID int64) (Cart, bool) {
+	for _, c := range ms.carts {
+		if c.UserID != nil && *c.UserID == userID {
+			return c, true
+		}
+	}
+	return Cart{}, false
+}
+
+// AddCartItem adds quantity of the product to the cart, on top of what the
+// cart already holds.
+func (ms *MemoryStorer) AddCartItem(_ context.Context, cartID string, productID, quantity int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	c, ok := ms.carts[cartID]
+	if !ok {
+		return fmt.Errorf("error adding cart item: %w: cart %q does not exist", ErrConstraint, cartID)
+	}
+	if _, ok := ms.products[productID]; !ok {
+		return fmt.Errorf("error adding cart item: %w: product %d does not exist", ErrConstraint, productID)
+	}
+
+	ms.addCartItem(&c, productID, quantity)
+	ms.carts[cartID] = c
+	return nil
+}
+
+func (ms *MemoryStorer) addCartItem(c *Cart, productID, quantity int64) {
+	for i := range c.Items {
+		if c.Items[i].ProductID == productID {
+			c.Items[i].Quantity += quantity
+			return
+		}
+	}
+
+	ms.lastCartItemID++
+	c.Items = append(c.Items, CartItem{
+		ID:        ms.lastCartItemID,
+		CartID:    c.ID,
+		ProductID: productID,
+		Quantity:  quantity,
+		CreatedAt: time.Now(),
+	})
+}
+
+func (ms *MemoryStorer) UpdateCartItem(_ context.Context, cartID string, productID, quantity int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	c := ms.carts[cartID]
+	for i := range c.Items {
+		if c.Items[i].ProductID == productID {
+			c.Items[i].Quantity = quantity
+			return nil
+		}
+	}
+
+	return fmt.Errorf("error updating cart item: %w", errNoRows)
+}
+
+func (ms *MemoryStorer) RemoveCartItem(_ context.Context, cartID string, productID int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	c, ok := ms.carts[cartID]
+	if !ok {
+		return nil
+	}
+
+	items := c.Items[:0]
+	for _, ci := range c.Items {
+		if ci.ProductID != productID {
+			items = append(items, ci)
+		}
+	}
+	c.Items = items
+	ms.carts[cartID] = c
+	return nil
+}
+
+func (ms *MemoryStorer) ClearCart(_ context.Context, cartID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if c, ok := ms.carts[cartID]; ok {
+		c.Items = nil
+		ms.carts[cartID] = c
+	}
+	return nil
+}
+
+// MergeCarts moves the items of cart fromID into cart toID, adding up the
+// quantities of products in both, and deletes fromID.
+func (ms *MemoryStorer) MergeCarts(_ context.Context, fromID, toID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	to, ok := ms.carts[toID]
+	if !ok {
+		return fmt.Errorf("error merging carts: %w: cart %q does not exist", ErrConstraint, toID)
+	}
+
+	for _, ci := range ms.carts[fromID].Items {
+		ms.addCartItem(&to, ci.ProductID, ci.Quantity)
+	}
+	ms.carts[toID] = to
+	delete(ms.carts, fromID)
+	return nil
+}
+
+func copyCart(c *Cart) Cart {
+	cc := *c
+	cc.Items = append([]CartItem(nil), c.Items...)
+	return cc
+}
+
+func (ms *MemoryStorer) CreateSession(_ context.Context, s *Session) (*Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.sessions[s.ID]; ok {
+		return nil, fmt.Errorf("error inserting session: %w: duplicate id %q", ErrConflict, s.ID)
+	}
+	s.CreatedAt = time.Now()
+	ms.sessions[s.ID] = *s
+
+	return s, nil
+}
+
+func (ms *MemoryStorer) GetSession(_ context.Context, id string) (*Session, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	s, ok := ms.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting session: %w", errNoRows)
+	}
+
+	return &s, nil
+}
+
+func (ms *MemoryStorer) RotateSession(_ context.Context, oldID string, ns *Session) (*Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	old, ok := ms.sessions[oldID]
+	if !ok || old.ReplacedBy != nil || old.IsRevoked {
+		return nil, fmt.Errorf("error rotating session: %w", ErrSessionRotated)
+	}
+
+	replacedBy := ns.ID
+	old.ReplacedBy = &replacedBy
+	ms.sessions[oldID] = old
+
+	ns.CreatedAt = time.Now()
+	ms.sessions[ns.ID] = *ns
+
+	return ns, nil
+}
+
+func (ms *MemoryStorer) RevokeSessionFamily(_ context.Context, familyID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for id, s := range ms.sessions {
+		if s.FamilyID == familyID {
+			s.IsRevoked = true
+			ms.sessions[id] = s
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemoryStorer) RevokeToken(_ context.Context, id string, expiresAt time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.revoked[id]; !ok {
+		ms.revoked[id] = expiresAt
+	}
+	return nil
+}
+
+func (ms *MemoryStorer) IsTokenRevoked(_ context.Context, id string) (bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	_, ok := ms.revoked[id]
+	return ok, nil
+}
+
+func (ms *MemoryStorer) PurgeRevokedTokens(_ context.Context, before time.Time) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var n int64
+	for id, expiresAt := range ms.revoked {
+		if expiresAt.Before(before) {
+			delete(ms.revoked, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
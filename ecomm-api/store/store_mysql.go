@@ -3,6 +3,7 @@ package storer
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -11,6 +12,13 @@ type MySQLStorer struct {
 	db *sqlx.DB
 }
 
+// mysqlForUpdate locks the rows read by a SELECT until the transaction ends.
+const mysqlForUpdate = " FOR UPDATE"
+
+// mysqlAddCartItem inserts a cart item or adds to the quantity of the one
+// already in the cart.
+const mysqlAddCartItem = "INSERT INTO cart_items (cart_id, product_id, quantity) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE quantity=quantity+VALUES(quantity)"
+
 func NewMySQLStorer(db *sqlx.DB) *MySQLStorer {
 	return &MySQLStorer{db: db}
 }
@@ -19,20 +27,20 @@ func (ms *MySQLStorer) CreateProduct(ctx context.Context, p *Product) (*Product,
 	query := `
 		 INSERT INTO products (
 			  name, image, category, description, 
-			  rating, num_reviews, price, count_in_stock
+			  rating, num_reviews, price, currency, count_in_stock, is_active
 		 ) VALUES (
 			  :name, :image, :category, :description, 
-			  :rating, :num_reviews, :price, :count_in_stock
+			  :rating, :num_reviews, :price, :currency, :count_in_stock, :is_active
 		 )`
 
 	res, err := ms.db.NamedExecContext(ctx, query, p)
 	if err != nil {
-		return nil, fmt.Errorf("error inserting product: %w", err)
+		return nil, fmt.Errorf("error inserting product: %w", dbError(ctx, err))
 	}
 
 	id, err := res.LastInsertId()
 	if err != nil {
-		return nil, fmt.Errorf("error getting last insert id: %w", err)
+		return nil, fmt.Errorf("error getting last insert id: %w", dbError(ctx, err))
 	}
 
 	// Fetch the created product to get the correct timestamps
@@ -43,60 +51,97 @@ func (ms *MySQLStorer) GetProduct(ctx context.Context, id int64) (*Product, erro
 	var p Product
 	err := ms.db.GetContext(ctx, &p, "SELECT * FROM products WHERE id=?", id)
 	if err != nil {
-		return nil, fmt.Errorf("error getting product: %w", err)
+		return nil, fmt.Errorf("error getting product: %w", dbError(ctx, err))
 	}
+	p.setCurrency()
 
 	return &p, nil
 }
 
-func (ms *MySQLStorer) ListProducts(ctx context.Context) ([]Product, error) {
+// ListProducts returns one page of the products matching f and the cursor of
+// the next page, or nil if there is none.
+func (ms *MySQLStorer) ListProducts(ctx context.Context, f ProductFilter) ([]Product, *Cursor, error) {
+	query, args, err := productFilterQuery(f).build("products", f.Page, productSortKeys)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing products: %w", err)
+	}
+
 	var products []Product
-	err := ms.db.SelectContext(ctx, &products, "SELECT * FROM products")
+	err = ms.db.SelectContext(ctx, &products, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error listing products: %w", err)
+		return nil, nil, fmt.Errorf("error listing products: %w", dbError(ctx, err))
 	}
 
-	return products, nil
+	products, next := pageOf(products, f.Page, productSortKeys, productSortValue)
+	for i := range products {
+		products[i].setCurrency()
+	}
+	return products, next, nil
 }
 
 func (ms *MySQLStorer) UpdateProduct(ctx context.Context, p *Product) (*Product, error) {
-	_, err := ms.db.NamedExecContext(ctx, "UPDATE products SET name=:name, image=:image, category=:category, description=:description, rating=:rating, num_reviews=:num_reviews, price=:price, count_in_stock=:count_in_stock WHERE id=:id", p)
+	_, err := ms.db.NamedExecContext(ctx, "UPDATE products SET name=:name, image=:image, category=:category, description=:description, rating=:rating, num_reviews=:num_reviews, price=:price, currency=:currency, is_active=:is_active WHERE id=:id", p)
 	if err != nil {
-		return nil, fmt.Errorf("error updating product: %w", err)
+		return nil, fmt.Errorf("error updating product: %w", dbError(ctx, err))
 	}
 
 	return p, nil
 }
 
+func (ms *MySQLStorer) SetProductStock(ctx context.Context, id, count int64) error {
+	return setProductStock(ctx, ms.db, id, count)
+}
+
 func (ms *MySQLStorer) DeleteProduct(ctx context.Context, id int64) error {
 	_, err := ms.db.ExecContext(ctx, "DELETE FROM products WHERE id=?", id)
 	if err != nil {
-		return fmt.Errorf("error deleting product: %w", err)
+		return fmt.Errorf("error deleting product: %w", dbError(ctx, err))
 	}
 
 	return nil
 }
 
 func (ms *MySQLStorer) CreateOrder(ctx context.Context, o *Order) (*Order, error) {
-	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+	if o.Status == "" {
+		o.Status = OrderStatusPending
+	}
+
+	err := execTx(ctx, ms.db, func(tx *sqlx.Tx) error {
+		err := reserveStock(ctx, tx, o.Items, mysqlForUpdate)
+		if err != nil {
+			return err
+		}
+
 		// insert into orders
 		order, err := createOrder(ctx, tx, o)
 		if err != nil {
-			return fmt.Errorf("error creating order: %w", err)
+			return fmt.Errorf("error creating order: %w", dbError(ctx, err))
 		}
 
-		for _, oi := range o.Items {
-			oi.OrderID = order.ID
+		for i := range o.Items {
+			o.Items[i].OrderID = order.ID
 			// insert into order_items
-			err = createOrderItem(ctx, tx, oi)
+			err = createOrderItem(ctx, tx, &o.Items[i])
 			if err != nil {
-				return fmt.Errorf("error creating order item: %w", err)
+				return fmt.Errorf("error creating order item: %w", dbError(ctx, err))
+			}
+		}
+
+		for i := range o.Discounts {
+			if err := claimPromotion(ctx, tx, o.Discounts[i], o.UserID); err != nil {
+				return err
+			}
+
+			o.Discounts[i].OrderID = order.ID
+			err = createOrderDiscount(ctx, tx, &o.Discounts[i])
+			if err != nil {
+				return fmt.Errorf("error creating order discount: %w", dbError(ctx, err))
 			}
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("error creating order: %w", err)
+		return nil, fmt.Errorf("error creating order: %w", dbError(ctx, err))
 	}
 
 	return o, nil
@@ -105,122 +150,399 @@ func (ms *MySQLStorer) CreateOrder(ctx context.Context, o *Order) (*Order, error
 func createOrder(ctx context.Context, tx *sqlx.Tx, o *Order) (*Order, error) {
 	res, err := tx.NamedExecContext(ctx, `
 		 INSERT INTO orders (
+			  status, 
 			  payment_method, 
+			  currency, 
+			  items_price, 
 			  tax_price, 
 			  shipping_price, 
+			  discount_price, 
 			  total_price,
 			  user_id
 		 ) VALUES (
+			  :status, 
 			  :payment_method, 
+			  :currency, 
+			  :items_price, 
 			  :tax_price, 
 			  :shipping_price, 
+			  :discount_price, 
 			  :total_price,
 			  :user_id
 		 )`, o)
 	if err != nil {
-		return nil, fmt.Errorf("error inserting order: %w", err)
+		return nil, fmt.Errorf("error inserting order: %w", dbError(ctx, err))
 	}
 
 	id, err := res.LastInsertId()
 	if err != nil {
-		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+		return nil, fmt.Errorf("error getting last insert ID: %w", dbError(ctx, err))
 	}
 	o.ID = id
 
+	// the creation time is set by the database, as RETURNING does elsewhere
+	err = tx.GetContext(ctx, &o.CreatedAt, "SELECT created_at FROM orders WHERE id=?", id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting order creation time: %w", dbError(ctx, err))
+	}
+
 	return o, nil
 }
 
-func createOrderItem(ctx context.Context, tx *sqlx.Tx, oi OrderItem) error {
+func createOrderItem(ctx context.Context, tx *sqlx.Tx, oi *OrderItem) error {
 	res, err := tx.NamedExecContext(ctx, "INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (:name, :quantity, :image, :price, :product_id, :order_id)", oi)
 	if err != nil {
-		return fmt.Errorf("error inserting order item: %w", err)
+		return fmt.Errorf("error inserting order item: %w", dbError(ctx, err))
 	}
 
 	id, err := res.LastInsertId()
 	if err != nil {
-		return fmt.Errorf("error getting last insert ID: %w", err)
+		return fmt.Errorf("error getting last insert ID: %w", dbError(ctx, err))
 	}
 	oi.ID = id
 
 	return nil
 }
 
+func createOrderDiscount(ctx context.Context, tx *sqlx.Tx, d *OrderDiscount) error {
+	res, err := tx.NamedExecContext(ctx, "INSERT INTO order_discounts (order_id, promotion_id, code, description, amount) VALUES (:order_id, :promotion_id, :code, :description, :amount)", d)
+	if err != nil {
+		return fmt.Errorf("error inserting order discount: %w", dbError(ctx, err))
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting last insert ID: %w", dbError(ctx, err))
+	}
+	d.ID = id
+
+	return nil
+}
+
 func (ms *MySQLStorer) GetOrder(ctx context.Context, id int64) (*Order, error) {
 	var o Order
 	err := ms.db.GetContext(ctx, &o, "SELECT * FROM orders WHERE id=?", id)
 	if err != nil {
-		return nil, fmt.Errorf("error getting order: %w", err)
+		return nil, fmt.Errorf("error getting order: %w", dbError(ctx, err))
 	}
 
 	var items []OrderItem
 	err = ms.db.SelectContext(ctx, &items, "SELECT * FROM order_items WHERE order_id=?", id)
 	if err != nil {
-		return nil, fmt.Errorf("error getting order items: %w", err)
+		return nil, fmt.Errorf("error getting order items: %w", dbError(ctx, err))
 	}
 	o.Items = items
 
+	// only orders with a discount have discount lines to load
+	if !o.DiscountPrice.IsZero() {
+		err = ms.db.SelectContext(ctx, &o.Discounts, "SELECT * FROM order_discounts WHERE order_id=? ORDER BY id", id)
+		if err != nil {
+			return nil, fmt.Errorf("error getting order discounts: %w", dbError(ctx, err))
+		}
+	}
+
+	orders := []Order{o}
+	if err := attachOrderRefunds(ctx, ms.db, orders); err != nil {
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+	o = orders[0]
+	o.setCurrency()
+
 	return &o, nil
 }
 
-func (ms *MySQLStorer) ListOrders(ctx context.Context) ([]Order, error) {
+// ListOrders returns one page of the orders matching f, with their items, and
+// the cursor of the next page, or nil if there is none.
+func (ms *MySQLStorer) ListOrders(ctx context.Context, f OrderFilter) ([]Order, *Cursor, error) {
+	query, args, err := orderFilterQuery(f).build("orders", f.Page, orderSortKeys)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing orders: %w", err)
+	}
+
 	var orders []Order
-	err := ms.db.SelectContext(ctx, &orders, "SELECT * FROM orders")
+	err = ms.db.SelectContext(ctx, &orders, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error listing orders: %w", err)
+		return nil, nil, fmt.Errorf("error listing orders: %w", dbError(ctx, err))
 	}
+	orders, next := pageOf(orders, f.Page, orderSortKeys, orderSortValue)
 
+	if err := attachOrderItems(ctx, ms.db, orders); err != nil {
+		return nil, nil, fmt.Errorf("error listing orders: %w", err)
+	}
+	if err := attachOrderDiscounts(ctx, ms.db, orders); err != nil {
+		return nil, nil, fmt.Errorf("error listing orders: %w", err)
+	}
+	if err := attachOrderRefunds(ctx, ms.db, orders); err != nil {
+		return nil, nil, fmt.Errorf("error listing orders: %w", err)
+	}
 	for i := range orders {
-		var items []OrderItem
-		err = ms.db.SelectContext(ctx, &items, "SELECT * FROM order_items WHERE order_id=?", orders[i].ID)
-		if err != nil {
-			return nil, fmt.Errorf("error getting order items: %w", err)
-		}
-		orders[i].Items = items
+		orders[i].setCurrency()
 	}
 
-	return orders, nil
+	return orders, next, nil
 }
 
-// UpdateOrderStatus
+// UpdateOrderStatus moves the order from one status to another, recording
+// actor in its status history. Cancelling an order puts its items back into
+// stock.
+func (ms *MySQLStorer) UpdateOrderStatus(ctx context.Context, id int64, from, to OrderStatus, actor string) (*Order, error) {
+	err := execTx(ctx, ms.db, func(tx *sqlx.Tx) error {
+		return updateOrderStatus(ctx, tx, id, from, to, actor)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating order status: %w", dbError(ctx, err))
+	}
+
+	return ms.GetOrder(ctx, id)
+}
 
+func (ms *MySQLStorer) ListOrderStatusHistory(ctx context.Context, orderID int64) ([]OrderStatusChange, error) {
+	var changes []OrderStatusChange
+	err := ms.db.SelectContext(ctx, &changes, "SELECT * FROM order_status_history WHERE order_id=? ORDER BY id", orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing order status history: %w", dbError(ctx, err))
+	}
+
+	return changes, nil
+}
+
+// DeleteOrder deletes the order and, unless it already shipped or was
+// cancelled, puts its items back into stock.
 func (ms *MySQLStorer) DeleteOrder(ctx context.Context, id int64) error {
-	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
-		_, err := tx.ExecContext(ctx, "DELETE FROM order_items WHERE order_id=?", id)
-		if err != nil {
-			return fmt.Errorf("error deleting order items: %w", err)
-		}
+	err := execTx(ctx, ms.db, func(tx *sqlx.Tx) error {
+		return deleteOrder(ctx, tx, id, mysqlForUpdate)
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting order: %w", dbError(ctx, err))
+	}
 
-		_, err = tx.ExecContext(ctx, "DELETE FROM orders WHERE id=?", id)
-		if err != nil {
-			return fmt.Errorf("error deleting order: %w", err)
-		}
+	return nil
+}
 
-		return nil
+func (ms *MySQLStorer) CreatePromotion(ctx context.Context, p *Promotion) (*Promotion, error) {
+	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO promotions (code, description, kind, percent_off, amount_off, currency, buy_quantity, get_quantity, category, min_subtotal, max_uses, max_uses_per_user, stackable, is_active, starts_at, ends_at) VALUES (:code, :description, :kind, :percent_off, :amount_off, :currency, :buy_quantity, :get_quantity, :category, :min_subtotal, :max_uses, :max_uses_per_user, :stackable, :is_active, :starts_at, :ends_at)", p)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting promotion: %w", dbError(ctx, err))
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting last insert ID: %w", dbError(ctx, err))
+	}
+
+	return ms.GetPromotion(ctx, id)
+}
+
+func (ms *MySQLStorer) GetPromotion(ctx context.Context, id int64) (*Promotion, error) {
+	var p Promotion
+	err := ms.db.GetContext(ctx, &p, "SELECT * FROM promotions WHERE id=?", id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting promotion: %w", dbError(ctx, err))
+	}
+	p.setCurrency()
+
+	return &p, nil
+}
+
+func (ms *MySQLStorer) GetPromotionByCode(ctx context.Context, code string) (*Promotion, error) {
+	var p Promotion
+	err := ms.db.GetContext(ctx, &p, "SELECT * FROM promotions WHERE code=?", code)
+	if err != nil {
+		return nil, fmt.Errorf("error getting promotion: %w", dbError(ctx, err))
+	}
+	p.setCurrency()
+
+	return &p, nil
+}
+
+func (ms *MySQLStorer) ListPromotions(ctx context.Context) ([]Promotion, error) {
+	var promotions []Promotion
+	err := ms.db.SelectContext(ctx, &promotions, "SELECT * FROM promotions ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing promotions: %w", dbError(ctx, err))
+	}
+	for i := range promotions {
+		promotions[i].setCurrency()
+	}
+
+	return promotions, nil
+}
+
+// UpdatePromotion updates the rules of the promotion. Its uses are only ever
+// counted by CreateOrder and left alone.
+func (ms *MySQLStorer) UpdatePromotion(ctx context.Context, p *Promotion) (*Promotion, error) {
+	_, err := ms.db.NamedExecContext(ctx, "UPDATE promotions SET code=:code, description=:description, kind=:kind, percent_off=:percent_off, amount_off=:amount_off, currency=:currency, buy_quantity=:buy_quantity, get_quantity=:get_quantity, category=:category, min_subtotal=:min_subtotal, max_uses=:max_uses, max_uses_per_user=:max_uses_per_user, stackable=:stackable, is_active=:is_active, starts_at=:starts_at, ends_at=:ends_at WHERE id=:id", p)
+	if err != nil {
+		return nil, fmt.Errorf("error updating promotion: %w", dbError(ctx, err))
+	}
+
+	return ms.GetPromotion(ctx, p.ID)
+}
+
+func (ms *MySQLStorer) CreatePayment(ctx context.Context, p *Payment) (*Payment, error) {
+	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO payments (order_id, provider, operation, status, amount, currency, reference, error) VALUES (:order_id, :provider, :operation, :status, :amount, :currency, :reference, :error)", p)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting payment: %w", dbError(ctx, err))
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting last insert ID: %w", dbError(ctx, err))
+	}
+
+	p.ID = id
+	return p, nil
+}
+
+// ListPayments returns the payments of the order, oldest first.
+func (ms *MySQLStorer) ListPayments(ctx context.Context, orderID int64) ([]Payment, error) {
+	var payments []Payment
+	err := ms.db.SelectContext(ctx, &payments, "SELECT * FROM payments WHERE order_id=? ORDER BY id", orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing payments: %w", dbError(ctx, err))
+	}
+	for i := range payments {
+		payments[i].setCurrency()
+	}
+
+	return payments, nil
+}
+
+// GetPaymentByReference returns the first payment recorded with the reference
+// of provider, i.e. the authorization that named it.
+func (ms *MySQLStorer) GetPaymentByReference(ctx context.Context, provider, reference string) (*Payment, error) {
+	var p Payment
+	err := ms.db.GetContext(ctx, &p, "SELECT * FROM payments WHERE provider=? AND reference=? ORDER BY id LIMIT 1", provider, reference)
+	if err != nil {
+		return nil, fmt.Errorf("error getting payment: %w", dbError(ctx, err))
+	}
+	p.setCurrency()
+
+	return &p, nil
+}
+
+// CreateWebhookEvent stores a webhook event. An event already stored for the
+// provider is rejected with ErrConflict.
+func (ms *MySQLStorer) CreateWebhookEvent(ctx context.Context, e *WebhookEvent) (*WebhookEvent, error) {
+	_, err := ms.db.NamedExecContext(ctx, "INSERT INTO webhook_events (provider, event_id, type, payload, status) VALUES (:provider, :event_id, :type, :payload, :status)", e)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting webhook event: %w", dbError(ctx, err))
+	}
+
+	return ms.GetWebhookEvent(ctx, e.Provider, e.EventID)
+}
+
+func (ms *MySQLStorer) GetWebhookEvent(ctx context.Context, provider, eventID string) (*WebhookEvent, error) {
+	var e WebhookEvent
+	err := ms.db.GetContext(ctx, &e, "SELECT * FROM webhook_events WHERE provider=? AND event_id=?", provider, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting webhook event: %w", dbError(ctx, err))
+	}
+
+	return &e, nil
+}
+
+// ListWebhookEvents returns the events of provider in the given status,
+// oldest first.
+func (ms *MySQLStorer) ListWebhookEvents(ctx context.Context, provider string, status WebhookEventStatus) ([]WebhookEvent, error) {
+	var events []WebhookEvent
+	err := ms.db.SelectContext(ctx, &events, "SELECT * FROM webhook_events WHERE provider=? AND status=? ORDER BY id", provider, status)
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhook events: %w", dbError(ctx, err))
+	}
+
+	return events, nil
+}
+
+// ProcessWebhookEvent marks the event processed and applies its change in a
+// single transaction, so that an event is applied exactly once.
+func (ms *MySQLStorer) ProcessWebhookEvent(ctx context.Context, id int64, change *PaymentChange) error {
+	err := execTx(ctx, ms.db, func(tx *sqlx.Tx) error {
+		return processWebhookEvent(ctx, tx, id, change, ms.createRefund)
 	})
 	if err != nil {
-		return fmt.Errorf("error deleting order: %w", err)
+		return fmt.Errorf("error processing webhook event: %w", dbError(ctx, err))
 	}
 
 	return nil
 }
 
-func (ms *MySQLStorer) execTx(ctx context.Context, fn func(*sqlx.Tx) error) error {
-	tx, err := ms.db.BeginTxx(ctx, nil)
+// FailWebhookEvent records why the event could not be processed, unless it
+// was processed in the meantime.
+func (ms *MySQLStorer) FailWebhookEvent(ctx context.Context, id int64, reason string) error {
+	_, err := ms.db.ExecContext(ctx, "UPDATE webhook_events SET status=?, error=?, attempts=attempts+1 WHERE id=? AND status<>?", WebhookEventFailed, reason, id, WebhookEventProcessed)
 	if err != nil {
-		return fmt.Errorf("error beginning transaction: %w", err)
+		return fmt.Errorf("error updating webhook event: %w", dbError(ctx, err))
 	}
 
-	err = fn(tx)
+	return nil
+}
 
+// CreateRefund records refund r with its items, see applyRefund, in one
+// transaction.
+func (ms *MySQLStorer) CreateRefund(ctx context.Context, r *Refund) (*Refund, error) {
+	err := execTx(ctx, ms.db, func(tx *sqlx.Tx) error {
+		return ms.createRefund(ctx, tx, r)
+	})
 	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			return fmt.Errorf("error rolling back transaction: %w", rbErr)
+		return nil, fmt.Errorf("error creating refund: %w", dbError(ctx, err))
+	}
+
+	return r, nil
+}
+
+// createRefund records refund r with its items in transaction tx.
+func (ms *MySQLStorer) createRefund(ctx context.Context, tx *sqlx.Tx, r *Refund) error {
+	if err := applyRefund(ctx, tx, r, mysqlForUpdate); err != nil {
+		return err
+	}
+
+	res, err := tx.NamedExecContext(ctx, "INSERT INTO refunds (order_id, status, amount, shipping_amount, currency, reason, note, restock, reference, actor) VALUES (:order_id, :status, :amount, :shipping_amount, :currency, :reason, :note, :restock, :reference, :actor)", r)
+	if err != nil {
+		return fmt.Errorf("error inserting refund: %w", dbError(ctx, err))
+	}
+	r.ID, err = res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting last insert ID: %w", dbError(ctx, err))
+	}
+
+	for i := range r.Items {
+		r.Items[i].RefundID = r.ID
+		res, err := tx.NamedExecContext(ctx, "INSERT INTO refund_items (refund_id, order_item_id, quantity, amount) VALUES (:refund_id, :order_item_id, :quantity, :amount)", r.Items[i])
+		if err != nil {
+			return fmt.Errorf("error inserting refund item: %w", dbError(ctx, err))
 		}
-		return fmt.Errorf("error executing transaction: %w", err)
+		r.Items[i].ID, err = res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("error getting last insert ID: %w", dbError(ctx, err))
+		}
+	}
+
+	return nil
+}
+
+// CompleteRefund marks pending refund id succeeded, see completeRefund.
+func (ms *MySQLStorer) CompleteRefund(ctx context.Context, id int64, reference string) (*Refund, error) {
+	var r *Refund
+	err := execTx(ctx, ms.db, func(tx *sqlx.Tx) error {
+		var err error
+		r, err = completeRefund(ctx, tx, id, reference)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error completing refund: %w", dbError(ctx, err))
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("error committing transaction: %w", err)
+	return r, nil
+}
+
+// FailRefund marks pending refund id failed, see failRefund.
+func (ms *MySQLStorer) FailRefund(ctx context.Context, id int64) error {
+	err := execTx(ctx, ms.db, func(tx *sqlx.Tx) error {
+		return failRefund(ctx, tx, id)
+	})
+	if err != nil {
+		return fmt.Errorf("error failing refund: %w", dbError(ctx, err))
 	}
 
 	return nil
@@ -229,13 +551,13 @@ func (ms *MySQLStorer) execTx(ctx context.Context, fn func(*sqlx.Tx) error) erro
 func (ms *MySQLStorer) CreateUser(ctx context.Context, u *User) (*User, error) {
 	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO users (name, email, password, is_admin) VALUES (:name, :email, :password, :is_admin)", u)
 	if err != nil {
-		return nil, fmt.Errorf("error inserting user: %w", err)
+		return nil, fmt.Errorf("error inserting user: %w", dbError(ctx, err))
 	}
 
 	id, err := res.LastInsertId()
 
 	if err != nil {
-		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+		return nil, fmt.Errorf("error getting last insert ID: %w", dbError(ctx, err))
 	}
 
 	u.ID = id
@@ -244,28 +566,36 @@ func (ms *MySQLStorer) CreateUser(ctx context.Context, u *User) (*User, error) {
 
 func (ms *MySQLStorer) GetUser(ctx context.Context, email string) (*User, error) {
 	var u User
-	err := ms.db.GetContext(ctx, &u, "SELECT * FROM users WHERE emauk=?", email)
+	err := ms.db.GetContext(ctx, &u, "SELECT * FROM users WHERE email=?", email)
 	if err != nil {
-		return nil, fmt.Errorf("error getting user: %w", err)
+		return nil, fmt.Errorf("error getting user: %w", dbError(ctx, err))
 	}
 
 	return &u, nil
 }
 
-func (ms *MySQLStorer) ListUsers(ctx context.Context) ([]User, error) {
+// ListUsers returns one page of the users and the cursor of the next page, or
+// nil if there is none.
+func (ms *MySQLStorer) ListUsers(ctx context.Context, f UserFilter) ([]User, *Cursor, error) {
+	query, args, err := (&listQuery{}).build("users", f.Page, userSortKeys)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing users: %w", err)
+	}
+
 	var users []User
-	err := ms.db.SelectContext(ctx, &users, "SELECT * FROM users")
+	err = ms.db.SelectContext(ctx, &users, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error listing users: %w", err)
+		return nil, nil, fmt.Errorf("error listing users: %w", dbError(ctx, err))
 	}
 
-	return users, nil
+	users, next := pageOf(users, f.Page, userSortKeys, userSortValue)
+	return users, next, nil
 }
 
 func (ms *MySQLStorer) UpdateUser(ctx context.Context, u *User) (*User, error) {
 	_, err := ms.db.NamedExecContext(ctx, "UPDATE users SET name=:name, email=:email, password=:password, is_admin=:is_admin WHERE id=:id", u)
 	if err != nil {
-		return nil, fmt.Errorf("error updating user: %w", err)
+		return nil, fmt.Errorf("error updating user: %w", dbError(ctx, err))
 	}
 
 	return u, nil
@@ -274,8 +604,154 @@ func (ms *MySQLStorer) UpdateUser(ctx context.Context, u *User) (*User, error) {
 func (ms *MySQLStorer) DeleteUser(ctx context.Context, id int64) error {
 	_, err := ms.db.ExecContext(ctx, "DELETE FROM users WHERE id=?", id)
 	if err != nil {
-		return fmt.Errorf("error deleting user: %w", err)
+		return fmt.Errorf("error deleting user: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) CreateCart(ctx context.Context, c *Cart) (*Cart, error) {
+	_, err := ms.db.NamedExecContext(ctx, "INSERT INTO carts (id, user_id) VALUES (:id, :user_id)", c)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting cart: %w", dbError(ctx, err))
+	}
+
+	return ms.GetCart(ctx, c.ID)
+}
+
+func (ms *MySQLStorer) GetCart(ctx context.Context, id string) (*Cart, error) {
+	return getCart(ctx, ms.db, "SELECT * FROM carts WHERE id=?", id)
+}
+
+func (ms *MySQLStorer) GetUserCart(ctx context.Context, userID int64) (*Cart, error) {
+	return getCart(ctx, ms.db, "SELECT * FROM carts WHERE user_id=?", userID)
+}
+
+// AddCartItem adds quantity of the product to the cart, on top of what the
+// cart already holds.
+func (ms *MySQLStorer) AddCartItem(ctx context.Context, cartID string, productID, quantity int64) error {
+	_, err := ms.db.ExecContext(ctx, mysqlAddCartItem, cartID, productID, quantity)
+	if err != nil {
+		return fmt.Errorf("error adding cart item: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) UpdateCartItem(ctx context.Context, cartID string, productID, quantity int64) error {
+	return updateCartItem(ctx, ms.db, cartID, productID, quantity)
+}
+
+func (ms *MySQLStorer) RemoveCartItem(ctx context.Context, cartID string, productID int64) error {
+	return removeCartItem(ctx, ms.db, cartID, productID)
+}
+
+func (ms *MySQLStorer) ClearCart(ctx context.Context, cartID string) error {
+	return clearCart(ctx, ms.db, cartID)
+}
+
+// MergeCarts moves the items of cart fromID into cart toID, adding up the
+// quantities of products in both, and deletes fromID.
+func (ms *MySQLStorer) MergeCarts(ctx context.Context, fromID, toID string) error {
+	err := execTx(ctx, ms.db, func(tx *sqlx.Tx) error {
+		return mergeCarts(ctx, tx, fromID, toID, mysqlAddCartItem)
+	})
+	if err != nil {
+		return fmt.Errorf("error merging carts: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) CreateSession(ctx context.Context, s *Session) (*Session, error) {
+	_, err := ms.db.NamedExecContext(ctx, "INSERT INTO sessions (id, family_id, user_email, refresh_token, is_revoked, expires_at) VALUES (:id, :family_id, :user_email, :refresh_token, :is_revoked, :expires_at)", s)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting session: %w", dbError(ctx, err))
+	}
+
+	return s, nil
+}
+
+func (ms *MySQLStorer) GetSession(ctx context.Context, id string) (*Session, error) {
+	var s Session
+	err := ms.db.GetContext(ctx, &s, "SELECT * FROM sessions WHERE id=?", id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting session: %w", dbError(ctx, err))
+	}
+
+	return &s, nil
+}
+
+// RotateSession marks the session identified by oldID as replaced by ns and
+// inserts ns in the same transaction. ErrSessionRotated is returned if the old
+// session was already rotated or revoked.
+func (ms *MySQLStorer) RotateSession(ctx context.Context, oldID string, ns *Session) (*Session, error) {
+	err := execTx(ctx, ms.db, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE sessions SET replaced_by=? WHERE id=? AND replaced_by IS NULL AND is_revoked=false", ns.ID, oldID)
+		if err != nil {
+			return fmt.Errorf("error updating session: %w", dbError(ctx, err))
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error getting rows affected: %w", dbError(ctx, err))
+		}
+		if n == 0 {
+			return ErrSessionRotated
+		}
+
+		_, err = tx.NamedExecContext(ctx, "INSERT INTO sessions (id, family_id, user_email, refresh_token, is_revoked, expires_at) VALUES (:id, :family_id, :user_email, :refresh_token, :is_revoked, :expires_at)", ns)
+		if err != nil {
+			return fmt.Errorf("error inserting session: %w", dbError(ctx, err))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error rotating session: %w", dbError(ctx, err))
+	}
+
+	return ns, nil
+}
+
+func (ms *MySQLStorer) RevokeSessionFamily(ctx context.Context, familyID string) error {
+	_, err := ms.db.ExecContext(ctx, "UPDATE sessions SET is_revoked=true WHERE family_id=?", familyID)
+	if err != nil {
+		return fmt.Errorf("error revoking session family: %w", dbError(ctx, err))
 	}
 
 	return nil
 }
+
+func (ms *MySQLStorer) RevokeToken(ctx context.Context, id string, expiresAt time.Time) error {
+	_, err := ms.db.ExecContext(ctx, "INSERT IGNORE INTO revoked_tokens (id, expires_at) VALUES (?, ?)", id, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error inserting revoked token: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) IsTokenRevoked(ctx context.Context, id string) (bool, error) {
+	var n int
+	err := ms.db.GetContext(ctx, &n, "SELECT COUNT(*) FROM revoked_tokens WHERE id=?", id)
+	if err != nil {
+		return false, fmt.Errorf("error checking revoked token: %w", dbError(ctx, err))
+	}
+
+	return n > 0, nil
+}
+
+func (ms *MySQLStorer) PurgeRevokedTokens(ctx context.Context, before time.Time) (int64, error) {
+	res, err := ms.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at<?", before)
+	if err != nil {
+		return 0, fmt.Errorf("error purging revoked tokens: %w", dbError(ctx, err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", dbError(ctx, err))
+	}
+
+	return n, nil
+}
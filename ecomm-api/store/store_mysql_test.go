@@ -2,10 +2,15 @@ package storer
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
 )
@@ -29,7 +34,7 @@ func TestCreateProduct(t *testing.T) {
 		Description:  "test description",
 		Rating:       5,
 		NumReviews:   10,
-		Price:        100.0,
+		Price:        money.New(10000, "USD"),
 		CountInStock: 100,
 	}
 
@@ -40,7 +45,10 @@ func TestCreateProduct(t *testing.T) {
 		{
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO products ( name, image, category, description, rating, num_reviews, price, currency, count_in_stock, is_active ) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ?, ? )").WillReturnResult(sqlmock.NewResult(1, 1))
+				rows := sqlmock.NewRows([]string{"id", "name", "image", "category", "description", "rating", "num_reviews", "price", "count_in_stock", "created_at", "updated_at"}).
+					AddRow(1, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, p.Price.String(), p.CountInStock, p.CreatedAt, p.UpdatedAt)
+				mock.ExpectQuery("SELECT * FROM products WHERE id=?").WithArgs(1).WillReturnRows(rows)
 				cp, err := st.CreateProduct(context.Background(), p)
 				require.NoError(t, err)
 				require.Equal(t, int64(1), cp.ID)
@@ -51,7 +59,7 @@ func TestCreateProduct(t *testing.T) {
 		{
 			name: "failed inserting product",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").WillReturnError(fmt.Errorf("error inserting product"))
+				mock.ExpectExec("INSERT INTO products ( name, image, category, description, rating, num_reviews, price, currency, count_in_stock, is_active ) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ?, ? )").WillReturnError(fmt.Errorf("error inserting product"))
 				_, err := st.CreateProduct(context.Background(), p)
 				require.Error(t, err)
 				err = mock.ExpectationsWereMet()
@@ -61,7 +69,7 @@ func TestCreateProduct(t *testing.T) {
 		{
 			name: "failed getting last insert ID",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewErrorResult(fmt.Errorf("error getting last insert ID")))
+				mock.ExpectExec("INSERT INTO products ( name, image, category, description, rating, num_reviews, price, currency, count_in_stock, is_active ) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ?, ? )").WillReturnResult(sqlmock.NewErrorResult(fmt.Errorf("error getting last insert ID")))
 				_, err := st.CreateProduct(context.Background(), p)
 				require.Error(t, err)
 				err = mock.ExpectationsWereMet()
@@ -88,7 +96,7 @@ func TestGetProduct(t *testing.T) {
 		Description:  "test description",
 		Rating:       5,
 		NumReviews:   10,
-		Price:        100.0,
+		Price:        money.New(10000, "USD"),
 		CountInStock: 100,
 	}
 
@@ -100,7 +108,7 @@ func TestGetProduct(t *testing.T) {
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				rows := sqlmock.NewRows([]string{"id", "name", "image", "category", "description", "rating", "num_reviews", "price", "count_in_stock", "created_at", "updated_at"}).
-					AddRow(1, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, p.Price, p.CountInStock, p.CreatedAt, p.UpdatedAt)
+					AddRow(1, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, p.Price.String(), p.CountInStock, p.CreatedAt, p.UpdatedAt)
 
 				mock.ExpectQuery("SELECT * FROM products WHERE id=?").WithArgs(1).WillReturnRows(rows)
 
@@ -124,6 +132,31 @@ func TestGetProduct(t *testing.T) {
 				require.NoError(t, err)
 			},
 		},
+		{
+			name: "canceled",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				// the driver reports a broken connection rather than the context error
+				mock.ExpectQuery("SELECT * FROM products WHERE id=?").WithArgs(1).WillReturnError(fmt.Errorf("invalid connection"))
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, err := st.GetProduct(ctx, 1)
+				require.ErrorIs(t, err, ErrCanceled)
+				require.ErrorIs(t, err, context.Canceled)
+			},
+		},
+		{
+			name: "deadline exceeded",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT * FROM products WHERE id=?").WithArgs(1).WillDelayFor(time.Second).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				_, err := st.GetProduct(ctx, 1)
+				require.ErrorIs(t, err, ErrCanceled)
+				require.ErrorIs(t, err, context.DeadlineExceeded)
+			},
+		},
 	}
 
 	for _, tc := range tcs {
@@ -144,7 +177,7 @@ func TestListProducts(t *testing.T) {
 		Description:  "test description",
 		Rating:       5,
 		NumReviews:   100,
-		Price:        99.99,
+		Price:        money.New(9999, "USD"),
 		CountInStock: 10,
 	}
 
@@ -156,12 +189,54 @@ func TestListProducts(t *testing.T) {
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				rows := sqlmock.NewRows([]string{"id", "name", "image", "category", "description", "rating", "num_reviews", "price", "count_in_stock", "created_at", "updated_at"}).
-					AddRow(1, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, p.Price, p.CountInStock, p.CreatedAt, p.UpdatedAt)
-				mock.ExpectQuery("SELECT * FROM products").WillReturnRows(rows)
+					AddRow(1, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, p.Price.String(), p.CountInStock, p.CreatedAt, p.UpdatedAt)
+				mock.ExpectQuery("SELECT * FROM products ORDER BY id ASC LIMIT ?").WithArgs(DefaultPageLimit + 1).WillReturnRows(rows)
 
-				products, err := st.ListProducts(context.Background())
+				products, next, err := st.ListProducts(context.Background(), ProductFilter{})
 				require.NoError(t, err)
 				require.Len(t, products, 1)
+				require.Nil(t, next)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "filtered page after cursor",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "name", "price"}).
+					AddRow(3, "c", "20.00").
+					AddRow(2, "b", "10.00").
+					AddRow(1, "a", "10.00")
+				mock.ExpectQuery("SELECT * FROM products WHERE category=? AND price<=? AND count_in_stock>0 AND (price<? OR (price=? AND id<?)) ORDER BY price DESC, id DESC LIMIT ?").
+					WithArgs("test category", "50.00", "30.00", "30.00", 4, 3).
+					WillReturnRows(rows)
+
+				maxPrice := money.New(5000, "USD")
+				products, next, err := st.ListProducts(context.Background(), ProductFilter{
+					Category: "test category",
+					MaxPrice: &maxPrice,
+					InStock:  true,
+					Page: Page{
+						Limit:  2,
+						Sort:   "price",
+						Desc:   true,
+						Cursor: &Cursor{Sort: "price", Desc: true, Value: 3000.0, ID: 4},
+					},
+				})
+				require.NoError(t, err)
+				require.Len(t, products, 2)
+				require.Equal(t, &Cursor{Sort: "price", Desc: true, Value: 1000.0, ID: 2}, next)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "unknown sort field",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				_, _, err := st.ListProducts(context.Background(), ProductFilter{Page: Page{Sort: "description"}})
+				require.ErrorIs(t, err, ErrValidation)
 
 				err = mock.ExpectationsWereMet()
 				require.NoError(t, err)
@@ -170,9 +245,9 @@ func TestListProducts(t *testing.T) {
 		{
 			name: "failed querying products",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT * FROM products").WillReturnError(fmt.Errorf("error querying products"))
+				mock.ExpectQuery("SELECT * FROM products ORDER BY id ASC LIMIT ?").WithArgs(DefaultPageLimit + 1).WillReturnError(fmt.Errorf("error querying products"))
 
-				_, err := st.ListProducts(context.Background())
+				_, _, err := st.ListProducts(context.Background(), ProductFilter{})
 				require.Error(t, err)
 
 				err = mock.ExpectationsWereMet()
@@ -198,7 +273,7 @@ func TestUpdateProduct(t *testing.T) {
 		Description:  "test description",
 		Rating:       5,
 		NumReviews:   100,
-		Price:        99.99,
+		Price:        money.New(9999, "USD"),
 		CountInStock: 10,
 	}
 
@@ -210,7 +285,7 @@ func TestUpdateProduct(t *testing.T) {
 		Description:  "test description",
 		Rating:       5,
 		NumReviews:   100,
-		Price:        99.99,
+		Price:        money.New(9999, "USD"),
 		CountInStock: 10,
 	}
 
@@ -221,13 +296,16 @@ func TestUpdateProduct(t *testing.T) {
 		{
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").
+				mock.ExpectExec("INSERT INTO products ( name, image, category, description, rating, num_reviews, price, currency, count_in_stock, is_active ) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ?, ? )").
 					WillReturnResult(sqlmock.NewResult(1, 1))
+				rows := sqlmock.NewRows([]string{"id", "name", "image", "category", "description", "rating", "num_reviews", "price", "count_in_stock", "created_at", "updated_at"}).
+					AddRow(1, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, p.Price.String(), p.CountInStock, p.CreatedAt, p.UpdatedAt)
+				mock.ExpectQuery("SELECT * FROM products WHERE id=?").WithArgs(1).WillReturnRows(rows)
 				cp, err := st.CreateProduct(context.Background(), p)
 				require.NoError(t, err)
 				require.Equal(t, int64(1), cp.ID)
 
-				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, rating=?, num_reviews=?, price=?, count_in_stock=? WHERE id=?").
+				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, rating=?, num_reviews=?, price=?, currency=?, is_active=? WHERE id=?").
 					WillReturnResult(sqlmock.NewResult(1, 1))
 				up, err := st.UpdateProduct(context.Background(), np)
 				require.NoError(t, err)
@@ -241,7 +319,7 @@ func TestUpdateProduct(t *testing.T) {
 		{
 			name: "failed updating product",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, rating=?, num_reviews=?, price=?, count_in_stock=? WHERE id=?").
+				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, rating=?, num_reviews=?, price=?, currency=?, is_active=? WHERE id=?").
 					WillReturnError(fmt.Errorf("error updating product"))
 				_, err := st.UpdateProduct(context.Background(), p)
 				require.Error(t, err)
@@ -260,6 +338,43 @@ func TestUpdateProduct(t *testing.T) {
 	}
 }
 
+func TestSetProductStock(t *testing.T) {
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE products SET count_in_stock=? WHERE id=?").WithArgs(42, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				err := st.SetProductStock(context.Background(), 1, 42)
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed setting stock",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE products SET count_in_stock=? WHERE id=?").WithArgs(42, 1).WillReturnError(fmt.Errorf("error setting stock"))
+				err := st.SetProductStock(context.Background(), 1, 42)
+				require.Error(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
 func TestDeleteProduct(t *testing.T) {
 	tcs := []struct {
 		name string
@@ -303,23 +418,24 @@ func TestGetOrder(t *testing.T) {
 			Name:      "test product",
 			Quantity:  1,
 			Image:     "test.jpg",
-			Price:     99.99,
+			Price:     money.New(9999, "USD"),
 			ProductID: 1,
 		},
 		{
 			Name:      "test product 2",
 			Quantity:  2,
 			Image:     "test2.jpg",
-			Price:     199.99,
+			Price:     money.New(19999, "USD"),
 			ProductID: 2,
 		},
 	}
 
 	o := &Order{
 		PaymentMethod: "test payment method",
-		TaxPrice:      10.0,
-		ShippingPrice: 20.0,
-		TotalPrice:    129.99,
+		Currency:      "USD",
+		TaxPrice:      money.New(1000, "USD"),
+		ShippingPrice: money.New(2000, "USD"),
+		TotalPrice:    money.New(12999, "USD"),
 		Items:         ois,
 	}
 
@@ -330,14 +446,14 @@ func TestGetOrder(t *testing.T) {
 		{
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				orows := sqlmock.NewRows([]string{"id", "payment_method", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
-					AddRow(1, o.PaymentMethod, o.TaxPrice, o.ShippingPrice, o.TotalPrice, o.CreatedAt, o.UpdatedAt)
+				orows := sqlmock.NewRows([]string{"id", "payment_method", "currency", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
+					AddRow(1, o.PaymentMethod, o.Currency, o.TaxPrice.String(), o.ShippingPrice.String(), o.TotalPrice.String(), o.CreatedAt, o.UpdatedAt)
 
 				mock.ExpectQuery("SELECT * FROM orders WHERE id=?").WithArgs(1).WillReturnRows(orows)
 
 				oirows := sqlmock.NewRows([]string{"id", "name", "quantity", "image", "price", "product_id", "order_id"}).
-					AddRow(1, ois[0].Name, ois[0].Quantity, ois[0].Image, ois[0].Price, ois[0].ProductID, 1).
-					AddRow(2, ois[1].Name, ois[1].Quantity, ois[1].Image, ois[1].Price, ois[1].ProductID, 1)
+					AddRow(1, ois[0].Name, ois[0].Quantity, ois[0].Image, ois[0].Price.String(), ois[0].ProductID, 1).
+					AddRow(2, ois[1].Name, ois[1].Quantity, ois[1].Image, ois[1].Price.String(), ois[1].ProductID, 1)
 
 				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id=?").WithArgs(1).WillReturnRows(oirows)
 
@@ -372,8 +488,8 @@ func TestGetOrder(t *testing.T) {
 		{
 			name: "failed getting order items",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				orows := sqlmock.NewRows([]string{"id", "payment_method", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
-					AddRow(1, o.PaymentMethod, o.TaxPrice, o.ShippingPrice, o.TotalPrice, o.CreatedAt, o.UpdatedAt)
+				orows := sqlmock.NewRows([]string{"id", "payment_method", "currency", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
+					AddRow(1, o.PaymentMethod, o.Currency, o.TaxPrice.String(), o.ShippingPrice.String(), o.TotalPrice.String(), o.CreatedAt, o.UpdatedAt)
 
 				mock.ExpectQuery("SELECT * FROM orders WHERE id=?").WithArgs(1).WillReturnRows(orows)
 
@@ -390,7 +506,9 @@ func TestGetOrder(t *testing.T) {
 			name: "failed committing transaction",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				expectReserveStock(mock, "SELECT count_in_stock FROM products WHERE id=? FOR UPDATE", "UPDATE products SET count_in_stock=count_in_stock-? WHERE id=?")
+				mock.ExpectExec("INSERT INTO orders ( status, payment_method, currency, items_price, tax_price, shipping_price, discount_price, total_price, user_id ) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ? )").WillReturnResult(sqlmock.NewResult(1, 1))
+				expectOrderCreatedAt(mock)
 				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(2, 1))
 				mock.ExpectCommit().WillReturnError(fmt.Errorf("error committing transaction"))
@@ -418,23 +536,24 @@ func TestListOrders(t *testing.T) {
 			Name:      "test product",
 			Quantity:  1,
 			Image:     "test.jpg",
-			Price:     99.99,
+			Price:     money.New(9999, "USD"),
 			ProductID: 1,
 		},
 		{
 			Name:      "test product 2",
 			Quantity:  2,
 			Image:     "test2.jpg",
-			Price:     199.99,
+			Price:     money.New(19999, "USD"),
 			ProductID: 2,
 		},
 	}
 
 	o := &Order{
 		PaymentMethod: "test payment method",
-		TaxPrice:      10.0,
-		ShippingPrice: 20.0,
-		TotalPrice:    129.99,
+		Currency:      "USD",
+		TaxPrice:      money.New(1000, "USD"),
+		ShippingPrice: money.New(2000, "USD"),
+		TotalPrice:    money.New(12999, "USD"),
 		Items:         ois,
 	}
 
@@ -445,20 +564,26 @@ func TestListOrders(t *testing.T) {
 		{
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				orows := sqlmock.NewRows([]string{"id", "payment_method", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
-					AddRow(1, o.PaymentMethod, o.TaxPrice, o.ShippingPrice, o.TotalPrice, o.CreatedAt, o.UpdatedAt)
+				orows := sqlmock.NewRows([]string{"id", "payment_method", "currency", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
+					AddRow(1, o.PaymentMethod, o.Currency, o.TaxPrice.String(), o.ShippingPrice.String(), o.TotalPrice.String(), o.CreatedAt, o.UpdatedAt).
+					AddRow(2, o.PaymentMethod, o.Currency, o.TaxPrice.String(), o.ShippingPrice.String(), o.TotalPrice.String(), o.CreatedAt, o.UpdatedAt)
 
-				mock.ExpectQuery("SELECT * FROM orders").WillReturnRows(orows)
+				mock.ExpectQuery("SELECT * FROM orders ORDER BY id ASC LIMIT ?").WithArgs(DefaultPageLimit + 1).WillReturnRows(orows)
 
 				oirows := sqlmock.NewRows([]string{"id", "name", "quantity", "image", "price", "product_id", "order_id"}).
-					AddRow(1, ois[0].Name, ois[0].Quantity, ois[0].Image, ois[0].Price, ois[0].ProductID, 1).
-					AddRow(2, ois[1].Name, ois[1].Quantity, ois[1].Image, ois[1].Price, ois[1].ProductID, 1)
+					AddRow(1, ois[0].Name, ois[0].Quantity, ois[0].Image, ois[0].Price.String(), ois[0].ProductID, 1).
+					AddRow(2, ois[1].Name, ois[1].Quantity, ois[1].Image, ois[1].Price.String(), ois[1].ProductID, 1).
+					AddRow(3, ois[0].Name, ois[0].Quantity, ois[0].Image, ois[0].Price.String(), ois[0].ProductID, 2)
 
-				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id=?").WithArgs(1).WillReturnRows(oirows)
+				// the items of all orders are loaded with one query
+				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id IN (?, ?) ORDER BY id").WithArgs(1, 2).WillReturnRows(oirows)
 
-				mo, err := st.ListOrders(context.Background())
+				mo, _, err := st.ListOrders(context.Background(), OrderFilter{})
 				require.NoError(t, err)
-				require.Len(t, mo, 1)
+				require.Len(t, mo, 2)
+				require.Len(t, mo[0].Items, 2)
+				require.Len(t, mo[1].Items, 1)
+				require.Equal(t, int64(2), mo[1].Items[0].OrderID)
 
 				err = mock.ExpectationsWereMet()
 				require.NoError(t, err)
@@ -467,9 +592,9 @@ func TestListOrders(t *testing.T) {
 		{
 			name: "failed querying orders",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT * FROM orders").WillReturnError(fmt.Errorf("error querying orders"))
+				mock.ExpectQuery("SELECT * FROM orders ORDER BY id ASC LIMIT ?").WithArgs(DefaultPageLimit + 1).WillReturnError(fmt.Errorf("error querying orders"))
 
-				_, err := st.ListOrders(context.Background())
+				_, _, err := st.ListOrders(context.Background(), OrderFilter{})
 				require.Error(t, err)
 
 				err = mock.ExpectationsWereMet()
@@ -479,14 +604,14 @@ func TestListOrders(t *testing.T) {
 		{
 			name: "failed querying order items",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				orows := sqlmock.NewRows([]string{"id", "payment_method", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
-					AddRow(1, o.PaymentMethod, o.TaxPrice, o.ShippingPrice, o.TotalPrice, o.CreatedAt, o.UpdatedAt)
+				orows := sqlmock.NewRows([]string{"id", "payment_method", "currency", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
+					AddRow(1, o.PaymentMethod, o.Currency, o.TaxPrice.String(), o.ShippingPrice.String(), o.TotalPrice.String(), o.CreatedAt, o.UpdatedAt)
 
-				mock.ExpectQuery("SELECT * FROM orders").WillReturnRows(orows)
+				mock.ExpectQuery("SELECT * FROM orders ORDER BY id ASC LIMIT ?").WithArgs(DefaultPageLimit + 1).WillReturnRows(orows)
 
-				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id=?").WithArgs(1).WillReturnError(fmt.Errorf("error querying order items"))
+				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id IN (?) ORDER BY id").WithArgs(1).WillReturnError(fmt.Errorf("error querying order items"))
 
-				_, err := st.ListOrders(context.Background())
+				_, _, err := st.ListOrders(context.Background(), OrderFilter{})
 				require.Error(t, err)
 
 				err = mock.ExpectationsWereMet()
@@ -503,6 +628,57 @@ func TestListOrders(t *testing.T) {
 	}
 }
 
+// BenchmarkListOrders lists pages of growing size and reports the queries
+// run per listing, which stays at two: one for the orders, one for all their
+// items.
+func BenchmarkListOrders(b *testing.B) {
+	for _, n := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("%d orders", n), func(b *testing.B) {
+			var queries int
+			matcher := sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+				queries++
+				return sqlmock.QueryMatcherEqual.Match(expectedSQL, actualSQL)
+			})
+			mockDB, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(matcher))
+			require.NoError(b, err)
+			defer mockDB.Close()
+			st := NewMySQLStorer(sqlx.NewDb(mockDB, "sqlmock"))
+
+			ids := make([]string, n)
+			for i := range ids {
+				ids[i] = "?"
+			}
+			itemsQuery := fmt.Sprintf("SELECT * FROM order_items WHERE order_id IN (%s) ORDER BY id", strings.Join(ids, ", "))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				orows := sqlmock.NewRows([]string{"id", "payment_method"})
+				oirows := sqlmock.NewRows([]string{"id", "name", "quantity", "product_id", "order_id"})
+				for id := 1; id <= n; id++ {
+					orows.AddRow(id, "card")
+					oirows.AddRow(2*id-1, "test product", 1, 1, id).AddRow(2*id, "test product 2", 2, 2, id)
+				}
+				mock.ExpectQuery("SELECT * FROM orders ORDER BY id ASC LIMIT ?").WillReturnRows(orows)
+				mock.ExpectQuery(itemsQuery).WillReturnRows(oirows)
+				b.StartTimer()
+
+				orders, _, err := st.ListOrders(context.Background(), OrderFilter{Page: Page{Limit: n}})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(orders) != n {
+					b.Fatalf("got %d orders, want %d", len(orders), n)
+				}
+			}
+			b.StopTimer()
+
+			require.NoError(b, mock.ExpectationsWereMet())
+			b.ReportMetric(float64(queries)/float64(b.N), "queries/op")
+		})
+	}
+}
+
 func TestDeleteOrder(t *testing.T) {
 	tcs := []struct {
 		name string
@@ -512,6 +688,32 @@ func TestDeleteOrder(t *testing.T) {
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
+				expectOrderStatus(mock, OrderStatusPending)
+				expectOrderRecords(mock, 0, 0)
+				expectRestoreStock(mock)
+				expectReleasePromotions(mock)
+				mock.ExpectExec("DELETE FROM order_discounts WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("DELETE FROM order_status_history WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("DELETE FROM order_items WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("DELETE FROM orders WHERE id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+
+				err := st.DeleteOrder(context.Background(), 1)
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "shipped order keeps its stock",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectOrderStatus(mock, OrderStatusShipped)
+				expectOrderRecords(mock, 0, 0)
+				expectReleasePromotions(mock)
+				mock.ExpectExec("DELETE FROM order_discounts WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("DELETE FROM order_status_history WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
 				mock.ExpectExec("DELETE FROM order_items WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectExec("DELETE FROM orders WHERE id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit()
@@ -523,10 +725,60 @@ func TestDeleteOrder(t *testing.T) {
 				require.NoError(t, err)
 			},
 		},
+		{
+			name: "missing order",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT status FROM orders WHERE id=? FOR UPDATE").WithArgs(1).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+
+				err := st.DeleteOrder(context.Background(), 1)
+				require.ErrorIs(t, err, ErrNotFound)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "paid order",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectOrderStatus(mock, OrderStatusPaid)
+				expectOrderRecords(mock, 1)
+				mock.ExpectRollback()
+
+				err := st.DeleteOrder(context.Background(), 1)
+				require.ErrorIs(t, err, ErrConflict)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "refunded order",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectOrderStatus(mock, OrderStatusCancelled)
+				expectOrderRecords(mock, 0, 1)
+				mock.ExpectRollback()
+
+				err := st.DeleteOrder(context.Background(), 1)
+				require.ErrorIs(t, err, ErrConflict)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
 		{
 			name: "failed deleting order item",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
+				expectOrderStatus(mock, OrderStatusPending)
+				expectOrderRecords(mock, 0, 0)
+				expectRestoreStock(mock)
+				expectReleasePromotions(mock)
+				mock.ExpectExec("DELETE FROM order_discounts WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("DELETE FROM order_status_history WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
 				mock.ExpectExec("DELETE FROM order_items WHERE order_id=?").WithArgs(1).WillReturnError(fmt.Errorf("error deleting order item"))
 				mock.ExpectRollback()
 
@@ -541,6 +793,12 @@ func TestDeleteOrder(t *testing.T) {
 			name: "failed deleting order",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
+				expectOrderStatus(mock, OrderStatusPending)
+				expectOrderRecords(mock, 0, 0)
+				expectRestoreStock(mock)
+				expectReleasePromotions(mock)
+				mock.ExpectExec("DELETE FROM order_discounts WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("DELETE FROM order_status_history WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
 				mock.ExpectExec("DELETE FROM order_items WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectExec("DELETE FROM orders WHERE id=?").WithArgs(1).WillReturnError(fmt.Errorf("error deleting order"))
 				mock.ExpectRollback()
@@ -561,3 +819,790 @@ func TestDeleteOrder(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateOrderStock(t *testing.T) {
+	newOrder := func() *Order {
+		return &Order{
+			PaymentMethod: "card",
+			UserID:        1,
+			Items: []OrderItem{
+				{Name: "test product 2", Quantity: 2, ProductID: 2},
+				{Name: "test product", Quantity: 1, ProductID: 1},
+			},
+		}
+	}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "locks products in id order",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectReserveStock(mock, "SELECT count_in_stock FROM products WHERE id=? FOR UPDATE", "UPDATE products SET count_in_stock=count_in_stock-? WHERE id=?")
+				mock.ExpectExec("INSERT INTO orders ( status, payment_method, currency, items_price, tax_price, shipping_price, discount_price, total_price, user_id ) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ? )").WillReturnResult(sqlmock.NewResult(1, 1))
+				expectOrderCreatedAt(mock)
+				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(2, 1))
+				mock.ExpectCommit()
+
+				o, err := st.CreateOrder(context.Background(), newOrder())
+				require.NoError(t, err)
+				require.Equal(t, int64(1), o.ID)
+				require.False(t, o.CreatedAt.IsZero())
+				require.Equal(t, int64(1), o.Items[0].ID)
+				require.Equal(t, int64(2), o.Items[1].ID)
+				require.Equal(t, int64(1), o.Items[1].OrderID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "insufficient stock",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT count_in_stock FROM products WHERE id=? FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count_in_stock"}).AddRow(0))
+				mock.ExpectQuery("SELECT count_in_stock FROM products WHERE id=? FOR UPDATE").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"count_in_stock"}).AddRow(1))
+				mock.ExpectRollback()
+
+				_, err := st.CreateOrder(context.Background(), newOrder())
+				require.ErrorIs(t, err, ErrConflict)
+
+				var stockErr *InsufficientStockError
+				require.ErrorAs(t, err, &stockErr)
+				require.Equal(t, []StockShortfall{
+					{ProductID: 1, Requested: 1, Available: 0},
+					{ProductID: 2, Requested: 2, Available: 1},
+				}, stockErr.Shortfalls)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewMySQLStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
+
+func TestCreateOrderDiscount(t *testing.T) {
+	const claim = "UPDATE promotions SET uses=uses+1 WHERE id=? AND (max_uses IS NULL OR uses<max_uses) AND (max_uses_per_user IS NULL OR max_uses_per_user>(SELECT COUNT(*) FROM order_discounts d JOIN orders o ON o.id=d.order_id WHERE d.promotion_id=? AND o.user_id=? AND o.status<>?))"
+
+	newOrder := func() *Order {
+		return &Order{
+			PaymentMethod: "card",
+			UserID:        1,
+			Items: []OrderItem{
+				{Name: "test product 2", Quantity: 2, ProductID: 2},
+				{Name: "test product", Quantity: 1, ProductID: 1},
+			},
+			DiscountPrice: money.New(500, "USD"),
+			Discounts:     []OrderDiscount{{PromotionID: 7, Code: "SAVE5", Amount: money.New(500, "USD")}},
+		}
+	}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "claims a use and records the discount",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectReserveStock(mock, "SELECT count_in_stock FROM products WHERE id=? FOR UPDATE", "UPDATE products SET count_in_stock=count_in_stock-? WHERE id=?")
+				mock.ExpectExec("INSERT INTO orders ( status, payment_method, currency, items_price, tax_price, shipping_price, discount_price, total_price, user_id ) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ? )").WillReturnResult(sqlmock.NewResult(1, 1))
+				expectOrderCreatedAt(mock)
+				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(2, 1))
+				mock.ExpectExec(claim).WithArgs(7, 7, 1, OrderStatusCancelled).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO order_discounts (order_id, promotion_id, code, description, amount) VALUES (?, ?, ?, ?, ?)").
+					WithArgs(1, 7, "SAVE5", "", money.New(500, "USD")).WillReturnResult(sqlmock.NewResult(3, 1))
+				mock.ExpectCommit()
+
+				o, err := st.CreateOrder(context.Background(), newOrder())
+				require.NoError(t, err)
+				require.Equal(t, int64(3), o.Discounts[0].ID)
+				require.Equal(t, int64(1), o.Discounts[0].OrderID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "usage limit reached",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectReserveStock(mock, "SELECT count_in_stock FROM products WHERE id=? FOR UPDATE", "UPDATE products SET count_in_stock=count_in_stock-? WHERE id=?")
+				mock.ExpectExec("INSERT INTO orders ( status, payment_method, currency, items_price, tax_price, shipping_price, discount_price, total_price, user_id ) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ? )").WillReturnResult(sqlmock.NewResult(1, 1))
+				expectOrderCreatedAt(mock)
+				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(2, 1))
+				mock.ExpectExec(claim).WithArgs(7, 7, 1, OrderStatusCancelled).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+
+				_, err := st.CreateOrder(context.Background(), newOrder())
+				require.ErrorIs(t, err, ErrConflict)
+
+				var conflict *ConflictError
+				require.ErrorAs(t, err, &conflict)
+				require.Equal(t, "coupon SAVE5 has reached its usage limit", conflict.Reason)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewMySQLStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
+
+// expectReserveStock expects the stock of an order for one of product 1 and
+// two of product 2 to be locked and decremented.
+func expectReserveStock(mock sqlmock.Sqlmock, lockQuery, updateQuery string) {
+	mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count_in_stock"}).AddRow(10))
+	mock.ExpectQuery(lockQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"count_in_stock"}).AddRow(10))
+	mock.ExpectExec(updateQuery).WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(updateQuery).WithArgs(2, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectOrderCreatedAt expects the creation time of order 1 to be read back
+// after it is inserted.
+func expectOrderCreatedAt(mock sqlmock.Sqlmock) {
+	rows := sqlmock.NewRows([]string{"created_at"}).AddRow(time.Date(2024, 12, 1, 10, 0, 0, 0, time.UTC))
+	mock.ExpectQuery("SELECT created_at FROM orders WHERE id=?").WithArgs(1).WillReturnRows(rows)
+}
+
+// expectRestoreStock expects order 1, holding two of product 1, to be put
+// back into stock.
+func expectRestoreStock(mock sqlmock.Sqlmock) {
+	rows := sqlmock.NewRows([]string{"id", "name", "quantity", "image", "price", "product_id", "order_id"}).
+		AddRow(1, "test product", 2, "test.jpg", 10.0, 1, 1)
+	mock.ExpectQuery("SELECT * FROM order_items WHERE order_id=?").WithArgs(1).WillReturnRows(rows)
+	mock.ExpectExec("UPDATE products SET count_in_stock=count_in_stock+? WHERE id=?").WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectReleasePromotions expects the promotions used by order 1 to be given
+// their uses back.
+func expectReleasePromotions(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("UPDATE promotions SET uses=uses-1 WHERE id IN (SELECT promotion_id FROM order_discounts WHERE order_id=?)").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// expectOrderStatus expects the status of order 1 to be locked and read.
+func expectOrderStatus(mock sqlmock.Sqlmock, status OrderStatus) {
+	rows := sqlmock.NewRows([]string{"status"}).AddRow(status)
+	mock.ExpectQuery("SELECT status FROM orders WHERE id=? FOR UPDATE").WithArgs(1).WillReturnRows(rows)
+}
+
+// expectOrderRecords expects deleteOrder to count the payments and then the
+// refunds of order 1, stopping at the first count that is not zero.
+func expectOrderRecords(mock sqlmock.Sqlmock, counts ...int64) {
+	for i, table := range []string{"payments", "refunds"}[:len(counts)] {
+		rows := sqlmock.NewRows([]string{"count"}).AddRow(counts[i])
+		mock.ExpectQuery("SELECT COUNT(*) FROM " + table + " WHERE order_id=?").WithArgs(1).WillReturnRows(rows)
+	}
+}
+
+func TestCreatePayment(t *testing.T) {
+	p := &Payment{OrderID: 1, Provider: "fake", Operation: "authorize", Status: PaymentSucceeded, Amount: money.New(1000, "USD"), Currency: "USD", Reference: "fake_1"}
+	query := "INSERT INTO payments (order_id, provider, operation, status, amount, currency, reference, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec(query).WithArgs(p.OrderID, p.Provider, p.Operation, p.Status, p.Amount, p.Currency, p.Reference, p.Error).WillReturnResult(sqlmock.NewResult(1, 1))
+				cp, err := st.CreatePayment(context.Background(), p)
+				require.NoError(t, err)
+				require.Equal(t, int64(1), cp.ID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "order does not exist",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec(query).WithArgs(p.OrderID, p.Provider, p.Operation, p.Status, p.Amount, p.Currency, p.Reference, p.Error).WillReturnError(&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"})
+				_, err := st.CreatePayment(context.Background(), p)
+				require.ErrorIs(t, err, ErrConstraint)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "cancel",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("UPDATE orders SET status=?, updated_at=CURRENT_TIMESTAMP WHERE id=? AND status=?").WithArgs(OrderStatusCancelled, 1, OrderStatusPending).WillReturnResult(sqlmock.NewResult(0, 1))
+				expectRestoreStock(mock)
+				expectReleasePromotions(mock)
+				mock.ExpectExec("INSERT INTO order_status_history (order_id, from_status, to_status, actor) VALUES (?, ?, ?, ?)").WithArgs(1, OrderStatusPending, OrderStatusCancelled, "admin@example.com").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+				mock.ExpectQuery("SELECT * FROM orders WHERE id=?").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "status", "payment_method"}).AddRow(1, OrderStatusCancelled, "card"))
+				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id=?").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "order_id"}))
+
+				o, err := st.UpdateOrderStatus(context.Background(), 1, OrderStatusPending, OrderStatusCancelled, "admin@example.com")
+				require.NoError(t, err)
+				require.Equal(t, OrderStatusCancelled, o.Status)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "status changed concurrently",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("UPDATE orders SET status=?, updated_at=CURRENT_TIMESTAMP WHERE id=? AND status=?").WithArgs(OrderStatusPaid, 1, OrderStatusPending).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+
+				_, err := st.UpdateOrderStatus(context.Background(), 1, OrderStatusPending, OrderStatusPaid, "admin@example.com")
+				require.ErrorIs(t, err, ErrConflict)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestProcessWebhookEvent(t *testing.T) {
+	markProcessed := "UPDATE webhook_events SET status=?, error='', attempts=attempts+1, processed_at=CURRENT_TIMESTAMP WHERE id=? AND status<>?"
+	insertPayment := "INSERT INTO payments (order_id, provider, operation, status, amount, currency, reference, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	p := &Payment{OrderID: 1, Provider: "fake", Operation: "capture", Status: PaymentSucceeded, Amount: money.New(1000, "USD"), Currency: "USD", Reference: "fake_1"}
+	change := &PaymentChange{OrderID: 1, Payment: p, From: OrderStatusPending, To: OrderStatusPaid, Actor: "webhook:fake"}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(markProcessed).WithArgs(WebhookEventProcessed, 1, WebhookEventProcessed).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(insertPayment).WithArgs(p.OrderID, p.Provider, p.Operation, p.Status, p.Amount, p.Currency, p.Reference, p.Error).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("UPDATE orders SET status=?, updated_at=CURRENT_TIMESTAMP WHERE id=? AND status=?").WithArgs(OrderStatusPaid, 1, OrderStatusPending).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO order_status_history (order_id, from_status, to_status, actor) VALUES (?, ?, ?, ?)").WithArgs(1, OrderStatusPending, OrderStatusPaid, "webhook:fake").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+
+				err := st.ProcessWebhookEvent(context.Background(), 1, change)
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "already processed",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(markProcessed).WithArgs(WebhookEventProcessed, 1, WebhookEventProcessed).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+
+				err := st.ProcessWebhookEvent(context.Background(), 1, change)
+				require.ErrorIs(t, err, ErrConflict)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "refund",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				r := &Refund{OrderID: 1, Status: RefundSucceeded, Amount: money.New(400, "USD"), ShippingAmount: money.New(0, "USD"), Currency: "USD", Reason: RefundOther, Reference: "fake_2", Actor: "webhook:fake"}
+				mock.ExpectBegin()
+				mock.ExpectExec(markProcessed).WithArgs(WebhookEventProcessed, 1, WebhookEventProcessed).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT currency, total_price, refunded_price FROM orders WHERE id=? FOR UPDATE").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"currency", "total_price", "refunded_price"}).AddRow("USD", "10.00", "0.00"))
+				mock.ExpectExec("UPDATE orders SET refunded_price=?, updated_at=CURRENT_TIMESTAMP WHERE id=?").WithArgs(money.New(400, "USD"), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO refunds (order_id, status, amount, shipping_amount, currency, reason, note, restock, reference, actor) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)").WithArgs(1, RefundSucceeded, r.Amount, r.ShippingAmount, "USD", RefundOther, "", false, "fake_2", "webhook:fake").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+
+				err := st.ProcessWebhookEvent(context.Background(), 1, &PaymentChange{OrderID: 1, Refund: r, Actor: "webhook:fake"})
+				require.NoError(t, err)
+				require.Equal(t, int64(1), r.ID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "order status changed concurrently",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(markProcessed).WithArgs(WebhookEventProcessed, 1, WebhookEventProcessed).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(insertPayment).WithArgs(p.OrderID, p.Provider, p.Operation, p.Status, p.Amount, p.Currency, p.Reference, p.Error).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("UPDATE orders SET status=?, updated_at=CURRENT_TIMESTAMP WHERE id=? AND status=?").WithArgs(OrderStatusPaid, 1, OrderStatusPending).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+
+				err := st.ProcessWebhookEvent(context.Background(), 1, change)
+				require.ErrorIs(t, err, ErrConflict)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestCreateRefund(t *testing.T) {
+	selectOrder := "SELECT currency, total_price, refunded_price FROM orders WHERE id=? FOR UPDATE"
+	selectItem := "SELECT * FROM order_items WHERE id=? AND order_id=?"
+	selectRefunded := "SELECT COALESCE(SUM(ri.quantity), 0) FROM refund_items ri JOIN refunds r ON r.id=ri.refund_id WHERE ri.order_item_id=? AND r.status<>?"
+	newRefund := func() *Refund {
+		return &Refund{
+			OrderID:  1,
+			Amount:   money.New(2000, "USD"),
+			Currency: "USD",
+			Reason:   RefundDamaged,
+			Restock:  true,
+			Actor:    "admin",
+			Items:    []RefundItem{{OrderItemID: 1, Quantity: 2, Amount: money.New(2000, "USD")}},
+		}
+	}
+	orderRows := func(refunded string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"currency", "total_price", "refunded_price"}).AddRow("USD", "35.00", refunded)
+	}
+	itemRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "name", "quantity", "image", "price", "product_id", "order_id"}).AddRow(1, "test product", 3, "test.jpg", "10.00", 1, 1)
+	}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				r := newRefund()
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectOrder).WithArgs(1).WillReturnRows(orderRows("0.00"))
+				mock.ExpectQuery(selectItem).WithArgs(1, 1).WillReturnRows(itemRows())
+				mock.ExpectQuery(selectRefunded).WithArgs(1, RefundFailed).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+				mock.ExpectExec("UPDATE orders SET refunded_price=?, updated_at=CURRENT_TIMESTAMP WHERE id=?").WithArgs(money.New(2000, "USD"), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE products SET count_in_stock=count_in_stock+? WHERE id=?").WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO refunds (order_id, status, amount, shipping_amount, currency, reason, note, restock, reference, actor) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)").WithArgs(1, RefundSucceeded, r.Amount, r.ShippingAmount, "USD", RefundDamaged, "", true, "", "admin").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO refund_items (refund_id, order_item_id, quantity, amount) VALUES (?, ?, ?, ?)").WithArgs(1, 1, 2, money.New(2000, "USD")).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+
+				cr, err := st.CreateRefund(context.Background(), r)
+				require.NoError(t, err)
+				require.Equal(t, int64(1), cr.ID)
+				require.Equal(t, int64(1), cr.Items[0].RefundID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "pending refunds restock once completed",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				r := newRefund()
+				r.Status = RefundPending
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectOrder).WithArgs(1).WillReturnRows(orderRows("0.00"))
+				mock.ExpectQuery(selectItem).WithArgs(1, 1).WillReturnRows(itemRows())
+				mock.ExpectQuery(selectRefunded).WithArgs(1, RefundFailed).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+				mock.ExpectExec("UPDATE orders SET refunded_price=?, updated_at=CURRENT_TIMESTAMP WHERE id=?").WithArgs(money.New(2000, "USD"), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO refunds (order_id, status, amount, shipping_amount, currency, reason, note, restock, reference, actor) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)").WithArgs(1, RefundPending, r.Amount, r.ShippingAmount, "USD", RefundDamaged, "", true, "", "admin").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO refund_items (refund_id, order_item_id, quantity, amount) VALUES (?, ?, ?, ?)").WithArgs(1, 1, 2, money.New(2000, "USD")).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+
+				_, err := st.CreateRefund(context.Background(), r)
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "exceeds the total price",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectOrder).WithArgs(1).WillReturnRows(orderRows("20.00"))
+				mock.ExpectRollback()
+
+				_, err := st.CreateRefund(context.Background(), newRefund())
+				require.ErrorIs(t, err, ErrConflict)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "units already refunded",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectOrder).WithArgs(1).WillReturnRows(orderRows("0.00"))
+				mock.ExpectQuery(selectItem).WithArgs(1, 1).WillReturnRows(itemRows())
+				mock.ExpectQuery(selectRefunded).WithArgs(1, RefundFailed).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(2))
+				mock.ExpectRollback()
+
+				_, err := st.CreateRefund(context.Background(), newRefund())
+				require.ErrorIs(t, err, ErrConflict)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "item of another order",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectOrder).WithArgs(1).WillReturnRows(orderRows("0.00"))
+				mock.ExpectQuery(selectItem).WithArgs(1, 1).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+
+				_, err := st.CreateRefund(context.Background(), newRefund())
+				require.ErrorIs(t, err, ErrValidation)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestSettleRefund(t *testing.T) {
+	settle := "UPDATE refunds SET status=?, reference=? WHERE id=? AND status=?"
+	refundRows := func(status RefundStatus) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "order_id", "status", "amount", "shipping_amount", "currency", "reason", "note", "restock", "reference", "actor", "created_at"}).
+			AddRow(1, 1, status, "20.00", "0.00", "USD", RefundDamaged, "", true, "ref_1", "admin", time.Now())
+	}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "complete",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(settle).WithArgs(RefundSucceeded, "ref_1", 1, RefundPending).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT * FROM refunds WHERE id=?").WithArgs(1).WillReturnRows(refundRows(RefundSucceeded))
+				mock.ExpectQuery("SELECT * FROM refund_items WHERE refund_id=? ORDER BY id").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "refund_id", "order_item_id", "quantity", "amount"}).AddRow(1, 1, 1, 2, "20.00"))
+				mock.ExpectQuery("SELECT oi.product_id, ri.quantity FROM refund_items ri JOIN order_items oi ON oi.id=ri.order_item_id WHERE ri.refund_id=?").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"product_id", "quantity"}).AddRow(1, 2))
+				mock.ExpectExec("UPDATE products SET count_in_stock=count_in_stock+? WHERE id=?").WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				r, err := st.CompleteRefund(context.Background(), 1, "ref_1")
+				require.NoError(t, err)
+				require.Equal(t, RefundSucceeded, r.Status)
+				require.Equal(t, money.New(2000, "USD"), r.Amount)
+				require.Equal(t, money.New(2000, "USD"), r.Items[0].Amount)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "complete a failed refund",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(settle).WithArgs(RefundSucceeded, "ref_1", 1, RefundPending).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT status FROM refunds WHERE id=?").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(RefundFailed))
+				mock.ExpectRollback()
+
+				_, err := st.CompleteRefund(context.Background(), 1, "ref_1")
+				require.ErrorIs(t, err, ErrConflict)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "fail",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(settle).WithArgs(RefundFailed, "", 1, RefundPending).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT order_id, amount, currency FROM refunds WHERE id=?").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"order_id", "amount", "currency"}).AddRow(1, "20.00", "USD"))
+				mock.ExpectExec("UPDATE orders SET refunded_price=refunded_price-?, updated_at=CURRENT_TIMESTAMP WHERE id=?").WithArgs(money.New(2000, "USD"), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				err := st.FailRefund(context.Background(), 1)
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "fail an unknown refund",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(settle).WithArgs(RefundFailed, "", 1, RefundPending).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT status FROM refunds WHERE id=?").WithArgs(1).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+
+				err := st.FailRefund(context.Background(), 1)
+				require.ErrorIs(t, err, ErrNotFound)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestRotateSession(t *testing.T) {
+	ns := &Session{
+		ID:           "new-session",
+		FamilyID:     "old-session",
+		UserEmail:    "test@example.com",
+		RefreshToken: "refresh token",
+		ExpiresAt:    time.Now().Add(time.Hour),
+	}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("UPDATE sessions SET replaced_by=? WHERE id=? AND replaced_by IS NULL AND is_revoked=false").WithArgs(ns.ID, "old-session").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO sessions (id, family_id, user_email, refresh_token, is_revoked, expires_at) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				rs, err := st.RotateSession(context.Background(), "old-session", ns)
+				require.NoError(t, err)
+				require.Equal(t, ns.ID, rs.ID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "session already rotated",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("UPDATE sessions SET replaced_by=? WHERE id=? AND replaced_by IS NULL AND is_revoked=false").WithArgs(ns.ID, "old-session").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+
+				_, err := st.RotateSession(context.Background(), "old-session", ns)
+				require.ErrorIs(t, err, ErrSessionRotated)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed inserting session",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("UPDATE sessions SET replaced_by=? WHERE id=? AND replaced_by IS NULL AND is_revoked=false").WithArgs(ns.ID, "old-session").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO sessions (id, family_id, user_email, refresh_token, is_revoked, expires_at) VALUES (?, ?, ?, ?, ?, ?)").WillReturnError(fmt.Errorf("error inserting session"))
+				mock.ExpectRollback()
+
+				_, err := st.RotateSession(context.Background(), "old-session", ns)
+				require.Error(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestRevokeSessionFamily(t *testing.T) {
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE sessions SET is_revoked=true WHERE family_id=?").WithArgs("family").WillReturnResult(sqlmock.NewResult(0, 3))
+				err := st.RevokeSessionFamily(context.Background(), "family")
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed revoking session family",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE sessions SET is_revoked=true WHERE family_id=?").WithArgs("family").WillReturnError(fmt.Errorf("error revoking session family"))
+				err := st.RevokeSessionFamily(context.Background(), "family")
+				require.Error(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestMergeCarts(t *testing.T) {
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM cart_items WHERE cart_id=? ORDER BY id").WithArgs("anon").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "cart_id", "product_id", "quantity", "created_at"}).
+						AddRow(1, "anon", 1, 2, time.Now()).
+						AddRow(2, "anon", 2, 1, time.Now()))
+				mock.ExpectExec(mysqlAddCartItem).WithArgs("user", 1, 2).WillReturnResult(sqlmock.NewResult(3, 1))
+				mock.ExpectExec(mysqlAddCartItem).WithArgs("user", 2, 1).WillReturnResult(sqlmock.NewResult(4, 2))
+				mock.ExpectExec("DELETE FROM cart_items WHERE cart_id=?").WithArgs("anon").WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectExec("DELETE FROM carts WHERE id=?").WithArgs("anon").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				err := st.MergeCarts(context.Background(), "anon", "user")
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed adding item rolls back",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM cart_items WHERE cart_id=? ORDER BY id").WithArgs("anon").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "cart_id", "product_id", "quantity", "created_at"}).
+						AddRow(1, "anon", 1, 2, time.Now()))
+				mock.ExpectExec(mysqlAddCartItem).WithArgs("user", 1, 2).WillReturnError(fmt.Errorf("error adding cart item"))
+				mock.ExpectRollback()
+
+				err := st.MergeCarts(context.Background(), "anon", "user")
+				require.Error(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestUpdateCartItem(t *testing.T) {
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE cart_items SET quantity=? WHERE cart_id=? AND product_id=?").WithArgs(3, "cart", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				err := st.UpdateCartItem(context.Background(), "cart", 1, 3)
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "item not in cart",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE cart_items SET quantity=? WHERE cart_id=? AND product_id=?").WithArgs(3, "cart", 1).WillReturnResult(sqlmock.NewResult(0, 0))
+				err := st.UpdateCartItem(context.Background(), "cart", 1, 3)
+				require.ErrorIs(t, err, ErrNotFound)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
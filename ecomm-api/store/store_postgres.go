@@ -0,0 +1,15 @@
+package storer
+
+import "github.com/jmoiron/sqlx"
+
+// PostgresStorer is the PostgreSQL counterpart of MySQLStorer.
+type PostgresStorer struct {
+	*sqlStorer
+}
+
+func NewPostgresStorer(db *sqlx.DB) *PostgresStorer {
+	return &PostgresStorer{&sqlStorer{
+		db:        db,
+		forUpdate: " FOR UPDATE",
+	}}
+}
@@ -0,0 +1,166 @@
+package storer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func withPostgresTestDB(t *testing.T, fn func(*sqlx.DB, sqlmock.Sqlmock)) {
+	mockDB, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer mockDB.Close()
+
+	// the driver name selects the $n bind type used by Rebind
+	db := sqlx.NewDb(mockDB, "postgres")
+	fn(db, mock)
+}
+
+func TestPostgresCreateProduct(t *testing.T) {
+	p := &Product{
+		Name:         "test product",
+		Image:        "test.jpg",
+		Category:     "test category",
+		Description:  "test description",
+		Rating:       5,
+		NumReviews:   10,
+		Price:        money.New(10000, "USD"),
+		CountInStock: 100,
+	}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *PostgresStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *PostgresStorer, mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "name", "image", "category", "description", "rating", "num_reviews", "price", "count_in_stock", "created_at", "updated_at"}).
+					AddRow(1, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, p.Price.String(), p.CountInStock, time.Now(), nil)
+				mock.ExpectQuery("INSERT INTO products (name, image, category, description, rating, num_reviews, price, currency, count_in_stock, is_active) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING *").WillReturnRows(rows)
+
+				cp, err := st.CreateProduct(context.Background(), p)
+				require.NoError(t, err)
+				require.Equal(t, int64(1), cp.ID)
+				require.False(t, cp.CreatedAt.IsZero())
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed inserting product",
+			test: func(t *testing.T, st *PostgresStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("INSERT INTO products (name, image, category, description, rating, num_reviews, price, currency, count_in_stock, is_active) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING *").WillReturnError(fmt.Errorf("error inserting product"))
+
+				_, err := st.CreateProduct(context.Background(), p)
+				require.Error(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withPostgresTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewPostgresStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
+
+func TestPostgresCreateOrder(t *testing.T) {
+	newOrder := func() *Order {
+		return &Order{
+			PaymentMethod: "test payment method",
+			TaxPrice:      money.New(1000, "USD"),
+			ShippingPrice: money.New(2000, "USD"),
+			TotalPrice:    money.New(12999, "USD"),
+			UserID:        1,
+			Items: []OrderItem{
+				{Name: "test product", Quantity: 1, Image: "test.jpg", Price: money.New(9999, "USD"), ProductID: 1},
+				{Name: "test product 2", Quantity: 2, Image: "test2.jpg", Price: money.New(19999, "USD"), ProductID: 2},
+			},
+		}
+	}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *PostgresStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *PostgresStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectReserveStock(mock, "SELECT count_in_stock FROM products WHERE id=$1 FOR UPDATE", "UPDATE products SET count_in_stock=count_in_stock-$1 WHERE id=$2")
+				mock.ExpectQuery("INSERT INTO orders (status, payment_method, currency, items_price, tax_price, shipping_price, discount_price, total_price, user_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+				mock.ExpectQuery("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectQuery("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mock.ExpectCommit()
+
+				o, err := st.CreateOrder(context.Background(), newOrder())
+				require.NoError(t, err)
+				require.Equal(t, int64(1), o.ID)
+				require.Equal(t, int64(1), o.Items[0].OrderID)
+				require.Equal(t, int64(2), o.Items[1].ID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed inserting order item",
+			test: func(t *testing.T, st *PostgresStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectReserveStock(mock, "SELECT count_in_stock FROM products WHERE id=$1 FOR UPDATE", "UPDATE products SET count_in_stock=count_in_stock-$1 WHERE id=$2")
+				mock.ExpectQuery("INSERT INTO orders (status, payment_method, currency, items_price, tax_price, shipping_price, discount_price, total_price, user_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+				mock.ExpectQuery("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id").
+					WillReturnError(fmt.Errorf("error inserting order item"))
+				mock.ExpectRollback()
+
+				_, err := st.CreateOrder(context.Background(), newOrder())
+				require.Error(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withPostgresTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewPostgresStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
+
+// TestPostgresStorer runs the behavioral suite against the PostgreSQL database
+// of ECOMM_TEST_POSTGRES_DSN, if set, which it migrates up first.
+func TestPostgresStorer(t *testing.T) {
+	dsn := os.Getenv("ECOMM_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("ECOMM_TEST_POSTGRES_DSN is not set")
+	}
+
+	testStorer(t, NewPostgresStorer(openTestDB(t, "postgres", dsn)))
+}
@@ -0,0 +1,641 @@
+package storer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// sqlStorer implements Storer for databases supporting INSERT ... RETURNING,
+// which is used to read back generated columns instead of LastInsertId.
+// Queries are written with ? placeholders and rebound for the driver.
+type sqlStorer struct {
+	db *sqlx.DB
+
+	// forUpdate is the clause locking the rows read by a SELECT until the
+	// transaction ends, or empty if the database has no row locks.
+	forUpdate string
+}
+
+// sqlAddCartItem inserts a cart item or adds to the quantity of the one
+// already in the cart.
+const sqlAddCartItem = "INSERT INTO cart_items (cart_id, product_id, quantity) VALUES (?, ?, ?) ON CONFLICT (cart_id, product_id) DO UPDATE SET quantity=cart_items.quantity+excluded.quantity"
+
+func (ss *sqlStorer) CreateProduct(ctx context.Context, p *Product) (*Product, error) {
+	var cp Product
+	err := namedGetContext(ctx, ss.db, &cp, "INSERT INTO products (name, image, category, description, rating, num_reviews, price, currency, count_in_stock, is_active) VALUES (:name, :image, :category, :description, :rating, :num_reviews, :price, :currency, :count_in_stock, :is_active) RETURNING *", p)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting product: %w", dbError(ctx, err))
+	}
+	cp.setCurrency()
+
+	return &cp, nil
+}
+
+func (ss *sqlStorer) GetProduct(ctx context.Context, id int64) (*Product, error) {
+	var p Product
+	err := ss.db.GetContext(ctx, &p, ss.db.Rebind("SELECT * FROM products WHERE id=?"), id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting product: %w", dbError(ctx, err))
+	}
+	p.setCurrency()
+
+	return &p, nil
+}
+
+func (ss *sqlStorer) ListProducts(ctx context.Context, f ProductFilter) ([]Product, *Cursor, error) {
+	query, args, err := productFilterQuery(f).build("products", f.Page, productSortKeys)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing products: %w", err)
+	}
+
+	var products []Product
+	err = ss.db.SelectContext(ctx, &products, ss.db.Rebind(query), args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing products: %w", dbError(ctx, err))
+	}
+
+	products, next := pageOf(products, f.Page, productSortKeys, productSortValue)
+	for i := range products {
+		products[i].setCurrency()
+	}
+	return products, next, nil
+}
+
+func (ss *sqlStorer) UpdateProduct(ctx context.Context, p *Product) (*Product, error) {
+	_, err := ss.db.NamedExecContext(ctx, "UPDATE products SET name=:name, image=:image, category=:category, description=:description, rating=:rating, num_reviews=:num_reviews, price=:price, currency=:currency, is_active=:is_active, updated_at=:updated_at WHERE id=:id", p)
+	if err != nil {
+		return nil, fmt.Errorf("error updating product: %w", dbError(ctx, err))
+	}
+
+	return p, nil
+}
+
+func (ss *sqlStorer) SetProductStock(ctx context.Context, id, count int64) error {
+	return setProductStock(ctx, ss.db, id, count)
+}
+
+func (ss *sqlStorer) DeleteProduct(ctx context.Context, id int64) error {
+	_, err := ss.db.ExecContext(ctx, ss.db.Rebind("DELETE FROM products WHERE id=?"), id)
+	if err != nil {
+		return fmt.Errorf("error deleting product: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func (ss *sqlStorer) CreateOrder(ctx context.Context, o *Order) (*Order, error) {
+	if o.Status == "" {
+		o.Status = OrderStatusPending
+	}
+
+	err := execTx(ctx, ss.db, func(tx *sqlx.Tx) error {
+		err := reserveStock(ctx, tx, o.Items, ss.forUpdate)
+		if err != nil {
+			return err
+		}
+
+		err = namedGetContext(ctx, tx, o, "INSERT INTO orders (status, payment_method, currency, items_price, tax_price, shipping_price, discount_price, total_price, user_id) VALUES (:status, :payment_method, :currency, :items_price, :tax_price, :shipping_price, :discount_price, :total_price, :user_id) RETURNING id, created_at", o)
+		if err != nil {
+			return fmt.Errorf("error inserting order: %w", dbError(ctx, err))
+		}
+
+		for i := range o.Items {
+			o.Items[i].OrderID = o.ID
+			err = namedGetContext(ctx, tx, &o.Items[i].ID, "INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (:name, :quantity, :image, :price, :product_id, :order_id) RETURNING id", o.Items[i])
+			if err != nil {
+				return fmt.Errorf("error inserting order item: %w", dbError(ctx, err))
+			}
+		}
+
+		for i := range o.Discounts {
+			if err := claimPromotion(ctx, tx, o.Discounts[i], o.UserID); err != nil {
+				return err
+			}
+
+			o.Discounts[i].OrderID = o.ID
+			err = namedGetContext(ctx, tx, &o.Discounts[i].ID, "INSERT INTO order_discounts (order_id, promotion_id, code, description, amount) VALUES (:order_id, :promotion_id, :code, :description, :amount) RETURNING id", o.Discounts[i])
+			if err != nil {
+				return fmt.Errorf("error inserting order discount: %w", dbError(ctx, err))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating order: %w", dbError(ctx, err))
+	}
+
+	return o, nil
+}
+
+func (ss *sqlStorer) GetOrder(ctx context.Context, id int64) (*Order, error) {
+	var o Order
+	err := ss.db.GetContext(ctx, &o, ss.db.Rebind("SELECT * FROM orders WHERE id=?"), id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting order: %w", dbError(ctx, err))
+	}
+
+	var items []OrderItem
+	err = ss.db.SelectContext(ctx, &items, ss.db.Rebind("SELECT * FROM order_items WHERE order_id=? ORDER BY id"), id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting order items: %w", dbError(ctx, err))
+	}
+	o.Items = items
+
+	if !o.DiscountPrice.IsZero() {
+		err = ss.db.SelectContext(ctx, &o.Discounts, ss.db.Rebind("SELECT * FROM order_discounts WHERE order_id=? ORDER BY id"), id)
+		if err != nil {
+			return nil, fmt.Errorf("error getting order discounts: %w", dbError(ctx, err))
+		}
+	}
+
+	orders := []Order{o}
+	if err := attachOrderRefunds(ctx, ss.db, orders); err != nil {
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+	o = orders[0]
+	o.setCurrency()
+
+	return &o, nil
+}
+
+func (ss *sqlStorer) ListOrders(ctx context.Context, f OrderFilter) ([]Order, *Cursor, error) {
+	query, args, err := orderFilterQuery(f).build("orders", f.Page, orderSortKeys)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing orders: %w", err)
+	}
+
+	var orders []Order
+	err = ss.db.SelectContext(ctx, &orders, ss.db.Rebind(query), args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing orders: %w", dbError(ctx, err))
+	}
+	orders, next := pageOf(orders, f.Page, orderSortKeys, orderSortValue)
+
+	if err := attachOrderItems(ctx, ss.db, orders); err != nil {
+		return nil, nil, fmt.Errorf("error listing orders: %w", err)
+	}
+	if err := attachOrderDiscounts(ctx, ss.db, orders); err != nil {
+		return nil, nil, fmt.Errorf("error listing orders: %w", err)
+	}
+	if err := attachOrderRefunds(ctx, ss.db, orders); err != nil {
+		return nil, nil, fmt.Errorf("error listing orders: %w", err)
+	}
+	for i := range orders {
+		orders[i].setCurrency()
+	}
+
+	return orders, next, nil
+}
+
+func (ss *sqlStorer) UpdateOrderStatus(ctx context.Context, id int64, from, to OrderStatus, actor string) (*Order, error) {
+	err := execTx(ctx, ss.db, func(tx *sqlx.Tx) error {
+		return updateOrderStatus(ctx, tx, id, from, to, actor)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating order status: %w", dbError(ctx, err))
+	}
+
+	return ss.GetOrder(ctx, id)
+}
+
+func (ss *sqlStorer) ListOrderStatusHistory(ctx context.Context, orderID int64) ([]OrderStatusChange, error) {
+	var changes []OrderStatusChange
+	err := ss.db.SelectContext(ctx, &changes, ss.db.Rebind("SELECT * FROM order_status_history WHERE order_id=? ORDER BY id"), orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing order status history: %w", dbError(ctx, err))
+	}
+
+	return changes, nil
+}
+
+func (ss *sqlStorer) DeleteOrder(ctx context.Context, id int64) error {
+	err := execTx(ctx, ss.db, func(tx *sqlx.Tx) error {
+		return deleteOrder(ctx, tx, id, ss.forUpdate)
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting order: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func (ss *sqlStorer) CreatePromotion(ctx context.Context, p *Promotion) (*Promotion, error) {
+	var cp Promotion
+	err := namedGetContext(ctx, ss.db, &cp, "INSERT INTO promotions (code, description, kind, percent_off, amount_off, currency, buy_quantity, get_quantity, category, min_subtotal, max_uses, max_uses_per_user, stackable, is_active, starts_at, ends_at) VALUES (:code, :description, :kind, :percent_off, :amount_off, :currency, :buy_quantity, :get_quantity, :category, :min_subtotal, :max_uses, :max_uses_per_user, :stackable, :is_active, :starts_at, :ends_at) RETURNING *", p)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting promotion: %w", dbError(ctx, err))
+	}
+	cp.setCurrency()
+
+	return &cp, nil
+}
+
+func (ss *sqlStorer) GetPromotion(ctx context.Context, id int64) (*Promotion, error) {
+	var p Promotion
+	err := ss.db.GetContext(ctx, &p, ss.db.Rebind("SELECT * FROM promotions WHERE id=?"), id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting promotion: %w", dbError(ctx, err))
+	}
+	p.setCurrency()
+
+	return &p, nil
+}
+
+func (ss *sqlStorer) GetPromotionByCode(ctx context.Context, code string) (*Promotion, error) {
+	var p Promotion
+	err := ss.db.GetContext(ctx, &p, ss.db.Rebind("SELECT * FROM promotions WHERE code=?"), code)
+	if err != nil {
+		return nil, fmt.Errorf("error getting promotion: %w", dbError(ctx, err))
+	}
+	p.setCurrency()
+
+	return &p, nil
+}
+
+func (ss *sqlStorer) ListPromotions(ctx context.Context) ([]Promotion, error) {
+	var promotions []Promotion
+	err := ss.db.SelectContext(ctx, &promotions, "SELECT * FROM promotions ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing promotions: %w", dbError(ctx, err))
+	}
+	for i := range promotions {
+		promotions[i].setCurrency()
+	}
+
+	return promotions, nil
+}
+
+// UpdatePromotion updates the rules of the promotion. Its uses are only ever
+// counted by CreateOrder and left alone.
+func (ss *sqlStorer) UpdatePromotion(ctx context.Context, p *Promotion) (*Promotion, error) {
+	_, err := ss.db.NamedExecContext(ctx, "UPDATE promotions SET code=:code, description=:description, kind=:kind, percent_off=:percent_off, amount_off=:amount_off, currency=:currency, buy_quantity=:buy_quantity, get_quantity=:get_quantity, category=:category, min_subtotal=:min_subtotal, max_uses=:max_uses, max_uses_per_user=:max_uses_per_user, stackable=:stackable, is_active=:is_active, starts_at=:starts_at, ends_at=:ends_at WHERE id=:id", p)
+	if err != nil {
+		return nil, fmt.Errorf("error updating promotion: %w", dbError(ctx, err))
+	}
+
+	return ss.GetPromotion(ctx, p.ID)
+}
+
+func (ss *sqlStorer) CreatePayment(ctx context.Context, p *Payment) (*Payment, error) {
+	var cp Payment
+	err := namedGetContext(ctx, ss.db, &cp, "INSERT INTO payments (order_id, provider, operation, status, amount, currency, reference, error) VALUES (:order_id, :provider, :operation, :status, :amount, :currency, :reference, :error) RETURNING *", p)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting payment: %w", dbError(ctx, err))
+	}
+	cp.setCurrency()
+
+	return &cp, nil
+}
+
+// ListPayments returns the payments of the order, oldest first.
+func (ss *sqlStorer) ListPayments(ctx context.Context, orderID int64) ([]Payment, error) {
+	var payments []Payment
+	err := ss.db.SelectContext(ctx, &payments, ss.db.Rebind("SELECT * FROM payments WHERE order_id=? ORDER BY id"), orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing payments: %w", dbError(ctx, err))
+	}
+	for i := range payments {
+		payments[i].setCurrency()
+	}
+
+	return payments, nil
+}
+
+// GetPaymentByReference returns the first payment recorded with the reference
+// of provider, i.e. the authorization that named it.
+func (ss *sqlStorer) GetPaymentByReference(ctx context.Context, provider, reference string) (*Payment, error) {
+	var p Payment
+	err := ss.db.GetContext(ctx, &p, ss.db.Rebind("SELECT * FROM payments WHERE provider=? AND reference=? ORDER BY id LIMIT 1"), provider, reference)
+	if err != nil {
+		return nil, fmt.Errorf("error getting payment: %w", dbError(ctx, err))
+	}
+	p.setCurrency()
+
+	return &p, nil
+}
+
+// CreateWebhookEvent stores a webhook event. An event already stored for the
+// provider is rejected with ErrConflict.
+func (ss *sqlStorer) CreateWebhookEvent(ctx context.Context, e *WebhookEvent) (*WebhookEvent, error) {
+	var ce WebhookEvent
+	err := namedGetContext(ctx, ss.db, &ce, "INSERT INTO webhook_events (provider, event_id, type, payload, status) VALUES (:provider, :event_id, :type, :payload, :status) RETURNING *", e)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting webhook event: %w", dbError(ctx, err))
+	}
+
+	return &ce, nil
+}
+
+func (ss *sqlStorer) GetWebhookEvent(ctx context.Context, provider, eventID string) (*WebhookEvent, error) {
+	var e WebhookEvent
+	err := ss.db.GetContext(ctx, &e, ss.db.Rebind("SELECT * FROM webhook_events WHERE provider=? AND event_id=?"), provider, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting webhook event: %w", dbError(ctx, err))
+	}
+
+	return &e, nil
+}
+
+// ListWebhookEvents returns the events of provider in the given status,
+// oldest first.
+func (ss *sqlStorer) ListWebhookEvents(ctx context.Context, provider string, status WebhookEventStatus) ([]WebhookEvent, error) {
+	var events []WebhookEvent
+	err := ss.db.SelectContext(ctx, &events, ss.db.Rebind("SELECT * FROM webhook_events WHERE provider=? AND status=? ORDER BY id"), provider, status)
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhook events: %w", dbError(ctx, err))
+	}
+
+	return events, nil
+}
+
+func (ss *sqlStorer) ProcessWebhookEvent(ctx context.Context, id int64, change *PaymentChange) error {
+	err := execTx(ctx, ss.db, func(tx *sqlx.Tx) error {
+		return processWebhookEvent(ctx, tx, id, change, ss.createRefund)
+	})
+	if err != nil {
+		return fmt.Errorf("error processing webhook event: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func (ss *sqlStorer) FailWebhookEvent(ctx context.Context, id int64, reason string) error {
+	_, err := ss.db.ExecContext(ctx, ss.db.Rebind("UPDATE webhook_events SET status=?, error=?, attempts=attempts+1 WHERE id=? AND status<>?"), WebhookEventFailed, reason, id, WebhookEventProcessed)
+	if err != nil {
+		return fmt.Errorf("error updating webhook event: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+// CreateRefund records refund r with its items, see applyRefund, in one
+// transaction.
+func (ss *sqlStorer) CreateRefund(ctx context.Context, r *Refund) (*Refund, error) {
+	err := execTx(ctx, ss.db, func(tx *sqlx.Tx) error {
+		return ss.createRefund(ctx, tx, r)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating refund: %w", dbError(ctx, err))
+	}
+
+	return r, nil
+}
+
+// createRefund records refund r with its items in transaction tx.
+func (ss *sqlStorer) createRefund(ctx context.Context, tx *sqlx.Tx, r *Refund) error {
+	if err := applyRefund(ctx, tx, r, ss.forUpdate); err != nil {
+		return err
+	}
+
+	err := namedGetContext(ctx, tx, r, "INSERT INTO refunds (order_id, status, amount, shipping_amount, currency, reason, note, restock, reference, actor) VALUES (:order_id, :status, :amount, :shipping_amount, :currency, :reason, :note, :restock, :reference, :actor) RETURNING id, created_at", r)
+	if err != nil {
+		return fmt.Errorf("error inserting refund: %w", dbError(ctx, err))
+	}
+
+	for i := range r.Items {
+		r.Items[i].RefundID = r.ID
+		err = namedGetContext(ctx, tx, &r.Items[i].ID, "INSERT INTO refund_items (refund_id, order_item_id, quantity, amount) VALUES (:refund_id, :order_item_id, :quantity, :amount) RETURNING id", r.Items[i])
+		if err != nil {
+			return fmt.Errorf("error inserting refund item: %w", dbError(ctx, err))
+		}
+	}
+
+	return nil
+}
+
+// CompleteRefund marks pending refund id succeeded, see completeRefund.
+func (ss *sqlStorer) CompleteRefund(ctx context.Context, id int64, reference string) (*Refund, error) {
+	var r *Refund
+	err := execTx(ctx, ss.db, func(tx *sqlx.Tx) error {
+		var err error
+		r, err = completeRefund(ctx, tx, id, reference)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error completing refund: %w", dbError(ctx, err))
+	}
+
+	return r, nil
+}
+
+// FailRefund marks pending refund id failed, see failRefund.
+func (ss *sqlStorer) FailRefund(ctx context.Context, id int64) error {
+	err := execTx(ctx, ss.db, func(tx *sqlx.Tx) error {
+		return failRefund(ctx, tx, id)
+	})
+	if err != nil {
+		return fmt.Errorf("error failing refund: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func (ss *sqlStorer) CreateUser(ctx context.Context, u *User) (*User, error) {
+	err := namedGetContext(ctx, ss.db, u, "INSERT INTO users (name, email, password, is_admin) VALUES (:name, :email, :password, :is_admin) RETURNING id, created_at", u)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting user: %w", dbError(ctx, err))
+	}
+
+	return u, nil
+}
+
+func (ss *sqlStorer) GetUser(ctx context.Context, email string) (*User, error) {
+	var u User
+	err := ss.db.GetContext(ctx, &u, ss.db.Rebind("SELECT * FROM users WHERE email=?"), email)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", dbError(ctx, err))
+	}
+
+	return &u, nil
+}
+
+func (ss *sqlStorer) ListUsers(ctx context.Context, f UserFilter) ([]User, *Cursor, error) {
+	query, args, err := (&listQuery{}).build("users", f.Page, userSortKeys)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing users: %w", err)
+	}
+
+	var users []User
+	err = ss.db.SelectContext(ctx, &users, ss.db.Rebind(query), args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing users: %w", dbError(ctx, err))
+	}
+
+	users, next := pageOf(users, f.Page, userSortKeys, userSortValue)
+	return users, next, nil
+}
+
+func (ss *sqlStorer) UpdateUser(ctx context.Context, u *User) (*User, error) {
+	_, err := ss.db.NamedExecContext(ctx, "UPDATE users SET name=:name, email=:email, password=:password, is_admin=:is_admin, updated_at=CURRENT_TIMESTAMP WHERE id=:id", u)
+	if err != nil {
+		return nil, fmt.Errorf("error updating user: %w", dbError(ctx, err))
+	}
+
+	return u, nil
+}
+
+func (ss *sqlStorer) DeleteUser(ctx context.Context, id int64) error {
+	_, err := ss.db.ExecContext(ctx, ss.db.Rebind("DELETE FROM users WHERE id=?"), id)
+	if err != nil {
+		return fmt.Errorf("error deleting user: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func (ss *sqlStorer) CreateCart(ctx context.Context, c *Cart) (*Cart, error) {
+	_, err := ss.db.NamedExecContext(ctx, "INSERT INTO carts (id, user_id) VALUES (:id, :user_id)", c)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting cart: %w", dbError(ctx, err))
+	}
+
+	return ss.GetCart(ctx, c.ID)
+}
+
+func (ss *sqlStorer) GetCart(ctx context.Context, id string) (*Cart, error) {
+	return getCart(ctx, ss.db, "SELECT * FROM carts WHERE id=?", id)
+}
+
+func (ss *sqlStorer) GetUserCart(ctx context.Context, userID int64) (*Cart, error) {
+	return getCart(ctx, ss.db, "SELECT * FROM carts WHERE user_id=?", userID)
+}
+
+// AddCartItem adds quantity of the product to the cart, on top of what the
+// cart already holds.
+func (ss *sqlStorer) AddCartItem(ctx context.Context, cartID string, productID, quantity int64) error {
+	_, err := ss.db.ExecContext(ctx, ss.db.Rebind(sqlAddCartItem), cartID, productID, quantity)
+	if err != nil {
+		return fmt.Errorf("error adding cart item: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func (ss *sqlStorer) UpdateCartItem(ctx context.Context, cartID string, productID, quantity int64) error {
+	return updateCartItem(ctx, ss.db, cartID, productID, quantity)
+}
+
+func (ss *sqlStorer) RemoveCartItem(ctx context.Context, cartID string, productID int64) error {
+	return removeCartItem(ctx, ss.db, cartID, productID)
+}
+
+func (ss *sqlStorer) ClearCart(ctx context.Context, cartID string) error {
+	return clearCart(ctx, ss.db, cartID)
+}
+
+// MergeCarts moves the items of cart fromID into cart toID, adding up the
+// quantities of products in both, and deletes fromID.
+func (ss *sqlStorer) MergeCarts(ctx context.Context, fromID, toID string) error {
+	err := execTx(ctx, ss.db, func(tx *sqlx.Tx) error {
+		return mergeCarts(ctx, tx, fromID, toID, sqlAddCartItem)
+	})
+	if err != nil {
+		return fmt.Errorf("error merging carts: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func (ss *sqlStorer) CreateSession(ctx context.Context, s *Session) (*Session, error) {
+	err := namedGetContext(ctx, ss.db, &s.CreatedAt, "INSERT INTO sessions (id, family_id, user_email, refresh_token, is_revoked, expires_at) VALUES (:id, :family_id, :user_email, :refresh_token, :is_revoked, :expires_at) RETURNING created_at", s)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting session: %w", dbError(ctx, err))
+	}
+
+	return s, nil
+}
+
+func (ss *sqlStorer) GetSession(ctx context.Context, id string) (*Session, error) {
+	var s Session
+	err := ss.db.GetContext(ctx, &s, ss.db.Rebind("SELECT * FROM sessions WHERE id=?"), id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting session: %w", dbError(ctx, err))
+	}
+
+	return &s, nil
+}
+
+func (ss *sqlStorer) RotateSession(ctx context.Context, oldID string, ns *Session) (*Session, error) {
+	err := execTx(ctx, ss.db, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, ss.db.Rebind("UPDATE sessions SET replaced_by=? WHERE id=? AND replaced_by IS NULL AND is_revoked=false"), ns.ID, oldID)
+		if err != nil {
+			return fmt.Errorf("error updating session: %w", dbError(ctx, err))
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error getting rows affected: %w", dbError(ctx, err))
+		}
+		if n == 0 {
+			return ErrSessionRotated
+		}
+
+		err = namedGetContext(ctx, tx, &ns.CreatedAt, "INSERT INTO sessions (id, family_id, user_email, refresh_token, is_revoked, expires_at) VALUES (:id, :family_id, :user_email, :refresh_token, :is_revoked, :expires_at) RETURNING created_at", ns)
+		if err != nil {
+			return fmt.Errorf("error inserting session: %w", dbError(ctx, err))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error rotating session: %w", dbError(ctx, err))
+	}
+
+	return ns, nil
+}
+
+func (ss *sqlStorer) RevokeSessionFamily(ctx context.Context, familyID string) error {
+	_, err := ss.db.ExecContext(ctx, ss.db.Rebind("UPDATE sessions SET is_revoked=true WHERE family_id=?"), familyID)
+	if err != nil {
+		return fmt.Errorf("error revoking session family: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func (ss *sqlStorer) RevokeToken(ctx context.Context, id string, expiresAt time.Time) error {
+	_, err := ss.db.ExecContext(ctx, ss.db.Rebind("INSERT INTO revoked_tokens (id, expires_at) VALUES (?, ?) ON CONFLICT (id) DO NOTHING"), id, expiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("error inserting revoked token: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func (ss *sqlStorer) IsTokenRevoked(ctx context.Context, id string) (bool, error) {
+	var n int
+	err := ss.db.GetContext(ctx, &n, ss.db.Rebind("SELECT COUNT(*) FROM revoked_tokens WHERE id=?"), id)
+	if err != nil {
+		return false, fmt.Errorf("error checking revoked token: %w", dbError(ctx, err))
+	}
+
+	return n > 0, nil
+}
+
+func (ss *sqlStorer) PurgeRevokedTokens(ctx context.Context, before time.Time) (int64, error) {
+	res, err := ss.db.ExecContext(ctx, ss.db.Rebind("DELETE FROM revoked_tokens WHERE expires_at<?"), before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("error purging revoked tokens: %w", dbError(ctx, err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", dbError(ctx, err))
+	}
+
+	return n, nil
+}
+
+// namedGetContext runs a named query that returns a single row, such as an
+// INSERT ... RETURNING, and scans it into dest.
+func namedGetContext(ctx context.Context, q sqlx.ExtContext, dest interface{}, query string, arg interface{}) error {
+	query, args, err := sqlx.Named(query, arg)
+	if err != nil {
+		return err
+	}
+
+	return sqlx.GetContext(ctx, q, dest, q.Rebind(query), args...)
+}
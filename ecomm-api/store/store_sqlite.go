@@ -0,0 +1,28 @@
+package storer
+
+import (
+	"github.com/jmoiron/sqlx"
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	// modernc.org/sqlite registers itself as "sqlite", which sqlx does not
+	// know about yet.
+	sqlx.BindDriver("sqlite", sqlx.QUESTION)
+}
+
+// SQLiteStorer stores data in an embedded SQLite database, which makes it
+// possible to run the API without any external services.
+type SQLiteStorer struct {
+	*sqlStorer
+}
+
+func NewSQLiteStorer(db *sqlx.DB) *SQLiteStorer {
+	// SQLite has no row locks: a write transaction locks the whole database
+	// and NewSQLiteDatabase allows a single connection, so orders are
+	// serialized anyway.
+	return &SQLiteStorer{&sqlStorer{
+		db:        db,
+		forUpdate: "",
+	}}
+}
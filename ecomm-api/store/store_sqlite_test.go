@@ -0,0 +1,18 @@
+package storer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gauss2302/ecomm-service/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteStorer(t *testing.T) {
+	database, err := db.NewSQLiteDatabase(":memory:")
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.MigrateUp(context.Background()))
+
+	testStorer(t, NewSQLiteStorer(database.GetDB()))
+}
@@ -0,0 +1,755 @@
+package storer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Storer is the persistence layer used by server.Server.
+type Storer interface {
+	CreateProduct(ctx context.Context, p *Product) (*Product, error)
+	GetProduct(ctx context.Context, id int64) (*Product, error)
+	ListProducts(ctx context.Context, f ProductFilter) ([]Product, *Cursor, error)
+	// UpdateProduct writes every field of p but its stock, which only orders
+	// and SetProductStock change, so that an edit does not undo the stock
+	// taken by an order in the meantime.
+	UpdateProduct(ctx context.Context, p *Product) (*Product, error)
+	SetProductStock(ctx context.Context, id, count int64) error
+	DeleteProduct(ctx context.Context, id int64) error
+
+	CreateOrder(ctx context.Context, o *Order) (*Order, error)
+	GetOrder(ctx context.Context, id int64) (*Order, error)
+	ListOrders(ctx context.Context, f OrderFilter) ([]Order, *Cursor, error)
+	DeleteOrder(ctx context.Context, id int64) error
+	UpdateOrderStatus(ctx context.Context, id int64, from, to OrderStatus, actor string) (*Order, error)
+	ListOrderStatusHistory(ctx context.Context, orderID int64) ([]OrderStatusChange, error)
+
+	CreatePromotion(ctx context.Context, p *Promotion) (*Promotion, error)
+	GetPromotion(ctx context.Context, id int64) (*Promotion, error)
+	GetPromotionByCode(ctx context.Context, code string) (*Promotion, error)
+	ListPromotions(ctx context.Context) ([]Promotion, error)
+	UpdatePromotion(ctx context.Context, p *Promotion) (*Promotion, error)
+
+	CreatePayment(ctx context.Context, p *Payment) (*Payment, error)
+	ListPayments(ctx context.Context, orderID int64) ([]Payment, error)
+	GetPaymentByReference(ctx context.Context, provider, reference string) (*Payment, error)
+
+	CreateWebhookEvent(ctx context.Context, e *WebhookEvent) (*WebhookEvent, error)
+	GetWebhookEvent(ctx context.Context, provider, eventID string) (*WebhookEvent, error)
+	ListWebhookEvents(ctx context.Context, provider string, status WebhookEventStatus) ([]WebhookEvent, error)
+	ProcessWebhookEvent(ctx context.Context, id int64, change *PaymentChange) error
+	FailWebhookEvent(ctx context.Context, id int64, reason string) error
+
+	// CreateRefund records refund r, see applyRefund. A pending refund is
+	// then either completed once the provider paid it back, or failed.
+	CreateRefund(ctx context.Context, r *Refund) (*Refund, error)
+	CompleteRefund(ctx context.Context, id int64, reference string) (*Refund, error)
+	FailRefund(ctx context.Context, id int64) error
+
+	CreateUser(ctx context.Context, u *User) (*User, error)
+	GetUser(ctx context.Context, email string) (*User, error)
+	ListUsers(ctx context.Context, f UserFilter) ([]User, *Cursor, error)
+	UpdateUser(ctx context.Context, u *User) (*User, error)
+	DeleteUser(ctx context.Context, id int64) error
+
+	CreateCart(ctx context.Context, c *Cart) (*Cart, error)
+	GetCart(ctx context.Context, id string) (*Cart, error)
+	GetUserCart(ctx context.Context, userID int64) (*Cart, error)
+	AddCartItem(ctx context.Context, cartID string, productID, quantity int64) error
+	UpdateCartItem(ctx context.Context, cartID string, productID, quantity int64) error
+	RemoveCartItem(ctx context.Context, cartID string, productID int64) error
+	ClearCart(ctx context.Context, cartID string) error
+	MergeCarts(ctx context.Context, fromID, toID string) error
+
+	CreateSession(ctx context.Context, s *Session) (*Session, error)
+	GetSession(ctx context.Context, id string) (*Session, error)
+	RotateSession(ctx context.Context, oldID string, ns *Session) (*Session, error)
+	RevokeSessionFamily(ctx context.Context, familyID string) error
+
+	RevokeToken(ctx context.Context, id string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, id string) (bool, error)
+	PurgeRevokedTokens(ctx context.Context, before time.Time) (int64, error)
+}
+
+var (
+	_ Storer = (*MySQLStorer)(nil)
+	_ Storer = (*MemoryStorer)(nil)
+	_ Storer = (*PostgresStorer)(nil)
+	_ Storer = (*SQLiteStorer)(nil)
+)
+
+// stockDemand adds up the quantities of items per product. The result is
+// sorted by product ID so that product rows are always locked in the same
+// order, which keeps concurrent orders from deadlocking. A quantity that is not
+// positive, or a sum that does not fit in an int64, is rejected with a
+// *ValidationError instead of wrapping around.
+func stockDemand(items []OrderItem) ([]OrderItem, error) {
+	quantities := make(map[int64]int64)
+	var demand []OrderItem
+	for _, oi := range items {
+		if oi.Quantity <= 0 {
+			return nil, &ValidationError{Reason: fmt.Sprintf("quantity of product %d must be positive", oi.ProductID)}
+		}
+		q, ok := quantities[oi.ProductID]
+		if !ok {
+			demand = append(demand, OrderItem{ProductID: oi.ProductID})
+		}
+		if oi.Quantity > math.MaxInt64-q {
+			return nil, &ValidationError{Reason: fmt.Sprintf("quantity of product %d is out of range", oi.ProductID)}
+		}
+		quantities[oi.ProductID] = q + oi.Quantity
+	}
+
+	sort.Slice(demand, func(i, j int) bool { return demand[i].ProductID < demand[j].ProductID })
+	for i := range demand {
+		demand[i].Quantity = quantities[demand[i].ProductID]
+	}
+
+	return demand, nil
+}
+
+// reserveStock locks the products of items and decrements their stock.
+// forUpdate is the locking clause appended to the SELECT of each product, or
+// empty if the database has no row locks. If any product is short, nothing is
+// decremented and an *InsufficientStockError listing every shortfall is
+// returned.
+func reserveStock(ctx context.Context, tx *sqlx.Tx, items []OrderItem, forUpdate string) error {
+	demand, err := stockDemand(items)
+	if err != nil {
+		return err
+	}
+
+	var shortfalls []StockShortfall
+	for _, d := range demand {
+		var available int64
+		err := tx.GetContext(ctx, &available, tx.Rebind("SELECT count_in_stock FROM products WHERE id=?"+forUpdate), d.ProductID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: product %d does not exist", ErrConstraint, d.ProductID)
+			}
+			return fmt.Errorf("error locking product: %w", dbError(ctx, err))
+		}
+
+		if available < d.Quantity {
+			shortfalls = append(shortfalls, StockShortfall{ProductID: d.ProductID, Requested: d.Quantity, Available: available})
+		}
+	}
+	if len(shortfalls) > 0 {
+		return &InsufficientStockError{Shortfalls: shortfalls}
+	}
+
+	for _, d := range demand {
+		_, err := tx.ExecContext(ctx, tx.Rebind("UPDATE products SET count_in_stock=count_in_stock-? WHERE id=?"), d.Quantity, d.ProductID)
+		if err != nil {
+			return fmt.Errorf("error decrementing stock: %w", dbError(ctx, err))
+		}
+	}
+
+	return nil
+}
+
+// restoreStock puts the quantities of items back into stock, updating the
+// product rows in the same order as reserveStock.
+func restoreStock(ctx context.Context, tx *sqlx.Tx, items []OrderItem) error {
+	demand, err := stockDemand(items)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range demand {
+		_, err := tx.ExecContext(ctx, tx.Rebind("UPDATE products SET count_in_stock=count_in_stock+? WHERE id=?"), d.Quantity, d.ProductID)
+		if err != nil {
+			return fmt.Errorf("error restoring stock: %w", dbError(ctx, err))
+		}
+	}
+
+	return nil
+}
+
+// setProductStock sets the stock of product id to count.
+func setProductStock(ctx context.Context, db *sqlx.DB, id, count int64) error {
+	_, err := db.ExecContext(ctx, db.Rebind("UPDATE products SET count_in_stock=? WHERE id=?"), count, id)
+	if err != nil {
+		return fmt.Errorf("error setting product stock: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+// restoreOrderStock puts the items of order id back into stock.
+func restoreOrderStock(ctx context.Context, tx *sqlx.Tx, id int64) error {
+	var items []OrderItem
+	err := tx.SelectContext(ctx, &items, tx.Rebind("SELECT * FROM order_items WHERE order_id=?"), id)
+	if err != nil {
+		return fmt.Errorf("error getting order items: %w", dbError(ctx, err))
+	}
+
+	return restoreStock(ctx, tx, items)
+}
+
+// updateOrderStatus moves order id from one status to another and records the
+// change. The update only applies if the order is still in the from status,
+// otherwise a *ConflictError is returned. Cancelling an order puts its items
+// back into stock and gives back the uses of its promotions.
+func updateOrderStatus(ctx context.Context, tx *sqlx.Tx, id int64, from, to OrderStatus, actor string) error {
+	res, err := tx.ExecContext(ctx, tx.Rebind("UPDATE orders SET status=?, updated_at=CURRENT_TIMESTAMP WHERE id=? AND status=?"), to, id, from)
+	if err != nil {
+		return fmt.Errorf("error updating order status: %w", dbError(ctx, err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", dbError(ctx, err))
+	}
+	if n == 0 {
+		return &ConflictError{Reason: fmt.Sprintf("order %d is no longer %s", id, from)}
+	}
+
+	if to == OrderStatusCancelled && from.HoldsStock() {
+		if err := restoreOrderStock(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	if to == OrderStatusCancelled {
+		if err := releasePromotions(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind("INSERT INTO order_status_history (order_id, from_status, to_status, actor) VALUES (?, ?, ?, ?)"), id, from, to, actor)
+	if err != nil {
+		return fmt.Errorf("error inserting order status change: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+// deleteOrder deletes order id with its items, discounts and status history,
+// putting the items back into stock unless the order already shipped or was
+// cancelled, and giving back the uses of its promotions unless it was
+// cancelled. The order row is read with the forUpdate locking clause so a
+// concurrent cancellation cannot restore the stock a second time. An order
+// with payments or refunds is kept as a record of them, with a ConflictError,
+// and a missing order is ErrNotFound.
+func deleteOrder(ctx context.Context, tx *sqlx.Tx, id int64, forUpdate string) error {
+	var status OrderStatus
+	err := tx.GetContext(ctx, &status, tx.Rebind("SELECT status FROM orders WHERE id=?"+forUpdate), id)
+	if err != nil {
+		return fmt.Errorf("error getting order status: %w", dbError(ctx, err))
+	}
+
+	for _, table := range []string{"payments", "refunds"} {
+		var n int64
+		err = tx.GetContext(ctx, &n, tx.Rebind("SELECT COUNT(*) FROM "+table+" WHERE order_id=?"), id)
+		if err != nil {
+			return fmt.Errorf("error counting order %s: %w", table, dbError(ctx, err))
+		}
+		if n > 0 {
+			return &ConflictError{Reason: fmt.Sprintf("order %d has %s", id, table)}
+		}
+	}
+
+	if status.HoldsStock() {
+		if err := restoreOrderStock(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+
+	if status != OrderStatusCancelled {
+		if err := releasePromotions(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind("DELETE FROM order_discounts WHERE order_id=?"), id)
+	if err != nil {
+		return fmt.Errorf("error deleting order discounts: %w", dbError(ctx, err))
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind("DELETE FROM order_status_history WHERE order_id=?"), id)
+	if err != nil {
+		return fmt.Errorf("error deleting order status history: %w", dbError(ctx, err))
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind("DELETE FROM order_items WHERE order_id=?"), id)
+	if err != nil {
+		return fmt.Errorf("error deleting order items: %w", dbError(ctx, err))
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind("DELETE FROM orders WHERE id=?"), id)
+	if err != nil {
+		return fmt.Errorf("error deleting order: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+// attachOrderItems loads the items of all orders with a single query and
+// assigns them to their orders, so listing a page of orders costs two queries
+// however many orders it holds.
+func attachOrderItems(ctx context.Context, db *sqlx.DB, orders []Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(orders))
+	index := make(map[int64]int, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ID
+		index[o.ID] = i
+	}
+
+	query, args, err := sqlx.In("SELECT * FROM order_items WHERE order_id IN (?) ORDER BY id", ids)
+	if err != nil {
+		return fmt.Errorf("error building order items query: %w", err)
+	}
+
+	var items []OrderItem
+	err = db.SelectContext(ctx, &items, db.Rebind(query), args...)
+	if err != nil {
+		return fmt.Errorf("error getting order items: %w", dbError(ctx, err))
+	}
+
+	for _, oi := range items {
+		o := &orders[index[oi.OrderID]]
+		o.Items = append(o.Items, oi)
+	}
+
+	return nil
+}
+
+// claimPromotion counts one more use of promotion promotionID by user userID.
+// The limits are checked by the UPDATE itself, so concurrent orders cannot
+// both take the last use; a *ConflictError is returned if none is left.
+// Orders that were cancelled do not count against the per user limit.
+func claimPromotion(ctx context.Context, tx *sqlx.Tx, d OrderDiscount, userID int64) error {
+	res, err := tx.ExecContext(ctx, tx.Rebind("UPDATE promotions SET uses=uses+1 WHERE id=? AND (max_uses IS NULL OR uses<max_uses) AND (max_uses_per_user IS NULL OR max_uses_per_user>(SELECT COUNT(*) FROM order_discounts d JOIN orders o ON o.id=d.order_id WHERE d.promotion_id=? AND o.user_id=? AND o.status<>?))"), d.PromotionID, d.PromotionID, userID, OrderStatusCancelled)
+	if err != nil {
+		return fmt.Errorf("error claiming promotion: %w", dbError(ctx, err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", dbError(ctx, err))
+	}
+	if n == 0 {
+		return &ConflictError{Reason: fmt.Sprintf("coupon %s has reached its usage limit", d.Code)}
+	}
+
+	return nil
+}
+
+// releasePromotions gives back the uses the discounts of order id took.
+func releasePromotions(ctx context.Context, tx *sqlx.Tx, id int64) error {
+	_, err := tx.ExecContext(ctx, tx.Rebind("UPDATE promotions SET uses=uses-1 WHERE id IN (SELECT promotion_id FROM order_discounts WHERE order_id=?)"), id)
+	if err != nil {
+		return fmt.Errorf("error releasing promotions: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+// attachOrderDiscounts loads the discounts of the orders that have any with a
+// single query, so orders without discounts cost nothing extra.
+func attachOrderDiscounts(ctx context.Context, db *sqlx.DB, orders []Order) error {
+	var ids []int64
+	index := make(map[int64]int, len(orders))
+	for i, o := range orders {
+		if !o.DiscountPrice.IsZero() {
+			ids = append(ids, o.ID)
+			index[o.ID] = i
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query, args, err := sqlx.In("SELECT * FROM order_discounts WHERE order_id IN (?) ORDER BY id", ids)
+	if err != nil {
+		return fmt.Errorf("error building order discounts query: %w", err)
+	}
+
+	var discounts []OrderDiscount
+	err = db.SelectContext(ctx, &discounts, db.Rebind(query), args...)
+	if err != nil {
+		return fmt.Errorf("error getting order discounts: %w", dbError(ctx, err))
+	}
+
+	for _, d := range discounts {
+		o := &orders[index[d.OrderID]]
+		o.Discounts = append(o.Discounts, d)
+	}
+
+	return nil
+}
+
+// attachOrderRefunds loads the refunds, with their items, of the orders that
+// have any, so orders never refunded cost nothing extra. Failed refunds gave
+// nothing back and are left out.
+func attachOrderRefunds(ctx context.Context, db *sqlx.DB, orders []Order) error {
+	var ids []int64
+	index := make(map[int64]int, len(orders))
+	for i, o := range orders {
+		if !o.RefundedPrice.IsZero() {
+			ids = append(ids, o.ID)
+			index[o.ID] = i
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query, args, err := sqlx.In("SELECT * FROM refunds WHERE order_id IN (?) AND status<>? ORDER BY id", ids, RefundFailed)
+	if err != nil {
+		return fmt.Errorf("error building refunds query: %w", err)
+	}
+
+	var refunds []Refund
+	err = db.SelectContext(ctx, &refunds, db.Rebind(query), args...)
+	if err != nil {
+		return fmt.Errorf("error getting refunds: %w", dbError(ctx, err))
+	}
+	if len(refunds) == 0 {
+		return nil
+	}
+
+	refundIDs := make([]int64, len(refunds))
+	refundIndex := make(map[int64]int, len(refunds))
+	for i, r := range refunds {
+		refundIDs[i] = r.ID
+		refundIndex[r.ID] = i
+	}
+
+	query, args, err = sqlx.In("SELECT * FROM refund_items WHERE refund_id IN (?) ORDER BY id", refundIDs)
+	if err != nil {
+		return fmt.Errorf("error building refund items query: %w", err)
+	}
+
+	var items []RefundItem
+	err = db.SelectContext(ctx, &items, db.Rebind(query), args...)
+	if err != nil {
+		return fmt.Errorf("error getting refund items: %w", dbError(ctx, err))
+	}
+
+	for _, ri := range items {
+		r := &refunds[refundIndex[ri.RefundID]]
+		r.Items = append(r.Items, ri)
+	}
+	for _, r := range refunds {
+		o := &orders[index[r.OrderID]]
+		o.Refunds = append(o.Refunds, r)
+	}
+
+	return nil
+}
+
+// getCart reads the cart selected by query, which takes arg, with its items.
+func getCart(ctx context.Context, db *sqlx.DB, query string, arg interface{}) (*Cart, error) {
+	var c Cart
+	err := db.GetContext(ctx, &c, db.Rebind(query), arg)
+	if err != nil {
+		return nil, fmt.Errorf("error getting cart: %w", dbError(ctx, err))
+	}
+
+	err = db.SelectContext(ctx, &c.Items, db.Rebind("SELECT * FROM cart_items WHERE cart_id=? ORDER BY id"), c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting cart items: %w", dbError(ctx, err))
+	}
+
+	return &c, nil
+}
+
+// updateCartItem sets the quantity of a product already in the cart.
+func updateCartItem(ctx context.Context, db *sqlx.DB, cartID string, productID, quantity int64) error {
+	res, err := db.ExecContext(ctx, db.Rebind("UPDATE cart_items SET quantity=? WHERE cart_id=? AND product_id=?"), quantity, cartID, productID)
+	if err != nil {
+		return fmt.Errorf("error updating cart item: %w", dbError(ctx, err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", dbError(ctx, err))
+	}
+	if n == 0 {
+		return fmt.Errorf("error updating cart item: %w", errNoRows)
+	}
+
+	return nil
+}
+
+func removeCartItem(ctx context.Context, db *sqlx.DB, cartID string, productID int64) error {
+	_, err := db.ExecContext(ctx, db.Rebind("DELETE FROM cart_items WHERE cart_id=? AND product_id=?"), cartID, productID)
+	if err != nil {
+		return fmt.Errorf("error removing cart item: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+func clearCart(ctx context.Context, db *sqlx.DB, cartID string) error {
+	_, err := db.ExecContext(ctx, db.Rebind("DELETE FROM cart_items WHERE cart_id=?"), cartID)
+	if err != nil {
+		return fmt.Errorf("error clearing cart: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+// mergeCarts adds the items of cart fromID to cart toID and deletes fromID.
+// addItem is the upsert of the storer adding to the quantity of an item
+// already in the cart.
+func mergeCarts(ctx context.Context, tx *sqlx.Tx, fromID, toID, addItem string) error {
+	var items []CartItem
+	err := tx.SelectContext(ctx, &items, tx.Rebind("SELECT * FROM cart_items WHERE cart_id=? ORDER BY id"), fromID)
+	if err != nil {
+		return fmt.Errorf("error getting cart items: %w", dbError(ctx, err))
+	}
+
+	for _, ci := range items {
+		_, err := tx.ExecContext(ctx, tx.Rebind(addItem), toID, ci.ProductID, ci.Quantity)
+		if err != nil {
+			return fmt.Errorf("error adding cart item: %w", dbError(ctx, err))
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind("DELETE FROM cart_items WHERE cart_id=?"), fromID)
+	if err != nil {
+		return fmt.Errorf("error deleting cart items: %w", dbError(ctx, err))
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind("DELETE FROM carts WHERE id=?"), fromID)
+	if err != nil {
+		return fmt.Errorf("error deleting cart: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+// processWebhookEvent marks webhook event id processed and applies change, if
+// any: the refund is recorded with createRefund of the storer, the payment is
+// recorded and the order moved to its new status. An event that was already
+// processed, e.g. by a concurrent replay, is rejected with a *ConflictError
+// before anything is applied.
+func processWebhookEvent(ctx context.Context, tx *sqlx.Tx, id int64, change *PaymentChange, createRefund func(context.Context, *sqlx.Tx, *Refund) error) error {
+	res, err := tx.ExecContext(ctx, tx.Rebind("UPDATE webhook_events SET status=?, error='', attempts=attempts+1, processed_at=CURRENT_TIMESTAMP WHERE id=? AND status<>?"), WebhookEventProcessed, id, WebhookEventProcessed)
+	if err != nil {
+		return fmt.Errorf("error updating webhook event: %w", dbError(ctx, err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", dbError(ctx, err))
+	}
+	if n == 0 {
+		return &ConflictError{Reason: fmt.Sprintf("webhook event %d is already processed", id)}
+	}
+
+	if change == nil {
+		return nil
+	}
+
+	if change.Refund != nil {
+		if err := createRefund(ctx, tx, change.Refund); err != nil {
+			return err
+		}
+	}
+
+	if change.Payment != nil {
+		_, err := tx.NamedExecContext(ctx, "INSERT INTO payments (order_id, provider, operation, status, amount, currency, reference, error) VALUES (:order_id, :provider, :operation, :status, :amount, :currency, :reference, :error)", change.Payment)
+		if err != nil {
+			return fmt.Errorf("error inserting payment: %w", dbError(ctx, err))
+		}
+	}
+
+	if change.To != "" {
+		if err := updateOrderStatus(ctx, tx, change.OrderID, change.From, change.To, change.Actor); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyRefund adds refund r to the refunded price of its order and, if r is
+// succeeded and r.Restock is set, puts its items back into stock; a pending
+// refund restocks them once completed, see completeRefund. A refund without a
+// status is recorded as succeeded. The refund may not take
+// the refunded price over the total price, nor refund more units of an item
+// than were ordered and not refunded yet; a *ConflictError is returned if it
+// does. The order row is read with the forUpdate locking clause so concurrent
+// refunds are checked one after the other.
+func applyRefund(ctx context.Context, tx *sqlx.Tx, r *Refund, forUpdate string) error {
+	var o Order
+	err := tx.GetContext(ctx, &o, tx.Rebind("SELECT currency, total_price, refunded_price FROM orders WHERE id=?"+forUpdate), r.OrderID)
+	if err != nil {
+		return fmt.Errorf("error getting order: %w", dbError(ctx, err))
+	}
+	o.setCurrency()
+
+	if r.Currency != o.Currency {
+		return &ValidationError{Reason: fmt.Sprintf("refund is in %s, order is in %s", r.Currency, o.Currency)}
+	}
+	r.setCurrency()
+	if r.Status == "" {
+		r.Status = RefundSucceeded
+	}
+	refunded := o.RefundedPrice.Add(r.Amount)
+	if refunded.Cmp(o.TotalPrice) > 0 {
+		return &ConflictError{Reason: fmt.Sprintf("refund of %s exceeds the %s left to refund", r.Amount, o.TotalPrice.Sub(o.RefundedPrice))}
+	}
+
+	stock := make([]OrderItem, 0, len(r.Items))
+	seen := make(map[int64]bool, len(r.Items))
+	for _, ri := range r.Items {
+		if seen[ri.OrderItemID] {
+			return &ValidationError{Reason: fmt.Sprintf("item %d is refunded twice", ri.OrderItemID)}
+		}
+		seen[ri.OrderItemID] = true
+
+		var oi OrderItem
+		err := tx.GetContext(ctx, &oi, tx.Rebind("SELECT * FROM order_items WHERE id=? AND order_id=?"), ri.OrderItemID, r.OrderID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &ValidationError{Reason: fmt.Sprintf("item %d is not part of order %d", ri.OrderItemID, r.OrderID)}
+		}
+		if err != nil {
+			return fmt.Errorf("error getting order item: %w", dbError(ctx, err))
+		}
+
+		var quantity int64
+		err = tx.GetContext(ctx, &quantity, tx.Rebind("SELECT COALESCE(SUM(ri.quantity), 0) FROM refund_items ri JOIN refunds r ON r.id=ri.refund_id WHERE ri.order_item_id=? AND r.status<>?"), oi.ID, RefundFailed)
+		if err != nil {
+			return fmt.Errorf("error getting refunded quantity: %w", dbError(ctx, err))
+		}
+		if ri.Quantity > oi.Quantity-quantity {
+			return &ConflictError{Reason: fmt.Sprintf("only %d of item %d are left to refund", oi.Quantity-quantity, oi.ID)}
+		}
+
+		oi.Quantity = ri.Quantity
+		stock = append(stock, oi)
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind("UPDATE orders SET refunded_price=?, updated_at=CURRENT_TIMESTAMP WHERE id=?"), refunded, r.OrderID)
+	if err != nil {
+		return fmt.Errorf("error updating refunded price: %w", dbError(ctx, err))
+	}
+
+	if r.Status == RefundSucceeded && r.Restock {
+		if err := restoreStock(ctx, tx, stock); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// completeRefund marks pending refund id succeeded with the reference of the
+// provider and, if it restocks, puts its items back into stock. It returns the
+// refund with its items; a refund that is no longer pending is rejected with a
+// *ConflictError.
+func completeRefund(ctx context.Context, tx *sqlx.Tx, id int64, reference string) (*Refund, error) {
+	if err := settleRefund(ctx, tx, id, RefundSucceeded, reference); err != nil {
+		return nil, err
+	}
+
+	var r Refund
+	err := tx.GetContext(ctx, &r, tx.Rebind("SELECT * FROM refunds WHERE id=?"), id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting refund: %w", dbError(ctx, err))
+	}
+	err = tx.SelectContext(ctx, &r.Items, tx.Rebind("SELECT * FROM refund_items WHERE refund_id=? ORDER BY id"), id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting refund items: %w", dbError(ctx, err))
+	}
+	r.setCurrency()
+
+	if r.Restock {
+		var stock []OrderItem
+		err := tx.SelectContext(ctx, &stock, tx.Rebind("SELECT oi.product_id, ri.quantity FROM refund_items ri JOIN order_items oi ON oi.id=ri.order_item_id WHERE ri.refund_id=?"), id)
+		if err != nil {
+			return nil, fmt.Errorf("error getting refunded items: %w", dbError(ctx, err))
+		}
+		if err := restoreStock(ctx, tx, stock); err != nil {
+			return nil, err
+		}
+	}
+
+	return &r, nil
+}
+
+// failRefund marks pending refund id failed and takes it off the refunded
+// price of its order, so that it can be refunded again. A refund that is no
+// longer pending is rejected with a *ConflictError.
+func failRefund(ctx context.Context, tx *sqlx.Tx, id int64) error {
+	if err := settleRefund(ctx, tx, id, RefundFailed, ""); err != nil {
+		return err
+	}
+
+	var r Refund
+	err := tx.GetContext(ctx, &r, tx.Rebind("SELECT order_id, amount, currency FROM refunds WHERE id=?"), id)
+	if err != nil {
+		return fmt.Errorf("error getting refund: %w", dbError(ctx, err))
+	}
+	r.setCurrency()
+
+	_, err = tx.ExecContext(ctx, tx.Rebind("UPDATE orders SET refunded_price=refunded_price-?, updated_at=CURRENT_TIMESTAMP WHERE id=?"), r.Amount, r.OrderID)
+	if err != nil {
+		return fmt.Errorf("error updating refunded price: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
+
+// settleRefund moves pending refund id to status, with the reference of the
+// provider. The update itself checks that the refund is pending, so that a
+// refund is settled once.
+func settleRefund(ctx context.Context, tx *sqlx.Tx, id int64, status RefundStatus, reference string) error {
+	res, err := tx.ExecContext(ctx, tx.Rebind("UPDATE refunds SET status=?, reference=? WHERE id=? AND status=?"), status, reference, id, RefundPending)
+	if err != nil {
+		return fmt.Errorf("error updating refund: %w", dbError(ctx, err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", dbError(ctx, err))
+	}
+	if n == 0 {
+		var current RefundStatus
+		err := tx.GetContext(ctx, &current, tx.Rebind("SELECT status FROM refunds WHERE id=?"), id)
+		if err != nil {
+			return fmt.Errorf("error getting refund status: %w", dbError(ctx, err))
+		}
+		return &ConflictError{Reason: fmt.Sprintf("refund %d is %s", id, current)}
+	}
+
+	return nil
+}
+
+// execTx runs fn in a transaction, rolling back if it returns an error.
+func execTx(ctx context.Context, db *sqlx.DB, fn func(*sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", dbError(ctx, err))
+	}
+
+	err = fn(tx)
+
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("error rolling back transaction: %w", rbErr)
+		}
+		return fmt.Errorf("error executing transaction: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", dbError(ctx, err))
+	}
+
+	return nil
+}
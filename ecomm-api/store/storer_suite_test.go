@@ -0,0 +1,807 @@
+package storer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gauss2302/ecomm-service/db"
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+// testStorer runs the behavioral test suite shared by every Storer
+// implementation. It only relies on records it created itself so it can run
+// against a database that already holds data.
+func testStorer(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	t.Run("products", func(t *testing.T) {
+		p, err := st.CreateProduct(ctx, &Product{
+			Name:         "test product",
+			Image:        "test.jpg",
+			Category:     "test category",
+			Description:  "test description",
+			Rating:       5,
+			NumReviews:   10,
+			Price:        money.New(9999, "USD"),
+			Currency:     "USD",
+			CountInStock: 100,
+		})
+		require.NoError(t, err)
+		require.NotZero(t, p.ID)
+		require.False(t, p.CreatedAt.IsZero())
+
+		gp, err := st.GetProduct(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, "test product", gp.Name)
+		require.Equal(t, money.New(9999, "USD"), gp.Price)
+
+		products, _, err := st.ListProducts(ctx, ProductFilter{Category: "test category"})
+		require.NoError(t, err)
+		require.True(t, containsProduct(products, p.ID))
+
+		gp.Name = "updated product"
+		_, err = st.UpdateProduct(ctx, gp)
+		require.NoError(t, err)
+
+		gp, err = st.GetProduct(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, "updated product", gp.Name)
+
+		// the stock is left alone by edits, which may carry a stale count
+		gp.CountInStock = 1
+		_, err = st.UpdateProduct(ctx, gp)
+		require.NoError(t, err)
+		requireStock(t, st, p.ID, 100)
+
+		require.NoError(t, st.SetProductStock(ctx, p.ID, 42))
+		requireStock(t, st, p.ID, 42)
+
+		err = st.DeleteProduct(ctx, p.ID)
+		require.NoError(t, err)
+
+		_, err = st.GetProduct(ctx, p.ID)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("product pages", func(t *testing.T) {
+		category := uniqueID()
+		prices := []int64{3000, 1000, 2000, 2000, 5000}
+		for i, price := range prices {
+			_, err := st.CreateProduct(ctx, &Product{Name: fmt.Sprintf("product %d", i), Category: category, Price: money.New(price, "USD"), Currency: "USD", CountInStock: int64(i)})
+			require.NoError(t, err)
+		}
+
+		var got []int64
+		f := ProductFilter{Category: category, InStock: true, Page: Page{Limit: 2, Sort: "price", Desc: true}}
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 3)
+
+			products, next, err := st.ListProducts(ctx, f)
+			require.NoError(t, err)
+			for _, p := range products {
+				got = append(got, p.Price.Amount)
+			}
+			if next == nil {
+				break
+			}
+			f.Cursor = next
+		}
+		// the first product is out of stock
+		require.Equal(t, []int64{5000, 2000, 2000, 1000}, got)
+
+		minPrice, maxPrice := money.New(1500, "USD"), money.New(3000, "USD")
+		products, next, err := st.ListProducts(ctx, ProductFilter{Category: category, MinPrice: &minPrice, MaxPrice: &maxPrice})
+		require.NoError(t, err)
+		require.Nil(t, next)
+		require.Len(t, products, 3)
+
+		_, _, err = st.ListProducts(ctx, ProductFilter{Page: Page{Sort: "description"}})
+		require.ErrorIs(t, err, ErrValidation)
+
+		_, _, err = st.ListProducts(ctx, ProductFilter{Page: Page{Sort: "name", Cursor: f.Cursor}})
+		require.ErrorIs(t, err, ErrValidation)
+	})
+
+	t.Run("users", func(t *testing.T) {
+		email := uniqueEmail()
+		u, err := st.CreateUser(ctx, &User{Name: "test user", Email: email, Password: "hashed"})
+		require.NoError(t, err)
+		require.NotZero(t, u.ID)
+
+		_, err = st.CreateUser(ctx, &User{Name: "other user", Email: email, Password: "hashed"})
+		require.ErrorIs(t, err, ErrConflict)
+
+		gu, err := st.GetUser(ctx, email)
+		require.NoError(t, err)
+		require.Equal(t, u.ID, gu.ID)
+		require.False(t, gu.IsAdmin)
+
+		gu.IsAdmin = true
+		_, err = st.UpdateUser(ctx, gu)
+		require.NoError(t, err)
+
+		gu, err = st.GetUser(ctx, email)
+		require.NoError(t, err)
+		require.True(t, gu.IsAdmin)
+
+		users, _, err := st.ListUsers(ctx, UserFilter{})
+		require.NoError(t, err)
+		require.NotEmpty(t, users)
+
+		err = st.DeleteUser(ctx, u.ID)
+		require.NoError(t, err)
+
+		_, err = st.GetUser(ctx, email)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("orders", func(t *testing.T) {
+		u, err := st.CreateUser(ctx, &User{Name: "test user", Email: uniqueEmail(), Password: "hashed"})
+		require.NoError(t, err)
+		p, err := st.CreateProduct(ctx, &Product{Name: "test product", Image: "test.jpg", Rating: 5, Price: money.New(1000, "USD"), Currency: "USD", CountInStock: 10})
+		require.NoError(t, err)
+
+		o, err := st.CreateOrder(ctx, &Order{
+			PaymentMethod: "card",
+			Currency:      "USD",
+			TaxPrice:      money.New(100, "USD"),
+			ShippingPrice: money.New(200, "USD"),
+			TotalPrice:    money.New(2300, "USD"),
+			UserID:        u.ID,
+			Items: []OrderItem{
+				{Name: p.Name, Quantity: 2, Image: p.Image, Price: p.Price, ProductID: p.ID},
+			},
+		})
+		require.NoError(t, err)
+		require.NotZero(t, o.ID)
+		require.False(t, o.CreatedAt.IsZero())
+		// refunds are keyed by the item IDs returned here
+		require.NotZero(t, o.Items[0].ID)
+		require.Equal(t, o.ID, o.Items[0].OrderID)
+		requireStock(t, st, p.ID, 8)
+
+		_, err = st.CreateOrder(ctx, &Order{
+			PaymentMethod: "card",
+			UserID:        u.ID,
+			Items: []OrderItem{
+				{Name: p.Name, Quantity: 5, Price: p.Price, ProductID: p.ID},
+				{Name: p.Name, Quantity: 5, Price: p.Price, ProductID: p.ID},
+			},
+		})
+		var stockErr *InsufficientStockError
+		require.ErrorAs(t, err, &stockErr)
+		require.ErrorIs(t, err, ErrConflict)
+		require.Equal(t, []StockShortfall{{ProductID: p.ID, Requested: 10, Available: 8}}, stockErr.Shortfalls)
+		requireStock(t, st, p.ID, 8)
+
+		// quantities adding up past an int64 are rejected, not wrapped around
+		_, err = st.CreateOrder(ctx, &Order{
+			PaymentMethod: "card",
+			UserID:        u.ID,
+			Items: []OrderItem{
+				{Name: p.Name, Quantity: 1 << 62, Price: p.Price, ProductID: p.ID},
+				{Name: p.Name, Quantity: 1 << 62, Price: p.Price, ProductID: p.ID},
+			},
+		})
+		require.ErrorIs(t, err, ErrValidation)
+		requireStock(t, st, p.ID, 8)
+
+		gotOrder, err := st.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+		require.Equal(t, u.ID, gotOrder.UserID)
+		require.Len(t, gotOrder.Items, 1)
+		require.Equal(t, int64(2), gotOrder.Items[0].Quantity)
+		require.Equal(t, o.ID, gotOrder.Items[0].OrderID)
+		require.Equal(t, money.New(2300, "USD"), gotOrder.TotalPrice)
+		require.Equal(t, money.New(1000, "USD"), gotOrder.Items[0].Price)
+
+		orders, _, err := st.ListOrders(ctx, OrderFilter{UserID: u.ID, Status: OrderStatusPending})
+		require.NoError(t, err)
+		var found bool
+		for _, lo := range orders {
+			if lo.ID == o.ID {
+				found = true
+				require.Len(t, lo.Items, 1)
+			}
+		}
+		require.True(t, found)
+
+		err = st.DeleteProduct(ctx, p.ID)
+		require.ErrorIs(t, err, ErrConstraint)
+
+		_, err = st.CreateOrder(ctx, &Order{
+			PaymentMethod: "card",
+			UserID:        u.ID,
+			Items: []OrderItem{
+				{Name: "missing product", Quantity: 1, ProductID: p.ID + 1000000},
+			},
+		})
+		require.ErrorIs(t, err, ErrConstraint)
+
+		err = st.DeleteOrder(ctx, o.ID)
+		require.NoError(t, err)
+		requireStock(t, st, p.ID, 10)
+
+		// cancelling puts the stock back once, also when the order is deleted
+		o, err = st.CreateOrder(ctx, &Order{
+			PaymentMethod: "card",
+			UserID:        u.ID,
+			Items:         []OrderItem{{Name: p.Name, Quantity: 3, Price: p.Price, ProductID: p.ID}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, OrderStatusPending, o.Status)
+		requireStock(t, st, p.ID, 7)
+
+		_, err = st.UpdateOrderStatus(ctx, o.ID, OrderStatusPaid, OrderStatusCancelled, "admin@example.com")
+		require.ErrorIs(t, err, ErrConflict)
+
+		cancelled, err := st.UpdateOrderStatus(ctx, o.ID, OrderStatusPending, OrderStatusCancelled, "admin@example.com")
+		require.NoError(t, err)
+		require.Equal(t, OrderStatusCancelled, cancelled.Status)
+		requireStock(t, st, p.ID, 10)
+
+		history, err := st.ListOrderStatusHistory(ctx, o.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		require.Equal(t, OrderStatusPending, history[0].FromStatus)
+		require.Equal(t, OrderStatusCancelled, history[0].ToStatus)
+		require.Equal(t, "admin@example.com", history[0].Actor)
+
+		err = st.DeleteOrder(ctx, o.ID)
+		require.NoError(t, err)
+		requireStock(t, st, p.ID, 10)
+
+		_, err = st.GetOrder(ctx, o.ID)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		err = st.DeleteOrder(ctx, o.ID)
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("carts", func(t *testing.T) {
+		u, err := st.CreateUser(ctx, &User{Name: "cart user", Email: uniqueEmail(), Password: "password"})
+		require.NoError(t, err)
+		p1, err := st.CreateProduct(ctx, &Product{Name: "first", Price: money.New(1000, "USD"), Currency: "USD", CountInStock: 10, IsActive: true})
+		require.NoError(t, err)
+		p2, err := st.CreateProduct(ctx, &Product{Name: "second", Price: money.New(500, "USD"), Currency: "USD", CountInStock: 10, IsActive: true})
+		require.NoError(t, err)
+
+		uc, err := st.CreateCart(ctx, &Cart{ID: uniqueID() + "-user", UserID: &u.ID})
+		require.NoError(t, err)
+		_, err = st.CreateCart(ctx, &Cart{ID: uniqueID() + "-dup", UserID: &u.ID})
+		require.ErrorIs(t, err, ErrConflict)
+
+		gc, err := st.GetUserCart(ctx, u.ID)
+		require.NoError(t, err)
+		require.Equal(t, uc.ID, gc.ID)
+		require.Empty(t, gc.Items)
+
+		// adding a product twice adds up its quantity
+		require.NoError(t, st.AddCartItem(ctx, uc.ID, p1.ID, 1))
+		require.NoError(t, st.AddCartItem(ctx, uc.ID, p1.ID, 2))
+		gc, err = st.GetCart(ctx, uc.ID)
+		require.NoError(t, err)
+		require.Len(t, gc.Items, 1)
+		require.Equal(t, int64(3), gc.Items[0].Quantity)
+
+		require.ErrorIs(t, st.AddCartItem(ctx, uc.ID, p1.ID+1000000, 1), ErrConstraint)
+		require.ErrorIs(t, st.UpdateCartItem(ctx, uc.ID, p2.ID, 1), ErrNotFound)
+
+		// an anonymous cart is merged into the cart of the user
+		ac, err := st.CreateCart(ctx, &Cart{ID: uniqueID() + "-anon"})
+		require.NoError(t, err)
+		require.Nil(t, ac.UserID)
+		require.NoError(t, st.AddCartItem(ctx, ac.ID, p1.ID, 1))
+		require.NoError(t, st.AddCartItem(ctx, ac.ID, p2.ID, 4))
+
+		require.NoError(t, st.MergeCarts(ctx, ac.ID, uc.ID))
+		_, err = st.GetCart(ctx, ac.ID)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		gc, err = st.GetCart(ctx, uc.ID)
+		require.NoError(t, err)
+		quantities := make(map[int64]int64)
+		for _, ci := range gc.Items {
+			quantities[ci.ProductID] = ci.Quantity
+		}
+		require.Equal(t, map[int64]int64{p1.ID: 4, p2.ID: 4}, quantities)
+
+		require.NoError(t, st.UpdateCartItem(ctx, uc.ID, p2.ID, 1))
+		require.NoError(t, st.RemoveCartItem(ctx, uc.ID, p1.ID))
+		gc, err = st.GetCart(ctx, uc.ID)
+		require.NoError(t, err)
+		require.Len(t, gc.Items, 1)
+		require.Equal(t, p2.ID, gc.Items[0].ProductID)
+		require.Equal(t, int64(1), gc.Items[0].Quantity)
+
+		// deleting a product takes it out of the carts holding it
+		require.NoError(t, st.DeleteProduct(ctx, p2.ID))
+		gc, err = st.GetCart(ctx, uc.ID)
+		require.NoError(t, err)
+		require.Empty(t, gc.Items)
+
+		require.NoError(t, st.AddCartItem(ctx, uc.ID, p1.ID, 1))
+		require.NoError(t, st.ClearCart(ctx, uc.ID))
+		gc, err = st.GetCart(ctx, uc.ID)
+		require.NoError(t, err)
+		require.Empty(t, gc.Items)
+	})
+
+	t.Run("promotions", func(t *testing.T) {
+		u, err := st.CreateUser(ctx, &User{Name: "promotion user", Email: uniqueEmail(), Password: "password"})
+		require.NoError(t, err)
+		prod, err := st.CreateProduct(ctx, &Product{Name: "promoted", Price: money.New(1000, "USD"), Currency: "USD", CountInStock: 10, IsActive: true})
+		require.NoError(t, err)
+
+		maxUses, maxUsesPerUser := int64(2), int64(1)
+		code := "SAVE-" + uniqueID()
+		p, err := st.CreatePromotion(ctx, &Promotion{
+			Code:           code,
+			Kind:           PromotionFixedAmount,
+			AmountOff:      money.New(250, "USD"),
+			Currency:       "USD",
+			MinSubtotal:    money.New(1000, "USD"),
+			MaxUses:        &maxUses,
+			MaxUsesPerUser: &maxUsesPerUser,
+			IsActive:       true,
+		})
+		require.NoError(t, err)
+		require.NotZero(t, p.ID)
+		require.Equal(t, money.New(250, "USD"), p.AmountOff)
+		require.Equal(t, money.New(1000, "USD"), p.MinSubtotal)
+		require.Equal(t, &maxUsesPerUser, p.MaxUsesPerUser)
+
+		_, err = st.CreatePromotion(ctx, &Promotion{Code: code, Kind: PromotionFreeShipping, Currency: "USD"})
+		require.ErrorIs(t, err, ErrConflict)
+
+		gp, err := st.GetPromotionByCode(ctx, code)
+		require.NoError(t, err)
+		require.Equal(t, p.ID, gp.ID)
+		_, err = st.GetPromotionByCode(ctx, "missing-"+code)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		promotions, err := st.ListPromotions(ctx)
+		require.NoError(t, err)
+		require.NotEmpty(t, promotions)
+
+		newOrder := func() *Order {
+			return &Order{
+				PaymentMethod: "card",
+				Currency:      "USD",
+				ItemsPrice:    money.New(1000, "USD"),
+				DiscountPrice: money.New(250, "USD"),
+				TotalPrice:    money.New(750, "USD"),
+				UserID:        u.ID,
+				Items:         []OrderItem{{Name: prod.Name, Quantity: 1, Price: prod.Price, ProductID: prod.ID}},
+				Discounts:     []OrderDiscount{{PromotionID: p.ID, Code: p.Code, Amount: money.New(250, "USD")}},
+			}
+		}
+
+		o, err := st.CreateOrder(ctx, newOrder())
+		require.NoError(t, err)
+
+		got, err := st.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+		require.Equal(t, money.New(250, "USD"), got.DiscountPrice)
+		require.Len(t, got.Discounts, 1)
+		require.Equal(t, code, got.Discounts[0].Code)
+		require.Equal(t, money.New(250, "USD"), got.Discounts[0].Amount)
+
+		orders, _, err := st.ListOrders(ctx, OrderFilter{UserID: u.ID})
+		require.NoError(t, err)
+		require.Len(t, orders, 1)
+		require.Len(t, orders[0].Discounts, 1)
+
+		gp, err = st.GetPromotion(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), gp.Uses)
+
+		// the user has used the coupon once already, and the failed order
+		// leaves the stock alone
+		_, err = st.CreateOrder(ctx, newOrder())
+		require.ErrorIs(t, err, ErrConflict)
+		requireStock(t, st, prod.ID, 9)
+
+		// cancelling the order gives the use back
+		_, err = st.UpdateOrderStatus(ctx, o.ID, OrderStatusPending, OrderStatusCancelled, "test")
+		require.NoError(t, err)
+		gp, err = st.GetPromotion(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), gp.Uses)
+
+		o, err = st.CreateOrder(ctx, newOrder())
+		require.NoError(t, err)
+
+		// updating the rules keeps the uses
+		gp.Description = "2.50 off"
+		gp.MaxUsesPerUser = nil
+		up, err := st.UpdatePromotion(ctx, gp)
+		require.NoError(t, err)
+		require.Equal(t, "2.50 off", up.Description)
+		require.Nil(t, up.MaxUsesPerUser)
+		require.Equal(t, int64(1), up.Uses)
+
+		// the global limit holds regardless of the user
+		_, err = st.CreateOrder(ctx, newOrder())
+		require.NoError(t, err)
+		_, err = st.CreateOrder(ctx, newOrder())
+		require.ErrorIs(t, err, ErrConflict)
+
+		require.NoError(t, st.DeleteOrder(ctx, o.ID))
+		gp, err = st.GetPromotion(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), gp.Uses)
+	})
+
+	t.Run("payments", func(t *testing.T) {
+		u, err := st.CreateUser(ctx, &User{Name: "test user", Email: uniqueEmail(), Password: "hashed"})
+		require.NoError(t, err)
+		p, err := st.CreateProduct(ctx, &Product{Name: "test product", Price: money.New(1000, "USD"), Currency: "USD", CountInStock: 10})
+		require.NoError(t, err)
+		o, err := st.CreateOrder(ctx, &Order{
+			PaymentMethod: "card",
+			Currency:      "USD",
+			TotalPrice:    money.New(1000, "USD"),
+			UserID:        u.ID,
+			Items:         []OrderItem{{Name: p.Name, Quantity: 1, Price: p.Price, ProductID: p.ID}},
+		})
+		require.NoError(t, err)
+
+		declined, err := st.CreatePayment(ctx, &Payment{OrderID: o.ID, Provider: "fake", Operation: "authorize", Status: PaymentDeclined, Amount: o.TotalPrice, Currency: "USD", Reference: "ref_1", Error: "card_declined"})
+		require.NoError(t, err)
+		require.NotZero(t, declined.ID)
+		_, err = st.CreatePayment(ctx, &Payment{OrderID: o.ID, Provider: "fake", Operation: "authorize", Status: PaymentSucceeded, Amount: o.TotalPrice, Currency: "USD", Reference: "ref_2"})
+		require.NoError(t, err)
+
+		payments, err := st.ListPayments(ctx, o.ID)
+		require.NoError(t, err)
+		require.Len(t, payments, 2)
+		require.Equal(t, PaymentDeclined, payments[0].Status)
+		require.Equal(t, "card_declined", payments[0].Error)
+		require.Equal(t, PaymentSucceeded, payments[1].Status)
+		require.Equal(t, "ref_2", payments[1].Reference)
+		require.Equal(t, money.New(1000, "USD"), payments[1].Amount)
+
+		_, err = st.CreatePayment(ctx, &Payment{OrderID: o.ID + 1000000, Provider: "fake", Operation: "authorize", Status: PaymentSucceeded, Amount: o.TotalPrice, Currency: "USD"})
+		require.ErrorIs(t, err, ErrConstraint)
+
+		// an order that was paid for keeps its payments
+		err = st.DeleteOrder(ctx, o.ID)
+		require.ErrorIs(t, err, ErrConflict)
+		_, err = st.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+	})
+
+	t.Run("refunds", func(t *testing.T) {
+		u, err := st.CreateUser(ctx, &User{Name: "test user", Email: uniqueEmail(), Password: "hashed"})
+		require.NoError(t, err)
+		p, err := st.CreateProduct(ctx, &Product{Name: "test product", Price: money.New(1000, "USD"), Currency: "USD", CountInStock: 10})
+		require.NoError(t, err)
+		o, err := st.CreateOrder(ctx, &Order{
+			PaymentMethod: "card",
+			Currency:      "USD",
+			ItemsPrice:    money.New(3000, "USD"),
+			ShippingPrice: money.New(500, "USD"),
+			TotalPrice:    money.New(3500, "USD"),
+			UserID:        u.ID,
+			Items:         []OrderItem{{Name: p.Name, Quantity: 3, Price: p.Price, ProductID: p.ID}},
+		})
+		require.NoError(t, err)
+		_, err = st.UpdateOrderStatus(ctx, o.ID, OrderStatusPending, OrderStatusPaid, "admin")
+		require.NoError(t, err)
+		itemID := o.Items[0].ID
+
+		r, err := st.CreateRefund(ctx, &Refund{
+			OrderID:  o.ID,
+			Amount:   money.New(2000, "USD"),
+			Currency: "USD",
+			Reason:   RefundDamaged,
+			Restock:  true,
+			Actor:    "admin",
+			Items:    []RefundItem{{OrderItemID: itemID, Quantity: 2, Amount: money.New(2000, "USD")}},
+		})
+		require.NoError(t, err)
+		require.NotZero(t, r.ID)
+		require.NotZero(t, r.Items[0].ID)
+
+		gotProduct, err := st.GetProduct(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(9), gotProduct.CountInStock)
+
+		// nothing is applied when more is refunded than is left
+		_, err = st.CreateRefund(ctx, &Refund{OrderID: o.ID, Amount: money.New(2000, "USD"), Currency: "USD", Reason: RefundDamaged, Items: []RefundItem{{OrderItemID: itemID, Quantity: 2, Amount: money.New(2000, "USD")}}})
+		require.ErrorIs(t, err, ErrConflict)
+		_, err = st.CreateRefund(ctx, &Refund{OrderID: o.ID, Amount: money.New(2000, "USD"), ShippingAmount: money.New(2000, "USD"), Currency: "USD", Reason: RefundOther})
+		require.ErrorIs(t, err, ErrConflict)
+		_, err = st.CreateRefund(ctx, &Refund{OrderID: o.ID, Amount: money.New(100, "USD"), Currency: "USD", Reason: RefundOther, Items: []RefundItem{{OrderItemID: itemID + 1000000, Quantity: 1, Amount: money.New(100, "USD")}}})
+		require.ErrorIs(t, err, ErrValidation)
+
+		// a pending refund holds its share of the order until it is settled,
+		// and a failed one gives it back
+		newLastRefund := func() *Refund {
+			return &Refund{
+				OrderID:        o.ID,
+				Status:         RefundPending,
+				Amount:         money.New(1500, "USD"),
+				ShippingAmount: money.New(500, "USD"),
+				Currency:       "USD",
+				Reason:         RefundCustomerRequest,
+				Restock:        true,
+				Actor:          "admin",
+				Items:          []RefundItem{{OrderItemID: itemID, Quantity: 1, Amount: money.New(1000, "USD")}},
+			}
+		}
+		pending, err := st.CreateRefund(ctx, newLastRefund())
+		require.NoError(t, err)
+		_, err = st.CreateRefund(ctx, newLastRefund())
+		require.ErrorIs(t, err, ErrConflict)
+		require.NoError(t, st.FailRefund(ctx, pending.ID))
+		require.ErrorIs(t, st.FailRefund(ctx, pending.ID), ErrConflict)
+		_, err = st.CompleteRefund(ctx, pending.ID, "ref_1")
+		require.ErrorIs(t, err, ErrConflict)
+
+		pending, err = st.CreateRefund(ctx, newLastRefund())
+		require.NoError(t, err)
+		gotProduct, err = st.GetProduct(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(9), gotProduct.CountInStock)
+
+		completed, err := st.CompleteRefund(ctx, pending.ID, "ref_2")
+		require.NoError(t, err)
+		require.Equal(t, RefundSucceeded, completed.Status)
+		require.Equal(t, "ref_2", completed.Reference)
+		require.Equal(t, money.New(1500, "USD"), completed.Amount)
+		require.Len(t, completed.Items, 1)
+		_, err = st.CompleteRefund(ctx, pending.ID, "ref_2")
+		require.ErrorIs(t, err, ErrConflict)
+		_, err = st.UpdateOrderStatus(ctx, o.ID, OrderStatusPaid, OrderStatusRefunded, "admin")
+		require.NoError(t, err)
+
+		gotOrder, err := st.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+		require.Equal(t, OrderStatusRefunded, gotOrder.Status)
+		require.Equal(t, money.New(3500, "USD"), gotOrder.RefundedPrice)
+		require.Len(t, gotOrder.Refunds, 2)
+		require.Equal(t, RefundDamaged, gotOrder.Refunds[0].Reason)
+		require.True(t, gotOrder.Refunds[0].Restock)
+		require.Equal(t, money.New(500, "USD"), gotOrder.Refunds[1].ShippingAmount)
+		require.Len(t, gotOrder.Refunds[1].Items, 1)
+		require.Equal(t, money.New(1000, "USD"), gotOrder.Refunds[1].Items[0].Amount)
+		require.Equal(t, RefundSucceeded, gotOrder.Refunds[1].Status)
+
+		gotProduct, err = st.GetProduct(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(10), gotProduct.CountInStock)
+
+		orders, _, err := st.ListOrders(ctx, OrderFilter{UserID: u.ID})
+		require.NoError(t, err)
+		require.Len(t, orders, 1)
+		require.Len(t, orders[0].Refunds, 2)
+
+		// an order that was refunded keeps its refunds
+		err = st.DeleteOrder(ctx, o.ID)
+		require.ErrorIs(t, err, ErrConflict)
+	})
+
+	t.Run("webhook events", func(t *testing.T) {
+		u, err := st.CreateUser(ctx, &User{Name: "test user", Email: uniqueEmail(), Password: "hashed"})
+		require.NoError(t, err)
+		p, err := st.CreateProduct(ctx, &Product{Name: "test product", Price: money.New(1000, "USD"), Currency: "USD", CountInStock: 10})
+		require.NoError(t, err)
+		o, err := st.CreateOrder(ctx, &Order{
+			PaymentMethod: "card",
+			Currency:      "USD",
+			TotalPrice:    money.New(1000, "USD"),
+			UserID:        u.ID,
+			Items:         []OrderItem{{Name: p.Name, Quantity: 1, Price: p.Price, ProductID: p.ID}},
+		})
+		require.NoError(t, err)
+
+		ref := uniqueID()
+		_, err = st.CreatePayment(ctx, &Payment{OrderID: o.ID, Provider: "fake", Operation: "authorize", Status: PaymentSucceeded, Amount: o.TotalPrice, Currency: "USD", Reference: ref})
+		require.NoError(t, err)
+		auth, err := st.GetPaymentByReference(ctx, "fake", ref)
+		require.NoError(t, err)
+		require.Equal(t, o.ID, auth.OrderID)
+		_, err = st.GetPaymentByReference(ctx, "other", ref)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		eventID := uniqueID()
+		e, err := st.CreateWebhookEvent(ctx, &WebhookEvent{Provider: "fake", EventID: eventID, Type: "payment.captured", Payload: "{}", Status: WebhookEventPending})
+		require.NoError(t, err)
+		require.NotZero(t, e.ID)
+		_, err = st.CreateWebhookEvent(ctx, &WebhookEvent{Provider: "fake", EventID: eventID, Type: "payment.captured", Payload: "{}", Status: WebhookEventPending})
+		require.ErrorIs(t, err, ErrConflict)
+
+		// a failed change leaves nothing applied
+		change := &PaymentChange{
+			OrderID: o.ID,
+			Payment: &Payment{OrderID: o.ID, Provider: "fake", Operation: "capture", Status: PaymentSucceeded, Amount: o.TotalPrice, Currency: "USD", Reference: ref},
+			From:    OrderStatusPaid,
+			To:      OrderStatusProcessing,
+			Actor:   "webhook:fake",
+		}
+		err = st.ProcessWebhookEvent(ctx, e.ID, change)
+		require.ErrorIs(t, err, ErrConflict)
+		require.NoError(t, st.FailWebhookEvent(ctx, e.ID, "order is no longer paid"))
+
+		failed, err := st.ListWebhookEvents(ctx, "fake", WebhookEventFailed)
+		require.NoError(t, err)
+		require.Len(t, failed, 1)
+		require.Equal(t, "order is no longer paid", failed[0].Error)
+		require.Equal(t, int64(1), failed[0].Attempts)
+		payments, err := st.ListPayments(ctx, o.ID)
+		require.NoError(t, err)
+		require.Len(t, payments, 1)
+
+		change.From, change.To = OrderStatusPending, OrderStatusPaid
+		require.NoError(t, st.ProcessWebhookEvent(ctx, e.ID, change))
+
+		got, err := st.GetWebhookEvent(ctx, "fake", eventID)
+		require.NoError(t, err)
+		require.Equal(t, WebhookEventProcessed, got.Status)
+		require.Empty(t, got.Error)
+		require.Equal(t, int64(2), got.Attempts)
+		require.NotNil(t, got.ProcessedAt)
+
+		gotOrder, err := st.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+		require.Equal(t, OrderStatusPaid, gotOrder.Status)
+		payments, err = st.ListPayments(ctx, o.ID)
+		require.NoError(t, err)
+		require.Len(t, payments, 2)
+
+		// processed events are applied once, and are not failed afterwards
+		err = st.ProcessWebhookEvent(ctx, e.ID, nil)
+		require.ErrorIs(t, err, ErrConflict)
+		require.NoError(t, st.FailWebhookEvent(ctx, e.ID, "too late"))
+		got, err = st.GetWebhookEvent(ctx, "fake", eventID)
+		require.NoError(t, err)
+		require.Equal(t, WebhookEventProcessed, got.Status)
+
+		failed, err = st.ListWebhookEvents(ctx, "fake", WebhookEventFailed)
+		require.NoError(t, err)
+		require.Empty(t, failed)
+
+		// a refund is recorded with its event, or not at all
+		refundEvent := func(amount int64) (*WebhookEvent, *PaymentChange) {
+			e, err := st.CreateWebhookEvent(ctx, &WebhookEvent{Provider: "fake", EventID: uniqueID(), Type: "payment.refunded", Payload: "{}", Status: WebhookEventPending})
+			require.NoError(t, err)
+			return e, &PaymentChange{
+				OrderID: o.ID,
+				Payment: &Payment{OrderID: o.ID, Provider: "fake", Operation: "refund", Status: PaymentSucceeded, Amount: money.New(amount, "USD"), Currency: "USD", Reference: ref},
+				Refund:  &Refund{OrderID: o.ID, Status: RefundSucceeded, Amount: money.New(amount, "USD"), ShippingAmount: money.New(0, "USD"), Currency: "USD", Reason: RefundOther, Actor: "webhook:fake"},
+				Actor:   "webhook:fake",
+			}
+		}
+		e, change = refundEvent(400)
+		require.NoError(t, st.ProcessWebhookEvent(ctx, e.ID, change))
+		e, change = refundEvent(700)
+		require.ErrorIs(t, st.ProcessWebhookEvent(ctx, e.ID, change), ErrConflict)
+
+		gotOrder, err = st.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+		require.Equal(t, money.New(400, "USD"), gotOrder.RefundedPrice)
+		require.Len(t, gotOrder.Refunds, 1)
+		payments, err = st.ListPayments(ctx, o.ID)
+		require.NoError(t, err)
+		require.Len(t, payments, 3)
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		id := uniqueID()
+		s, err := st.CreateSession(ctx, &Session{
+			ID:           id,
+			FamilyID:     id,
+			UserEmail:    "test@example.com",
+			RefreshToken: "refresh token",
+			ExpiresAt:    time.Now().Add(time.Hour),
+		})
+		require.NoError(t, err)
+
+		ns := &Session{
+			ID:           id + "-2",
+			FamilyID:     s.FamilyID,
+			UserEmail:    s.UserEmail,
+			RefreshToken: "new refresh token",
+			ExpiresAt:    time.Now().Add(time.Hour),
+		}
+		_, err = st.RotateSession(ctx, s.ID, ns)
+		require.NoError(t, err)
+
+		gs, err := st.GetSession(ctx, s.ID)
+		require.NoError(t, err)
+		require.NotNil(t, gs.ReplacedBy)
+		require.Equal(t, ns.ID, *gs.ReplacedBy)
+
+		// rotating the same session twice is a replay
+		_, err = st.RotateSession(ctx, s.ID, &Session{ID: id + "-3", FamilyID: s.FamilyID, ExpiresAt: time.Now().Add(time.Hour)})
+		require.ErrorIs(t, err, ErrSessionRotated)
+
+		err = st.RevokeSessionFamily(ctx, s.FamilyID)
+		require.NoError(t, err)
+
+		gs, err = st.GetSession(ctx, ns.ID)
+		require.NoError(t, err)
+		require.True(t, gs.IsRevoked)
+	})
+
+	t.Run("revoked tokens", func(t *testing.T) {
+		id := uniqueID()
+		err := st.RevokeToken(ctx, id, time.Now().Add(-time.Minute))
+		require.NoError(t, err)
+
+		revoked, err := st.IsTokenRevoked(ctx, id)
+		require.NoError(t, err)
+		require.True(t, revoked)
+
+		n, err := st.PurgeRevokedTokens(ctx, time.Now())
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, n, int64(1))
+
+		revoked, err = st.IsTokenRevoked(ctx, id)
+		require.NoError(t, err)
+		require.False(t, revoked)
+	})
+}
+
+func requireStock(t *testing.T, st Storer, productID, want int64) {
+	t.Helper()
+
+	p, err := st.GetProduct(context.Background(), productID)
+	require.NoError(t, err)
+	require.Equal(t, want, p.CountInStock)
+}
+
+func containsProduct(products []Product, id int64) bool {
+	for _, p := range products {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueID() string {
+	return time.Now().Format("20060102150405.000000000")
+}
+
+func uniqueEmail() string {
+	return uniqueID() + "@example.com"
+}
+
+func TestMemoryStorer(t *testing.T) {
+	testStorer(t, NewMemoryStorer())
+}
+
+// TestMySQLStorer runs the suite against the MySQL database of
+// ECOMM_TEST_MYSQL_DSN, if set, which it migrates up first.
+func TestMySQLStorer(t *testing.T) {
+	dsn := os.Getenv("ECOMM_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("ECOMM_TEST_MYSQL_DSN is not set")
+	}
+
+	testStorer(t, NewMySQLStorer(openTestDB(t, "mysql", dsn)))
+}
+
+// openTestDB connects to the database of a driver and dsn given to the tests
+// and migrates it up, so the suite runs against the current schema.
+func openTestDB(t *testing.T, driverName, dsn string) *sqlx.DB {
+	t.Helper()
+
+	database, err := db.Open(driverName, dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.MigrateUp(context.Background()))
+
+	return database.GetDB()
+}
@@ -1,41 +1,361 @@
 package storer
 
-import "time"
+import (
+	"time"
+
+	"github.com/gauss2302/ecomm-service/money"
+)
 
 type Product struct {
-	ID           int64      `db:"id"`
-	Name         string     `db:"name"`
-	Image        string     `db:"image"`
-	Category     string     `db:"category"`
-	Description  string     `db:"description"`
-	Rating       int64      `db:"rating"`
-	NumReviews   int64      `db:"num_reviews"`
-	Price        float64    `db:"price"`
-	CountInStock int64      `db:"count_in_stock"`
-	CreatedAt    time.Time  `db:"created_at"`
-	UpdatedAt    *time.Time `db:"updated_at"`
+	ID           int64       `db:"id"`
+	Name         string      `db:"name"`
+	Image        string      `db:"image"`
+	Category     string      `db:"category"`
+	Description  string      `db:"description"`
+	Rating       int64       `db:"rating"`
+	NumReviews   int64       `db:"num_reviews"`
+	Price        money.Money `db:"price"`
+	Currency     string      `db:"currency"`
+	CountInStock int64       `db:"count_in_stock"`
+	IsActive     bool        `db:"is_active"`
+	CreatedAt    time.Time   `db:"created_at"`
+	UpdatedAt    *time.Time  `db:"updated_at"`
 }
 
 type Order struct {
-	ID            int64      `db:"id"`
-	PaymentMethod string     `db:"payment_method"`
-	TaxPrice      float64    `db:"tax_price"`
-	ShippingPrice float64    `db:"shipping_price"`
-	TotalPrice    float64    `db:"total_price"`
-	UserID        int64      `db:"user_id"`
-	CreatedAt     time.Time  `db:"created_at"`
-	UpdatedAt     *time.Time `db:"updated_at"`
+	ID            int64       `db:"id"`
+	Status        OrderStatus `db:"status"`
+	PaymentMethod string      `db:"payment_method"`
+	Currency      string      `db:"currency"`
+	ItemsPrice    money.Money `db:"items_price"`
+	TaxPrice      money.Money `db:"tax_price"`
+	ShippingPrice money.Money `db:"shipping_price"`
+	// DiscountPrice is the sum of the Discounts, which are taken off the
+	// items and shipping price: TotalPrice is ItemsPrice + TaxPrice +
+	// ShippingPrice - DiscountPrice.
+	DiscountPrice money.Money `db:"discount_price"`
+	TotalPrice    money.Money `db:"total_price"`
+	// RefundedPrice is the sum of the Refunds, at most TotalPrice.
+	RefundedPrice money.Money `db:"refunded_price"`
+	UserID        int64       `db:"user_id"`
+	CreatedAt     time.Time   `db:"created_at"`
+	UpdatedAt     *time.Time  `db:"updated_at"`
 	Items         []OrderItem
+	Discounts     []OrderDiscount
+	Refunds       []Refund
+}
+
+// OrderStatus is the stage of an order in its lifecycle. The transitions
+// allowed between them are enforced by the server.
+type OrderStatus string
+
+const (
+	OrderStatusPending    OrderStatus = "pending"
+	OrderStatusPaid       OrderStatus = "paid"
+	OrderStatusProcessing OrderStatus = "processing"
+	OrderStatusShipped    OrderStatus = "shipped"
+	OrderStatusDelivered  OrderStatus = "delivered"
+	OrderStatusCancelled  OrderStatus = "cancelled"
+	OrderStatusRefunded   OrderStatus = "refunded"
+)
+
+// Valid reports whether s is one of the known statuses.
+func (s OrderStatus) Valid() bool {
+	switch s {
+	case OrderStatusPending, OrderStatusPaid, OrderStatusProcessing, OrderStatusShipped,
+		OrderStatusDelivered, OrderStatusCancelled, OrderStatusRefunded:
+		return true
+	default:
+		return false
+	}
+}
+
+// HoldsStock reports whether the items of an order in this status are still
+// reserved in stock, i.e. the order has not shipped or been cancelled yet.
+func (s OrderStatus) HoldsStock() bool {
+	switch s {
+	case OrderStatusPending, OrderStatusPaid, OrderStatusProcessing:
+		return true
+	default:
+		return false
+	}
+}
+
+// OrderStatusChange records a transition of an order and who made it.
+type OrderStatusChange struct {
+	ID         int64       `db:"id"`
+	OrderID    int64       `db:"order_id"`
+	FromStatus OrderStatus `db:"from_status"`
+	ToStatus   OrderStatus `db:"to_status"`
+	Actor      string      `db:"actor"`
+	CreatedAt  time.Time   `db:"created_at"`
+}
+
+// setCurrency copies the currency column of a scanned product into its price,
+// as money.Money only scans the amount.
+func (p *Product) setCurrency() {
+	p.Price.Currency = p.Currency
+}
+
+// setCurrency copies the currency column of a scanned order into its prices
+// and those of its items.
+func (o *Order) setCurrency() {
+	o.ItemsPrice.Currency = o.Currency
+	o.TaxPrice.Currency = o.Currency
+	o.ShippingPrice.Currency = o.Currency
+	o.DiscountPrice.Currency = o.Currency
+	o.TotalPrice.Currency = o.Currency
+	o.RefundedPrice.Currency = o.Currency
+	for i := range o.Items {
+		o.Items[i].Price.Currency = o.Currency
+	}
+	for i := range o.Discounts {
+		o.Discounts[i].Amount.Currency = o.Currency
+	}
+	for i := range o.Refunds {
+		o.Refunds[i].setCurrency()
+	}
 }
 
 type OrderItem struct {
-	ID        int64   `db:"id"`
-	Name      string  `db:"name"`
-	Quantity  int64   `db:"quantity"`
-	Image     string  `db:"image"`
-	Price     float64 `db:"price"`
-	ProductID int64   `db:"product_id"`
-	OrderID   int64   `db:"order_id"`
+	ID        int64       `db:"id"`
+	Name      string      `db:"name"`
+	Quantity  int64       `db:"quantity"`
+	Image     string      `db:"image"`
+	Price     money.Money `db:"price"`
+	ProductID int64       `db:"product_id"`
+	OrderID   int64       `db:"order_id"`
+}
+
+// OrderDiscount is a discount applied to an order by a promotion. The code
+// and description are copied from the promotion so the order stays
+// auditable if the promotion changes.
+type OrderDiscount struct {
+	ID          int64       `db:"id"`
+	OrderID     int64       `db:"order_id"`
+	PromotionID int64       `db:"promotion_id"`
+	Code        string      `db:"code"`
+	Description string      `db:"description"`
+	Amount      money.Money `db:"amount"`
+}
+
+// PromotionKind is what a promotion takes off an order.
+type PromotionKind string
+
+const (
+	// PromotionPercentage takes PercentOff percent off the eligible items.
+	PromotionPercentage PromotionKind = "percentage"
+	// PromotionFixedAmount takes AmountOff off the eligible items.
+	PromotionFixedAmount PromotionKind = "fixed_amount"
+	// PromotionFreeShipping waives the shipping price.
+	PromotionFreeShipping PromotionKind = "free_shipping"
+	// PromotionBuyXGetY makes GetQuantity of every BuyQuantity+GetQuantity
+	// units of an eligible product free.
+	PromotionBuyXGetY PromotionKind = "buy_x_get_y"
+)
+
+// Valid reports whether k is one of the known kinds.
+func (k PromotionKind) Valid() bool {
+	switch k {
+	case PromotionPercentage, PromotionFixedAmount, PromotionFreeShipping, PromotionBuyXGetY:
+		return true
+	default:
+		return false
+	}
+}
+
+// Promotion is a coupon customers apply to an order by its code. The rules
+// deciding whether it applies are enforced by the server, and its usage
+// limits by the storer when the order is created.
+type Promotion struct {
+	ID          int64         `db:"id"`
+	Code        string        `db:"code"`
+	Description string        `db:"description"`
+	Kind        PromotionKind `db:"kind"`
+	PercentOff  int64         `db:"percent_off"`
+	AmountOff   money.Money   `db:"amount_off"`
+	Currency    string        `db:"currency"`
+	BuyQuantity int64         `db:"buy_quantity"`
+	GetQuantity int64         `db:"get_quantity"`
+	// Category restricts the promotion to the items of a category, and
+	// MinSubtotal applies to those items only. Empty means every item.
+	Category    string      `db:"category"`
+	MinSubtotal money.Money `db:"min_subtotal"`
+	// MaxUses and MaxUsesPerUser limit the orders the promotion applies to,
+	// nil means unlimited. Uses counts the orders so far; cancelled orders
+	// give their use back.
+	MaxUses        *int64 `db:"max_uses"`
+	MaxUsesPerUser *int64 `db:"max_uses_per_user"`
+	Uses           int64  `db:"uses"`
+	// Stackable promotions can be combined with each other, others can only
+	// be used alone.
+	Stackable bool       `db:"stackable"`
+	IsActive  bool       `db:"is_active"`
+	StartsAt  *time.Time `db:"starts_at"`
+	EndsAt    *time.Time `db:"ends_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+// setCurrency copies the currency column of a scanned promotion into its
+// amounts.
+func (p *Promotion) setCurrency() {
+	p.AmountOff.Currency = p.Currency
+	p.MinSubtotal.Currency = p.Currency
+}
+
+// PaymentStatus is the outcome of a payment attempt.
+type PaymentStatus string
+
+const (
+	// PaymentSucceeded is an operation approved by the provider.
+	PaymentSucceeded PaymentStatus = "succeeded"
+	// PaymentDeclined is an operation the provider declined, e.g. for
+	// insufficient funds.
+	PaymentDeclined PaymentStatus = "declined"
+	// PaymentFailed is an operation the provider could not process.
+	PaymentFailed PaymentStatus = "failed"
+)
+
+// Payment records an operation made with a payment provider for an order,
+// whatever its outcome. Reference is that of the provider, and Error its
+// decline reason or what went wrong.
+type Payment struct {
+	ID        int64         `db:"id"`
+	OrderID   int64         `db:"order_id"`
+	Provider  string        `db:"provider"`
+	Operation string        `db:"operation"`
+	Status    PaymentStatus `db:"status"`
+	Amount    money.Money   `db:"amount"`
+	Currency  string        `db:"currency"`
+	Reference string        `db:"reference"`
+	Error     string        `db:"error"`
+	CreatedAt time.Time     `db:"created_at"`
+}
+
+// setCurrency copies the currency column of a scanned payment into its
+// amount.
+func (p *Payment) setCurrency() {
+	p.Amount.Currency = p.Currency
+}
+
+// WebhookEventStatus is how far a webhook event was processed.
+type WebhookEventStatus string
+
+const (
+	// WebhookEventPending is an event stored but not processed yet.
+	WebhookEventPending WebhookEventStatus = "pending"
+	// WebhookEventProcessed is an event whose change was applied.
+	WebhookEventProcessed WebhookEventStatus = "processed"
+	// WebhookEventFailed is an event that could not be applied and is left to
+	// be replayed.
+	WebhookEventFailed WebhookEventStatus = "failed"
+)
+
+// WebhookEvent is a webhook received from a payment provider. EventID is the
+// ID of the provider, unique per provider, so an event sent twice is only
+// stored once.
+type WebhookEvent struct {
+	ID          int64              `db:"id"`
+	Provider    string             `db:"provider"`
+	EventID     string             `db:"event_id"`
+	Type        string             `db:"type"`
+	Payload     string             `db:"payload"`
+	Status      WebhookEventStatus `db:"status"`
+	Error       string             `db:"error"`
+	Attempts    int64              `db:"attempts"`
+	CreatedAt   time.Time          `db:"created_at"`
+	ProcessedAt *time.Time         `db:"processed_at"`
+}
+
+// PaymentChange is what a webhook event changes: a payment of order OrderID and
+// a refund of it to record, if any, and a transition of the order from one
+// status to another, if To is set.
+type PaymentChange struct {
+	OrderID int64
+	Payment *Payment
+	Refund  *Refund
+	From    OrderStatus
+	To      OrderStatus
+	Actor   string
+}
+
+// RefundReason is why an order is refunded.
+type RefundReason string
+
+const (
+	RefundCustomerRequest RefundReason = "customer_request"
+	RefundDamaged         RefundReason = "damaged"
+	RefundDefective       RefundReason = "defective"
+	RefundWrongItem       RefundReason = "wrong_item"
+	RefundNotDelivered    RefundReason = "not_delivered"
+	RefundDuplicate       RefundReason = "duplicate"
+	RefundFraudulent      RefundReason = "fraudulent"
+	RefundOther           RefundReason = "other"
+)
+
+// Valid reports whether r is one of the known reasons.
+func (r RefundReason) Valid() bool {
+	switch r {
+	case RefundCustomerRequest, RefundDamaged, RefundDefective, RefundWrongItem,
+		RefundNotDelivered, RefundDuplicate, RefundFraudulent, RefundOther:
+		return true
+	default:
+		return false
+	}
+}
+
+// RefundStatus is how far a refund went.
+type RefundStatus string
+
+const (
+	// RefundPending is a refund reserved on its order while the provider is
+	// asked to pay it back.
+	RefundPending RefundStatus = "pending"
+	// RefundSucceeded is a refund given back to the customer.
+	RefundSucceeded RefundStatus = "succeeded"
+	// RefundFailed is a refund the provider did not pay back; it no longer
+	// counts towards the refunded price of its order.
+	RefundFailed RefundStatus = "failed"
+)
+
+// Refund is money given back for an order: Amount in total, of which
+// ShippingAmount for shipping and the rest for the Items. Reference is that
+// of the provider that paid it back, empty for an order not paid through it.
+// The items are put back in stock if Restock is set, once it succeeded.
+type Refund struct {
+	ID             int64        `db:"id"`
+	OrderID        int64        `db:"order_id"`
+	Status         RefundStatus `db:"status"`
+	Amount         money.Money  `db:"amount"`
+	ShippingAmount money.Money  `db:"shipping_amount"`
+	Currency       string       `db:"currency"`
+	Reason         RefundReason `db:"reason"`
+	Note           string       `db:"note"`
+	Restock        bool         `db:"restock"`
+	Reference      string       `db:"reference"`
+	Actor          string       `db:"actor"`
+	CreatedAt      time.Time    `db:"created_at"`
+	Items          []RefundItem
+}
+
+// RefundItem is the part of a refund given back for Quantity units of an
+// order item.
+type RefundItem struct {
+	ID          int64       `db:"id"`
+	RefundID    int64       `db:"refund_id"`
+	OrderItemID int64       `db:"order_item_id"`
+	Quantity    int64       `db:"quantity"`
+	Amount      money.Money `db:"amount"`
+}
+
+// setCurrency copies the currency column of a scanned refund into its amounts
+// and those of its items.
+func (r *Refund) setCurrency() {
+	r.Amount.Currency = r.Currency
+	r.ShippingAmount.Currency = r.Currency
+	for i := range r.Items {
+		r.Items[i].Amount.Currency = r.Currency
+	}
 }
 
 type User struct {
@@ -47,3 +367,34 @@ type User struct {
 	CreatedAt time.Time  `db:"created_at"`
 	UpdatedAt *time.Time `db:"updated_at"`
 }
+
+type Session struct {
+	ID           string    `db:"id"`
+	FamilyID     string    `db:"family_id"`
+	UserEmail    string    `db:"user_email"`
+	RefreshToken string    `db:"refresh_token"`
+	IsRevoked    bool      `db:"is_revoked"`
+	ReplacedBy   *string   `db:"replaced_by"`
+	CreatedAt    time.Time `db:"created_at"`
+	ExpiresAt    time.Time `db:"expires_at"`
+}
+
+// Cart holds the items a shopper intends to order. It belongs to a user, or
+// is anonymous and only known by its ID until it is merged into the cart of
+// the user logging in.
+type Cart struct {
+	ID        string    `db:"id"`
+	UserID    *int64    `db:"user_id"`
+	CreatedAt time.Time `db:"created_at"`
+	Items     []CartItem
+}
+
+// CartItem is a product in a cart. Its price is not stored, carts are always
+// priced from the catalogue.
+type CartItem struct {
+	ID        int64     `db:"id"`
+	CartID    string    `db:"cart_id"`
+	ProductID int64     `db:"product_id"`
+	Quantity  int64     `db:"quantity"`
+	CreatedAt time.Time `db:"created_at"`
+}
@@ -0,0 +1,244 @@
+// Package money represents amounts of money exactly, as integer minor units
+// of an ISO 4217 currency.
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in minor units, e.g. cents, of Currency.
+//
+// Only currencies with two minor digits are supported, as amounts are stored
+// in decimal(10,2) columns. Scan and UnmarshalJSON only set the amount; the
+// currency is kept alongside, e.g. in a currency column.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// minorDigits is the number of digits after the decimal point of an amount.
+const minorDigits = 2
+
+// minorUnits is the number of minor units in a major unit.
+const minorUnits = 100
+
+// MaxAmount is the largest amount a decimal(10,2) column holds.
+const MaxAmount = 99_999_999_99
+
+// currencies lists the supported ISO 4217 codes.
+var currencies = map[string]bool{
+	"AUD": true, "BRL": true, "CAD": true, "CHF": true, "CNY": true, "CZK": true,
+	"DKK": true, "EUR": true, "GBP": true, "HKD": true, "INR": true, "KZT": true,
+	"MXN": true, "NOK": true, "NZD": true, "PLN": true, "RUB": true, "SEK": true,
+	"SGD": true, "TRY": true, "USD": true, "ZAR": true,
+}
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// ErrOverflow is returned when the result of an operation does not fit in an
+// amount.
+var ErrOverflow = errors.New("amount out of range")
+
+// IsCurrency reports whether code is a supported ISO 4217 currency code.
+func IsCurrency(code string) bool {
+	return currencies[code]
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Parse parses a decimal amount with at most two fractional digits, e.g.
+// "12.34" or "-5", without going through floating point.
+func Parse(s, currency string) (Money, error) {
+	amount, err := parseAmount(s)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// FromFloat converts a float amount in major units, rounding half away from
+// zero to the nearest minor unit. It is meant for configuration values, not
+// for arithmetic.
+func FromFloat(f float64, currency string) Money {
+	return Money{Amount: int64(math.Round(f * minorUnits)), Currency: currency}
+}
+
+func parseAmount(s string) (int64, error) {
+	neg := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+
+	whole, frac, hasFrac := strings.Cut(digits, ".")
+	if whole == "" || (hasFrac && frac == "") || len(frac) > minorDigits {
+		return 0, fmt.Errorf("%w %q", ErrInvalidAmount, s)
+	}
+	frac += strings.Repeat("0", minorDigits-len(frac))
+
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("%w %q", ErrInvalidAmount, s)
+		}
+	}
+
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || w > math.MaxInt64/minorUnits-1 {
+		return 0, fmt.Errorf("%w %q", ErrInvalidAmount, s)
+	}
+	f, _ := strconv.ParseInt(frac, 10, 64)
+
+	amount := w*minorUnits + f
+	if neg {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// String formats the amount as a decimal in major units, e.g. "12.34".
+func (m Money) String() string {
+	sign := ""
+	a := m.Amount
+	if a < 0 {
+		sign = "-"
+		a = -a
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, a/minorUnits, a%minorUnits)
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Cmp compares m and o, which must be in the same currency, returning -1, 0
+// or 1.
+func (m Money) Cmp(o Money) int {
+	m.mustMatch(o)
+	switch {
+	case m.Amount < o.Amount:
+		return -1
+	case m.Amount > o.Amount:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Add returns m+o. It panics if the currencies differ, so callers check the
+// currency of amounts from different sources first.
+func (m Money) Add(o Money) Money {
+	m.mustMatch(o)
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}
+}
+
+// Sub returns m-o. It panics if the currencies differ.
+func (m Money) Sub(o Money) Money {
+	m.mustMatch(o)
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}
+}
+
+// Mul returns m times n, e.g. the price of n items. It returns ErrOverflow
+// instead of wrapping around if the result does not fit in an int64.
+func (m Money) Mul(n int64) (Money, error) {
+	p := m.Amount * n
+	if m.Amount != 0 && (p/m.Amount != n || (m.Amount == -1 && n == math.MinInt64)) {
+		return Money{}, fmt.Errorf("%s times %d: %w", m, n, ErrOverflow)
+	}
+	return Money{Amount: p, Currency: m.Currency}, nil
+}
+
+// MulRate returns m times rate rounded half away from zero to the nearest
+// minor unit, as taxes are. The rate is taken to millionths so the product is
+// computed in integers, exactly as it may not fit in an int64; rates are
+// below 1, so the result does.
+func (m Money) MulRate(rate float64) Money {
+	const scale = 1_000_000
+	ppm := int64(math.Round(rate * scale))
+
+	p := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(ppm))
+	q, r := new(big.Int).QuoRem(p, big.NewInt(scale), new(big.Int))
+	if r.Int64() >= scale/2 {
+		q.Add(q, big.NewInt(1))
+	} else if r.Int64() <= -scale/2 {
+		q.Sub(q, big.NewInt(1))
+	}
+	return Money{Amount: q.Int64(), Currency: m.Currency}
+}
+
+func (m Money) mustMatch(o Money) {
+	if m.Currency != o.Currency {
+		panic(fmt.Sprintf("money: mismatched currencies %q and %q", m.Currency, o.Currency))
+	}
+}
+
+// MarshalJSON encodes the amount as a decimal string, so clients do not parse
+// it into a float by accident.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts a decimal string or a JSON number and sets the amount
+// only, parsing its text exactly.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	}
+
+	amount, err := parseAmount(s)
+	if err != nil {
+		return err
+	}
+	m.Amount = amount
+	return nil
+}
+
+// Value stores the amount as a decimal string for decimal(10,2) columns.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan reads the amount of a decimal column, which drivers return as text or,
+// for SQLite, as a number.
+func (m *Money) Scan(src interface{}) error {
+	var amount int64
+	var err error
+	switch v := src.(type) {
+	case []byte:
+		amount, err = parseDecimal(string(v))
+	case string:
+		amount, err = parseDecimal(v)
+	case float64:
+		amount = int64(math.Round(v * minorUnits))
+	case int64:
+		amount = v * minorUnits
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+	if err != nil {
+		return err
+	}
+
+	m.Amount = amount
+	return nil
+}
+
+// parseDecimal parses a decimal column value, which may carry more trailing
+// zeros than minorDigits.
+func parseDecimal(s string) (int64, error) {
+	if whole, frac, ok := strings.Cut(s, "."); ok && len(frac) > minorDigits {
+		if strings.Trim(frac[minorDigits:], "0") != "" {
+			return 0, fmt.Errorf("%w %q", ErrInvalidAmount, s)
+		}
+		s = whole + "." + frac[:minorDigits]
+	}
+	return parseAmount(s)
+}
@@ -0,0 +1,155 @@
+package money
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tcs := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{in: "12.34", want: 1234},
+		{in: "12.3", want: 1230},
+		{in: "12", want: 1200},
+		{in: "0.01", want: 1},
+		{in: "-5.50", want: -550},
+		{in: "12.345", err: true},
+		{in: "12.", err: true},
+		{in: ".5", err: true},
+		{in: "1e3", err: true},
+		{in: "", err: true},
+		{in: "99999999999999999999", err: true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.in, func(t *testing.T) {
+			m, err := Parse(tc.in, "USD")
+			if tc.err {
+				require.ErrorIs(t, err, ErrInvalidAmount)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, New(tc.want, "USD"), m)
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	require.Equal(t, "12.34", New(1234, "USD").String())
+	require.Equal(t, "0.05", New(5, "USD").String())
+	require.Equal(t, "-0.50", New(-50, "USD").String())
+}
+
+func TestMulRate(t *testing.T) {
+	tcs := []struct {
+		name   string
+		amount int64
+		rate   float64
+		want   int64
+	}{
+		{name: "exact", amount: 2000, rate: 0.15, want: 300},
+		{name: "rounds half up", amount: 2997, rate: 0.15, want: 450},
+		{name: "rounds down", amount: 1001, rate: 0.15, want: 150},
+		// 50*0.29 is 14.499999999999998 in floating point
+		{name: "no float drift", amount: 50, rate: 0.29, want: 15},
+		{name: "negative rounds away from zero", amount: -2997, rate: 0.15, want: -450},
+		{name: "large", amount: math.MaxInt64 / 10, rate: 0.15, want: 138350580552821637},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, New(tc.want, "EUR"), New(tc.amount, "EUR").MulRate(tc.rate))
+		})
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	a, b := New(1050, "USD"), New(250, "USD")
+	require.Equal(t, New(1300, "USD"), a.Add(b))
+	require.Equal(t, New(800, "USD"), a.Sub(b))
+	m, err := a.Mul(3)
+	require.NoError(t, err)
+	require.Equal(t, New(3150, "USD"), m)
+	require.Equal(t, 1, a.Cmp(b))
+
+	require.Panics(t, func() { a.Add(New(1, "EUR")) })
+}
+
+func TestMulOverflow(t *testing.T) {
+	tcs := []struct {
+		name   string
+		amount int64
+		n      int64
+	}{
+		{name: "positive", amount: 1000, n: 1 << 62},
+		{name: "negative", amount: -1000, n: 1 << 62},
+		{name: "min int", amount: -1, n: math.MinInt64},
+		{name: "min amount", amount: math.MinInt64, n: -1},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(tc.amount, "USD").Mul(tc.n)
+			require.ErrorIs(t, err, ErrOverflow)
+		})
+	}
+
+	m, err := New(math.MaxInt64, "USD").Mul(1)
+	require.NoError(t, err)
+	require.Equal(t, int64(math.MaxInt64), m.Amount)
+	m, err = New(0, "USD").Mul(math.MinInt64)
+	require.NoError(t, err)
+	require.Zero(t, m.Amount)
+}
+
+func TestJSON(t *testing.T) {
+	b, err := json.Marshal(struct {
+		Price Money `json:"price"`
+	}{New(1999, "USD")})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"price": "19.99"}`, string(b))
+
+	for _, in := range []string{`"19.99"`, `19.99`} {
+		var m Money
+		require.NoError(t, json.Unmarshal([]byte(in), &m))
+		require.Equal(t, int64(1999), m.Amount)
+	}
+
+	var m Money
+	require.Error(t, json.Unmarshal([]byte(`"19.999"`), &m))
+}
+
+func TestScan(t *testing.T) {
+	tcs := []struct {
+		name string
+		src  interface{}
+		want int64
+	}{
+		{name: "mysql decimal", src: []byte("19.99"), want: 1999},
+		{name: "wide decimal", src: "19.9900", want: 1999},
+		{name: "sqlite real", src: 19.99, want: 1999},
+		{name: "sqlite integer", src: int64(20), want: 2000},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var m Money
+			require.NoError(t, m.Scan(tc.src))
+			require.Equal(t, tc.want, m.Amount)
+
+			v, err := m.Value()
+			require.NoError(t, err)
+			require.Equal(t, New(tc.want, "").String(), v)
+		})
+	}
+
+	var m Money
+	require.Error(t, m.Scan("19.995"))
+	require.Error(t, m.Scan(true))
+}
@@ -0,0 +1,179 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/google/uuid"
+)
+
+// FakeGatewayName is the name of the fake gateway.
+const FakeGatewayName = "fake"
+
+// DefaultDeclineCards are the card numbers the fake gateway declines unless
+// configured otherwise, and why.
+var DefaultDeclineCards = map[string]string{
+	"4000000000000002": "card_declined",
+	"4000000000009995": "insufficient_funds",
+	"4000000000000069": "expired_card",
+}
+
+// FakeGateway is a deterministic in-memory Gateway for tests and local runs.
+// Any well formed card number is approved except the decline cards. The
+// payments are kept in memory only, but references are random so that they
+// do not collide with the ones stored before a restart.
+type FakeGateway struct {
+	mu sync.Mutex
+
+	declineCards map[string]string
+	payments     map[string]*fakePayment
+}
+
+type fakePayment struct {
+	authorized money.Money
+	captured   money.Money
+	refunded   money.Money
+	voided     bool
+}
+
+// NewFakeGateway returns a fake gateway declining the given card numbers, or
+// DefaultDeclineCards if there are none. A card can be given as
+// "number:reason" to be declined with that reason rather than
+// "card_declined".
+func NewFakeGateway(declineCards []string) *FakeGateway {
+	cards := DefaultDeclineCards
+	if len(declineCards) > 0 {
+		cards = make(map[string]string, len(declineCards))
+		for _, c := range declineCards {
+			number, reason, ok := strings.Cut(c, ":")
+			if !ok {
+				reason = "card_declined"
+			}
+			cards[strings.TrimSpace(number)] = strings.TrimSpace(reason)
+		}
+	}
+
+	return &FakeGateway{
+		declineCards: cards,
+		payments:     make(map[string]*fakePayment),
+	}
+}
+
+func (g *FakeGateway) Name() string {
+	return FakeGatewayName
+}
+
+func (g *FakeGateway) Authorize(_ context.Context, req AuthorizeRequest) (*Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	res := &Result{Reference: g.nextRef()}
+	switch {
+	case !isCardNumber(req.Source):
+		res.DeclineReason = "invalid_number"
+	case req.Amount.Amount <= 0:
+		res.DeclineReason = "invalid_amount"
+	case g.declineCards[req.Source] != "":
+		res.DeclineReason = g.declineCards[req.Source]
+	default:
+		res.Approved = true
+		g.payments[res.Reference] = &fakePayment{
+			authorized: req.Amount,
+			captured:   money.New(0, req.Amount.Currency),
+			refunded:   money.New(0, req.Amount.Currency),
+		}
+	}
+
+	return res, nil
+}
+
+func (g *FakeGateway) Capture(_ context.Context, reference string, amount money.Money) (*Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[reference]
+	if !ok {
+		return nil, fmt.Errorf("error capturing payment: unknown reference %q", reference)
+	}
+
+	res := &Result{Reference: g.nextRef()}
+	switch {
+	case p.voided:
+		res.DeclineReason = "authorization_voided"
+	case !p.captured.IsZero():
+		res.DeclineReason = "already_captured"
+	case amount.Currency != p.authorized.Currency || amount.Amount <= 0 || amount.Cmp(p.authorized) > 0:
+		res.DeclineReason = "invalid_amount"
+	default:
+		res.Approved = true
+		p.captured = amount
+	}
+
+	return res, nil
+}
+
+func (g *FakeGateway) Void(_ context.Context, reference string) (*Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[reference]
+	if !ok {
+		return nil, fmt.Errorf("error voiding payment: unknown reference %q", reference)
+	}
+
+	res := &Result{Reference: g.nextRef()}
+	switch {
+	case p.voided:
+		res.DeclineReason = "authorization_voided"
+	case !p.captured.IsZero():
+		res.DeclineReason = "already_captured"
+	default:
+		res.Approved = true
+		p.voided = true
+	}
+
+	return res, nil
+}
+
+func (g *FakeGateway) Refund(_ context.Context, reference string, amount money.Money) (*Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[reference]
+	if !ok {
+		return nil, fmt.Errorf("error refunding payment: unknown reference %q", reference)
+	}
+
+	res := &Result{Reference: g.nextRef()}
+	switch {
+	case p.captured.IsZero():
+		res.DeclineReason = "not_captured"
+	case amount.Currency != p.captured.Currency || amount.Amount <= 0 || p.refunded.Add(amount).Cmp(p.captured) > 0:
+		res.DeclineReason = "invalid_amount"
+	default:
+		res.Approved = true
+		p.refunded = p.refunded.Add(amount)
+	}
+
+	return res, nil
+}
+
+func (g *FakeGateway) nextRef() string {
+	return "fake_" + uuid.NewString()
+}
+
+// isCardNumber reports whether s looks like a card number: 12 to 19 digits.
+func isCardNumber(s string) bool {
+	if len(s) < 12 || len(s) > 19 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package payments
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gauss2302/ecomm-service/money"
+	"github.com/stretchr/testify/require"
+)
+
+func usd(amount int64) money.Money {
+	return money.New(amount, "USD")
+}
+
+func TestFakeGatewayAuthorize(t *testing.T) {
+	tcs := []struct {
+		name         string
+		declineCards []string
+		source       string
+		amount       money.Money
+		reason       string
+	}{
+		{name: "approved", source: "4242424242424242", amount: usd(1000)},
+		{name: "default decline card", source: "4000000000009995", amount: usd(1000), reason: "insufficient_funds"},
+		{name: "configured decline card", declineCards: []string{"4242424242424242"}, source: "4242424242424242", amount: usd(1000), reason: "card_declined"},
+		{name: "configured decline reason", declineCards: []string{" 4111111111111111 : stolen_card "}, source: "4111111111111111", amount: usd(1000), reason: "stolen_card"},
+		{name: "configured cards replace the defaults", declineCards: []string{"4111111111111111"}, source: "4000000000009995", amount: usd(1000)},
+		{name: "not a card number", source: "4242-4242", amount: usd(1000), reason: "invalid_number"},
+		{name: "zero amount", source: "4242424242424242", amount: usd(0), reason: "invalid_amount"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewFakeGateway(tc.declineCards)
+			res, err := g.Authorize(context.Background(), AuthorizeRequest{OrderID: 1, Amount: tc.amount, Source: tc.source})
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(res.Reference, "fake_"))
+			require.Equal(t, tc.reason == "", res.Approved)
+			require.Equal(t, tc.reason, res.DeclineReason)
+		})
+	}
+}
+
+func TestFakeGatewayLifecycle(t *testing.T) {
+	ctx := context.Background()
+	g := NewFakeGateway(nil)
+
+	authorize := func(t *testing.T) string {
+		res, err := g.Authorize(ctx, AuthorizeRequest{OrderID: 1, Amount: usd(1000), Source: "4242424242424242"})
+		require.NoError(t, err)
+		require.True(t, res.Approved)
+		return res.Reference
+	}
+
+	requireResult := func(t *testing.T, res *Result, err error, reason string) {
+		t.Helper()
+		require.NoError(t, err)
+		require.Equal(t, reason == "", res.Approved)
+		require.Equal(t, reason, res.DeclineReason)
+	}
+
+	t.Run("capture and refund", func(t *testing.T) {
+		ref := authorize(t)
+
+		res, err := g.Refund(ctx, ref, usd(100))
+		requireResult(t, res, err, "not_captured")
+		res, err = g.Capture(ctx, ref, usd(1001))
+		requireResult(t, res, err, "invalid_amount")
+		res, err = g.Capture(ctx, ref, usd(1000))
+		requireResult(t, res, err, "")
+		res, err = g.Capture(ctx, ref, usd(1000))
+		requireResult(t, res, err, "already_captured")
+		res, err = g.Void(ctx, ref)
+		requireResult(t, res, err, "already_captured")
+
+		res, err = g.Refund(ctx, ref, usd(600))
+		requireResult(t, res, err, "")
+		res, err = g.Refund(ctx, ref, usd(500))
+		requireResult(t, res, err, "invalid_amount")
+		res, err = g.Refund(ctx, ref, usd(400))
+		requireResult(t, res, err, "")
+	})
+
+	t.Run("void", func(t *testing.T) {
+		ref := authorize(t)
+
+		res, err := g.Void(ctx, ref)
+		requireResult(t, res, err, "")
+		res, err = g.Void(ctx, ref)
+		requireResult(t, res, err, "authorization_voided")
+		res, err = g.Capture(ctx, ref, usd(1000))
+		requireResult(t, res, err, "authorization_voided")
+	})
+
+	t.Run("references survive a restart", func(t *testing.T) {
+		ref := authorize(t)
+
+		// a new gateway stands for the process after a restart
+		res, err := NewFakeGateway(nil).Authorize(ctx, AuthorizeRequest{OrderID: 2, Amount: usd(1000), Source: "4242424242424242"})
+		require.NoError(t, err)
+		require.NotEqual(t, ref, res.Reference)
+	})
+
+	t.Run("unknown reference", func(t *testing.T) {
+		_, err := g.Capture(ctx, "fake_999", usd(1000))
+		require.Error(t, err)
+		_, err = g.Void(ctx, "fake_999")
+		require.Error(t, err)
+		_, err = g.Refund(ctx, "fake_999", usd(1000))
+		require.Error(t, err)
+	})
+}
+
+func TestNewGateway(t *testing.T) {
+	g, err := NewGateway("fake", nil)
+	require.NoError(t, err)
+	require.Equal(t, FakeGatewayName, g.Name())
+
+	_, err = NewGateway("paypal", nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,90 @@
+// Package payments abstracts the payment providers orders are paid through.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gauss2302/ecomm-service/money"
+)
+
+// Gateway is a payment provider. Payments are authorized first, which
+// reserves the amount, and then captured, which collects it; an authorization
+// that is not captured is voided, and a captured payment is refunded.
+//
+// A provider declining an operation is not an error: the Result says so. The
+// error is for operations the provider could not process at all.
+type Gateway interface {
+	// Name identifies the provider in the recorded payments.
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeRequest) (*Result, error)
+	// Capture collects amount, at most the authorized amount, of the
+	// authorization reference.
+	Capture(ctx context.Context, reference string, amount money.Money) (*Result, error)
+	Void(ctx context.Context, reference string) (*Result, error)
+	// Refund returns amount, at most what is left of the captured amount, of
+	// the payment reference.
+	Refund(ctx context.Context, reference string, amount money.Money) (*Result, error)
+}
+
+var _ Gateway = (*FakeGateway)(nil)
+
+type AuthorizeRequest struct {
+	OrderID int64
+	Amount  money.Money
+	// Source is what the customer pays with: a card number for the fake
+	// gateway, or a token from the client SDK of a real provider. It is
+	// never stored.
+	Source string
+}
+
+// Result is the outcome of an operation processed by the provider.
+type Result struct {
+	// Reference identifies the operation at the provider. That of an
+	// authorization names the payment in later operations.
+	Reference string
+	Approved  bool
+	// DeclineReason is the code of the provider for a declined operation,
+	// e.g. "insufficient_funds".
+	DeclineReason string
+}
+
+// Operation is one of the operations of a Gateway, as recorded with each
+// payment attempt.
+type Operation string
+
+const (
+	OperationAuthorize Operation = "authorize"
+	OperationCapture   Operation = "capture"
+	OperationVoid      Operation = "void"
+	OperationRefund    Operation = "refund"
+)
+
+// ErrDeclined is returned when a payment is declined by the provider.
+var ErrDeclined = errors.New("payment declined")
+
+// DeclinedError is returned when a payment is declined by the provider. It
+// matches ErrDeclined, and Reason is meant for the client.
+type DeclinedError struct {
+	Reason string
+}
+
+func (e *DeclinedError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrDeclined, e.Reason)
+}
+
+func (e *DeclinedError) Is(target error) bool {
+	return target == ErrDeclined
+}
+
+// NewGateway returns the gateway of the named provider. Only the fake
+// gateway, declining the given card numbers, is built in.
+func NewGateway(name string, declineCards []string) (Gateway, error) {
+	switch name {
+	case FakeGatewayName:
+		return NewFakeGateway(declineCards), nil
+	default:
+		return nil, fmt.Errorf("unknown payment gateway %q", name)
+	}
+}
@@ -0,0 +1,122 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gauss2302/ecomm-service/money"
+)
+
+// SignatureHeader is the header providers send the signature of a webhook in.
+const SignatureHeader = "X-Payment-Signature"
+
+// ErrInvalidSignature is returned for a webhook whose signature is missing,
+// does not match its payload or is too old.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// SignWebhook returns the signature header of payload sent at t, as
+// "t=<unix time>,v1=<signature>". The signature is the hex encoded
+// HMAC-SHA256 of "<unix time>.<payload>" keyed with secret, so the time cannot
+// be changed without invalidating it.
+func SignWebhook(secret, payload []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, webhookSignature(secret, ts, payload))
+}
+
+// VerifyWebhook checks that header, as made by SignWebhook, signs payload
+// with secret and was made within tolerance of now, which keeps captured
+// webhooks from being replayed later. Several v1 signatures may be given,
+// e.g. while the secret is rotated; one matching is enough.
+func VerifyWebhook(secret []byte, header string, payload []byte, now time.Time, tolerance time.Duration) error {
+	if len(secret) == 0 {
+		return fmt.Errorf("%w: no webhook secret is configured", ErrInvalidSignature)
+	}
+
+	var ts string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing timestamp", ErrInvalidSignature)
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+		return fmt.Errorf("%w: timestamp outside the tolerance", ErrInvalidSignature)
+	}
+
+	want := webhookSignature(secret, ts, payload)
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(want)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: signature does not match", ErrInvalidSignature)
+}
+
+func webhookSignature(secret []byte, ts string, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EventType is what happened to a payment at the provider.
+type EventType string
+
+const (
+	// EventPaymentCaptured is sent when an authorization is captured.
+	EventPaymentCaptured EventType = "payment.captured"
+	// EventPaymentFailed is sent when a capture fails after the fact.
+	EventPaymentFailed EventType = "payment.failed"
+	// EventPaymentVoided is sent when an authorization is voided.
+	EventPaymentVoided EventType = "payment.voided"
+	// EventPaymentRefunded is sent when a captured payment is refunded.
+	EventPaymentRefunded EventType = "payment.refunded"
+)
+
+// Event is the payload of a payment webhook. Providers send every event at
+// least once, so an event may arrive several times with the same ID.
+type Event struct {
+	ID   string    `json:"id"`
+	Type EventType `json:"type"`
+	// Reference is that of the authorization of the payment, as returned by
+	// Gateway.Authorize.
+	Reference string      `json:"reference"`
+	Amount    money.Money `json:"amount"`
+	Currency  string      `json:"currency"`
+	// Reason is the decline reason of a failed payment.
+	Reason string `json:"reason"`
+	// RefundReference is that of the refund of a payment.refunded event, as
+	// returned by Gateway.Refund for refunds made through the API.
+	RefundReference string `json:"refund_reference"`
+}
+
+// ParseEvent decodes the payload of a payment webhook.
+func ParseEvent(payload []byte) (*Event, error) {
+	var e Event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, fmt.Errorf("error decoding event: %w", err)
+	}
+	if e.ID == "" || e.Type == "" {
+		return nil, errors.New("error decoding event: id and type are required")
+	}
+	e.Amount.Currency = e.Currency
+
+	return &e, nil
+}
@@ -0,0 +1,55 @@
+package payments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	secret := []byte("secret")
+	payload := []byte(`{"id":"evt_1","type":"payment.captured"}`)
+	now := time.Unix(1733400000, 0)
+
+	tcs := []struct {
+		name    string
+		secret  []byte
+		header  string
+		payload []byte
+		wantErr bool
+	}{
+		{name: "valid", secret: secret, header: SignWebhook(secret, payload, now), payload: payload},
+		{name: "within tolerance", secret: secret, header: SignWebhook(secret, payload, now.Add(-4*time.Minute)), payload: payload},
+		{name: "one of several signatures", secret: secret, header: SignWebhook(secret, payload, now) + ",v1=00ff", payload: payload},
+		{name: "too old", secret: secret, header: SignWebhook(secret, payload, now.Add(-6*time.Minute)), payload: payload, wantErr: true},
+		{name: "from the future", secret: secret, header: SignWebhook(secret, payload, now.Add(6*time.Minute)), payload: payload, wantErr: true},
+		{name: "other secret", secret: secret, header: SignWebhook([]byte("other"), payload, now), payload: payload, wantErr: true},
+		{name: "tampered payload", secret: secret, header: SignWebhook(secret, payload, now), payload: []byte(`{"id":"evt_2","type":"payment.captured"}`), wantErr: true},
+		{name: "no timestamp", secret: secret, header: "v1=00ff", payload: payload, wantErr: true},
+		{name: "no header", secret: secret, payload: payload, wantErr: true},
+		{name: "no secret", header: SignWebhook(nil, payload, now), payload: payload, wantErr: true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifyWebhook(tc.secret, tc.header, tc.payload, now, 5*time.Minute)
+			if tc.wantErr {
+				require.ErrorIs(t, err, ErrInvalidSignature)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	e, err := ParseEvent([]byte(`{"id":"evt_1","type":"payment.captured","reference":"fake_1","amount":"10.50","currency":"USD"}`))
+	require.NoError(t, err)
+	require.Equal(t, &Event{ID: "evt_1", Type: EventPaymentCaptured, Reference: "fake_1", Amount: usd(1050), Currency: "USD"}, e)
+
+	_, err = ParseEvent([]byte(`{"type":"payment.captured"}`))
+	require.Error(t, err)
+	_, err = ParseEvent([]byte(`not json`))
+	require.Error(t, err)
+}
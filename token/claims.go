@@ -7,14 +7,24 @@ import (
 	"time"
 )
 
+// TokenType tells access tokens, which authenticate requests, from refresh
+// tokens, which are only exchanged for new tokens.
+type TokenType string
+
+const (
+	AccessToken  TokenType = "access"
+	RefreshToken TokenType = "refresh"
+)
+
 type UserClaims struct {
-	ID      int64  `json:"id"`
-	Email   string `json:"email"`
-	IsAdmin bool   `json:"is_admin"`
+	ID      int64     `json:"id"`
+	Email   string    `json:"email"`
+	IsAdmin bool      `json:"is_admin"`
+	Type    TokenType `json:"typ"`
 	jwt.RegisteredClaims
 }
 
-func NewUserClaims(id int64, email string, isAdmin bool, duration time.Duration) (*UserClaims, error) {
+func NewUserClaims(id int64, email string, isAdmin bool, tokenType TokenType, duration time.Duration) (*UserClaims, error) {
 	tokenId, err := uuid.NewRandom()
 
 	if err != nil {
@@ -25,6 +35,7 @@ func NewUserClaims(id int64, email string, isAdmin bool, duration time.Duration)
 		Email:   email,
 		ID:      id,
 		IsAdmin: isAdmin,
+		Type:    tokenType,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ID:        tokenId.String(),
 			Subject:   email,
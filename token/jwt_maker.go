@@ -1,21 +1,28 @@
 package token
 
 import (
+	"context"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"time"
 )
 
 type JWTMaker struct {
-	secretKey string
+	secretKey   string
+	revocations RevocationStore
 }
 
-func NewJWTMaker(secretKey string) *JWTMaker {
-	return &JWTMaker{secretKey}
+// NewJWTMaker creates a maker signing tokens with secretKey. If revocations is
+// nil, tokens are valid until they expire.
+func NewJWTMaker(secretKey string, revocations RevocationStore) *JWTMaker {
+	return &JWTMaker{
+		secretKey:   secretKey,
+		revocations: revocations,
+	}
 }
 
-func (maker *JWTMaker) CreateToken(id int64, email string, isAdmin bool, duration time.Duration) (string, *UserClaims, error) {
-	claims, err := NewUserClaims(id, email, isAdmin, duration)
+func (maker *JWTMaker) CreateToken(id int64, email string, isAdmin bool, tokenType TokenType, duration time.Duration) (string, *UserClaims, error) {
+	claims, err := NewUserClaims(id, email, isAdmin, tokenType, duration)
 
 	if err != nil {
 		return "", nil, err
@@ -32,7 +39,7 @@ func (maker *JWTMaker) CreateToken(id int64, email string, isAdmin bool, duratio
 
 }
 
-func (maker *JWTMaker) VerifyToken(tokenStr string) (*UserClaims, error) {
+func (maker *JWTMaker) VerifyToken(ctx context.Context, tokenStr string) (*UserClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenStr, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
 		// Check the signing method
 		_, ok := token.Method.(*jwt.SigningMethodHMAC)
@@ -53,5 +60,19 @@ func (maker *JWTMaker) VerifyToken(tokenStr string) (*UserClaims, error) {
 		return nil, fmt.Errorf("could not parse claims")
 	}
 
+	if err := checkRevoked(ctx, maker.revocations, claims); err != nil {
+		return nil, err
+	}
+
 	return claims, nil
 }
+
+// RevokeToken denylists the token described by claims until it expires.
+func (maker *JWTMaker) RevokeToken(ctx context.Context, claims *UserClaims) error {
+	return revokeToken(ctx, maker.revocations, claims)
+}
+
+// JWKS returns an empty key set since HMAC secrets are never published.
+func (maker *JWTMaker) JWKS() JWKS {
+	return JWKS{Keys: []JWK{}}
+}
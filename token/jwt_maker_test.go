@@ -0,0 +1,54 @@
+package token
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyToken(t *testing.T) {
+	store := NewMemoryRevocationStore()
+	maker := NewJWTMaker("test secret key", store)
+
+	tokenStr, claims, err := maker.CreateToken(1, "test@example.com", true, AccessToken, time.Minute)
+	require.NoError(t, err)
+
+	vc, err := maker.VerifyToken(context.Background(), tokenStr)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), vc.ID)
+	require.Equal(t, "test@example.com", vc.Email)
+	require.True(t, vc.IsAdmin)
+
+	err = maker.RevokeToken(context.Background(), claims)
+	require.NoError(t, err)
+
+	_, err = maker.VerifyToken(context.Background(), tokenStr)
+	require.ErrorIs(t, err, ErrTokenRevoked)
+
+	other := NewJWTMaker("other secret key", nil)
+	_, err = other.VerifyToken(context.Background(), tokenStr)
+	require.Error(t, err)
+}
+
+func TestMemoryRevocationStorePurge(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryRevocationStore()
+	now := time.Now()
+
+	require.NoError(t, store.RevokeToken(ctx, "expired", now.Add(-time.Minute)))
+	require.NoError(t, store.RevokeToken(ctx, "active", now.Add(time.Minute)))
+
+	n, err := store.PurgeRevokedTokens(ctx, now)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	revoked, err := store.IsTokenRevoked(ctx, "expired")
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	revoked, err = store.IsTokenRevoked(ctx, "active")
+	require.NoError(t, err)
+	require.True(t, revoked)
+}
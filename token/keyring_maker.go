@@ -0,0 +1,169 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeyRingMaker signs tokens with its active key and verifies them with any key
+// of the ring, selected by the kid header. Keys replaced by Rotate remain
+// valid for verification until the grace period has elapsed.
+type KeyRingMaker struct {
+	mu          sync.RWMutex
+	active      *Key
+	keys        map[string]*Key
+	retiredAt   map[string]time.Time
+	gracePeriod time.Duration
+	revocations RevocationStore
+}
+
+func NewKeyRingMaker(active *Key, gracePeriod time.Duration, revocations RevocationStore) *KeyRingMaker {
+	return &KeyRingMaker{
+		active:      active,
+		keys:        map[string]*Key{active.ID: active},
+		retiredAt:   make(map[string]time.Time),
+		gracePeriod: gracePeriod,
+		revocations: revocations,
+	}
+}
+
+// Rotate makes next the signing key and retires the current one.
+func (maker *KeyRingMaker) Rotate(next *Key) {
+	maker.mu.Lock()
+	defer maker.mu.Unlock()
+
+	maker.retiredAt[maker.active.ID] = time.Now()
+	maker.keys[next.ID] = next
+	delete(maker.retiredAt, next.ID)
+	maker.active = next
+	maker.pruneLocked()
+}
+
+// AddRetiredKey adds a key that is only accepted for verification, as if it
+// had been rotated out at retiredAt.
+func (maker *KeyRingMaker) AddRetiredKey(key *Key, retiredAt time.Time) {
+	maker.mu.Lock()
+	defer maker.mu.Unlock()
+
+	if key.ID == maker.active.ID {
+		return
+	}
+	maker.keys[key.ID] = key
+	maker.retiredAt[key.ID] = retiredAt
+	maker.pruneLocked()
+}
+
+func (maker *KeyRingMaker) CreateToken(id int64, email string, isAdmin bool, tokenType TokenType, duration time.Duration) (string, *UserClaims, error) {
+	claims, err := NewUserClaims(id, email, isAdmin, tokenType, duration)
+	if err != nil {
+		return "", nil, err
+	}
+
+	maker.mu.RLock()
+	key := maker.active
+	maker.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+
+	tokenStr, err := token.SignedString(key.signKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("error signing token: %w", err)
+	}
+
+	return tokenStr, claims, nil
+}
+
+func (maker *KeyRingMaker) VerifyToken(ctx context.Context, tokenStr string) (*UserClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("missing kid header")
+		}
+
+		key, err := maker.verificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		// Check the signing method matches the key
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.verifyKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error parsing token: %w", err)
+	}
+
+	claims, ok := token.Claims.(*UserClaims)
+	if !ok {
+		return nil, fmt.Errorf("could not parse claims")
+	}
+
+	if err := checkRevoked(ctx, maker.revocations, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (maker *KeyRingMaker) RevokeToken(ctx context.Context, claims *UserClaims) error {
+	return revokeToken(ctx, maker.revocations, claims)
+}
+
+// JWKS returns the public keys of the ring that are still accepted for
+// verification. HMAC keys are never included.
+func (maker *KeyRingMaker) JWKS() JWKS {
+	maker.mu.RLock()
+	defer maker.mu.RUnlock()
+
+	jwks := JWKS{Keys: []JWK{}}
+	for id, key := range maker.keys {
+		if maker.expiredLocked(id, time.Now()) {
+			continue
+		}
+		if jwk, ok := key.publicJWK(); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+
+	return jwks
+}
+
+func (maker *KeyRingMaker) verificationKey(kid string) (*Key, error) {
+	maker.mu.RLock()
+	defer maker.mu.RUnlock()
+
+	key, ok := maker.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if maker.expiredLocked(kid, time.Now()) {
+		return nil, fmt.Errorf("key %q is no longer valid", kid)
+	}
+
+	return key, nil
+}
+
+func (maker *KeyRingMaker) expiredLocked(kid string, now time.Time) bool {
+	retiredAt, ok := maker.retiredAt[kid]
+	return ok && now.After(retiredAt.Add(maker.gracePeriod))
+}
+
+// pruneLocked drops retired keys whose grace period has elapsed.
+func (maker *KeyRingMaker) pruneLocked() {
+	now := time.Now()
+	for kid := range maker.retiredAt {
+		if maker.expiredLocked(kid, now) {
+			delete(maker.keys, kid)
+			delete(maker.retiredAt, kid)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package token
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEd25519Key(t *testing.T, id string) *Key {
+	_, pk, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return NewEd25519Key(id, pk)
+}
+
+func TestKeyRingMakerRotation(t *testing.T) {
+	ctx := context.Background()
+	maker := NewKeyRingMaker(newTestEd25519Key(t, "k1"), time.Hour, nil)
+
+	oldToken, _, err := maker.CreateToken(1, "test@example.com", false, AccessToken, time.Minute)
+	require.NoError(t, err)
+
+	maker.Rotate(newTestEd25519Key(t, "k2"))
+
+	newToken, _, err := maker.CreateToken(1, "test@example.com", false, AccessToken, time.Minute)
+	require.NoError(t, err)
+
+	// tokens signed by the retired key are still accepted during the grace period
+	_, err = maker.VerifyToken(ctx, oldToken)
+	require.NoError(t, err)
+	_, err = maker.VerifyToken(ctx, newToken)
+	require.NoError(t, err)
+
+	jwks := maker.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	require.Equal(t, "k1", jwks.Keys[0].Kid)
+	require.Equal(t, "OKP", jwks.Keys[0].Kty)
+	require.Equal(t, "EdDSA", jwks.Keys[0].Alg)
+
+	// once the grace period elapsed the retired key is dropped
+	expired := NewKeyRingMaker(newTestEd25519Key(t, "k3"), 0, nil)
+	expiredToken, _, err := expired.CreateToken(1, "test@example.com", false, AccessToken, time.Minute)
+	require.NoError(t, err)
+	expired.Rotate(newTestEd25519Key(t, "k4"))
+
+	_, err = expired.VerifyToken(ctx, expiredToken)
+	require.Error(t, err)
+	require.Len(t, expired.JWKS().Keys, 1)
+}
+
+func TestKeyRingMakerRejectsForeignTokens(t *testing.T) {
+	ctx := context.Background()
+	maker := NewKeyRingMaker(NewHMACKey("hmac", []byte("secret")), time.Hour, nil)
+	require.Empty(t, maker.JWKS().Keys)
+
+	// same kid, different key
+	other := NewKeyRingMaker(newTestEd25519Key(t, "hmac"), time.Hour, nil)
+	tokenStr, _, err := other.CreateToken(1, "test@example.com", false, AccessToken, time.Minute)
+	require.NoError(t, err)
+
+	_, err = maker.VerifyToken(ctx, tokenStr)
+	require.Error(t, err)
+
+	// tokens without a kid header
+	legacy := NewJWTMaker("secret", nil)
+	tokenStr, _, err = legacy.CreateToken(1, "test@example.com", false, AccessToken, time.Minute)
+	require.NoError(t, err)
+
+	_, err = maker.VerifyToken(ctx, tokenStr)
+	require.Error(t, err)
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+
+	key, err := ParsePrivateKeyPEM("rsa", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+
+	maker := NewKeyRingMaker(key, time.Hour, nil)
+	tokenStr, _, err := maker.CreateToken(1, "test@example.com", true, AccessToken, time.Minute)
+	require.NoError(t, err)
+
+	claims, err := maker.VerifyToken(context.Background(), tokenStr)
+	require.NoError(t, err)
+	require.True(t, claims.IsAdmin)
+
+	jwks := maker.JWKS()
+	require.Len(t, jwks.Keys, 1)
+	require.Equal(t, "RSA", jwks.Keys[0].Kty)
+	require.Equal(t, "AQAB", jwks.Keys[0].E)
+
+	_, err = ParsePrivateKeyPEM("bad", []byte("not a key"))
+	require.Error(t, err)
+}
@@ -0,0 +1,49 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Maker creates and verifies user access and refresh tokens. VerifyToken does
+// not check the type of the token, callers do.
+type Maker interface {
+	CreateToken(id int64, email string, isAdmin bool, tokenType TokenType, duration time.Duration) (string, *UserClaims, error)
+	VerifyToken(ctx context.Context, tokenStr string) (*UserClaims, error)
+	RevokeToken(ctx context.Context, claims *UserClaims) error
+	JWKS() JWKS
+}
+
+var (
+	_ Maker = (*JWTMaker)(nil)
+	_ Maker = (*KeyRingMaker)(nil)
+)
+
+func checkRevoked(ctx context.Context, revocations RevocationStore, claims *UserClaims) error {
+	if revocations == nil {
+		return nil
+	}
+
+	revoked, err := revocations.IsTokenRevoked(ctx, claims.RegisteredClaims.ID)
+	if err != nil {
+		return fmt.Errorf("error checking token revocation: %w", err)
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
+
+func revokeToken(ctx context.Context, revocations RevocationStore, claims *UserClaims) error {
+	if revocations == nil {
+		return fmt.Errorf("token revocation is not configured")
+	}
+
+	if err := revocations.RevokeToken(ctx, claims.RegisteredClaims.ID, claims.ExpiresAt.Time); err != nil {
+		return fmt.Errorf("error revoking token: %w", err)
+	}
+
+	return nil
+}
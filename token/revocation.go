@@ -0,0 +1,86 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// RevocationStore records the IDs (jti) of tokens that were revoked before
+// their expiry. Entries only need to be kept until the token would have
+// expired on its own, after which PurgeRevokedTokens may drop them.
+type RevocationStore interface {
+	RevokeToken(ctx context.Context, id string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, id string) (bool, error)
+	PurgeRevokedTokens(ctx context.Context, before time.Time) (int64, error)
+}
+
+// PurgeRevokedTokensEvery removes expired entries from store on every tick of
+// interval until ctx is done.
+func PurgeRevokedTokensEvery(ctx context.Context, store RevocationStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := store.PurgeRevokedTokens(ctx, now)
+			if err != nil {
+				log.Printf("error purging revoked tokens: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("purged %d revoked tokens", n)
+			}
+		}
+	}
+}
+
+// MemoryRevocationStore is an in-memory RevocationStore, mainly meant for
+// tests and single-instance deployments.
+type MemoryRevocationStore struct {
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		revoked: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryRevocationStore) RevokeToken(_ context.Context, id string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revoked[id] = expiresAt
+	return nil
+}
+
+func (s *MemoryRevocationStore) IsTokenRevoked(_ context.Context, id string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.revoked[id]
+	return ok, nil
+}
+
+func (s *MemoryRevocationStore) PurgeRevokedTokens(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for id, expiresAt := range s.revoked {
+		if expiresAt.Before(before) {
+			delete(s.revoked, id)
+			n++
+		}
+	}
+	return n, nil
+}
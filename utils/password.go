@@ -14,3 +14,7 @@ func HashPassword(password string) (string, error) {
 
 	return string(hashed), nil
 }
+
+func CheckPassword(password string, hashedPassword string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+}